@@ -29,4 +29,18 @@ psql -v ON_ERROR_STOP=1 --username "$POSTGRES_USER" --dbname "$ANALYTICS_DB" <<-
         country CHAR(2),
         city VARCHAR(255)
     );
+
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS short_code VARCHAR(20);
+    CREATE INDEX IF NOT EXISTS idx_clicks_short_code ON clicks (short_code);
+
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS variant VARCHAR(32);
+
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_source VARCHAR(255);
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_medium VARCHAR(255);
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_campaign VARCHAR(255);
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_term VARCHAR(255);
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS utm_content VARCHAR(255);
+
+    ALTER TABLE clicks ADD COLUMN IF NOT EXISTS domain_id INTEGER;
+    CREATE INDEX IF NOT EXISTS idx_clicks_domain_id_short_code ON clicks (domain_id, short_code);
 EOSQL
\ No newline at end of file
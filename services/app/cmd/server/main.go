@@ -2,18 +2,27 @@ package main
 
 import (
 	"context"
+	"hafiztri123/app-link-shortener/internal/analytics"
 	"hafiztri123/app-link-shortener/internal/api"
 	"hafiztri123/app-link-shortener/internal/auth"
 	"hafiztri123/app-link-shortener/internal/config"
+	"hafiztri123/app-link-shortener/internal/domain"
+	"hafiztri123/app-link-shortener/internal/linkimport"
 	"hafiztri123/app-link-shortener/internal/rabbitmq"
 	"hafiztri123/app-link-shortener/internal/redis"
+	"hafiztri123/app-link-shortener/internal/safety"
 	"hafiztri123/app-link-shortener/internal/url"
 	"hafiztri123/app-link-shortener/internal/user"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"hafiztri123/app-link-shortener/internal/workspace"
 	"hpj/hv1-link-shortener/shared/database"
 	"log/slog"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
 
@@ -38,6 +47,7 @@ func main() {
 	}
 
 	db := database.Connect(cfg.DatabaseAddr)
+	analyticsDB := database.Connect(cfg.AnalyticsDBAddr)
 	redis, err := redis.NewClient(context.Background(), cfg.RedisAddr, nil)
 
 	if err != nil {
@@ -47,28 +57,95 @@ func main() {
 
 	tokenService := auth.NewTokenService(cfg.SecretKey)
 
-	urlRepo := url.NewRepository(db)
-	urlService := url.NewService(urlRepo, redis, cfg.IDOffset)
+	var oidcProvider *auth.OIDCProvider
+	if cfg.OIDCIssuerURL != "" {
+		oidcProvider, err = auth.NewOIDCProvider(context.Background(), auth.OIDCConfig{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+		}, nil)
+		if err != nil {
+			slog.Error("Could not set up OIDC provider", "error", err)
+			os.Exit(1)
+		}
+	}
 
-	userRepo := user.NewRepository(db)
-	userService := user.NewService(db, userRepo, tokenService)
+	var codes url.CodeGenerator
+	switch cfg.CodeGenerator {
+	case url.CodeGeneratorSequential:
+		codes = url.NewSequentialGenerator(cfg.IDOffset)
+	case url.CodeGeneratorFeistel:
+		feistel, err := url.NewFeistelGenerator(cfg.CodeSecret, cfg.CodeAlphabet, cfg.CodeLength)
+		if err != nil {
+			slog.Error("Could not set up short code generator", "error", err)
+			os.Exit(1)
+		}
+		// Codes issued before the switch are sequential and must keep resolving.
+		codes = url.NewFallbackGenerator(feistel, url.NewSequentialGenerator(cfg.IDOffset))
+	default:
+		slog.Error("Unknown short code generator", "generator", cfg.CodeGenerator)
+		os.Exit(1)
+	}
 
-	mmdb, err := maxminddb.Open("GeoLite2-City.mmdb")
+	domainService := domain.NewService(domain.NewRepository(db), net.DefaultResolver)
+
+	checker, err := newDestinationChecker(cfg)
 	if err != nil {
-		slog.Error("couldn't find geolite mmdb", "err", err)
+		slog.Error("Could not set up destination safety checks", "error", err)
 		os.Exit(1)
 	}
 
-	rabbitmq, err := rabbitmq.NewRabbitMQ(cfg.RabbitMQAddr, cfg.ClickQueueLabel)
+	rabbitmq, err := rabbitmq.NewRabbitMQ(cfg.RabbitMQAddr, rabbitmq.Queues{
+		Click:        cfg.ClickQueueLabel,
+		LinkPurge:    cfg.LinkPurgeQueueLabel,
+		LinkImport:   cfg.LinkImportQueueLabel,
+		LinkMetadata: cfg.LinkMetadataQueueLabel,
+	})
 	if err != nil {
 		slog.Error("couldn't create new rabbitmq", "error", err)
 		os.Exit(1)
 	}
 
-	server := api.NewServer(db, redis, urlService, userService, tokenService, mmdb, rabbitmq)
+	urlRepo := url.NewRepository(db, codes)
+	localCache := url.NewLocalCache(cfg.LocalCacheSize, cfg.LocalCacheTTL)
+	clicks := analytics.NewRepository(analyticsDB)
+	urlService := url.NewService(urlRepo, redis, codes, localCache, url.NewCanonicalizer(cfg.URLStripParams), domainService, checker, clicks, rabbitmq)
+
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	defer stopListening()
+	go urlService.ListenForInvalidations(listenCtx)
+	go urlService.ScanDestinations(listenCtx, cfg.SafetyScanInterval)
+
+	userRepo := user.NewRepository(db)
+	userService := user.NewService(db, userRepo, tokenService, redis)
+
+	workspaceService := workspace.NewService(workspace.NewRepository(db))
+
+	mmdb, err := maxminddb.Open("GeoLite2-City.mmdb")
+	if err != nil {
+		slog.Error("couldn't find geolite mmdb", "err", err)
+		os.Exit(1)
+	}
+
+	var urlResolver utils.IPResolver
+	if cfg.URLResolveHosts {
+		urlResolver = net.DefaultResolver
+	}
+	urlValidator := utils.NewURLValidator(urlResolver, cfg.URLResolveTimeout)
+
+	importService := linkimport.NewService(urlService, urlValidator, workspaceService, redis, rabbitmq, cfg.ImportSyncRows)
+	go func() {
+		if err := rabbitmq.ConsumeLinkImports(listenCtx, importService.Handle); err != nil && listenCtx.Err() == nil {
+			slog.Error("Stopped consuming link imports", "error", err)
+		}
+	}()
+
+	server := api.NewServer(db, redis, urlService, userService, workspaceService, domainService, importService, tokenService, oidcProvider, mmdb, rabbitmq, urlValidator, cfg.BulkMaxURLs, cfg.ImportMaxBytes, cfg.ImportMaxRows, cfg.PublicBaseURL)
 	router := server.RegisterRoutes()
 
 	defer db.Close()
+	defer analyticsDB.Close()
 	defer redis.Close()
 
 	srv := &http.Server{
@@ -101,3 +178,33 @@ func main() {
 	slog.Info("Server shutdown successfully")
 
 }
+
+// newDestinationChecker blocks the configured domains and patterns, other
+// shorteners including this one, and, when a provider is configured, what
+// it reports.
+func newDestinationChecker(cfg *config.Config) (safety.DestinationChecker, error) {
+	shorteners := slices.Clone(safety.Shorteners)
+	if base, err := neturl.Parse(cfg.PublicBaseURL); err == nil && base.Hostname() != "" {
+		shorteners = append(shorteners, base.Hostname())
+	}
+
+	blocklist := safety.NewBlocklist(cfg.SafetyBlocklist, shorteners)
+	if cfg.SafetyBlocklistFile != "" {
+		file, err := os.Open(cfg.SafetyBlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		if err := blocklist.Load(file); err != nil {
+			return nil, err
+		}
+	}
+
+	checkers := safety.Chain{blocklist}
+	if cfg.SafetyProviderURL != "" {
+		checkers = append(checkers, safety.NewProvider(cfg.SafetyProviderURL, cfg.SafetyProviderKey, nil))
+	}
+
+	return checkers, nil
+}
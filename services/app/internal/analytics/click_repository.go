@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hafiztri123/app-link-shortener/internal/url"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"slices"
+)
+
+// clickTotalsChunkSize bounds the short codes counted per query.
+const clickTotalsChunkSize = 1000
+
+// Repository reads the click events the worker records in the analytics
+// database.
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// ClickTotals counts the clicks of each of links. Links never opened are
+// left out.
+func (r *Repository) ClickTotals(ctx context.Context, links []url.LinkRef) (map[url.LinkRef]int64, error) {
+	totals := make(map[url.LinkRef]int64)
+
+	// The same code can exist on several domains: clicks are matched by code
+	// and then kept only for the domains asked for.
+	wanted := make(map[url.LinkRef]bool, len(links))
+	var shortCodes []string
+	for _, link := range links {
+		if !wanted[link] {
+			wanted[link] = true
+			shortCodes = append(shortCodes, link.ShortCode)
+		}
+	}
+	slices.Sort(shortCodes)
+	shortCodes = slices.Compact(shortCodes)
+
+	for start := 0; start < len(shortCodes); start += clickTotalsChunkSize {
+		chunk := shortCodes[start:min(start+clickTotalsChunkSize, len(shortCodes))]
+		query := fmt.Sprintf(`SELECT domain_id, short_code, COUNT(*) FROM clicks WHERE short_code IN (%s) GROUP BY domain_id, short_code`,
+			utils.SelectPlaceholderBuilder(len(chunk), 1))
+
+		rows, err := r.db.QueryContext(ctx, query, utils.StringSliceToAny(chunk)...)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var domainID sql.NullInt64
+			var shortCode string
+			var total int64
+			if err := rows.Scan(&domainID, &shortCode, &total); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			link := url.LinkRef{DomainID: domainID.Int64, ShortCode: shortCode}
+			if wanted[link] {
+				totals[link] += total
+			}
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return totals, nil
+}
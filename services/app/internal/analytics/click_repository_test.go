@@ -0,0 +1,50 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"hafiztri123/app-link-shortener/internal/url"
+	_ "hafiztri123/app-link-shortener/internal/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickTotals(t *testing.T) {
+	db, err := sql.Open("sqlite3_proxy", fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name()))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE clicks (id INTEGER PRIMARY KEY AUTOINCREMENT, short_code TEXT, domain_id INTEGER, timestamp DATETIME)`)
+	require.NoError(t, err)
+	for _, click := range []struct {
+		shortCode string
+		domainID  any
+	}{{"a", nil}, {"a", nil}, {"b", nil}, {"a", nil}, {"c", nil}, {"a", 7}, {"a", 7}} {
+		_, err = db.Exec(`INSERT INTO clicks (short_code, domain_id, timestamp) VALUES ($1, $2, CURRENT_TIMESTAMP)`, click.shortCode, click.domainID)
+		require.NoError(t, err)
+	}
+
+	repo := NewRepository(db)
+
+	totals, err := repo.ClickTotals(context.Background(), []url.LinkRef{
+		{ShortCode: "a"},
+		{ShortCode: "b"},
+		{ShortCode: "never"},
+		{DomainID: 7, ShortCode: "a"},
+		{DomainID: 8, ShortCode: "b"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[url.LinkRef]int64{
+		{ShortCode: "a"}:              3,
+		{ShortCode: "b"}:              1,
+		{DomainID: 7, ShortCode: "a"}: 2,
+	}, totals)
+
+	totals, err = repo.ClickTotals(context.Background(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, totals)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/domain"
+	"hafiztri123/app-link-shortener/internal/response"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) handleRegisterDomain(w http.ResponseWriter, r *http.Request) {
+	owner, ok := domainOwner(w, r, true)
+	if !ok {
+		return
+	}
+
+	var req domain.RegisterDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	registered, err := s.domainService.Register(r.Context(), owner, req)
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+
+	response.Success(w, "Domain registered, publish the verification TXT record to verify it", http.StatusCreated, registered)
+}
+
+func (s *Server) handleListDomains(w http.ResponseWriter, r *http.Request) {
+	owner, ok := domainOwner(w, r, false)
+	if !ok {
+		return
+	}
+
+	domains, err := s.domainService.List(r.Context(), owner)
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+
+	response.Success(w, "success fetching domains", http.StatusOK, response.ListResponse[*domain.Domain]{
+		Data:  domains,
+		Count: len(domains),
+	})
+}
+
+func (s *Server) handleVerifyDomain(w http.ResponseWriter, r *http.Request) {
+	owner, domainID, ok := domainRequest(w, r)
+	if !ok {
+		return
+	}
+
+	verified, err := s.domainService.Verify(r.Context(), owner, domainID)
+	if err != nil {
+		writeDomainError(w, err)
+		return
+	}
+
+	response.Success(w, "Domain verified", http.StatusOK, verified)
+}
+
+func (s *Server) handleDeleteDomain(w http.ResponseWriter, r *http.Request) {
+	owner, domainID, ok := domainRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.domainService.Delete(r.Context(), owner, domainID); err != nil {
+		writeDomainError(w, err)
+		return
+	}
+
+	response.Success(w, "Domain deleted", http.StatusOK)
+}
+
+// domainOwner scopes a domain request to the selected workspace, or to the
+// caller's personal domains. With manage, workspace members also need a role
+// that may manage domains. It writes the error response itself.
+func domainOwner(w http.ResponseWriter, r *http.Request, manage bool) (domain.Owner, bool) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return domain.Owner{}, false
+	}
+
+	owner := domain.Owner{UserID: claims.UserID}
+	if member := workspace.GetMemberFromContext(r.Context()); member != nil {
+		if manage && !member.Role.CanManageDomains() {
+			response.Error(w, http.StatusForbidden, "Not allowed to manage domains")
+			return domain.Owner{}, false
+		}
+		owner.WorkspaceID = &member.WorkspaceID
+	}
+
+	return owner, true
+}
+
+// domainRequest is domainOwner for changes to the {domainID} domain.
+func domainRequest(w http.ResponseWriter, r *http.Request) (domain.Owner, int64, bool) {
+	owner, ok := domainOwner(w, r, true)
+	if !ok {
+		return domain.Owner{}, 0, false
+	}
+
+	domainID, err := strconv.ParseInt(chi.URLParam(r, "domainID"), 10, 64)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid domain id")
+		return domain.Owner{}, 0, false
+	}
+
+	return owner, domainID, true
+}
+
+func writeDomainError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *domain.InvalidRequestErr, *domain.NotVerifiedErr:
+		response.Error(w, http.StatusBadRequest, err.Error())
+	case *domain.DomainNotFoundErr:
+		response.Error(w, http.StatusNotFound, err.Error())
+	case *domain.DomainTakenErr, *domain.DomainInUseErr:
+		response.Error(w, http.StatusConflict, err.Error())
+	default:
+		response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+	}
+}
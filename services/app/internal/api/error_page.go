@@ -0,0 +1,83 @@
+package api
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+)
+
+// errorWriter reports a failed request; response.Error and writeErrorPage
+// are the JSON and HTML forms.
+type errorWriter func(w http.ResponseWriter, status int, message string)
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; color: #222; }
+main { text-align: center; padding: 2rem; }
+h1 { font-size: 4rem; margin: 0; }
+</style>
+</head>
+<body>
+<main>
+<h1>{{.Status}}</h1>
+<p>{{.Message}}</p>
+</main>
+</body>
+</html>
+`))
+
+// writeErrorPage is the HTML counterpart of response.Error, for routes
+// opened in a browser.
+func writeErrorPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	err := errorPage.Execute(w, struct {
+		Status  int
+		Title   string
+		Message string
+	}{status, http.StatusText(status), message})
+	if err != nil {
+		slog.Error("Failed to write error page", "error", err)
+	}
+}
+
+var warningPage = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Warning: unsafe link</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; color: #fff; background: #b3261e; }
+main { max-width: 32rem; padding: 2rem; }
+h1 { font-size: 2rem; margin: 0 0 1rem; }
+</style>
+</head>
+<body>
+<main>
+<h1>Warning: unsafe link</h1>
+<p>{{.Message}}</p>
+<p>It may lead to phishing, malware, or another redirect hiding its real destination, so it no longer redirects.</p>
+</main>
+</body>
+</html>
+`))
+
+// writeWarningPage is the interstitial shown instead of redirecting to a
+// destination flagged as unsafe. It never links to the destination.
+func writeWarningPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := warningPage.Execute(w, struct{ Message string }{message}); err != nil {
+		slog.Error("Failed to write warning page", "error", err)
+	}
+}
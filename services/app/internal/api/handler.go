@@ -15,7 +15,11 @@ import (
 	"hpj/hv1-link-shortener/shared/models"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -28,6 +32,26 @@ type Handler interface {
 	handleFetchURL(http.ResponseWriter, *http.Request)
 	handleFetchUserURLHistory(http.ResponseWriter, *http.Request)
 	handleGenerateQR(http.ResponseWriter, *http.Request)
+	handleFetchProfile(http.ResponseWriter, *http.Request)
+	handleUpdateProfile(http.ResponseWriter, *http.Request)
+	handleChangePassword(http.ResponseWriter, *http.Request)
+	handleDeleteAccount(http.ResponseWriter, *http.Request)
+	handleUpdateURL(http.ResponseWriter, *http.Request)
+	handleDeleteURL(http.ResponseWriter, *http.Request)
+	handleCreateWorkspace(http.ResponseWriter, *http.Request)
+	handleListWorkspaces(http.ResponseWriter, *http.Request)
+	handleListMembers(http.ResponseWriter, *http.Request)
+	handleInviteMember(http.ResponseWriter, *http.Request)
+	handleAcceptInvitation(http.ResponseWriter, *http.Request)
+	handleUpdateMember(http.ResponseWriter, *http.Request)
+	handleRemoveMember(http.ResponseWriter, *http.Request)
+	handleOIDCLogin(http.ResponseWriter, *http.Request)
+	handleOIDCCallback(http.ResponseWriter, *http.Request)
+	handleOIDCLink(http.ResponseWriter, *http.Request)
+	handleVerifyTwoFactor(http.ResponseWriter, *http.Request)
+	handleEnrollTwoFactor(http.ResponseWriter, *http.Request)
+	handleConfirmTwoFactor(http.ResponseWriter, *http.Request)
+	handleDisableTwoFactor(http.ResponseWriter, *http.Request)
 }
 
 func (s *Server) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -66,19 +90,49 @@ func (s *Server) handleCreateURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok := utils.IsValidURL(req.LongURL)
-	if !ok {
-		response.Error(w, http.StatusBadRequest, "Invalid URL")
+	if err := s.urlValidator.Validate(r.Context(), req.LongURL); err != nil {
+		writeInvalidURL(w, "Invalid URL", err)
 		return
 	}
 
-	shortcode, err := s.urlService.CreateShortCode(r.Context(), req.LongURL)
+	if !s.validateDestinations(w, r, req.RoutingRules, req.Variants) {
+		return
+	}
+
+	opts := url.LinkOptions{
+		RedirectType: req.RedirectType,
+		ExpiresAt:    req.ExpiresAt,
+		Domain:       req.Domain,
+		Details: url.LinkDetails{
+			Title:       req.Title,
+			Description: req.Description,
+			Tags:        req.Tags,
+			OpenGraph:   url.OpenGraph{Title: req.OGTitle, Description: req.OGDescription, ImageURL: req.OGImage},
+		},
+		Password:     req.Password,
+		RoutingRules: req.RoutingRules,
+		Variants:     req.Variants,
+		UTM:          req.UTM,
+		ForwardQuery: req.ForwardQuery,
+	}
+	shortcode, err := s.urlService.CreateShortCode(r.Context(), req.LongURL, req.ForceNew, opts)
 	if err != nil {
+		var forbiddenErr *url.ForbiddenErr
+		if errors.As(err, &forbiddenErr) {
+			response.Error(w, http.StatusForbidden, err.Error())
+			return
+		}
+		var invalidErr *url.InvalidRequestErr
+		var unsafeErr *url.UnsafeDestinationErr
+		if errors.As(err, &invalidErr) || errors.As(err, &unsafeErr) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "Failed to create short URL")
 		return
 	}
 
-	response.Success(w, "Success!, Short URL created", http.StatusOK, url.CreateURLResponse{ShortCode: shortcode})
+	response.Success(w, "Success!, Short URL created", http.StatusOK, url.CreateURLResponse{ShortCode: shortcode, ShortURL: s.shortURL(req.Domain, shortcode)})
 }
 
 func (s *Server) handleCreateURL_Bulk(w http.ResponseWriter, r *http.Request) {
@@ -95,27 +149,88 @@ func (s *Server) handleCreateURL_Bulk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(req.LongURLs) > s.maxBulkURLs {
+		response.Error(w, http.StatusBadRequest, fmt.Sprintf("long_urls cannot contain more than %d URLs", s.maxBulkURLs))
+		return
+	}
+
+	// Invalid URLs fail on their own; the rest are still shortened.
+	results := make([]url.CreateShortCodeBulkResult, len(req.LongURLs))
+	var valid []string
+	var validIndexes []int
 	for i, longURL := range req.LongURLs {
-		if longURL == "" {
-			response.Error(w, http.StatusBadRequest, fmt.Sprintf("long_urls[%d] cannot be empty", i))
-			return
+		if err := s.urlValidator.Validate(r.Context(), longURL); err != nil {
+			code := string(utils.URLMalformed)
+			var invalidErr *utils.InvalidURLErr
+			if errors.As(err, &invalidErr) {
+				code = string(invalidErr.Reason())
+			}
+			results[i] = url.BulkFailure(longURL, code, err)
+			continue
 		}
 
-		if !utils.IsValidURL(longURL) {
-			response.Error(w, http.StatusBadRequest, fmt.Sprintf("long_urls[%d] is not a valid URL", i))
+		valid = append(valid, longURL)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if len(valid) > 0 {
+		created, err := s.urlService.CreateShortCode_Bulk(r.Context(), valid, req.ForceNew)
+		if err != nil {
+			var forbiddenErr *url.ForbiddenErr
+			if errors.As(err, &forbiddenErr) {
+				response.Error(w, http.StatusForbidden, err.Error())
+				return
+			}
+			response.Error(w, http.StatusInternalServerError, "Failed to create short URLs")
 			return
 		}
+
+		for j, result := range created {
+			results[validIndexes[j]] = result
+		}
 	}
 
-	results, err := s.urlService.CreateShortCode_Bulk(r.Context(), req.LongURLs)
-	if err != nil {
-		response.Error(w, http.StatusInternalServerError, "Failed to create short URLs")
+	var failed int
+	for i := range results {
+		if results[i].Status != url.BulkItemCreated {
+			failed++
+			continue
+		}
+		results[i].ShortURL = s.shortURL("", results[i].ShortCode)
+	}
+
+	if failed > 0 {
+		response.Success(w, fmt.Sprintf("Bulk short URLs created, %d of %d failed", failed, len(results)), http.StatusOK, results)
 		return
 	}
 
 	response.Success(w, "Success! Bulk short URLs created", http.StatusOK, results)
 }
 
+// writeInvalidURL reports a destination rejected by the URL validator, with
+// the reason code of the rejection.
+func writeInvalidURL(w http.ResponseWriter, message string, err error) {
+	var invalidErr *utils.InvalidURLErr
+	if !errors.As(err, &invalidErr) {
+		response.Error(w, http.StatusBadRequest, message)
+		return
+	}
+
+	response.ErrorCode(w, http.StatusBadRequest, message+": "+err.Error(), string(invalidErr.Reason()))
+}
+
+// shortURL is the public link for shortCode on hostname, served by the root
+// redirect route. An empty hostname is the default domain; custom domains
+// are served over the same scheme.
+func (s *Server) shortURL(hostname string, shortCode string) string {
+	if hostname == "" {
+		return s.publicBaseURL + "/" + shortCode
+	}
+
+	scheme, _, _ := strings.Cut(s.publicBaseURL, "://")
+	return scheme + "://" + strings.ToLower(hostname) + "/" + shortCode
+}
+
 func (s *Server) handleFetchURL(w http.ResponseWriter, r *http.Request) {
 	shortCode := chi.URLParam(r, "shortCode")
 	if shortCode == "" {
@@ -123,19 +238,109 @@ func (s *Server) handleFetchURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	longURL, err := s.urlService.FetchLongURL(r.Context(), shortCode)
+	// domain picks the link; it is not part of the visit.
+	query := r.URL.Query()
+	hostname := query.Get("domain")
+	query.Del("domain")
+
+	s.redirect(w, r, hostname, shortCode, query, response.Error, response.Error)
+}
+
+// handlePublicRedirect serves short links at the root path, of the default
+// domain or of the custom domain the request was sent to. Failures are
+// reported as HTML pages, since it is opened in browsers, and flagged links
+// get a warning page. A trailing "+" asks for the link's preview page
+// instead of the redirect.
+func (s *Server) handlePublicRedirect(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortCode")
+	if code, ok := strings.CutSuffix(shortCode, "+"); ok {
+		s.writePreview(w, r, s.requestDomain(r), code, writeErrorPage, writeWarningPage)
+		return
+	}
+
+	s.redirect(w, r, s.requestDomain(r), shortCode, r.URL.Query(), writeErrorPage, writeWarningPage)
+}
+
+// writeLinkError reports why a link could not be opened.
+func writeLinkError(w http.ResponseWriter, err error, writeError errorWriter, writeWarning errorWriter) {
+	var disabledErr *url.LinkDisabledErr
+	var expiredErr *url.LinkExpiredErr
+	var flaggedErr *url.LinkFlaggedErr
+	var invalidErr *url.InvalidShortCodeErr
+	switch {
+	case errors.Is(err, sql.ErrNoRows) || errors.As(err, &invalidErr):
+		writeError(w, http.StatusNotFound, "Short URL not found")
+	case errors.As(err, &flaggedErr):
+		writeWarning(w, http.StatusForbidden, err.Error())
+	case errors.As(err, &disabledErr) || errors.As(err, &expiredErr):
+		writeError(w, http.StatusGone, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to fetch long URL")
+	}
+}
+
+// requestDomain is the custom domain r was sent to, or "" for the default
+// domain.
+func (s *Server) requestDomain(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == s.publicHost {
+		return ""
+	}
+
+	return host
+}
+
+// redirect sends the client on to the destination of shortCode on hostname
+// and records the visit. Links flagged as unsafe are reported through
+// writeWarning. Social platforms unfurling the link get its preview page
+// instead, and are not counted as visits. Protected links ask for their
+// password until the visitor holds an access cookie.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, hostname string, shortCode string, query neturl.Values, writeError errorWriter, writeWarning errorWriter) {
+	// Crawlers and visitors get different answers at the same URL, so shared
+	// caches must keep them apart.
+	w.Header().Add("Vary", "User-Agent")
+	if isSocialCrawler(r.UserAgent()) {
+		s.writePreview(w, r, hostname, shortCode, writeError, writeWarning)
+		return
+	}
+
+	redirect, err := s.urlService.FetchRedirect(r.Context(), hostname, shortCode)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			response.Error(w, http.StatusNotFound, "Short URL not found")
-			return
-		}
-		response.Error(w, http.StatusInternalServerError, "Failed to fetch long URL")
+		writeLinkError(w, err, writeError, writeWarning)
+		return
+	}
+
+	if redirect.Protected && !s.linkUnlocked(r, hostname, shortCode, redirect.PasswordStamp) {
+		writePasswordPage(w, http.StatusUnauthorized, "")
 		return
 	}
 
-	slog.Info("redirecting to long URL", "short_code", shortCode, "long_url", longURL)
+	visit := visitor(r, hostname, shortCode)
+	visit.Query = query
+	destination, variant := redirect.Destination(visit)
+	slog.Info("redirecting to long URL", "short_code", shortCode, "long_url", destination, "variant", variant)
+
+	setRedirectCacheHeaders(w, redirect, time.Now())
+
+	// HEAD requests check where a link goes; they are not visits.
+	if r.Method == http.MethodHead {
+		http.Redirect(w, r, destination, redirect.Type)
+		return
+	}
+
+	if variant != "" {
+		s.setVariantCookie(w, r, shortCode, variant)
+	}
 
 	if value, ok := r.Context().Value(shared.ClickDataKey).(*models.Click); ok {
+		value.ShortCode = shortCode
+		value.DomainID = redirect.DomainID
+		value.Variant = variant
 		slog.Info("publishing click event", "click", value)
 		go func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -148,7 +353,111 @@ func (s *Server) handleFetchURL(w http.ResponseWriter, r *http.Request) {
 		slog.Info("click data not found in context")
 	}
 
-	http.Redirect(w, r, longURL, http.StatusMovedPermanently)
+	http.Redirect(w, r, destination, redirect.Type)
+}
+
+// permanentRedirectMaxAge bounds how long browsers may cache a permanent
+// redirect, which they would otherwise keep indefinitely.
+const permanentRedirectMaxAge = 24 * time.Hour
+
+// setRedirectCacheHeaders lets browsers cache permanent redirects until the
+// link expires, and no longer than permanentRedirectMaxAge. Temporary
+// redirects are never cached, so every visit reaches us. Routed redirects
+// differ by visitor, so only their browser may keep them.
+func setRedirectCacheHeaders(w http.ResponseWriter, redirect *url.Redirect, now time.Time) {
+	// Shared caches must not hand a protected link to visitors who were
+	// never asked for its password.
+	if !redirect.Permanent() || redirect.Protected {
+		w.Header().Set("Cache-Control", "private, no-store")
+		return
+	}
+
+	maxAge := permanentRedirectMaxAge
+	if redirect.ExpiresAt != nil {
+		maxAge = min(maxAge, redirect.ExpiresAt.Sub(now))
+	}
+	maxAge = maxAge.Truncate(time.Second)
+
+	scope := "public"
+	if redirect.PerVisitor() {
+		scope = "private"
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+	w.Header().Set("Expires", now.Add(maxAge).UTC().Format(http.TimeFormat))
+}
+
+func (s *Server) handleUpdateURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortCode")
+
+	var req url.UpdateURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	var rules []url.RoutingRule
+	if req.RoutingRules != nil {
+		rules = *req.RoutingRules
+	}
+	var variants []url.Variant
+	if req.Variants != nil {
+		variants = *req.Variants
+	}
+	if !s.validateDestinations(w, r, rules, variants) {
+		return
+	}
+
+	if err := s.urlService.UpdateLink(r.Context(), r.URL.Query().Get("domain"), shortCode, req); err != nil {
+		writeURLError(w, err)
+		return
+	}
+
+	response.Success(w, "Short URL updated", http.StatusOK)
+}
+
+func (s *Server) handleDeleteURL(w http.ResponseWriter, r *http.Request) {
+	shortCode := chi.URLParam(r, "shortCode")
+
+	link, err := s.urlService.DeleteLink(r.Context(), r.URL.Query().Get("domain"), shortCode)
+	if err != nil {
+		writeURLError(w, err)
+		return
+	}
+
+	if s.rabbitMq != nil {
+		purge := &models.LinkPurge{
+			Links:     []models.PurgedLink{{DomainID: link.DomainID, ShortCode: link.ShortCode}},
+			Timestamp: time.Now().UTC(),
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := s.rabbitMq.PublishLinkPurge(ctx, purge); err != nil {
+				slog.Error("failed to publish link purge", "error", err)
+			}
+		}()
+	}
+
+	response.Success(w, "Short URL deleted", http.StatusOK)
+}
+
+func writeURLError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		response.Error(w, http.StatusNotFound, "Short URL not found")
+		return
+	}
+
+	switch err.(type) {
+	case *url.InvalidRequestErr, *url.UnsafeDestinationErr:
+		response.Error(w, http.StatusBadRequest, err.Error())
+	case *url.ForbiddenErr, *url.LinkFlaggedErr:
+		response.Error(w, http.StatusForbidden, err.Error())
+	case *auth.ValueNotFoundErr:
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+	default:
+		response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+	}
 }
 
 func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
@@ -161,6 +470,9 @@ func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
 	err = s.userService.Register(r.Context(), req)
 	if err != nil {
 		switch err.(type) {
+		case *user.InvalidRequestErr:
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
 		case *user.InvalidCredentialErr:
 			response.Error(w, http.StatusUnauthorized, err.Error())
 			return
@@ -185,9 +497,10 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
 	}
 
-	token, err := s.userService.Login(r.Context(), req)
+	login, err := s.userService.Login(r.Context(), req)
 	if err != nil {
 		switch err.(type) {
 		case *user.InvalidCredentialErr:
@@ -203,7 +516,7 @@ func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	response.Success(w, "Success", http.StatusOK, user.LoginResponse{Token: token})
+	writeLogin(w, login)
 }
 
 func (s *Server) handleFetchUserURLHistory(w http.ResponseWriter, r *http.Request) {
@@ -213,16 +526,219 @@ func (s *Server) handleFetchUserURLHistory(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	urls, err := s.urlService.FetchUserURLHistory(r.Context(), claims.UserID)
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := s.urlService.FetchUserURLHistory(r.Context(), claims.UserID, query)
 	if err != nil {
+		var invalidErr *url.InvalidRequestErr
+		if errors.As(err, &invalidErr) {
+			response.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
 		return
 	}
 
-	response.Success(w, "success fetching user url history", http.StatusOK, response.ListResponse[*url.URL]{
-		Data:  urls,
-		Count: len(urls),
-	})
+	response.Success(w, "success fetching user url history", http.StatusOK, page)
+}
+
+func parseHistoryQuery(r *http.Request) (url.HistoryQuery, error) {
+	params := r.URL.Query()
+
+	query := url.HistoryQuery{
+		Cursor: params.Get("cursor"),
+		Sort:   params.Get("sort"),
+		Search: params.Get("q"),
+		Status: params.Get("status"),
+		Tags:   params["tag"],
+	}
+
+	if raw := params.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil {
+			return query, errors.New("limit must be a number")
+		}
+		query.Limit = limit
+	}
+
+	bounds := []struct {
+		name   string
+		target **time.Time
+	}{
+		{"from", &query.From},
+		{"to", &query.To},
+	}
+
+	for _, bound := range bounds {
+		raw := params.Get(bound.name)
+		if raw == "" {
+			continue
+		}
+
+		parsed, err := parseHistoryTime(raw)
+		if err != nil {
+			return query, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", bound.name)
+		}
+		*bound.target = &parsed
+	}
+
+	return query, nil
+}
+
+func parseHistoryTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.DateOnly, raw)
+}
+
+func (s *Server) handleFetchProfile(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	profile, err := s.userService.GetProfile(r.Context(), claims.UserID)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "success fetching profile", http.StatusOK, profile)
+}
+
+func (s *Server) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req user.UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if claims.IssuedAt != nil {
+		req.SignedInAt = claims.IssuedAt.Time
+	}
+
+	profile, err := s.userService.UpdateProfile(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "Profile updated", http.StatusOK, profile)
+}
+
+func (s *Server) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req user.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	token, err := s.userService.ChangePassword(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "Password changed, other sessions have been signed out", http.StatusOK, user.LoginResponse{Token: token})
+}
+
+func (s *Server) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req user.DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if claims.IssuedAt != nil {
+		req.SignedInAt = claims.IssuedAt.Time
+	}
+
+	result, err := s.userService.DeleteAccount(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	// Kept links still resolve, so only the other policies need their cached
+	// destinations dropped.
+	if result.Policy != user.LinkPolicyKeep {
+		if err := s.urlService.InvalidateCache(r.Context(), accountLinks(result.Links)); err != nil {
+			slog.Warn("failed to invalidate cache of deleted account", "error", err, "user_id", claims.UserID)
+		}
+	}
+
+	if result.Policy == user.LinkPolicyDelete && len(result.Links) > 0 && s.rabbitMq != nil {
+		links := accountLinks(result.Links)
+		purged := make([]models.PurgedLink, len(links))
+		for i, link := range links {
+			purged[i] = models.PurgedLink{DomainID: link.DomainID, ShortCode: link.ShortCode}
+		}
+
+		purge := &models.LinkPurge{Links: purged, Timestamp: time.Now().UTC()}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := s.rabbitMq.PublishLinkPurge(ctx, purge); err != nil {
+				slog.Error("failed to publish link purge", "error", err)
+			}
+		}()
+	}
+
+	response.Success(w, "Account deleted", http.StatusOK)
+}
+
+func accountLinks(links []user.LinkRef) []url.LinkRef {
+	refs := make([]url.LinkRef, len(links))
+	for i, link := range links {
+		refs[i] = url.LinkRef{ShortCode: link.ShortCode}
+		if link.DomainID != nil {
+			refs[i].DomainID = *link.DomainID
+		}
+	}
+
+	return refs
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *user.InvalidRequestErr:
+		response.Error(w, http.StatusBadRequest, err.Error())
+	case *user.InvalidCredentialErr:
+		response.Error(w, http.StatusUnauthorized, err.Error())
+	case *user.UserNotFoundErr:
+		response.Error(w, http.StatusNotFound, err.Error())
+	case *user.EmailAlreadyExistsErr:
+		response.Error(w, http.StatusConflict, err.Error())
+	case *user.InvalidTwoFactorCodeErr, *user.ReauthenticationRequiredErr:
+		response.Error(w, http.StatusUnauthorized, err.Error())
+	case *user.TooManyTwoFactorAttemptsErr:
+		response.Error(w, http.StatusTooManyRequests, err.Error())
+	default:
+		response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+	}
 }
 
 func (s *Server) handleGenerateQR(w http.ResponseWriter, r *http.Request) {
@@ -232,18 +748,20 @@ func (s *Server) handleGenerateQR(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	longUrl, err := s.urlService.FetchLongURL(r.Context(), shortCode)
+	hostname := r.URL.Query().Get("domain")
+	redirect, err := s.urlService.FetchRedirect(r.Context(), hostname, shortCode)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			response.Error(w, http.StatusNotFound, "Short URL not found")
-			return
-		}
+		writeLinkError(w, err, response.Error, response.Error)
+		return
+	}
 
-		response.Error(w, http.StatusInternalServerError, "Failed to fetch long URL")
+	// The code carries the destination, so it is guarded like the redirect.
+	if redirect.Protected && !s.linkUnlocked(r, hostname, shortCode, redirect.PasswordStamp) {
+		response.Error(w, http.StatusUnauthorized, "This link is protected by a password")
 		return
 	}
 
-	qr, err := s.urlService.GenerateQRCode(longUrl)
+	qr, err := s.urlService.GenerateQRCode(redirect.LongURL)
 	if err != nil {
 		response.Error(w, http.StatusInternalServerError, "Failed to generate qr code")
 		return
@@ -10,13 +10,20 @@ import (
 	"hafiztri123/app-link-shortener/internal/shared"
 	"hafiztri123/app-link-shortener/internal/url"
 	"hafiztri123/app-link-shortener/internal/user"
+	"hafiztri123/app-link-shortener/internal/utils"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"hpj/hv1-link-shortener/shared/models"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockDB struct {
@@ -28,17 +35,31 @@ type mockURLService struct {
 	createError          error
 	createBulkResult     []url.CreateShortCodeBulkResult
 	createBulkError      error
+	bulkLongURLs         []string
 	FetchResult          string
+	fetchRedirect        *url.Redirect
 	FetchError           error
 	GenerateQRCodeResult []byte
 	GenerateQRCodeError  error
-	FetchListResult      any
+	FetchListResult      *url.HistoryPage
 	FetchListResultError error
+	historyQuery         url.HistoryQuery
+	manageError          error
+	invalidated          []url.LinkRef
+	fetchedDomain        string
+	update               url.UpdateURLRequest
+	exportRows           []url.ExportRow
+	exportError          error
+	preview              *url.Preview
+	unlockPassword       string
 }
 
 type mockUserService struct {
-	token string
-	err   error
+	token        string
+	err          error
+	profile      *user.User
+	deleteResult *user.DeleteAccountResult
+	challenge    string
 }
 
 func (m *mockDB) Ping() error {
@@ -48,20 +69,39 @@ func (m *mockDB) Ping() error {
 	return nil
 }
 
-func (m *mockURLService) CreateShortCode(ctx context.Context, longURL string) (string, error) {
+func (m *mockURLService) CreateShortCode(ctx context.Context, longURL string, forceNew bool, opts url.LinkOptions) (string, error) {
 	return m.createResult, m.createError
 }
 
-func (m *mockURLService) FetchLongURL(ctx context.Context, shortCode string) (string, error) {
-	return m.FetchResult, m.FetchError
+func (m *mockURLService) FetchPreview(ctx context.Context, hostname string, shortCode string) (*url.Preview, error) {
+	m.fetchedDomain = hostname
+	if m.FetchError != nil {
+		return nil, m.FetchError
+	}
+	if m.preview != nil {
+		return m.preview, nil
+	}
+	return &url.Preview{ShortCode: shortCode, LongURL: m.FetchResult}, nil
+}
+
+func (m *mockURLService) FetchRedirect(ctx context.Context, hostname string, shortCode string) (*url.Redirect, error) {
+	m.fetchedDomain = hostname
+	if m.FetchError != nil {
+		return nil, m.FetchError
+	}
+	if m.fetchRedirect != nil {
+		return m.fetchRedirect, nil
+	}
+	return &url.Redirect{LongURL: m.FetchResult, Type: url.DefaultRedirectType}, nil
 }
 
 func (m *mockURLService) GenerateQRCode(url string) ([]byte, error) {
 	return m.GenerateQRCodeResult, m.GenerateQRCodeError
 }
 
-func (m *mockURLService) FetchUserURLHistory(ctx context.Context, userId int64) ([]*url.URL, error) {
-	return m.FetchListResult.([]*url.URL), m.FetchListResultError
+func (m *mockURLService) FetchUserURLHistory(ctx context.Context, userId int64, query url.HistoryQuery) (*url.HistoryPage, error) {
+	m.historyQuery = query
+	return m.FetchListResult, m.FetchListResultError
 
 }
 
@@ -69,14 +109,112 @@ func (m *mockUserService) Register(ctx context.Context, req user.RegisterRequest
 	return m.err
 }
 
-func (m *mockUserService) Login(ctx context.Context, req user.LoginRequest) (string, error) {
+func (m *mockUserService) Login(ctx context.Context, req user.LoginRequest) (*user.LoginResponse, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.challenge != "" {
+		return &user.LoginResponse{TwoFactorRequired: true, ChallengeToken: m.challenge}, nil
+	}
+	return &user.LoginResponse{Token: m.token}, nil
+}
+
+func (m *mockUserService) VerifyTwoFactor(ctx context.Context, req user.TwoFactorLoginRequest) (string, error) {
+	return m.token, m.err
+}
+
+func (m *mockUserService) EnrollTwoFactor(ctx context.Context, userID int64) (*user.TwoFactorEnrollment, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &user.TwoFactorEnrollment{Secret: "SECRET", URI: "otpauth://totp/x"}, nil
+}
+
+func (m *mockUserService) ConfirmTwoFactor(ctx context.Context, userID int64, req user.ConfirmTwoFactorRequest) (*user.TwoFactorConfirmation, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &user.TwoFactorConfirmation{RecoveryCodes: []string{"recovery"}}, nil
+}
+
+func (m *mockUserService) DisableTwoFactor(ctx context.Context, userID int64, req user.DisableTwoFactorRequest) error {
+	return m.err
+}
+
+func (m *mockUserService) GetProfile(ctx context.Context, userID int64) (*user.User, error) {
+	return m.profile, m.err
+}
+
+func (m *mockUserService) UpdateProfile(ctx context.Context, userID int64, req user.UpdateProfileRequest) (*user.ProfileUpdate, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &user.ProfileUpdate{User: m.profile, Token: m.token}, nil
+}
+
+func (m *mockUserService) ChangePassword(ctx context.Context, userID int64, req user.ChangePasswordRequest) (string, error) {
 	return m.token, m.err
 }
 
-func (m *mockURLService) CreateShortCode_Bulk(ctx context.Context, longURLs []string) ([]url.CreateShortCodeBulkResult, error) {
+func (m *mockUserService) DeleteAccount(ctx context.Context, userID int64, req user.DeleteAccountRequest) (*user.DeleteAccountResult, error) {
+	return m.deleteResult, m.err
+}
+
+func (m *mockUserService) LoginWithOIDC(ctx context.Context, identity *auth.OIDCIdentity) (*user.LoginResponse, error) {
+	return m.Login(ctx, user.LoginRequest{})
+}
+
+func (m *mockUserService) LinkOIDCIdentity(ctx context.Context, req user.LinkIdentityRequest) (*user.LoginResponse, error) {
+	return m.Login(ctx, user.LoginRequest{})
+}
+
+func (m *mockUserService) ValidateSession(ctx context.Context, claims *auth.Claims) error {
+	return m.err
+}
+
+func (m *mockURLService) InvalidateCache(ctx context.Context, links []url.LinkRef) error {
+	m.invalidated = append(m.invalidated, links...)
+	return nil
+}
+
+func (m *mockURLService) UpdateLink(ctx context.Context, hostname string, shortCode string, req url.UpdateURLRequest) error {
+	m.update = req
+	return m.manageError
+}
+
+func (m *mockURLService) UnlockLink(ctx context.Context, hostname string, shortCode string, password string) (string, error) {
+	if m.FetchError != nil {
+		return "", m.FetchError
+	}
+	if password != m.unlockPassword {
+		return "", url.IncorrectPassword
+	}
+	return "stamp", nil
+}
+
+func (m *mockURLService) DeleteLink(ctx context.Context, hostname string, shortCode string) (url.LinkRef, error) {
+	return url.LinkRef{ShortCode: shortCode}, m.manageError
+}
+
+func (m *mockURLService) CreateShortCode_Bulk(ctx context.Context, longURLs []string, forceNew bool) ([]url.CreateShortCodeBulkResult, error) {
+	m.bulkLongURLs = longURLs
 	return m.createBulkResult, m.createBulkError
 }
 
+func (m *mockURLService) ImportLinks(ctx context.Context, rows []models.LinkImportRow) ([]url.CreateShortCodeBulkResult, error) {
+	return m.createBulkResult, m.createBulkError
+}
+
+func (m *mockURLService) ExportLinks(ctx context.Context, userId int64, write func([]url.ExportRow) error) error {
+	if m.exportError != nil {
+		return m.exportError
+	}
+	if len(m.exportRows) == 0 {
+		return nil
+	}
+	return write(m.exportRows)
+}
+
 func TestHandleCreateURL(t *testing.T) {
 	testCases := []struct {
 		name       string
@@ -104,7 +242,21 @@ func TestHandleCreateURL(t *testing.T) {
 			input:      `{"long_url": "example.com"}`,
 			err:        nil,
 			wantStatus: http.StatusBadRequest,
-			wantMsg:    "Invalid URL",
+			wantMsg:    `"message":"Invalid URL: URL must be absolute"`,
+		},
+		{
+			name:       "private address",
+			input:      `{"long_url": "http://169.254.169.254/latest/meta-data"}`,
+			err:        nil,
+			wantStatus: http.StatusBadRequest,
+			wantMsg:    `"code":"reserved_address"`,
+		},
+		{
+			name:       "routing rule to a private address",
+			input:      `{"long_url": "https://example.com", "routing_rules": [{"countries": ["ID"], "url": "http://10.0.0.1/admin"}]}`,
+			err:        nil,
+			wantStatus: http.StatusBadRequest,
+			wantMsg:    `"message":"Invalid URL in routing rule 1: `,
 		},
 		{
 			name:       "missing long url",
@@ -126,8 +278,11 @@ func TestHandleCreateURL(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			server := &Server{
 				urlService: &mockURLService{
-					createError: tc.err,
+					createResult: "abc",
+					createError:  tc.err,
 				},
+				urlValidator:  utils.NewURLValidator(nil, 0),
+				publicBaseURL: "https://sho.rt",
 			}
 
 			requestBody := []byte(tc.input)
@@ -138,6 +293,9 @@ func TestHandleCreateURL(t *testing.T) {
 
 			assert.Equal(t, rr.Code, tc.wantStatus)
 			assert.Contains(t, rr.Body.String(), tc.wantMsg)
+			if tc.wantStatus == http.StatusOK {
+				assert.Contains(t, rr.Body.String(), `"short_url":"https://sho.rt/abc"`)
+			}
 		})
 	}
 }
@@ -155,7 +313,7 @@ func TestFetchLongURL(t *testing.T) {
 			name:        "Success",
 			input:       "success",
 			wantResult:  "https://example.com",
-			wantStatus:  http.StatusMovedPermanently,
+			wantStatus:  http.StatusFound,
 			fetchResult: "https://example.com",
 			fetchError:  nil,
 		},
@@ -182,36 +340,659 @@ func TestFetchLongURL(t *testing.T) {
 			wantStatus: http.StatusNotFound,
 			fetchError: sql.ErrNoRows,
 		},
+		{
+			name:       "disabled link",
+			input:      "disabled",
+			wantResult: "Short URL has been disabled",
+			wantStatus: http.StatusGone,
+			fetchError: url.LinkDisabled,
+		},
+		{
+			name:       "expired link",
+			input:      "expired",
+			wantResult: "Short URL has expired",
+			wantStatus: http.StatusGone,
+			fetchError: url.LinkExpired,
+		},
+		{
+			name:       "flagged link",
+			input:      "flagged",
+			wantResult: "flagged as unsafe",
+			wantStatus: http.StatusForbidden,
+			fetchError: url.LinkFlagged,
+		},
+		{
+			name:       "malformed short code",
+			input:      "not-a-code",
+			wantResult: "Short URL not found",
+			wantStatus: http.StatusNotFound,
+			fetchError: url.InvalidShortCode,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{
+				urlService: &mockURLService{
+					FetchResult: tc.fetchResult,
+					FetchError:  tc.fetchError,
+				},
+			}
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", tc.input)
+
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/url", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+			rr := httptest.NewRecorder()
+
+			server.handleFetchURL(rr, req)
+
+			assert.Equal(t, rr.Code, tc.wantStatus)
+			if tc.wantResult != "" {
+				assert.Contains(t, rr.Body.String(), tc.wantResult)
+
+			}
+		})
+	}
+}
+
+func TestFetchURL_RedirectSettings(t *testing.T) {
+	expiresAt := time.Now().Add(2 * time.Hour)
+	soon := time.Now().Add(90 * time.Second)
+
+	testCases := []struct {
+		name             string
+		method           string
+		redirect         *url.Redirect
+		wantStatus       int
+		wantCacheControl string
+		wantExpires      bool
+	}{
+		{
+			name:             "temporary redirect is not cached",
+			method:           http.MethodGet,
+			redirect:         &url.Redirect{LongURL: "https://example.com", Type: http.StatusTemporaryRedirect, ExpiresAt: &expiresAt},
+			wantStatus:       http.StatusTemporaryRedirect,
+			wantCacheControl: "private, no-store",
+		},
+		{
+			name:             "permanent redirect without expiry",
+			method:           http.MethodGet,
+			redirect:         &url.Redirect{LongURL: "https://example.com", Type: http.StatusMovedPermanently},
+			wantStatus:       http.StatusMovedPermanently,
+			wantCacheControl: "public, max-age=86400",
+			wantExpires:      true,
+		},
+		{
+			name:             "permanent redirect cached until expiry",
+			method:           http.MethodGet,
+			redirect:         &url.Redirect{LongURL: "https://example.com", Type: http.StatusPermanentRedirect, ExpiresAt: &soon},
+			wantStatus:       http.StatusPermanentRedirect,
+			wantCacheControl: "public, max-age=89",
+			wantExpires:      true,
+		},
+		{
+			name:             "routed permanent redirect is cached by the browser only",
+			method:           http.MethodGet,
+			redirect:         &url.Redirect{LongURL: "https://example.com", Type: http.StatusMovedPermanently, Rules: []url.RoutingRule{{Countries: []string{"ID"}, URL: "https://example.co.id"}}},
+			wantStatus:       http.StatusMovedPermanently,
+			wantCacheControl: "private, max-age=86400",
+			wantExpires:      true,
+		},
+		{
+			// Click data is present, so publishing a click would reach the
+			// nil RabbitMQ client.
+			name:             "HEAD redirects without recording a click",
+			method:           http.MethodHead,
+			redirect:         &url.Redirect{LongURL: "https://example.com", Type: http.StatusFound},
+			wantStatus:       http.StatusFound,
+			wantCacheControl: "private, no-store",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{urlService: &mockURLService{fetchRedirect: tc.redirect}}
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", "abc")
+
+			req := httptest.NewRequest(tc.method, "/api/v1/url/abc", nil)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx)
+			if tc.method == http.MethodHead {
+				ctx = context.WithValue(ctx, shared.ClickDataKey, &models.Click{})
+			}
+			req = req.WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			server.handleFetchURL(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, "https://example.com", rr.Header().Get("Location"))
+			assert.Equal(t, tc.wantCacheControl, rr.Header().Get("Cache-Control"))
+			if tc.wantExpires {
+				_, err := http.ParseTime(rr.Header().Get("Expires"))
+				assert.NoError(t, err)
+			} else {
+				assert.Empty(t, rr.Header().Get("Expires"))
+			}
+		})
+	}
+}
+
+func TestFetchURL_RoutingRules(t *testing.T) {
+	redirect := &url.Redirect{
+		LongURL: "https://example.com",
+		Type:    http.StatusFound,
+		Rules: []url.RoutingRule{
+			{Countries: []string{"ID", "MY"}, URL: "https://example.com/asia"},
+			{Devices: []string{"Mobile"}, OS: []string{"iOS"}, URL: "https://apps.apple.com/app/id1"},
+			{Languages: []string{"de"}, URL: "https://example.com/de"},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		click          *models.Click
+		acceptLanguage string
+		wantLocation   string
+	}{
+		{name: "country", click: &models.Click{Country: "MY", Device: "Mobile", OS: "iOS"}, wantLocation: "https://example.com/asia"},
+		{name: "device and OS", click: &models.Click{Country: "US", Device: "Mobile", OS: "iOS"}, wantLocation: "https://apps.apple.com/app/id1"},
+		{name: "OS alone is not enough", click: &models.Click{Country: "US", Device: "Tablet", OS: "iOS"}, wantLocation: "https://example.com"},
+		{name: "preferred language", click: &models.Click{Country: "US", Device: "Desktop"}, acceptLanguage: "en;q=0.5, de-AT", wantLocation: "https://example.com/de"},
+		{name: "fallback", click: &models.Click{Country: "unknown", Device: "Desktop"}, acceptLanguage: "en-US,de;q=0.9", wantLocation: "https://example.com"},
+		{name: "without click metadata", acceptLanguage: "de", wantLocation: "https://example.com/de"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{urlService: &mockURLService{fetchRedirect: redirect}}
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", "abc")
+
+			// HEAD requests are routed like visits but publish no click.
+			req := httptest.NewRequest(http.MethodHead, "/api/v1/url/abc", nil)
+			req.Header.Set("Accept-Language", tc.acceptLanguage)
+			ctx := context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx)
+			if tc.click != nil {
+				ctx = context.WithValue(ctx, shared.ClickDataKey, tc.click)
+			}
+			req = req.WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			server.handleFetchURL(rr, req)
+
+			assert.Equal(t, http.StatusFound, rr.Code)
+			assert.Equal(t, tc.wantLocation, rr.Header().Get("Location"))
+		})
+	}
+}
+
+func TestFetchURL_Variants(t *testing.T) {
+	redirect := &url.Redirect{
+		LongURL: "https://example.com",
+		Type:    http.StatusFound,
+		Variants: []url.Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 50},
+			{Name: "b", URL: "https://example.com/b", Weight: 50},
+		},
+	}
+	server := &Server{urlService: &mockURLService{fetchRedirect: redirect}, publicBaseURL: "https://sho.rt"}
+
+	fetch := func(method string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		reqCtx := chi.NewRouteContext()
+		reqCtx.URLParams.Add("shortCode", "abc")
+
+		req := httptest.NewRequest(method, "/api/v1/url/abc", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+		rr := httptest.NewRecorder()
+		server.handleFetchURL(rr, req)
+		return rr
+	}
+
+	rr := fetch(http.MethodGet, nil)
+	require.Equal(t, http.StatusFound, rr.Code)
+	cookies := rr.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, linkVariantCookie("abc"), cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Secure)
+	assert.Equal(t, "https://example.com/"+cookies[0].Value, rr.Header().Get("Location"))
+	assert.Equal(t, rr.Header().Get("Location"), fetch(http.MethodGet, nil).Header().Get("Location"), "visitors are placed the same way every time")
+
+	for _, variant := range []string{"a", "b"} {
+		rr := fetch(http.MethodGet, &http.Cookie{Name: linkVariantCookie("abc"), Value: variant})
+		assert.Equal(t, "https://example.com/"+variant, rr.Header().Get("Location"), "the cookie keeps visitors on their variant")
+	}
+
+	rr = fetch(http.MethodHead, nil)
+	assert.Equal(t, http.StatusFound, rr.Code)
+	assert.Empty(t, rr.Result().Cookies(), "HEAD requests are not visits")
+}
+
+func TestFetchURL_ForwardQuery(t *testing.T) {
+	redirect := &url.Redirect{LongURL: "https://example.com/page?ref=site", Type: http.StatusFound, ForwardQuery: true}
+
+	testCases := []struct {
+		name         string
+		target       string
+		handle       func(*Server) http.HandlerFunc
+		wantLocation string
+		wantDomain   string
+	}{
+		{
+			name:         "public link",
+			target:       "/abc?utm_source=x&ref=spam",
+			handle:       func(s *Server) http.HandlerFunc { return s.handlePublicRedirect },
+			wantLocation: "https://example.com/page?ref=site&utm_source=x",
+		},
+		{
+			name:         "api keeps domain to itself",
+			target:       "/api/v1/url/abc?domain=go.acme.com&utm_source=x",
+			handle:       func(s *Server) http.HandlerFunc { return s.handleFetchURL },
+			wantLocation: "https://example.com/page?ref=site&utm_source=x",
+			wantDomain:   "go.acme.com",
+		},
+		{
+			name:         "nothing to forward",
+			target:       "/abc",
+			handle:       func(s *Server) http.HandlerFunc { return s.handlePublicRedirect },
+			wantLocation: "https://example.com/page?ref=site",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlService := &mockURLService{fetchRedirect: redirect}
+			server := &Server{urlService: urlService, publicHost: "example.com"}
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", "abc")
+
+			req := httptest.NewRequest(http.MethodHead, tc.target, nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+			rr := httptest.NewRecorder()
+			tc.handle(server)(rr, req)
+
+			assert.Equal(t, http.StatusFound, rr.Code)
+			assert.Equal(t, tc.wantLocation, rr.Header().Get("Location"))
+			assert.Equal(t, tc.wantDomain, urlService.fetchedDomain)
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	testCases := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "id-ID", want: "id-id"},
+		{header: "en-US,en;q=0.9,id;q=0.8", want: "en-us"},
+		{header: "fr;q=0.4, de;q=0.7, *;q=0.9", want: "de"},
+		{header: "ms;q=0.8, id;q=0.8", want: "ms"},
+		{header: "en;q=0, id;q=0.1", want: "id"},
+		{header: "en;q=bogus, id;q=0.2", want: "id"},
+		{header: "*", want: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.header, func(t *testing.T) {
+			assert.Equal(t, tc.want, preferredLanguage(tc.header))
+		})
+	}
+}
+
+func TestHandlePublicRedirect(t *testing.T) {
+	testCases := []struct {
+		name       string
+		fetchError error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "redirects",
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "unknown code",
+			fetchError: sql.ErrNoRows,
+			wantStatus: http.StatusNotFound,
+			wantBody:   "Short URL not found",
+		},
+		{
+			name:       "malformed code",
+			fetchError: url.InvalidShortCode,
+			wantStatus: http.StatusNotFound,
+			wantBody:   "Short URL not found",
+		},
+		{
+			name:       "disabled link",
+			fetchError: url.LinkDisabled,
+			wantStatus: http.StatusGone,
+			wantBody:   "Short URL has been disabled",
+		},
+		{
+			name:       "flagged link",
+			fetchError: url.LinkFlagged,
+			wantStatus: http.StatusForbidden,
+			wantBody:   "Warning: unsafe link",
+		},
+		{
+			name:       "service error",
+			fetchError: errors.New("boom"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Failed to fetch long URL",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{urlService: &mockURLService{FetchResult: "https://example.com", FetchError: tc.fetchError}}
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", "abc")
+
+			req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+			rr := httptest.NewRecorder()
+			server.handlePublicRedirect(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			if tc.wantBody == "" {
+				assert.Equal(t, "https://example.com", rr.Header().Get("Location"))
+				assert.Equal(t, "User-Agent", rr.Header().Get("Vary"))
+				return
+			}
+
+			assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+			assert.Contains(t, rr.Body.String(), "<!DOCTYPE html>")
+			assert.Contains(t, rr.Body.String(), tc.wantBody)
+		})
+	}
+}
+
+func TestHandlePublicRedirect_Domain(t *testing.T) {
+	testCases := []struct {
+		name       string
+		host       string
+		wantDomain string
+	}{
+		{name: "default domain", host: "sho.rt", wantDomain: ""},
+		{name: "default domain with port", host: "SHO.RT:443", wantDomain: ""},
+		{name: "custom domain", host: "go.acme.com", wantDomain: "go.acme.com"},
+		{name: "custom domain with port", host: "Go.Acme.com:8080", wantDomain: "go.acme.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlService := &mockURLService{FetchResult: "https://example.com"}
+			server := NewServer(nil, nil, urlService, nil, nil, nil, nil, nil, nil, nil, nil, nil, 0, 0, 0, "https://sho.rt/")
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", "abc")
+
+			req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+			req.Host = tc.host
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+			rr := httptest.NewRecorder()
+			server.handlePublicRedirect(rr, req)
+
+			assert.Equal(t, http.StatusFound, rr.Code)
+			assert.Equal(t, tc.wantDomain, urlService.fetchedDomain)
+		})
+	}
+}
+
+func TestHandlePublicRedirect_Preview(t *testing.T) {
+	testCases := []struct {
+		name       string
+		path       string
+		userAgent  string
+		preview    *url.Preview
+		fetchError error
+		wantStatus int
+		wantBody   []string
+		wantVary   string
+	}{
+		{
+			name:      "social crawler gets the Open Graph tags",
+			path:      "abc",
+			userAgent: "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+			preview: &url.Preview{
+				ShortCode:   "abc",
+				LongURL:     "https://example.com/post",
+				Title:       `Launch "day"`,
+				Description: "All about it",
+				ImageURL:    "https://cdn.example.com/card.png",
+			},
+			wantStatus: http.StatusOK,
+			wantBody: []string{
+				`<meta property="og:url" content="https://sho.rt/abc">`,
+				`<meta property="og:title" content="Launch &#34;day&#34;">`,
+				`<meta property="og:description" content="All about it">`,
+				`<meta property="og:image" content="https://cdn.example.com/card.png">`,
+				`summary_large_image`,
+			},
+			wantVary: "User-Agent",
+		},
+		{
+			name:       "plus suffix shows the destination",
+			path:       "abc+",
+			userAgent:  "Mozilla/5.0",
+			preview:    &url.Preview{ShortCode: "abc", LongURL: "https://example.com/post"},
+			wantStatus: http.StatusOK,
+			wantBody: []string{
+				`<title>example.com</title>`,
+				`<p class="destination">https://example.com/post</p>`,
+				`<a href="https://sho.rt/abc" rel="nofollow">Continue</a>`,
+			},
+		},
+		{
+			name:       "unknown code",
+			path:       "abc+",
+			fetchError: sql.ErrNoRows,
+			wantStatus: http.StatusNotFound,
+			wantBody:   []string{"Short URL not found"},
+		},
+		{
+			name:       "flagged link",
+			path:       "abc",
+			userAgent:  "Twitterbot/1.0",
+			fetchError: url.LinkFlagged,
+			wantStatus: http.StatusForbidden,
+			wantBody:   []string{"Warning: unsafe link"},
+			wantVary:   "User-Agent",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlService := &mockURLService{preview: tc.preview, FetchError: tc.fetchError}
+			server := NewServer(nil, nil, urlService, nil, nil, nil, nil, nil, nil, nil, nil, nil, 0, 0, 0, "https://sho.rt")
+
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", tc.path)
+
+			req := httptest.NewRequest(http.MethodGet, "/"+tc.path, nil)
+			req.Host = "sho.rt"
+			req.Header.Set("User-Agent", tc.userAgent)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+			rr := httptest.NewRecorder()
+			server.handlePublicRedirect(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Empty(t, rr.Header().Get("Location"))
+			assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+			assert.Equal(t, tc.wantVary, rr.Header().Get("Vary"))
+			for _, want := range tc.wantBody {
+				assert.Contains(t, rr.Body.String(), want)
+			}
+		})
+	}
+}
+
+func TestHandlePublicRedirect_Protected(t *testing.T) {
+	tokens := auth.NewTokenService("secret")
+	validToken, err := tokens.GenerateLinkAccessToken(linkAccessSubject("", "abc"), "stamp")
+	require.NoError(t, err)
+	otherLinkToken, err := tokens.GenerateLinkAccessToken(linkAccessSubject("", "abd"), "stamp")
+	require.NoError(t, err)
+	oldPasswordToken, err := tokens.GenerateLinkAccessToken(linkAccessSubject("", "abc"), "old")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		path         string
+		userAgent    string
+		cookie       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "asks for the password", path: "abc", wantStatus: http.StatusUnauthorized},
+		{name: "access cookie lets the visitor through", path: "abc", cookie: validToken, wantStatus: http.StatusMovedPermanently, wantLocation: "https://example.com/internal"},
+		{name: "cookie of another link", path: "abc", cookie: otherLinkToken, wantStatus: http.StatusUnauthorized},
+		{name: "tampered cookie", path: "abc", cookie: validToken + "x", wantStatus: http.StatusUnauthorized},
+		{name: "cookie for a previous password", path: "abc", cookie: oldPasswordToken, wantStatus: http.StatusUnauthorized},
+		{name: "preview page with a cookie for a previous password", path: "abc+", cookie: oldPasswordToken, wantStatus: http.StatusUnauthorized},
+		{name: "crawlers see no preview", path: "abc", userAgent: "Twitterbot/1.0", wantStatus: http.StatusUnauthorized},
+		{name: "preview page asks for the password", path: "abc+", wantStatus: http.StatusUnauthorized},
+		{name: "preview page with access cookie", path: "abc+", cookie: validToken, wantStatus: http.StatusOK},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			server := &Server{
-				urlService: &mockURLService{
-					FetchResult: tc.fetchResult,
-					FetchError:  tc.fetchError,
-				},
+			urlService := &mockURLService{
+				fetchRedirect: &url.Redirect{LongURL: "https://example.com/internal", Type: http.StatusMovedPermanently, Protected: true, PasswordStamp: "stamp"},
+				preview:       &url.Preview{ShortCode: "abc", LongURL: "https://example.com/internal", Protected: true, PasswordStamp: "stamp"},
 			}
+			server := NewServer(nil, nil, urlService, nil, nil, nil, nil, tokens, nil, nil, nil, nil, 0, 0, 0, "https://sho.rt")
 
 			reqCtx := chi.NewRouteContext()
-			reqCtx.URLParams.Add("shortCode", tc.input)
+			reqCtx.URLParams.Add("shortCode", tc.path)
 
-			req, _ := http.NewRequest(http.MethodGet, "/api/v1/url", nil)
+			req := httptest.NewRequest(http.MethodGet, "/"+tc.path, nil)
+			req.Host = "sho.rt"
+			req.Header.Set("User-Agent", tc.userAgent)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: linkAccessCookie("abc"), Value: tc.cookie})
+			}
 			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
 
 			rr := httptest.NewRecorder()
+			server.handlePublicRedirect(rr, req)
 
-			server.handleFetchURL(rr, req)
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Equal(t, tc.wantLocation, rr.Header().Get("Location"))
+			assert.Contains(t, rr.Header().Get("Cache-Control"), "no-store", "protected links must stay out of shared caches")
+			if tc.wantStatus == http.StatusUnauthorized {
+				assert.Contains(t, rr.Body.String(), `<form method="post">`)
+				assert.NotContains(t, rr.Body.String(), "example.com/internal")
+			}
+		})
+	}
+}
 
-			assert.Equal(t, rr.Code, tc.wantStatus)
-			if tc.wantResult != "" {
-				assert.Contains(t, rr.Body.String(), tc.wantResult)
+func TestHandlePublicUnlock(t *testing.T) {
+	tokens := auth.NewTokenService("secret")
+
+	testCases := []struct {
+		name       string
+		path       string
+		password   string
+		fetchError error
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "correct password", path: "abc", password: "s3cret", wantStatus: http.StatusSeeOther},
+		{name: "correct password on the preview page", path: "abc+", password: "s3cret", wantStatus: http.StatusSeeOther},
+		{name: "incorrect password", path: "abc", password: "guess", wantStatus: http.StatusUnauthorized, wantBody: "Incorrect password"},
+		{name: "unknown code", path: "abc", fetchError: sql.ErrNoRows, wantStatus: http.StatusNotFound, wantBody: "Short URL not found"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlService := &mockURLService{unlockPassword: "s3cret", FetchError: tc.fetchError}
+			server := NewServer(nil, nil, urlService, nil, nil, nil, nil, tokens, nil, nil, nil, nil, 0, 0, 0, "https://sho.rt")
 
+			reqCtx := chi.NewRouteContext()
+			reqCtx.URLParams.Add("shortCode", tc.path)
+
+			form := neturl.Values{"password": {tc.password}}
+			req := httptest.NewRequest(http.MethodPost, "/"+tc.path, strings.NewReader(form.Encode()))
+			req.Host = "sho.rt"
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+			rr := httptest.NewRecorder()
+			server.handlePublicUnlock(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			if tc.wantStatus != http.StatusSeeOther {
+				assert.Contains(t, rr.Body.String(), tc.wantBody)
+				assert.Empty(t, rr.Result().Cookies())
+				return
 			}
+
+			assert.Equal(t, "/"+tc.path, rr.Header().Get("Location"))
+			cookies := rr.Result().Cookies()
+			require.Len(t, cookies, 1)
+			assert.Equal(t, linkAccessCookie("abc"), cookies[0].Name)
+			assert.True(t, cookies[0].HttpOnly)
+			assert.True(t, cookies[0].Secure)
+			assert.NoError(t, tokens.ValidateLinkAccessToken(cookies[0].Value, linkAccessSubject("", "abc"), "stamp"))
 		})
 	}
 }
 
+func TestIsSocialCrawler(t *testing.T) {
+	assert.True(t, isSocialCrawler("Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)"))
+	assert.True(t, isSocialCrawler("Mozilla/5.0 (compatible; Discordbot/2.0; +https://discordapp.com)"))
+	assert.True(t, isSocialCrawler("LinkedInBot/1.0 (compatible; Mozilla/5.0)"))
+	assert.False(t, isSocialCrawler("Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"))
+	assert.False(t, isSocialCrawler("Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/126.0"))
+	assert.False(t, isSocialCrawler(""))
+}
+
+func TestShortURL(t *testing.T) {
+	server := NewServer(nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, 0, 0, 0, "https://sho.rt/")
+
+	assert.Equal(t, "https://sho.rt/abc", server.shortURL("", "abc"))
+	assert.Equal(t, "https://go.acme.com/abc", server.shortURL("Go.Acme.com", "abc"))
+}
+
+func TestWriteErrorPage_EscapesMessage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeErrorPage(rr, http.StatusNotFound, "<script>alert(1)</script>")
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "<script>")
+	assert.Contains(t, rr.Body.String(), "Not Found")
+}
+
+func TestWriteWarningPage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeWarningPage(rr, http.StatusForbidden, "<b>flagged</b>")
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "no-store", rr.Header().Get("Cache-Control"))
+	assert.NotContains(t, rr.Body.String(), "<b>")
+	assert.Contains(t, rr.Body.String(), "noindex")
+}
+
 func TestHealthCheck(t *testing.T) {
 	testcases := []struct {
 		name               string
@@ -299,6 +1080,13 @@ func TestRegister(t *testing.T) {
 			wantStatusCode: http.StatusUnauthorized,
 		},
 
+		{
+			name:           "invalid request",
+			input:          validRequestBody,
+			registerErr:    user.InvalidRequest,
+			wantStatusCode: http.StatusBadRequest,
+		},
+
 		{
 			name:           "user not found",
 			input:          validRequestBody,
@@ -354,6 +1142,7 @@ func TestLogin(t *testing.T) {
 		name           string
 		input          string
 		token          string
+		challenge      string
 		registerErr    error
 		wantStatusCode int
 	}{
@@ -365,6 +1154,14 @@ func TestLogin(t *testing.T) {
 			wantStatusCode: http.StatusOK,
 		},
 
+		{
+			name:           "two-factor required",
+			input:          validRequestBody,
+			token:          "challenge-token",
+			challenge:      "challenge-token",
+			wantStatusCode: http.StatusOK,
+		},
+
 		{
 			name:           "bad request payload",
 			input:          `{"invalid": "invalid}`,
@@ -399,8 +1196,9 @@ func TestLogin(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			server := &Server{
 				userService: &mockUserService{
-					token: tc.token,
-					err:   tc.registerErr,
+					token:     tc.token,
+					challenge: tc.challenge,
+					err:       tc.registerErr,
 				},
 			}
 
@@ -420,36 +1218,72 @@ func TestLogin(t *testing.T) {
 }
 
 func TestHandleFetchUserURLHistory(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	testCases := []struct {
 		name           string
-		fetchResult    []*url.URL
+		target         string
+		fetchResult    *url.HistoryPage
 		fetchError     error
 		emptyClaims    bool
 		wantStatusCode int
+		wantQuery      url.HistoryQuery
 	}{
 		{
-			name: "success",
-			fetchResult: []*url.URL{
-				{LongURL: "https://example.com/1"},
-				{LongURL: "https://example.com/2"},
-				{LongURL: "https://example.com/3"},
+			name:   "success",
+			target: "/api/v1/user/history",
+			fetchResult: &url.HistoryPage{
+				Data: []*url.URL{
+					{LongURL: "https://example.com/1"},
+					{LongURL: "https://example.com/2"},
+					{LongURL: "https://example.com/3"},
+				},
+				Count: 3,
+				Total: 3,
 			},
-			fetchError:     nil,
-			emptyClaims:    false,
 			wantStatusCode: http.StatusOK,
 		},
+		{
+			name:           "query parameters",
+			target:         "/api/v1/user/history?limit=10&cursor=abc&sort=oldest&q=example&status=active&from=2024-01-01&tag=docs&tag=team",
+			fetchResult:    &url.HistoryPage{},
+			wantStatusCode: http.StatusOK,
+			wantQuery: url.HistoryQuery{
+				Limit:  10,
+				Cursor: "abc",
+				Sort:   url.SortOldest,
+				Search: "example",
+				Status: url.StatusActive,
+				Tags:   []string{"docs", "team"},
+				From:   &from,
+			},
+		},
+		{
+			name:           "invalid limit",
+			target:         "/api/v1/user/history?limit=ten",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid date",
+			target:         "/api/v1/user/history?to=yesterday",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "rejected by service",
+			target:         "/api/v1/user/history?cursor=broken",
+			fetchError:     url.InvalidRequest,
+			wantStatusCode: http.StatusBadRequest,
+		},
 		{
 			name:           "empty Claims",
-			fetchResult:    nil,
-			fetchError:     nil,
+			target:         "/api/v1/user/history",
 			emptyClaims:    true,
 			wantStatusCode: http.StatusUnauthorized,
 		},
 		{
 			name:           "service error",
-			fetchResult:    nil,
+			target:         "/api/v1/user/history",
 			fetchError:     errors.New("example"),
-			emptyClaims:    false,
 			wantStatusCode: http.StatusInternalServerError,
 		},
 	}
@@ -465,7 +1299,7 @@ func TestHandleFetchUserURLHistory(t *testing.T) {
 				urlService: mockService,
 			}
 
-			rrl := httptest.NewRequest(http.MethodGet, "/api/v1/user/history", nil)
+			rrl := httptest.NewRequest(http.MethodGet, tc.target, nil)
 
 			if !tc.emptyClaims {
 				claims := &auth.Claims{
@@ -481,6 +1315,9 @@ func TestHandleFetchUserURLHistory(t *testing.T) {
 			server.handleFetchUserURLHistory(rr, rrl)
 
 			assert.Equal(t, tc.wantStatusCode, rr.Code)
+			if tc.wantStatusCode == http.StatusOK {
+				assert.Equal(t, tc.wantQuery, mockService.historyQuery)
+			}
 		})
 	}
 }
@@ -543,6 +1380,31 @@ func TestHandleGenerateQR(t *testing.T) {
 			wantStatusCode: http.StatusInternalServerError,
 		},
 
+		{
+			name:      "disabled link",
+			shortcode: "example",
+			setMockUrlService: func(mu *mockURLService) {
+				mu.FetchError = url.LinkDisabled
+			},
+			wantStatusCode: http.StatusGone,
+		},
+		{
+			name:      "flagged link",
+			shortcode: "example",
+			setMockUrlService: func(mu *mockURLService) {
+				mu.FetchError = url.LinkFlagged
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:      "protected link without access cookie",
+			shortcode: "example",
+			setMockUrlService: func(mu *mockURLService) {
+				mu.fetchRedirect = &url.Redirect{LongURL: "https://example.com/internal", Type: http.StatusFound, Protected: true}
+				mu.GenerateQRCodeResult = []byte("example")
+			},
+			wantStatusCode: http.StatusUnauthorized,
+		},
 		{
 			name:      "generate qr error",
 			shortcode: "example",
@@ -560,7 +1422,7 @@ func TestHandleGenerateQR(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			mockUrlService := &mockURLService{}
 			tc.setMockUrlService(mockUrlService)
-			server := NewServer(nil, nil, mockUrlService, nil, nil, nil, nil)
+			server := NewServer(nil, nil, mockUrlService, nil, nil, nil, nil, nil, nil, nil, nil, nil, 0, 0, 0, "https://sho.rt")
 
 			reqCtx := chi.NewRouteContext()
 
@@ -585,63 +1447,78 @@ func TestHandleGenerateQR(t *testing.T) {
 }
 
 func TestHandleCreateURL_Bulk(t *testing.T) {
+	created := func(longURL, shortCode string) url.CreateShortCodeBulkResult {
+		return url.CreateShortCodeBulkResult{LongURL: longURL, ShortCode: shortCode, Status: url.BulkItemCreated}
+	}
+
 	testCases := []struct {
-		name           string
-		input          string
-		mockResult     []url.CreateShortCodeBulkResult
-		mockError      error
-		wantStatus     int
-		wantMsg        string
-		wantResultSize int
+		name         string
+		input        string
+		mockResult   []url.CreateShortCodeBulkResult
+		mockError    error
+		wantStatus   int
+		wantMsg      string
+		wantSubmit   []string
+		wantStatuses []string
+		wantCodes    []string
 	}{
 		{
 			name:  "success",
 			input: `{"long_urls": ["https://example.com/1", "https://example.com/2"]}`,
 			mockResult: []url.CreateShortCodeBulkResult{
-				{LongURL: "https://example.com/1", ShortCode: "abc1"},
-				{LongURL: "https://example.com/2", ShortCode: "abc2"},
+				created("https://example.com/1", "abc1"),
+				created("https://example.com/2", "abc2"),
 			},
-			mockError:      nil,
-			wantStatus:     http.StatusOK,
-			wantMsg:        "Success! Bulk short URLs created",
-			wantResultSize: 2,
+			wantStatus:   http.StatusOK,
+			wantMsg:      "Success! Bulk short URLs created",
+			wantSubmit:   []string{"https://example.com/1", "https://example.com/2"},
+			wantStatuses: []string{url.BulkItemCreated, url.BulkItemCreated},
+			wantCodes:    []string{"", ""},
 		},
 		{
 			name:       "invalid request payload",
 			input:      `{"failed,"}`,
-			mockResult: nil,
-			mockError:  nil,
 			wantStatus: http.StatusBadRequest,
 			wantMsg:    "Invalid request payload",
 		},
 		{
 			name:       "empty long_urls array",
 			input:      `{"long_urls": []}`,
-			mockResult: nil,
-			mockError:  nil,
 			wantStatus: http.StatusBadRequest,
 			wantMsg:    "long_urls array cannot be empty",
 		},
 		{
-			name:       "empty URL in array",
-			input:      `{"long_urls": ["https://example.com", ""]}`,
-			mockResult: nil,
-			mockError:  nil,
+			name:       "too many URLs",
+			input:      `{"long_urls": ["https://example.com/1", "https://example.com/2", "https://example.com/3", "https://example.com/4"]}`,
 			wantStatus: http.StatusBadRequest,
-			wantMsg:    "long_urls[1] cannot be empty",
+			wantMsg:    "long_urls cannot contain more than 3 URLs",
 		},
 		{
-			name:       "invalid URL in array",
-			input:      `{"long_urls": ["https://example.com", "invalid-url"]}`,
-			mockResult: nil,
-			mockError:  nil,
-			wantStatus: http.StatusBadRequest,
-			wantMsg:    "long_urls[1] is not a valid URL",
+			name:         "empty and invalid URLs fail on their own",
+			input:        `{"long_urls": ["https://example.com", "", "invalid-url"]}`,
+			mockResult:   []url.CreateShortCodeBulkResult{created("https://example.com", "abc1")},
+			wantStatus:   http.StatusOK,
+			wantMsg:      "Bulk short URLs created, 2 of 3 failed",
+			wantSubmit:   []string{"https://example.com"},
+			wantStatuses: []string{url.BulkItemCreated, url.BulkItemFailed, url.BulkItemFailed},
+			wantCodes:    []string{"", "empty", "malformed"},
+		},
+		{
+			name:  "service rejects an item",
+			input: `{"long_urls": ["https://example.com", "https://evil.example"]}`,
+			mockResult: []url.CreateShortCodeBulkResult{
+				created("https://example.com", "abc1"),
+				url.BulkFailure("https://evil.example", url.BulkUnsafeDestination, errors.New("flagged")),
+			},
+			wantStatus:   http.StatusOK,
+			wantMsg:      "Bulk short URLs created, 1 of 2 failed",
+			wantSubmit:   []string{"https://example.com", "https://evil.example"},
+			wantStatuses: []string{url.BulkItemCreated, url.BulkItemFailed},
+			wantCodes:    []string{"", url.BulkUnsafeDestination},
 		},
 		{
 			name:       "service error",
 			input:      `{"long_urls": ["https://example.com"]}`,
-			mockResult: nil,
 			mockError:  errors.New("database error"),
 			wantStatus: http.StatusInternalServerError,
 			wantMsg:    "Failed to create short URLs",
@@ -656,7 +1533,10 @@ func TestHandleCreateURL_Bulk(t *testing.T) {
 			}
 
 			server := &Server{
-				urlService: mockURLService,
+				urlService:    mockURLService,
+				urlValidator:  utils.NewURLValidator(nil, 0),
+				maxBulkURLs:   3,
+				publicBaseURL: "https://sho.rt",
 			}
 
 			req := httptest.NewRequest(http.MethodPost, "/api/v1/url/shorten/bulk", bytes.NewBuffer([]byte(tc.input)))
@@ -667,22 +1547,338 @@ func TestHandleCreateURL_Bulk(t *testing.T) {
 
 			assert.Equal(t, tc.wantStatus, rr.Code)
 
-			if tc.wantMsg != "" {
-				var response map[string]interface{}
-				err := json.Unmarshal(rr.Body.Bytes(), &response)
-				assert.NoError(t, err)
-				assert.Equal(t, tc.wantMsg, response["message"])
+			var response struct {
+				Message string                          `json:"message"`
+				Data    []url.CreateShortCodeBulkResult `json:"data"`
 			}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, tc.wantMsg, response.Message)
 
-			if tc.wantResultSize > 0 {
-				var response map[string]interface{}
-				err := json.Unmarshal(rr.Body.Bytes(), &response)
-				assert.NoError(t, err)
+			if tc.wantStatuses == nil {
+				return
+			}
+
+			assert.Equal(t, tc.wantSubmit, mockURLService.bulkLongURLs)
+			require.Len(t, response.Data, len(tc.wantStatuses))
+			for i, item := range response.Data {
+				assert.Equal(t, tc.wantStatuses[i], item.Status)
+				assert.Equal(t, tc.wantCodes[i], item.Code)
+				if item.Status == url.BulkItemCreated {
+					assert.Equal(t, "https://sho.rt/"+item.ShortCode, item.ShortURL)
+				} else {
+					assert.NotEmpty(t, item.Error)
+					assert.Empty(t, item.ShortURL)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleAccountSelfService(t *testing.T) {
+	domainID := int64(7)
+	testCases := []struct {
+		name            string
+		method          string
+		body            string
+		handler         func(*Server) http.HandlerFunc
+		serviceErr      error
+		deleteResult    *user.DeleteAccountResult
+		emptyClaims     bool
+		wantStatusCode  int
+		wantInvalidated []url.LinkRef
+	}{
+		{
+			name:           "fetch profile",
+			method:         http.MethodGet,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleFetchProfile },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "fetch profile without claims",
+			method:         http.MethodGet,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleFetchProfile },
+			emptyClaims:    true,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "update profile",
+			method:         http.MethodPatch,
+			body:           `{"display_name": "Jane"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateProfile },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "update profile with taken email",
+			method:         http.MethodPatch,
+			body:           `{"email": "taken@mail.com"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateProfile },
+			serviceErr:     user.EmailAlreadyExists,
+			wantStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "update profile with bad payload",
+			method:         http.MethodPatch,
+			body:           `{"email":`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateProfile },
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "change password",
+			method:         http.MethodPost,
+			body:           `{"current_password": "a", "new_password": "b"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleChangePassword },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "change password with wrong current password",
+			method:         http.MethodPost,
+			body:           `{"current_password": "x", "new_password": "b"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleChangePassword },
+			serviceErr:     user.InvalidCredentials,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:            "delete account disabling links",
+			method:          http.MethodDelete,
+			body:            `{"password": "a", "links": "disable"}`,
+			handler:         func(s *Server) http.HandlerFunc { return s.handleDeleteAccount },
+			deleteResult:    &user.DeleteAccountResult{Policy: user.LinkPolicyDisable, Links: []user.LinkRef{{ShortCode: "abc"}, {ShortCode: "def", DomainID: &domainID}}},
+			wantStatusCode:  http.StatusOK,
+			wantInvalidated: []url.LinkRef{{ShortCode: "abc"}, {DomainID: 7, ShortCode: "def"}},
+		},
+		{
+			name:           "delete account keeping links",
+			method:         http.MethodDelete,
+			body:           `{"password": "a", "links": "keep"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDeleteAccount },
+			deleteResult:   &user.DeleteAccountResult{Policy: user.LinkPolicyKeep, Links: []user.LinkRef{{ShortCode: "abc"}}},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "delete account with invalid policy",
+			method:         http.MethodDelete,
+			body:           `{"password": "a", "links": "archive"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDeleteAccount },
+			serviceErr:     user.InvalidRequest,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlService := &mockURLService{}
+			server := &Server{
+				urlService: urlService,
+				userService: &mockUserService{
+					token:        "token",
+					err:          tc.serviceErr,
+					profile:      &user.User{Id: 1, Email: "example@mail.com"},
+					deleteResult: tc.deleteResult,
+				},
+			}
+
+			rrl := httptest.NewRequest(tc.method, "/api/v1/user/me", bytes.NewBufferString(tc.body))
+			if !tc.emptyClaims {
+				claims := &auth.Claims{UserID: 1, Email: "example@mail.com"}
+				rrl = rrl.WithContext(context.WithValue(rrl.Context(), shared.UserContextKey, claims))
+			}
+
+			rr := httptest.NewRecorder()
+			tc.handler(server)(rr, rrl)
+
+			assert.Equal(t, tc.wantStatusCode, rr.Code)
+			assert.Equal(t, tc.wantInvalidated, urlService.invalidated)
+		})
+	}
+}
+
+func TestHandleManageURL(t *testing.T) {
+	testCases := []struct {
+		name           string
+		method         string
+		body           string
+		handler        func(*Server) http.HandlerFunc
+		manageErr      error
+		wantStatusCode int
+		wantUpdate     url.UpdateURLRequest
+	}{
+		{
+			name:           "disable link",
+			method:         http.MethodPatch,
+			body:           `{"status": "disabled"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateURL },
+			wantStatusCode: http.StatusOK,
+			wantUpdate:     url.UpdateURLRequest{Status: url.StatusDisabled},
+		},
+		{
+			name:           "edit link details",
+			method:         http.MethodPatch,
+			body:           `{"title": "Launch post", "tags": ["blog"]}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateURL },
+			wantStatusCode: http.StatusOK,
+			wantUpdate:     url.UpdateURLRequest{Title: ptr("Launch post"), Tags: &[]string{"blog"}},
+		},
+		{
+			name:           "set link password",
+			method:         http.MethodPatch,
+			body:           `{"password": "s3cret"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateURL },
+			wantStatusCode: http.StatusOK,
+			wantUpdate:     url.UpdateURLRequest{Password: ptr("s3cret")},
+		},
+		{
+			name:           "update link with bad payload",
+			method:         http.MethodPatch,
+			body:           `{"status":`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateURL },
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "update link with invalid status",
+			method:         http.MethodPatch,
+			body:           `{"status": "archived"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateURL },
+			manageErr:      url.InvalidRequest,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "delete link",
+			method:         http.MethodDelete,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDeleteURL },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "delete link owned by someone else",
+			method:         http.MethodDelete,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDeleteURL },
+			manageErr:      url.Forbidden,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "delete unknown link",
+			method:         http.MethodDelete,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDeleteURL },
+			manageErr:      sql.ErrNoRows,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			urlService := &mockURLService{manageError: tc.manageErr}
+			server := &Server{
+				urlService: urlService,
+			}
+
+			rrl := httptest.NewRequest(tc.method, "/api/v1/url/abc", bytes.NewBufferString(tc.body))
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("shortCode", "abc")
+			rrl = rrl.WithContext(context.WithValue(rrl.Context(), chi.RouteCtxKey, rctx))
+
+			rr := httptest.NewRecorder()
+			tc.handler(server)(rr, rrl)
+
+			assert.Equal(t, tc.wantStatusCode, rr.Code)
+			if tc.wantStatusCode == http.StatusOK && tc.method == http.MethodPatch {
+				assert.Equal(t, tc.wantUpdate, urlService.update)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestHandleTwoFactor(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		handler        func(*Server) http.HandlerFunc
+		serviceErr     error
+		emptyClaims    bool
+		wantStatusCode int
+	}{
+		{
+			name:           "verify login code",
+			body:           `{"challenge_token": "challenge", "code": "123456"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleVerifyTwoFactor },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "verify login with wrong code",
+			body:           `{"challenge_token": "challenge", "code": "000000"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleVerifyTwoFactor },
+			serviceErr:     user.InvalidTwoFactorCode,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "verify login after too many attempts",
+			body:           `{"challenge_token": "challenge", "code": "123456"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleVerifyTwoFactor },
+			serviceErr:     user.TooManyTwoFactorAttempts,
+			wantStatusCode: http.StatusTooManyRequests,
+		},
+		{
+			name:           "verify login with bad payload",
+			body:           `{"code":`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleVerifyTwoFactor },
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "enroll",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleEnrollTwoFactor },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "enroll without claims",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleEnrollTwoFactor },
+			emptyClaims:    true,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "enroll when already enabled",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleEnrollTwoFactor },
+			serviceErr:     user.InvalidRequest,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "confirm",
+			body:           `{"code": "123456"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleConfirmTwoFactor },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "disable",
+			body:           `{"password": "a", "code": "123456"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDisableTwoFactor },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "disable with wrong password",
+			body:           `{"password": "x", "code": "123456"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleDisableTwoFactor },
+			serviceErr:     user.InvalidCredentials,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{
+				userService: &mockUserService{token: "token", err: tc.serviceErr},
+			}
 
-				data, ok := response["data"].([]interface{})
-				assert.True(t, ok)
-				assert.Equal(t, tc.wantResultSize, len(data))
+			rrl := httptest.NewRequest(http.MethodPost, "/api/v1/user/2fa", bytes.NewBufferString(tc.body))
+			if !tc.emptyClaims {
+				claims := &auth.Claims{UserID: 1, Email: "example@mail.com"}
+				rrl = rrl.WithContext(context.WithValue(rrl.Context(), shared.UserContextKey, claims))
 			}
+
+			rr := httptest.NewRecorder()
+			tc.handler(server)(rr, rrl)
+
+			assert.Equal(t, tc.wantStatusCode, rr.Code)
 		})
 	}
 }
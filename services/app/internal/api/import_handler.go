@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/domain"
+	"hafiztri123/app-link-shortener/internal/linkimport"
+	"hafiztri123/app-link-shortener/internal/response"
+	"hafiztri123/app-link-shortener/internal/url"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// handleImportURLs creates links from an uploaded CSV or NDJSON file. Small
+// files are imported while the caller waits, streaming one NDJSON result
+// per row; large files, or any file with ?async=true, are queued and a job
+// is returned to poll.
+func (s *Server) handleImportURLs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.importMaxBytes)
+
+	rows, err := linkimport.Parse(r.Header.Get("Content-Type"), r.Body, s.importMaxRows)
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	async, _ := strconv.ParseBool(r.URL.Query().Get("async"))
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	streaming := false
+
+	job, err := s.importService.Import(r.Context(), rows, async, func(results []linkimport.Result) error {
+		if !streaming {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			streaming = true
+		}
+
+		for i := range results {
+			if results[i].Status == url.BulkItemCreated {
+				results[i].ShortURL = s.shortURL("", results[i].ShortCode)
+			}
+			if err := encoder.Encode(results[i]); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if streaming {
+			// The status is already sent; the last line tells the caller
+			// the rows after it were not imported.
+			slog.Error("Link import stopped", "error", err)
+			encoder.Encode(map[string]string{"error": "import stopped unexpectedly, the rows after the last result were not imported"})
+			return
+		}
+		writeImportError(w, err)
+		return
+	}
+
+	if job != nil {
+		response.Success(w, "Import queued", http.StatusAccepted, job)
+	}
+}
+
+func (s *Server) handleFetchImportJob(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	job, err := s.importService.Job(r.Context(), claims.UserID, chi.URLParam(r, "jobID"))
+	if err != nil {
+		writeImportError(w, err)
+		return
+	}
+
+	response.Success(w, "success fetching import job", http.StatusOK, job)
+}
+
+// handleExportURLs streams every link in the caller's scope as CSV, with
+// how often each was opened.
+func (s *Server) handleExportURLs(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	hostnames := map[int64]string{}
+	if s.domainService != nil {
+		owner := domain.Owner{UserID: claims.UserID}
+		if member := workspace.GetMemberFromContext(r.Context()); member != nil {
+			owner.WorkspaceID = &member.WorkspaceID
+		}
+		domains, err := s.domainService.List(r.Context(), owner)
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, "Failed to export links")
+			return
+		}
+		for _, d := range domains {
+			hostnames[d.ID] = d.Hostname
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="links.csv"`)
+		writer.Write(exportHeader)
+		started = true
+	}
+
+	err = s.urlService.ExportLinks(r.Context(), claims.UserID, func(rows []url.ExportRow) error {
+		start()
+
+		for _, row := range rows {
+			link := row.Link
+
+			var expiresAt string
+			if link.ExpiresAt.Valid {
+				expiresAt = link.ExpiresAt.Time.UTC().Format(time.RFC3339)
+			}
+
+			record := []string{
+				link.ShortCode.String,
+				s.shortURL(hostnames[link.DomainID.Int64], link.ShortCode.String),
+				link.LongURL,
+				link.Title.String,
+				link.Description.String,
+				strings.Join(link.Tags, ";"),
+				link.Status,
+				link.CreatedAt.UTC().Format(time.RFC3339),
+				expiresAt,
+				strconv.FormatInt(row.Clicks, 10),
+			}
+			for i, cell := range record {
+				record[i] = linkimport.EscapeCell(cell)
+			}
+			writer.Write(record)
+		}
+
+		writer.Flush()
+		return writer.Error()
+	})
+	if err != nil {
+		if started {
+			slog.Error("Link export stopped", "error", err)
+			return
+		}
+		response.Error(w, http.StatusInternalServerError, "Failed to export links")
+		return
+	}
+
+	// An empty export still gets its header row.
+	start()
+	writer.Flush()
+}
+
+// exportHeader names the columns of an export. Its long_url, title,
+// description, tags and expires_at columns can be imported again.
+var exportHeader = []string{"short_code", "short_url", "long_url", "title", "description", "tags", "status", "created_at", "expires_at", "clicks"}
+
+func writeImportError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var invalidFileErr *linkimport.InvalidFileErr
+	var unsupportedErr *linkimport.UnsupportedFormatErr
+	var notFoundErr *linkimport.JobNotFoundErr
+	var forbiddenErr *linkimport.ForbiddenErr
+	var urlForbiddenErr *url.ForbiddenErr
+	switch {
+	case errors.As(err, &maxBytesErr):
+		response.Error(w, http.StatusRequestEntityTooLarge, "Import file is too large")
+	case errors.As(err, &invalidFileErr):
+		response.Error(w, http.StatusBadRequest, "Invalid import file: "+err.Error())
+	case errors.As(err, &unsupportedErr):
+		response.Error(w, http.StatusUnsupportedMediaType, err.Error())
+	case errors.As(err, &notFoundErr):
+		response.Error(w, http.StatusNotFound, err.Error())
+	case errors.As(err, &forbiddenErr), errors.As(err, &urlForbiddenErr):
+		response.Error(w, http.StatusForbidden, err.Error())
+	default:
+		response.Error(w, http.StatusInternalServerError, "Failed to import links")
+	}
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/linkimport"
+	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/url"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"hpj/hv1-link-shortener/shared/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockLinkImportQueue struct {
+	published *models.LinkImport
+}
+
+func (m *mockLinkImportQueue) PublishLinkImport(ctx context.Context, job *models.LinkImport) error {
+	m.published = job
+	return nil
+}
+
+func TestHandleImportURLs(t *testing.T) {
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		query       string
+		member      *workspace.Member
+		wantStatus  int
+		wantBody    []string
+	}{
+		{
+			name:        "csv streams results",
+			contentType: "text/csv",
+			body:        "long_url,tags\nhttps://example.com,a;b\nexample.com,\n",
+			wantStatus:  http.StatusOK,
+			wantBody: []string{
+				`{"row":1,"long_url":"https://example.com","short_code":"abc","short_url":"https://sho.rt/abc","status":"created"}`,
+				`"row":2,"long_url":"example.com","status":"failed","error":"URL must be absolute","code":"malformed"`,
+			},
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body:        `{"long_url":"https://example.com"}` + "\n",
+			wantStatus:  http.StatusOK,
+			wantBody:    []string{`"short_url":"https://sho.rt/abc"`},
+		},
+		{
+			name:        "queued when async",
+			contentType: "text/csv",
+			body:        "long_url\nhttps://example.com\n",
+			query:       "?async=true",
+			wantStatus:  http.StatusAccepted,
+			wantBody:    []string{`"status":"queued"`, `"total":1`},
+		},
+		{
+			name:        "unsupported format",
+			contentType: "application/pdf",
+			body:        "%PDF",
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "invalid file",
+			contentType: "text/csv",
+			body:        "url\nhttps://example.com\n",
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    []string{"Invalid import file: line 1: header must have a long_url column"},
+		},
+		{
+			name:        "too many rows",
+			contentType: "text/csv",
+			body:        "long_url\nhttps://a.example\nhttps://b.example\nhttps://c.example\n",
+			wantStatus:  http.StatusBadRequest,
+			wantBody:    []string{"file has more than 2 rows"},
+		},
+		{
+			name:        "too large",
+			contentType: "text/csv",
+			body:        "long_url\nhttps://example.com/" + strings.Repeat("a", 1024) + "\n",
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "viewer cannot import",
+			contentType: "text/csv",
+			body:        "long_url\nhttps://example.com\n",
+			member:      &workspace.Member{WorkspaceID: 7, Role: workspace.RoleViewer},
+			wantStatus:  http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redisClient, redisMock := redismock.NewClientMock()
+			redisMock.Regexp().ExpectSet(`import:[0-9a-f]{32}`, `.*`, 24*time.Hour).SetVal("OK")
+
+			urlService := &mockURLService{
+				createBulkResult: []url.CreateShortCodeBulkResult{{LongURL: "https://example.com", ShortCode: "abc", Status: url.BulkItemCreated}},
+			}
+			queue := &mockLinkImportQueue{}
+			validator := utils.NewURLValidator(nil, 0)
+			server := &Server{
+				urlService:     urlService,
+				importService:  linkimport.NewService(urlService, validator, nil, redisClient, queue, 10),
+				urlValidator:   validator,
+				importMaxBytes: 512,
+				importMaxRows:  2,
+				publicBaseURL:  "https://sho.rt",
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/url/import"+tc.query, bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			ctx := context.WithValue(req.Context(), shared.UserContextKey, &auth.Claims{UserID: 1})
+			if tc.member != nil {
+				ctx = context.WithValue(ctx, shared.WorkspaceContextKey, tc.member)
+			}
+			rr := httptest.NewRecorder()
+
+			server.handleImportURLs(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tc.wantStatus, rr.Code, rr.Body.String())
+			for _, want := range tc.wantBody {
+				assert.Contains(t, rr.Body.String(), want)
+			}
+			if tc.wantStatus == http.StatusAccepted {
+				assert.NotNil(t, queue.published)
+			}
+		})
+	}
+}
+
+func TestHandleFetchImportJob(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectGet("import:abc").SetVal(`{"id":"abc","user_id":1,"status":"running","total":250,"processed":100}`)
+	redisMock.ExpectLRange("import:abc:results", 0, -1).SetVal([]string{`{"row":1,"long_url":"https://example.com","short_code":"abc","status":"created"}`})
+	redisMock.ExpectGet("import:abc").SetVal(`{"id":"abc","user_id":1,"status":"running","total":250,"processed":100}`)
+
+	server := &Server{importService: linkimport.NewService(nil, nil, nil, redisClient, nil, 0)}
+
+	fetch := func(userID int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/url/import/abc", nil)
+		routeCtx := chi.NewRouteContext()
+		routeCtx.URLParams.Add("jobID", "abc")
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, routeCtx)
+		ctx = context.WithValue(ctx, shared.UserContextKey, &auth.Claims{UserID: userID})
+		rr := httptest.NewRecorder()
+		server.handleFetchImportJob(rr, req.WithContext(ctx))
+		return rr
+	}
+
+	rr := fetch(1)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"processed":100`)
+	assert.Contains(t, rr.Body.String(), `"short_code":"abc"`)
+	assert.NotContains(t, rr.Body.String(), "user_id")
+
+	assert.Equal(t, http.StatusNotFound, fetch(2).Code)
+}
+
+func TestHandleExportURLs(t *testing.T) {
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		rows       []url.ExportRow
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "links with click totals",
+			rows: []url.ExportRow{
+				{Link: &url.URL{ShortCode: sql.NullString{String: "abc", Valid: true}, LongURL: "https://example.com/a,b", Status: url.StatusActive, CreatedAt: createdAt, Title: sql.NullString{String: "Home", Valid: true}, Tags: []string{"docs", "team"}}, Clicks: 42},
+				{Link: &url.URL{ShortCode: sql.NullString{String: "def", Valid: true}, LongURL: "https://example.org", Status: url.StatusDisabled, CreatedAt: createdAt, ExpiresAt: sql.NullTime{Time: createdAt.Add(time.Hour), Valid: true}}},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: "short_code,short_url,long_url,title,description,tags,status,created_at,expires_at,clicks\n" +
+				"abc,https://sho.rt/abc,\"https://example.com/a,b\",Home,,docs;team,active,2024-05-01T12:00:00Z,,42\n" +
+				"def,https://sho.rt/def,https://example.org,,,,disabled,2024-05-01T12:00:00Z,2024-05-01T13:00:00Z,0\n",
+		},
+		{
+			name: "formula cells are escaped",
+			rows: []url.ExportRow{
+				{Link: &url.URL{ShortCode: sql.NullString{String: "abc", Valid: true}, LongURL: "https://example.com", Status: url.StatusActive, CreatedAt: createdAt, Title: sql.NullString{String: "=HYPERLINK(\"https://evil.example\")", Valid: true}, Description: sql.NullString{String: "@SUM(A1)", Valid: true}, Tags: []string{"-1+1", "+cmd"}}},
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "abc,https://sho.rt/abc,https://example.com,\"'=HYPERLINK(\"\"https://evil.example\"\")\",'@SUM(A1),'-1+1;+cmd,active,",
+		},
+		{
+			name:       "no links",
+			wantStatus: http.StatusOK,
+			wantBody:   "short_code,short_url,long_url,title,description,tags,status,created_at,expires_at,clicks\n",
+		},
+		{
+			name:       "failure",
+			err:        errors.New("db down"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Failed to export links",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{
+				urlService:    &mockURLService{exportRows: tc.rows, exportError: tc.err},
+				publicBaseURL: "https://sho.rt",
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/user/export", nil)
+			ctx := context.WithValue(req.Context(), shared.UserContextKey, &auth.Claims{UserID: 1})
+			rr := httptest.NewRecorder()
+
+			server.handleExportURLs(rr, req.WithContext(ctx))
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.Contains(t, rr.Body.String(), tc.wantBody)
+			if tc.wantStatus == http.StatusOK {
+				assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+				assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+			}
+		})
+	}
+}
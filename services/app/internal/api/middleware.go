@@ -2,13 +2,16 @@ package api
 
 import (
 	"context"
+	"errors"
 	"hafiztri123/app-link-shortener/internal/auth"
 	"hafiztri123/app-link-shortener/internal/response"
 	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/workspace"
 	"hpj/hv1-link-shortener/shared/models"
 	"log/slog"
 	"net"
 	"net/http"
+	neturl "net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -34,9 +37,16 @@ func RateLimiter(r rate.Limit, b int) func(http.Handler) http.Handler {
 }
 
 func RedisRateLimiter(redisClient *redis.Client, limit int, window time.Duration) func(http.Handler) http.Handler {
+	return redisRateLimiter(redisClient, limit, window, "", response.Error)
+}
+
+// redisRateLimiter counts requests per client under keyPrefix, so routes with
+// different limits keep separate counts, and reports rejections with
+// writeError.
+func redisRateLimiter(redisClient *redis.Client, limit int, window time.Duration, keyPrefix string, writeError errorWriter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ip := r.RemoteAddr
+			ip := keyPrefix + r.RemoteAddr
 			now := time.Now().UnixNano()
 			windowStart := now - window.Nanoseconds()
 
@@ -58,7 +68,7 @@ func RedisRateLimiter(redisClient *redis.Client, limit int, window time.Duration
 			countCmd := cmds[2].(*redis.IntCmd)
 
 			if countCmd.Val() > int64(limit) {
-				response.Error(w, http.StatusTooManyRequests, "Too many requests")
+				writeError(w, http.StatusTooManyRequests, "Too many requests")
 				return
 			}
 
@@ -79,7 +89,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddleware(ts *auth.TokenService, permissive bool) func(http.Handler) http.Handler {
+func AuthMiddleware(ts *auth.TokenService, sessions auth.SessionValidator, permissive bool) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -109,12 +119,62 @@ func AuthMiddleware(ts *auth.TokenService, permissive bool) func(http.Handler) h
 				return
 			}
 
+			if sessions != nil {
+				if err := sessions.ValidateSession(r.Context(), claims); err != nil {
+					response.Error(w, http.StatusUnauthorized, "session is no longer valid")
+					return
+				}
+			}
+
 			ctx := context.WithValue(r.Context(), shared.UserContextKey, claims)
 			h.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// WorkspaceMiddleware resolves the X-Workspace-ID header into the caller's
+// membership so that downstream handlers act in that workspace's scope. It
+// must run after AuthMiddleware; requests without the header stay personal.
+func WorkspaceMiddleware(ws workspace.WorkspaceService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("X-Workspace-ID")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims, err := auth.GetUserFromContext(r.Context())
+			if err != nil {
+				response.Error(w, http.StatusUnauthorized, "authorization header required")
+				return
+			}
+
+			workspaceID, err := strconv.ParseInt(header, 10, 64)
+			if err != nil {
+				response.Error(w, http.StatusBadRequest, "invalid X-Workspace-ID header")
+				return
+			}
+
+			member, err := ws.GetMembership(r.Context(), workspaceID, claims.UserID)
+			if err != nil {
+				var notFoundErr *workspace.WorkspaceNotFoundErr
+				if errors.As(err, &notFoundErr) {
+					response.Error(w, http.StatusNotFound, err.Error())
+					return
+				}
+
+				slog.Error("failed to resolve workspace membership", "error", err, "workspace_id", workspaceID)
+				response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), shared.WorkspaceContextKey, member)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func MetadataMiddleware(db *maxminddb.Reader) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -167,8 +227,30 @@ func MetadataMiddleware(db *maxminddb.Reader) func(http.Handler) http.Handler {
 				Country:   country,
 				City:      city,
 			}
+			setClickUTM(clickData, r.URL.Query())
 
 			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), shared.ClickDataKey, clickData)))
 		})
 	}
 }
+
+// maxClickUTMLength caps the campaign values recorded on a click; visitors
+// can put anything in a query string.
+const maxClickUTMLength = 255
+
+// setClickUTM records the UTM parameters of query on click.
+func setClickUTM(click *models.Click, query neturl.Values) {
+	for key, field := range map[string]*string{
+		"utm_source":   &click.UTMSource,
+		"utm_medium":   &click.UTMMedium,
+		"utm_campaign": &click.UTMCampaign,
+		"utm_term":     &click.UTMTerm,
+		"utm_content":  &click.UTMContent,
+	} {
+		value := []rune(strings.TrimSpace(query.Get(key)))
+		if len(value) > maxClickUTMLength {
+			value = value[:maxClickUTMLength]
+		}
+		*field = string(value)
+	}
+}
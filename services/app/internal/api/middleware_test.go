@@ -5,9 +5,13 @@ import (
 	"context"
 	"fmt"
 	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/user"
+	"hpj/hv1-link-shortener/shared/models"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	neturl "net/url"
+	"strings"
 	"testing"
 	"time"
 
@@ -139,7 +143,7 @@ func TestRedisRateLimiter_Integration(t *testing.T) {
 func TestAuthMiddleware(t *testing.T) {
 	tokenService := auth.NewTokenService("test123")
 	reqBody := "test message"
-	token, err := tokenService.GenerateToken(1, "example@mail.com")
+	token, err := tokenService.GenerateToken(1, "example@mail.com", 0)
 
 	assert.NoError(t, err)
 
@@ -147,6 +151,7 @@ func TestAuthMiddleware(t *testing.T) {
 		name           string
 		token          string
 		permissive     bool
+		sessions       auth.SessionValidator
 		wantStatusCode int
 		wantBody       string
 	}{
@@ -188,6 +193,22 @@ func TestAuthMiddleware(t *testing.T) {
 			wantStatusCode: http.StatusOK,
 			wantBody:       reqBody,
 		},
+
+		{
+			name:           "valid session",
+			token:          "Bearer " + token,
+			sessions:       &mockUserService{},
+			wantStatusCode: http.StatusOK,
+			wantBody:       reqBody,
+		},
+
+		{
+			name:           "revoked session",
+			token:          "Bearer " + token,
+			sessions:       &mockUserService{err: user.InvalidCredentials},
+			wantStatusCode: http.StatusUnauthorized,
+			wantBody:       "session is no longer valid",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -198,7 +219,7 @@ func TestAuthMiddleware(t *testing.T) {
 				w.Write([]byte(reqBody))
 			})
 
-			middleware := AuthMiddleware(tokenService, tc.permissive)
+			middleware := AuthMiddleware(tokenService, tc.sessions, tc.permissive)
 			handler := middleware(testHandler)
 
 			rrl, _ := http.NewRequest(http.MethodGet, "/", nil)
@@ -217,3 +238,17 @@ func TestAuthMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestSetClickUTM(t *testing.T) {
+	query, err := neturl.ParseQuery("utm_source=newsletter&utm_campaign=+spring+&utm_source=other&ref=x&utm_term=" + strings.Repeat("a", maxClickUTMLength+1))
+	require.NoError(t, err)
+
+	click := &models.Click{}
+	setClickUTM(click, query)
+
+	assert.Equal(t, &models.Click{
+		UTMSource:   "newsletter",
+		UTMCampaign: "spring",
+		UTMTerm:     strings.Repeat("a", maxClickUTMLength),
+	}, click)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/response"
+	"hafiztri123/app-link-shortener/internal/user"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	oidcCookieName = "oidc_login"
+	oidcCookiePath = "/api/v1/user/oidc"
+	oidcLoginTTL   = 10 * time.Minute
+)
+
+// oidcLogin is the per-attempt secret kept in a short-lived cookie between
+// the redirect to the identity provider and the callback.
+type oidcLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		response.Error(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	var login oidcLogin
+	for _, field := range []*string{&login.State, &login.Nonce, &login.Verifier} {
+		token, err := auth.RandomToken()
+		if err != nil {
+			response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+			return
+		}
+		*field = token
+	}
+
+	payload, err := json.Marshal(login)
+	if err != nil {
+		response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(payload),
+		Path:     oidcCookiePath,
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, s.oidc.AuthCodeURL(login.State, login.Nonce, login.Verifier), http.StatusFound)
+}
+
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		response.Error(w, http.StatusNotFound, "single sign-on is not configured")
+		return
+	}
+
+	login, ok := readOIDCLogin(r)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    "",
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	state := r.URL.Query().Get("state")
+	if !ok || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(login.State)) != 1 {
+		response.Error(w, http.StatusBadRequest, "invalid or expired login attempt")
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		response.Error(w, http.StatusUnauthorized, "single sign-on was not completed")
+		return
+	}
+
+	identity, err := s.oidc.Exchange(r.Context(), code, login.Verifier, login.Nonce)
+	if err != nil {
+		slog.Warn("single sign-on exchange failed", "error", err)
+		response.Error(w, http.StatusUnauthorized, "single sign-on failed, please try again")
+		return
+	}
+
+	session, err := s.userService.LoginWithOIDC(r.Context(), identity)
+	if err != nil {
+		switch err.(type) {
+		case *user.UnverifiedEmailErr:
+			response.Error(w, http.StatusForbidden, err.Error())
+		case *user.EmailAlreadyExistsErr:
+			response.Error(w, http.StatusConflict, err.Error())
+		default:
+			response.Error(w, http.StatusInternalServerError, "something has occured, please try again later")
+		}
+		return
+	}
+
+	writeLogin(w, session)
+}
+
+// handleOIDCLink links a single sign-on identity to the account holding its
+// email, once the account's password is given.
+func (s *Server) handleOIDCLink(w http.ResponseWriter, r *http.Request) {
+	var req user.LinkIdentityRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	login, err := s.userService.LinkOIDCIdentity(r.Context(), req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	writeLogin(w, login)
+}
+
+func writeLogin(w http.ResponseWriter, login *user.LoginResponse) {
+	switch {
+	case login.LinkRequired:
+		response.Success(w, "An account with this email already exists, enter its password to link single sign-on", http.StatusOK, login)
+	case login.TwoFactorRequired:
+		response.Success(w, "Two-factor code required", http.StatusOK, login)
+	default:
+		response.Success(w, "Success", http.StatusOK, login)
+	}
+}
+
+func readOIDCLogin(r *http.Request) (oidcLogin, bool) {
+	var login oidcLogin
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return login, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return login, false
+	}
+
+	if err := json.Unmarshal(payload, &login); err != nil {
+		return login, false
+	}
+
+	return login, true
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/auth/oidctest"
+	"hafiztri123/app-link-shortener/internal/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCLoginFlow(t *testing.T) {
+	testCases := []struct {
+		name           string
+		tamperState    bool
+		tamperCode     bool
+		dropCookie     bool
+		serviceErr     error
+		wantStatusCode int
+	}{
+		{
+			name:           "success",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "state mismatch",
+			tamperState:    true,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "code rejected by the provider",
+			tamperCode:     true,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "missing login cookie",
+			dropCookie:     true,
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "unverified email",
+			serviceErr:     user.UnverifiedEmail,
+			wantStatusCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp := oidctest.NewProvider("app")
+			t.Cleanup(idp.Close)
+
+			provider, err := auth.NewOIDCProvider(context.Background(), auth.OIDCConfig{
+				IssuerURL:    idp.Issuer(),
+				ClientID:     "app",
+				ClientSecret: "secret",
+				RedirectURL:  "http://localhost:8080/api/v1/user/oidc/callback",
+			}, nil)
+			require.NoError(t, err)
+
+			server := &Server{
+				oidc:          provider,
+				userService:   &mockUserService{token: "token", err: tc.serviceErr},
+				publicBaseURL: "https://sho.rt",
+			}
+
+			loginReq := httptest.NewRequest(http.MethodGet, "/api/v1/user/oidc/login", nil)
+			loginRec := httptest.NewRecorder()
+			server.handleOIDCLogin(loginRec, loginReq)
+
+			require.Equal(t, http.StatusFound, loginRec.Code)
+			cookies := loginRec.Result().Cookies()
+			require.Len(t, cookies, 1)
+			assert.True(t, cookies[0].HttpOnly)
+			assert.True(t, cookies[0].Secure, "behind a TLS-terminating proxy the cookie is still https-only")
+
+			code, state, err := idp.Authorize(loginRec.Header().Get("Location"))
+			require.NoError(t, err)
+
+			if tc.tamperState {
+				state = "forged"
+			}
+			if tc.tamperCode {
+				code = "forged"
+			}
+
+			callbackReq := httptest.NewRequest(http.MethodGet, "/api/v1/user/oidc/callback?code="+code+"&state="+state, nil)
+			if !tc.dropCookie {
+				callbackReq.AddCookie(cookies[0])
+			}
+			callbackRec := httptest.NewRecorder()
+			server.handleOIDCCallback(callbackRec, callbackReq)
+
+			assert.Equal(t, tc.wantStatusCode, callbackRec.Code)
+			if tc.tamperCode {
+				assert.Contains(t, callbackRec.Body.String(), "single sign-on failed, please try again", "provider errors stay in the logs")
+			}
+
+			if tc.wantStatusCode == http.StatusOK {
+				var body struct {
+					Data user.LoginResponse `json:"data"`
+				}
+				require.NoError(t, json.NewDecoder(callbackRec.Body).Decode(&body))
+				assert.Equal(t, "token", body.Data.Token)
+			}
+		})
+	}
+}
+
+func TestOIDCNotConfigured(t *testing.T) {
+	server := &Server{}
+
+	rr := httptest.NewRecorder()
+	server.handleOIDCLogin(rr, httptest.NewRequest(http.MethodGet, "/api/v1/user/oidc/login", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestHandleOIDCLink(t *testing.T) {
+	testCases := []struct {
+		name           string
+		body           string
+		service        *mockUserService
+		wantStatusCode int
+		want           user.LoginResponse
+	}{
+		{
+			name:           "linked",
+			body:           `{"link_token": "link", "password": "admin"}`,
+			service:        &mockUserService{token: "token"},
+			wantStatusCode: http.StatusOK,
+			want:           user.LoginResponse{Token: "token"},
+		},
+		{
+			name:           "second factor required",
+			body:           `{"link_token": "link", "password": "admin"}`,
+			service:        &mockUserService{challenge: "challenge"},
+			wantStatusCode: http.StatusOK,
+			want:           user.LoginResponse{TwoFactorRequired: true, ChallengeToken: "challenge"},
+		},
+		{
+			name:           "wrong password",
+			body:           `{"link_token": "link", "password": "guess"}`,
+			service:        &mockUserService{err: user.InvalidCredentials},
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid payload",
+			body:           `{`,
+			service:        &mockUserService{},
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{userService: tc.service}
+
+			rr := httptest.NewRecorder()
+			server.handleOIDCLink(rr, httptest.NewRequest(http.MethodPost, "/api/v1/user/oidc/link", strings.NewReader(tc.body)))
+
+			assert.Equal(t, tc.wantStatusCode, rr.Code)
+			if tc.wantStatusCode == http.StatusOK {
+				var body struct {
+					Data user.LoginResponse `json:"data"`
+				}
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&body))
+				assert.Equal(t, tc.want, body.Data)
+			}
+		})
+	}
+}
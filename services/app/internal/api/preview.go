@@ -0,0 +1,126 @@
+package api
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	neturl "net/url"
+	"strings"
+)
+
+// socialCrawlers are fragments of the user agents of the bots social
+// platforms and chat apps send to unfurl shared links. Search engine
+// crawlers are left out: they should follow the redirect.
+var socialCrawlers = []string{
+	"facebookexternalhit",
+	"facebot",
+	"twitterbot",
+	"linkedinbot",
+	"slackbot",
+	"discordbot",
+	"telegrambot",
+	"whatsapp",
+	"pinterest",
+	"redditbot",
+	"skypeuripreview",
+	"vkshare",
+	"embedly",
+	"iframely",
+	"mastodon",
+	"bluesky",
+}
+
+// isSocialCrawler reports whether userAgent belongs to a link unfurling bot.
+func isSocialCrawler(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, crawler := range socialCrawlers {
+		if strings.Contains(userAgent, crawler) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+<meta property="og:type" content="website">
+<meta property="og:url" content="{{.ShortURL}}">
+<meta property="og:title" content="{{.Title}}">
+{{if .Description}}<meta property="og:description" content="{{.Description}}">
+<meta name="description" content="{{.Description}}">
+{{end}}{{if .ImageURL}}<meta property="og:image" content="{{.ImageURL}}">
+<meta name="twitter:card" content="summary_large_image">
+{{else}}<meta name="twitter:card" content="summary">
+{{end}}{{if .FaviconURL}}<link rel="icon" href="{{.FaviconURL}}">
+{{end}}<style>
+body { font-family: system-ui, sans-serif; margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; color: #222; background: #f6f6f6; }
+main { max-width: 32rem; padding: 2rem; background: #fff; border-radius: 0.5rem; }
+img { max-width: 100%; border-radius: 0.25rem; }
+.destination { word-break: break-all; color: #555; }
+</style>
+</head>
+<body>
+<main>
+{{if .ImageURL}}<img src="{{.ImageURL}}" alt="">
+{{end}}<h1>{{.Title}}</h1>
+{{if .Description}}<p>{{.Description}}</p>
+{{end}}<p>This link leads to:</p>
+<p class="destination">{{.LongURL}}</p>
+<p><a href="{{.ShortURL}}" rel="nofollow">Continue</a></p>
+</main>
+</body>
+</html>
+`))
+
+// writePreview serves the preview page of shortCode on hostname: the link's
+// Open Graph tags for crawlers, and where it leads for people. It does not
+// count as a visit.
+func (s *Server) writePreview(w http.ResponseWriter, r *http.Request, hostname string, shortCode string, writeError errorWriter, writeWarning errorWriter) {
+	preview, err := s.urlService.FetchPreview(r.Context(), hostname, shortCode)
+	if err != nil {
+		writeLinkError(w, err, writeError, writeWarning)
+		return
+	}
+
+	// Protected links reveal nothing, not even to crawlers, before the
+	// password is entered.
+	if preview.Protected && !s.linkUnlocked(r, hostname, shortCode, preview.PasswordStamp) {
+		writePasswordPage(w, http.StatusUnauthorized, "")
+		return
+	}
+
+	// Pages without a title of their own are named after their host.
+	title := preview.Title
+	if title == "" {
+		title = preview.LongURL
+		if dest, err := neturl.Parse(preview.LongURL); err == nil && dest.Host != "" {
+			title = dest.Host
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if preview.Protected {
+		w.Header().Set("Cache-Control", "private, no-store")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	err = previewPage.Execute(w, struct {
+		ShortURL    string
+		LongURL     string
+		Title       string
+		Description string
+		ImageURL    string
+		FaviconURL  string
+	}{s.shortURL(hostname, preview.ShortCode), preview.LongURL, title, preview.Description, preview.ImageURL, preview.FaviconURL})
+	if err != nil {
+		slog.Error("Failed to write preview page", "error", err)
+	}
+}
@@ -2,11 +2,18 @@ package api
 
 import (
 	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/domain"
+	"hafiztri123/app-link-shortener/internal/linkimport"
 	"hafiztri123/app-link-shortener/internal/metrics"
 	"hafiztri123/app-link-shortener/internal/rabbitmq"
+	"hafiztri123/app-link-shortener/internal/response"
 	"hafiztri123/app-link-shortener/internal/url"
 	"hafiztri123/app-link-shortener/internal/user"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"hafiztri123/app-link-shortener/internal/workspace"
 	"net/http"
+	neturl "net/url"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -20,56 +27,151 @@ type DB interface {
 }
 
 type Server struct {
-	db           DB
-	redis        *redis.Client
-	urlService   url.URLService
-	userService  user.UserService
-	tokenService *auth.TokenService
-	geoDb        *maxminddb.Reader
-	rabbitMq     *rabbitmq.RabbitMQ
+	db               DB
+	redis            *redis.Client
+	urlService       url.URLService
+	userService      user.UserService
+	workspaceService workspace.WorkspaceService
+	domainService    domain.DomainService
+	importService    linkimport.ImportService
+	tokenService     *auth.TokenService
+	oidc             *auth.OIDCProvider
+	geoDb            *maxminddb.Reader
+	rabbitMq         *rabbitmq.RabbitMQ
+	urlValidator     *utils.URLValidator
+	maxBulkURLs      int
+	importMaxBytes   int64
+	importMaxRows    int
+	publicBaseURL    string
+	publicHost       string
 }
 
-func NewServer(db DB, redis *redis.Client, urlService url.URLService, userService user.UserService, ts *auth.TokenService, geoDb *maxminddb.Reader, rabbitMq *rabbitmq.RabbitMQ) *Server {
+// NewServer wires the HTTP server. publicBaseURL is where the root redirect
+// route is reachable; short URLs handed out are built from it. Requests for
+// any other host are resolved against the verified custom domains.
+// urlValidator screens the destinations of new links, and at most
+// maxBulkURLs can be shortened in one request. Import files are refused
+// beyond importMaxBytes or importMaxRows rows.
+func NewServer(db DB, redis *redis.Client, urlService url.URLService, userService user.UserService, workspaceService workspace.WorkspaceService, domainService domain.DomainService, importService linkimport.ImportService, ts *auth.TokenService, oidc *auth.OIDCProvider, geoDb *maxminddb.Reader, rabbitMq *rabbitmq.RabbitMQ, urlValidator *utils.URLValidator, maxBulkURLs int, importMaxBytes int64, importMaxRows int, publicBaseURL string) *Server {
+	var publicHost string
+	if base, err := neturl.Parse(publicBaseURL); err == nil {
+		publicHost = strings.ToLower(base.Hostname())
+	}
+
 	return &Server{
-		db:           db,
-		redis:        redis,
-		urlService:   urlService,
-		userService:  userService,
-		tokenService: ts,
-		geoDb:        geoDb,
-		rabbitMq:     rabbitMq,
+		db:               db,
+		redis:            redis,
+		urlService:       urlService,
+		userService:      userService,
+		workspaceService: workspaceService,
+		domainService:    domainService,
+		importService:    importService,
+		tokenService:     ts,
+		oidc:             oidc,
+		geoDb:            geoDb,
+		rabbitMq:         rabbitMq,
+		urlValidator:     urlValidator,
+		maxBulkURLs:      maxBulkURLs,
+		importMaxBytes:   importMaxBytes,
+		importMaxRows:    importMaxRows,
+		publicBaseURL:    strings.TrimSuffix(publicBaseURL, "/"),
+		publicHost:       publicHost,
 	}
 }
 
 func (s *Server) RegisterRoutes() http.Handler {
 	r := chi.NewRouter()
 
-	r.Use(RedisRateLimiter(s.redis, 20, 1*time.Minute))
 	r.Use(metrics.PrometheusMiddleware)
-	r.Use(MetadataMiddleware(s.geoDb))
+
+	// Short links are opened by people, not API clients: errors are HTML
+	// pages, and the limit allows for browsing rather than scripting.
+	r.Group(func(public chi.Router) {
+		public.Use(redisRateLimiter(s.redis, 120, 1*time.Minute, "redirect:", writeErrorPage))
+		public.Use(MetadataMiddleware(s.geoDb))
+		public.Get("/{shortCode}", s.handlePublicRedirect)
+		public.Head("/{shortCode}", s.handlePublicRedirect)
+		public.With(redisRateLimiter(s.redis, 5, 1*time.Minute, "unlock:", writeErrorPage)).Post("/{shortCode}", s.handlePublicUnlock)
+	})
 
 	r.Route("/api/v1", func(v1 chi.Router) {
+		v1.Use(RedisRateLimiter(s.redis, 20, 1*time.Minute))
+		v1.Use(MetadataMiddleware(s.geoDb))
+
 		v1.Get("/health", s.healthCheckHandler)
 		v1.Post("/user/register", s.handleRegister)
 		v1.Post("/user/login", s.handleLogin)
+		v1.Post("/user/login/2fa", s.handleVerifyTwoFactor)
+		v1.Get("/user/oidc/login", s.handleOIDCLogin)
+		v1.Get("/user/oidc/callback", s.handleOIDCCallback)
+		v1.Post("/user/oidc/link", s.handleOIDCLink)
 		v1.Handle("/metrics", promhttp.Handler())
 
 		v1.Route("/url", func(url chi.Router) {
 
 			url.Get("/{shortCode}", s.handleFetchURL)
+			url.Head("/{shortCode}", s.handleFetchURL)
+			url.With(redisRateLimiter(s.redis, 5, 1*time.Minute, "unlock:", response.Error)).Post("/{shortCode}", s.handleUnlockURL)
 			url.Get("/{shortCode}/qr", s.handleGenerateQR)
 
 			url.Group(func(protected chi.Router) {
-				protected.Use(AuthMiddleware(s.tokenService, true))
+				protected.Use(AuthMiddleware(s.tokenService, s.userService, true))
+				protected.Use(WorkspaceMiddleware(s.workspaceService))
 				protected.Post("/shorten", s.handleCreateURL)
 				protected.Post("/shorten/bulk", s.handleCreateURL_Bulk)
 			})
+
+			url.Group(func(imports chi.Router) {
+				imports.Use(AuthMiddleware(s.tokenService, s.userService, false))
+				imports.Use(WorkspaceMiddleware(s.workspaceService))
+				imports.Post("/import", s.handleImportURLs)
+				imports.Get("/import/{jobID}", s.handleFetchImportJob)
+			})
+
+			url.Group(func(manage chi.Router) {
+				manage.Use(AuthMiddleware(s.tokenService, s.userService, false))
+				manage.Use(WorkspaceMiddleware(s.workspaceService))
+				manage.Patch("/{shortCode}", s.handleUpdateURL)
+				manage.Delete("/{shortCode}", s.handleDeleteURL)
+			})
 		})
 
 		// User routes
 		v1.Route("/user", func(user chi.Router) {
-			user.Use(AuthMiddleware(s.tokenService, false))
+			user.Use(AuthMiddleware(s.tokenService, s.userService, false))
+			user.Use(WorkspaceMiddleware(s.workspaceService))
 			user.Get("/history", s.handleFetchUserURLHistory)
+			user.Get("/export", s.handleExportURLs)
+			user.Get("/me", s.handleFetchProfile)
+			user.Patch("/me", s.handleUpdateProfile)
+			user.Delete("/me", s.handleDeleteAccount)
+			user.Post("/password", s.handleChangePassword)
+			user.Post("/2fa/enroll", s.handleEnrollTwoFactor)
+			user.Post("/2fa/confirm", s.handleConfirmTwoFactor)
+			user.Delete("/2fa", s.handleDisableTwoFactor)
+		})
+
+		v1.Route("/workspaces", func(workspaces chi.Router) {
+			workspaces.Use(AuthMiddleware(s.tokenService, s.userService, false))
+			workspaces.Post("/", s.handleCreateWorkspace)
+			workspaces.Get("/", s.handleListWorkspaces)
+			workspaces.Post("/invitations/accept", s.handleAcceptInvitation)
+
+			workspaces.Route("/{workspaceID}", func(one chi.Router) {
+				one.Get("/members", s.handleListMembers)
+				one.Post("/invitations", s.handleInviteMember)
+				one.Patch("/members/{userID}", s.handleUpdateMember)
+				one.Delete("/members/{userID}", s.handleRemoveMember)
+			})
+		})
+
+		v1.Route("/domains", func(domains chi.Router) {
+			domains.Use(AuthMiddleware(s.tokenService, s.userService, false))
+			domains.Use(WorkspaceMiddleware(s.workspaceService))
+			domains.Post("/", s.handleRegisterDomain)
+			domains.Get("/", s.handleListDomains)
+			domains.Post("/{domainID}/verify", s.handleVerifyDomain)
+			domains.Delete("/{domainID}", s.handleDeleteDomain)
 		})
 	})
 
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/url"
+	"hpj/hv1-link-shortener/shared/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// variantCookieTTL is how long a visitor of a split link keeps going to the
+// same variant, even from another network.
+const variantCookieTTL = 30 * 24 * time.Hour
+
+// linkVariantCookie names the cookie remembering the variant of the split
+// link at shortCode a visitor was sent to.
+func linkVariantCookie(shortCode string) string {
+	return "link_variant_" + shortCode
+}
+
+// visitor describes who made r to the routing rules and variants of the link
+// at shortCode on hostname, from the click metadata, the Accept-Language
+// header and the variant cookie. Visitors without the cookie are placed by
+// their IP address and user agent, so they stay put without cookies too.
+func visitor(r *http.Request, hostname string, shortCode string) url.Visitor {
+	v := url.Visitor{Language: preferredLanguage(r.Header.Get("Accept-Language"))}
+
+	var ip string
+	if click, ok := r.Context().Value(shared.ClickDataKey).(*models.Click); ok {
+		v.Country, v.Device, v.OS = click.Country, click.Device, click.OS
+		ip = click.IPAddress
+	}
+
+	if cookie, err := r.Cookie(linkVariantCookie(shortCode)); err == nil {
+		v.Variant = cookie.Value
+	}
+
+	// The link is part of the key, so a visitor's variants of different
+	// links are independent.
+	v.Key = strings.Join([]string{hostname, shortCode, ip, r.UserAgent()}, "\x00")
+
+	return v
+}
+
+// setVariantCookie remembers the variant of the split link at shortCode r
+// was sent to.
+func (s *Server) setVariantCookie(w http.ResponseWriter, r *http.Request, shortCode string, variant string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkVariantCookie(shortCode),
+		Value:    variant,
+		Path:     "/",
+		Expires:  time.Now().Add(variantCookieTTL),
+		MaxAge:   int(variantCookieTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// preferredLanguage is the language tag of header with the highest quality,
+// the first one listed on ties, in lower case. It is empty when header names
+// no language.
+func preferredLanguage(header string) string {
+	var preferred string
+	best := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = q
+		}
+
+		if quality > best {
+			preferred, best = tag, quality
+		}
+	}
+
+	return preferred
+}
+
+// validateDestinations screens the destinations of routing rules and
+// variants like long URLs. It writes the error and returns false when one is
+// rejected.
+func (s *Server) validateDestinations(w http.ResponseWriter, r *http.Request, rules []url.RoutingRule, variants []url.Variant) bool {
+	for i, rule := range rules {
+		if err := s.urlValidator.Validate(r.Context(), rule.URL); err != nil {
+			writeInvalidURL(w, fmt.Sprintf("Invalid URL in routing rule %d", i+1), err)
+			return false
+		}
+	}
+
+	for i, variant := range variants {
+		if err := s.urlValidator.Validate(r.Context(), variant.URL); err != nil {
+			writeInvalidURL(w, fmt.Sprintf("Invalid URL in variant %d", i+1), err)
+			return false
+		}
+	}
+
+	return true
+}
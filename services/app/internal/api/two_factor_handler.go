@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/response"
+	"hafiztri123/app-link-shortener/internal/user"
+	"net/http"
+)
+
+func (s *Server) handleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req user.TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	token, err := s.userService.VerifyTwoFactor(r.Context(), req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "Success", http.StatusOK, user.LoginResponse{Token: token})
+}
+
+func (s *Server) handleEnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	enrollment, err := s.userService.EnrollTwoFactor(r.Context(), claims.UserID)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "Scan the QR code and confirm with a code to enable two-factor authentication", http.StatusOK, enrollment)
+}
+
+func (s *Server) handleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req user.ConfirmTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	confirmation, err := s.userService.ConfirmTwoFactor(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "Two-factor authentication enabled, store the recovery codes somewhere safe", http.StatusOK, confirmation)
+}
+
+func (s *Server) handleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req user.DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := s.userService.DisableTwoFactor(r.Context(), claims.UserID, req); err != nil {
+		writeUserError(w, err)
+		return
+	}
+
+	response.Success(w, "Two-factor authentication disabled", http.StatusOK)
+}
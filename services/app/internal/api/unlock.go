@@ -0,0 +1,142 @@
+package api
+
+import (
+	"errors"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/response"
+	"hafiztri123/app-link-shortener/internal/url"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUnlockFormBytes bounds the body of a password submission.
+const maxUnlockFormBytes = 4 << 10
+
+var passwordPage = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; color: #222; }
+main { max-width: 24rem; padding: 2rem; }
+input, button { font: inherit; padding: 0.5rem; }
+.error { color: #b3261e; }
+</style>
+</head>
+<body>
+<main>
+<h1>Password required</h1>
+<p>This link is protected. Enter its password to continue.</p>
+{{if .Message}}<p class="error">{{.Message}}</p>
+{{end}}<form method="post">
+<input type="password" name="password" autocomplete="current-password" required autofocus>
+<button type="submit">Continue</button>
+</form>
+</main>
+</body>
+</html>
+`))
+
+// writePasswordPage asks for the password of a protected link. The form
+// posts back to the page's own address.
+func writePasswordPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := passwordPage.Execute(w, struct{ Message string }{message}); err != nil {
+		slog.Error("Failed to write password page", "error", err)
+	}
+}
+
+// linkAccessCookie names the cookie that lets a visitor back into the
+// protected link at shortCode. Cookies are scoped to the host, so one name
+// per code is enough.
+func linkAccessCookie(shortCode string) string {
+	return "link_access_" + shortCode
+}
+
+// linkAccessSubject names a link in its access tokens, so a token opens only
+// the link it was issued for.
+func linkAccessSubject(hostname string, shortCode string) string {
+	return hostname + "/" + shortCode
+}
+
+// secureCookies reports whether cookies set in response to r should only
+// travel over HTTPS.
+func (s *Server) secureCookies(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(s.publicBaseURL, "https://")
+}
+
+// linkUnlocked reports whether r carries a valid access token for the link at
+// shortCode on hostname, issued for its current password, named by
+// passwordStamp.
+func (s *Server) linkUnlocked(r *http.Request, hostname string, shortCode string, passwordStamp string) bool {
+	cookie, err := r.Cookie(linkAccessCookie(shortCode))
+	if err != nil || s.tokenService == nil {
+		return false
+	}
+
+	return s.tokenService.ValidateLinkAccessToken(cookie.Value, linkAccessSubject(hostname, shortCode), passwordStamp) == nil
+}
+
+func (s *Server) handleUnlockURL(w http.ResponseWriter, r *http.Request) {
+	s.unlock(w, r, r.URL.Query().Get("domain"), chi.URLParam(r, "shortCode"), response.Error, response.Error)
+}
+
+// handlePublicUnlock takes the password form of a link served at the root
+// path, including that of its "+" preview page.
+func (s *Server) handlePublicUnlock(w http.ResponseWriter, r *http.Request) {
+	shortCode := strings.TrimSuffix(chi.URLParam(r, "shortCode"), "+")
+	s.unlock(w, r, s.requestDomain(r), shortCode, writeErrorPage, writeWarningPage)
+}
+
+// unlock checks a submitted password for the link at shortCode on hostname.
+// On success the visitor gets a short-lived access cookie and is sent back
+// to the page they came from.
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request, hostname string, shortCode string, writeError errorWriter, writeWarning errorWriter) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUnlockFormBytes)
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid password form")
+		return
+	}
+
+	passwordStamp, err := s.urlService.UnlockLink(r.Context(), hostname, shortCode, r.PostFormValue("password"))
+	if err != nil {
+		var incorrectErr *url.IncorrectPasswordErr
+		if errors.As(err, &incorrectErr) {
+			writePasswordPage(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		writeLinkError(w, err, writeError, writeWarning)
+		return
+	}
+
+	token, err := s.tokenService.GenerateLinkAccessToken(linkAccessSubject(hostname, shortCode), passwordStamp)
+	if err != nil {
+		slog.Error("Failed to issue link access token", "error", err)
+		writeError(w, http.StatusInternalServerError, "Failed to unlock link")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkAccessCookie(shortCode),
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(auth.LinkAccessTokenTTL),
+		MaxAge:   int(auth.LinkAccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
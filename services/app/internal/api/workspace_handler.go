@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/response"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) handleCreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req workspace.CreateWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	created, err := s.workspaceService.Create(r.Context(), claims.UserID, req)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "Workspace created", http.StatusCreated, created)
+}
+
+func (s *Server) handleListWorkspaces(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	workspaces, err := s.workspaceService.ListForUser(r.Context(), claims.UserID)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "success fetching workspaces", http.StatusOK, response.ListResponse[*workspace.Workspace]{
+		Data:  workspaces,
+		Count: len(workspaces),
+	})
+}
+
+func (s *Server) handleListMembers(w http.ResponseWriter, r *http.Request) {
+	claims, workspaceID, ok := workspaceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := s.workspaceService.ListMembers(r.Context(), workspaceID, claims.UserID)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "success fetching members", http.StatusOK, response.ListResponse[*workspace.Member]{
+		Data:  members,
+		Count: len(members),
+	})
+}
+
+func (s *Server) handleInviteMember(w http.ResponseWriter, r *http.Request) {
+	claims, workspaceID, ok := workspaceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var req workspace.InviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	invitation, err := s.workspaceService.Invite(r.Context(), workspaceID, claims.UserID, req)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "Invitation created", http.StatusCreated, invitation)
+}
+
+func (s *Server) handleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return
+	}
+
+	var req workspace.AcceptInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	member, err := s.workspaceService.AcceptInvitation(r.Context(), claims.UserID, claims.Email, req)
+	if err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "Invitation accepted", http.StatusOK, member)
+}
+
+func (s *Server) handleUpdateMember(w http.ResponseWriter, r *http.Request) {
+	claims, workspaceID, ok := workspaceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	var req workspace.UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.Error(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := s.workspaceService.UpdateMemberRole(r.Context(), workspaceID, claims.UserID, memberID, req); err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "Member updated", http.StatusOK)
+}
+
+func (s *Server) handleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	claims, workspaceID, ok := workspaceRequest(w, r)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.ParseInt(chi.URLParam(r, "userID"), 10, 64)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if err := s.workspaceService.RemoveMember(r.Context(), workspaceID, claims.UserID, memberID); err != nil {
+		writeWorkspaceError(w, err)
+		return
+	}
+
+	response.Success(w, "Member removed", http.StatusOK)
+}
+
+// workspaceRequest extracts the caller and the {workspaceID} path parameter,
+// writing the error response itself when either is missing.
+func workspaceRequest(w http.ResponseWriter, r *http.Request) (*auth.Claims, int64, bool) {
+	claims, err := auth.GetUserFromContext(r.Context())
+	if err != nil {
+		response.Error(w, http.StatusUnauthorized, "not authorized")
+		return nil, 0, false
+	}
+
+	workspaceID, err := strconv.ParseInt(chi.URLParam(r, "workspaceID"), 10, 64)
+	if err != nil {
+		response.Error(w, http.StatusBadRequest, "invalid workspace id")
+		return nil, 0, false
+	}
+
+	return claims, workspaceID, true
+}
+
+func writeWorkspaceError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *workspace.InvalidRequestErr:
+		response.Error(w, http.StatusBadRequest, err.Error())
+	case *workspace.ForbiddenErr:
+		response.Error(w, http.StatusForbidden, err.Error())
+	case *workspace.WorkspaceNotFoundErr, *workspace.MemberNotFoundErr, *workspace.InvitationNotFoundErr:
+		response.Error(w, http.StatusNotFound, err.Error())
+	case *workspace.LastOwnerErr:
+		response.Error(w, http.StatusConflict, err.Error())
+	default:
+		response.Error(w, http.StatusInternalServerError, "Unexpected error has occured, please try again later")
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockWorkspaceService struct {
+	err error
+}
+
+func (m *mockWorkspaceService) Create(ctx context.Context, userID int64, req workspace.CreateWorkspaceRequest) (*workspace.Workspace, error) {
+	return &workspace.Workspace{ID: 1, Name: req.Name, Role: workspace.RoleOwner}, m.err
+}
+
+func (m *mockWorkspaceService) ListForUser(ctx context.Context, userID int64) ([]*workspace.Workspace, error) {
+	return []*workspace.Workspace{{ID: 1, Name: "team", Role: workspace.RoleOwner}}, m.err
+}
+
+func (m *mockWorkspaceService) GetMembership(ctx context.Context, workspaceID int64, userID int64) (*workspace.Member, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &workspace.Member{WorkspaceID: workspaceID, UserID: userID, Role: workspace.RoleEditor}, nil
+}
+
+func (m *mockWorkspaceService) ListMembers(ctx context.Context, workspaceID int64, actorID int64) ([]*workspace.Member, error) {
+	return []*workspace.Member{{WorkspaceID: workspaceID, UserID: actorID, Role: workspace.RoleOwner}}, m.err
+}
+
+func (m *mockWorkspaceService) Invite(ctx context.Context, workspaceID int64, actorID int64, req workspace.InviteRequest) (*workspace.Invitation, error) {
+	return &workspace.Invitation{WorkspaceID: workspaceID, Email: req.Email, Role: req.Role, Token: "token"}, m.err
+}
+
+func (m *mockWorkspaceService) AcceptInvitation(ctx context.Context, userID int64, email string, req workspace.AcceptInvitationRequest) (*workspace.Member, error) {
+	return &workspace.Member{WorkspaceID: 1, UserID: userID, Email: email, Role: workspace.RoleViewer}, m.err
+}
+
+func (m *mockWorkspaceService) UpdateMemberRole(ctx context.Context, workspaceID int64, actorID int64, memberID int64, req workspace.UpdateMemberRequest) error {
+	return m.err
+}
+
+func (m *mockWorkspaceService) RemoveMember(ctx context.Context, workspaceID int64, actorID int64, memberID int64) error {
+	return m.err
+}
+
+func TestWorkspaceHandlers(t *testing.T) {
+	testCases := []struct {
+		name           string
+		method         string
+		body           string
+		workspaceID    string
+		userID         string
+		handler        func(*Server) http.HandlerFunc
+		serviceErr     error
+		emptyClaims    bool
+		wantStatusCode int
+	}{
+		{
+			name:           "create workspace",
+			method:         http.MethodPost,
+			body:           `{"name": "team"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleCreateWorkspace },
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:           "create workspace with bad payload",
+			method:         http.MethodPost,
+			body:           `{"name":`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleCreateWorkspace },
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "create workspace without claims",
+			method:         http.MethodPost,
+			body:           `{"name": "team"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleCreateWorkspace },
+			emptyClaims:    true,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "list workspaces",
+			method:         http.MethodGet,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleListWorkspaces },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "list members",
+			method:         http.MethodGet,
+			workspaceID:    "1",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleListMembers },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "list members of unknown workspace",
+			method:         http.MethodGet,
+			workspaceID:    "1",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleListMembers },
+			serviceErr:     workspace.WorkspaceNotFound,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "list members with invalid workspace id",
+			method:         http.MethodGet,
+			workspaceID:    "abc",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleListMembers },
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "invite member",
+			method:         http.MethodPost,
+			body:           `{"email": "friend@mail.com", "role": "editor"}`,
+			workspaceID:    "1",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleInviteMember },
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name:           "invite member as viewer",
+			method:         http.MethodPost,
+			body:           `{"email": "friend@mail.com", "role": "editor"}`,
+			workspaceID:    "1",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleInviteMember },
+			serviceErr:     workspace.Forbidden,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "accept invitation",
+			method:         http.MethodPost,
+			body:           `{"token": "token"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleAcceptInvitation },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "accept unknown invitation",
+			method:         http.MethodPost,
+			body:           `{"token": "token"}`,
+			handler:        func(s *Server) http.HandlerFunc { return s.handleAcceptInvitation },
+			serviceErr:     workspace.InvitationNotFound,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "demote last owner",
+			method:         http.MethodPatch,
+			body:           `{"role": "admin"}`,
+			workspaceID:    "1",
+			userID:         "1",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateMember },
+			serviceErr:     workspace.LastOwner,
+			wantStatusCode: http.StatusConflict,
+		},
+		{
+			name:           "update member with invalid user id",
+			method:         http.MethodPatch,
+			body:           `{"role": "admin"}`,
+			workspaceID:    "1",
+			userID:         "abc",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleUpdateMember },
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "remove member",
+			method:         http.MethodDelete,
+			workspaceID:    "1",
+			userID:         "2",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleRemoveMember },
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "remove unknown member",
+			method:         http.MethodDelete,
+			workspaceID:    "1",
+			userID:         "2",
+			handler:        func(s *Server) http.HandlerFunc { return s.handleRemoveMember },
+			serviceErr:     workspace.MemberNotFound,
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &Server{
+				workspaceService: &mockWorkspaceService{err: tc.serviceErr},
+			}
+
+			rrl := httptest.NewRequest(tc.method, "/api/v1/workspaces", bytes.NewBufferString(tc.body))
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("workspaceID", tc.workspaceID)
+			rctx.URLParams.Add("userID", tc.userID)
+			ctx := context.WithValue(rrl.Context(), chi.RouteCtxKey, rctx)
+			if !tc.emptyClaims {
+				ctx = context.WithValue(ctx, shared.UserContextKey, &auth.Claims{UserID: 1, Email: "example@mail.com"})
+			}
+			rrl = rrl.WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			tc.handler(server)(rr, rrl)
+
+			assert.Equal(t, tc.wantStatusCode, rr.Code)
+		})
+	}
+}
+
+func TestWorkspaceMiddleware(t *testing.T) {
+	testCases := []struct {
+		name           string
+		header         string
+		serviceErr     error
+		emptyClaims    bool
+		wantStatusCode int
+		wantMember     bool
+	}{
+		{
+			name:           "no workspace header",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "member of workspace",
+			header:         "1",
+			wantStatusCode: http.StatusOK,
+			wantMember:     true,
+		},
+		{
+			name:           "invalid workspace id",
+			header:         "abc",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:           "not a member",
+			header:         "1",
+			serviceErr:     workspace.WorkspaceNotFound,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "workspace header without claims",
+			header:         "1",
+			emptyClaims:    true,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotMember *workspace.Member
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotMember = workspace.GetMemberFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			handler := WorkspaceMiddleware(&mockWorkspaceService{err: tc.serviceErr})(next)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set("X-Workspace-ID", tc.header)
+			}
+			if !tc.emptyClaims {
+				req = req.WithContext(context.WithValue(req.Context(), shared.UserContextKey, &auth.Claims{UserID: 1}))
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.wantStatusCode, rr.Code)
+			assert.Equal(t, tc.wantMember, gotMember != nil)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package auth
 import "log/slog"
 
 var ValueNotFound = &ValueNotFoundErr{}
+var OIDCFailed = &OIDCErr{}
 
 type ValueNotFoundErr struct {
 	Action string
@@ -17,3 +18,17 @@ func (e *ValueNotFoundErr) Is(target error) bool {
 	_, ok := target.(*ValueNotFoundErr)
 	return ok
 }
+
+type OIDCErr struct {
+	reason string
+	err    error
+}
+
+func (e *OIDCErr) Error() string {
+	slog.Error("OIDC login failed", "reason", e.reason, "error", e.err)
+	return "Single sign-on failed"
+}
+
+func (e *OIDCErr) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"hafiztri123/app-link-shortener/internal/auth/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOIDCProvider(t *testing.T, clientID string) (*oidctest.Provider, *OIDCProvider) {
+	idp := oidctest.NewProvider(clientID)
+	t.Cleanup(idp.Close)
+
+	provider, err := NewOIDCProvider(context.Background(), OIDCConfig{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     clientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/v1/user/oidc/callback",
+	}, nil)
+	require.NoError(t, err)
+
+	return idp, provider
+}
+
+func TestOIDCProvider_Exchange(t *testing.T) {
+	testCases := []struct {
+		name          string
+		exchangeNonce string
+		verifier      func(string) string
+		wantErr       bool
+	}{
+		{
+			name:     "success",
+			verifier: func(v string) string { return v },
+		},
+		{
+			name:          "nonce mismatch",
+			exchangeNonce: "other-nonce",
+			verifier:      func(v string) string { return v },
+			wantErr:       true,
+		},
+		{
+			name:     "wrong code verifier",
+			verifier: func(string) string { return "not-the-verifier" },
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idp, provider := newTestOIDCProvider(t, "app")
+			ctx := context.Background()
+
+			verifier, err := RandomToken()
+			require.NoError(t, err)
+
+			authURL := provider.AuthCodeURL("state-1", "nonce-1", verifier)
+			parsed, err := url.Parse(authURL)
+			require.NoError(t, err)
+			assert.Equal(t, CodeChallenge(verifier), parsed.Query().Get("code_challenge"))
+			assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+
+			code, state, err := idp.Authorize(authURL)
+			require.NoError(t, err)
+			assert.Equal(t, "state-1", state)
+
+			nonce := "nonce-1"
+			if tc.exchangeNonce != "" {
+				nonce = tc.exchangeNonce
+			}
+
+			identity, err := provider.Exchange(ctx, code, tc.verifier(verifier), nonce)
+			if tc.wantErr {
+				assert.ErrorAs(t, err, &OIDCFailed)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, idp.Issuer(), identity.Issuer)
+			assert.Equal(t, "subject-1", identity.Subject)
+			assert.Equal(t, "sso@mail.com", identity.Email)
+			assert.True(t, identity.EmailVerified)
+		})
+	}
+}
+
+func TestNewOIDCProvider_IssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"issuer": "https://evil.example.com", "authorization_endpoint": "a", "token_endpoint": "t", "jwks_uri": "j"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := NewOIDCProvider(context.Background(), OIDCConfig{IssuerURL: server.URL, ClientID: "app"}, nil)
+	assert.ErrorAs(t, err, &OIDCFailed)
+}
@@ -1,36 +1,95 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type JWT interface {
-	GenerateToken(userID int64, email string) (string, error)
+	GenerateToken(userID int64, email string, tokenVersion int) (string, error)
 	ValidateToken(tokenString string) (*Claims, error)
+	GenerateChallengeToken(userID int64) (string, error)
+	ValidateChallengeToken(tokenString string) (int64, error)
+	GenerateIdentityLinkToken(userID int64, issuer string, subject string) (string, error)
+	ValidateIdentityLinkToken(tokenString string) (*IdentityLinkClaims, error)
+}
+
+// SessionValidator reports whether the claims of an otherwise valid token
+// still belong to a live session, e.g. the password has not been changed since.
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, claims *Claims) error
 }
 
 type Claims struct {
-	UserID int64  `json:"user_id"`
-	Email  string `json:"email"`
+	UserID       int64  `json:"user_id"`
+	Email        string `json:"email"`
+	TokenVersion int    `json:"token_version"`
+	jwt.RegisteredClaims
+}
+
+// ChallengeClaims identify a user who passed the password check but still
+// owes a second factor. They are signed with a separate key so they can never
+// be mistaken for a session token.
+type ChallengeClaims struct {
+	UserID int64 `json:"user_id"`
+	jwt.RegisteredClaims
+}
+
+const challengeTokenTTL = 5 * time.Minute
+
+// IdentityLinkClaims name an identity provider login whose email belongs to
+// an existing account. Linking them takes the account's password, so they are
+// signed with a key of their own.
+type IdentityLinkClaims struct {
+	UserID          int64  `json:"user_id"`
+	IdentityIssuer  string `json:"identity_issuer"`
+	IdentitySubject string `json:"identity_subject"`
 	jwt.RegisteredClaims
 }
 
+const identityLinkTokenTTL = 10 * time.Minute
+
+// LinkAccessClaims grant access to one protected link for as long as its
+// password stays the same. PasswordStamp names the password they were issued
+// for, so changing it locks every visitor out again.
+type LinkAccessClaims struct {
+	PasswordStamp string `json:"password_stamp"`
+	jwt.RegisteredClaims
+}
+
+// LinkAccessTokenTTL is how long a visitor who entered the password of a
+// protected link can open it again without being asked.
+const LinkAccessTokenTTL = 30 * time.Minute
+
 type TokenService struct {
-	secretKey []byte
+	secretKey       []byte
+	challengeKey    []byte
+	linkAccessKey   []byte
+	identityLinkKey []byte
 }
 
 func NewTokenService(secretKey string) *TokenService {
+	challengeKey := sha256.Sum256([]byte("two-factor-challenge:" + secretKey))
+	linkAccessKey := sha256.Sum256([]byte("link-access:" + secretKey))
+	identityLinkKey := sha256.Sum256([]byte("identity-link:" + secretKey))
+
 	return &TokenService{
-		secretKey: []byte(secretKey),
+		secretKey:       []byte(secretKey),
+		challengeKey:    challengeKey[:],
+		linkAccessKey:   linkAccessKey[:],
+		identityLinkKey: identityLinkKey[:],
 	}
 }
 
-func (ts *TokenService) GenerateToken(userID int64, email string) (string, error) {
+func (ts *TokenService) GenerateToken(userID int64, email string, tokenVersion int) (string, error) {
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:       userID,
+		Email:        email,
+		TokenVersion: tokenVersion,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -54,3 +113,105 @@ func (ts *TokenService) ValidateToken(tokenString string) (*Claims, error) {
 
 	return claims, nil
 }
+
+func (ts *TokenService) GenerateChallengeToken(userID int64) (string, error) {
+	claims := &ChallengeClaims{
+		UserID: userID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(challengeTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(ts.challengeKey)
+}
+
+func (ts *TokenService) ValidateChallengeToken(tokenString string) (int64, error) {
+	claims := &ChallengeClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return ts.challengeKey, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !token.Valid {
+		return 0, errors.New("invalid challenge token")
+	}
+
+	return claims.UserID, nil
+}
+
+func (ts *TokenService) GenerateIdentityLinkToken(userID int64, issuer string, subject string) (string, error) {
+	claims := &IdentityLinkClaims{
+		UserID:          userID,
+		IdentityIssuer:  issuer,
+		IdentitySubject: subject,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(identityLinkTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(ts.identityLinkKey)
+}
+
+func (ts *TokenService) ValidateIdentityLinkToken(tokenString string) (*IdentityLinkClaims, error) {
+	claims := &IdentityLinkClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return ts.identityLinkKey, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid identity link token")
+	}
+
+	return claims, nil
+}
+
+// GenerateLinkAccessToken grants access to the protected link named link
+// while its password has passwordStamp. It is signed with a key of its own, so
+// it cannot pass as any other token.
+func (ts *TokenService) GenerateLinkAccessToken(link string, passwordStamp string) (string, error) {
+	claims := &LinkAccessClaims{
+		PasswordStamp: passwordStamp,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   link,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(LinkAccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(ts.linkAccessKey)
+}
+
+// ValidateLinkAccessToken checks that tokenString grants access to link, whose
+// password currently has passwordStamp.
+func (ts *TokenService) ValidateLinkAccessToken(tokenString string, link string, passwordStamp string) error {
+	claims := &LinkAccessClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
+		return ts.linkAccessKey, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired(), jwt.WithSubject(link))
+
+	if err != nil {
+		return err
+	}
+
+	if !token.Valid {
+		return errors.New("invalid link access token")
+	}
+
+	if claims.PasswordStamp != passwordStamp {
+		return errors.New("link password has changed")
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallengeToken(t *testing.T) {
+	ts := NewTokenService("secret")
+
+	challenge, err := ts.GenerateChallengeToken(7)
+	require.NoError(t, err)
+
+	userID, err := ts.ValidateChallengeToken(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), userID)
+
+	_, err = ts.ValidateToken(challenge)
+	assert.Error(t, err, "a challenge token must not be accepted as a session token")
+
+	session, err := ts.GenerateToken(7, "example@mail.com", 0)
+	require.NoError(t, err)
+
+	_, err = ts.ValidateChallengeToken(session)
+	assert.Error(t, err, "a session token must not be accepted as a challenge token")
+
+	_, err = NewTokenService("other").ValidateChallengeToken(challenge)
+	assert.Error(t, err)
+}
+
+func TestIdentityLinkToken(t *testing.T) {
+	ts := NewTokenService("secret")
+
+	link, err := ts.GenerateIdentityLinkToken(7, "https://idp", "sub")
+	require.NoError(t, err)
+
+	claims, err := ts.ValidateIdentityLinkToken(link)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), claims.UserID)
+	assert.Equal(t, "https://idp", claims.IdentityIssuer)
+	assert.Equal(t, "sub", claims.IdentitySubject)
+
+	_, err = ts.ValidateToken(link)
+	assert.Error(t, err, "an identity link token must not be accepted as a session token")
+
+	_, err = ts.ValidateChallengeToken(link)
+	assert.Error(t, err, "an identity link token must not stand in for a password check")
+
+	challenge, err := ts.GenerateChallengeToken(7)
+	require.NoError(t, err)
+	_, err = ts.ValidateIdentityLinkToken(challenge)
+	assert.Error(t, err)
+}
+
+func TestLinkAccessToken(t *testing.T) {
+	ts := NewTokenService("secret")
+
+	access, err := ts.GenerateLinkAccessToken("/abc", "stamp")
+	require.NoError(t, err)
+
+	assert.NoError(t, ts.ValidateLinkAccessToken(access, "/abc", "stamp"))
+	assert.Error(t, ts.ValidateLinkAccessToken(access, "/abd", "stamp"), "a token opens only its own link")
+	assert.Error(t, ts.ValidateLinkAccessToken(access, "go.acme.com/abc", "stamp"))
+	assert.Error(t, ts.ValidateLinkAccessToken(access, "/abc", "changed"), "a new password locks the link again")
+
+	_, err = ts.ValidateToken(access)
+	assert.Error(t, err, "a link access token must not be accepted as a session token")
+
+	session, err := ts.GenerateToken(7, "example@mail.com", 0)
+	require.NoError(t, err)
+	assert.Error(t, ts.ValidateLinkAccessToken(session, "/abc", ""))
+
+	assert.Error(t, NewTokenService("other").ValidateLinkAccessToken(access, "/abc", "stamp"))
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("hunter22")
+	require.NoError(t, err)
+
+	matched, err := CheckPassword(hash, "hunter22")
+	require.NoError(t, err)
+	assert.True(t, matched)
+
+	matched, err = CheckPassword(hash, "hunter23")
+	require.NoError(t, err)
+	assert.False(t, matched)
+
+	_, err = CheckPassword("not a hash", "hunter22")
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B test secret, truncated to six digits.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	testCases := []struct {
+		name     string
+		at       time.Time
+		code     string
+		wantOK   bool
+		wantStep int64
+	}{
+		{name: "rfc vector 59", at: time.Unix(59, 0), code: "287082", wantOK: true, wantStep: 1},
+		{name: "rfc vector 1111111109", at: time.Unix(1111111109, 0), code: "081804", wantOK: true, wantStep: 37037036},
+		{name: "previous period accepted", at: time.Unix(89, 0), code: "287082", wantOK: true, wantStep: 1},
+		{name: "two periods late rejected", at: time.Unix(119, 0), code: "287082"},
+		{name: "wrong code", at: time.Unix(59, 0), code: "000000"},
+		{name: "wrong length", at: time.Unix(59, 0), code: "28708"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, tc.code, tc.at)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.wantStep, step)
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	_, ok := ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+
+	uri := TOTPURI("Link Shortener", "example@mail.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Link%20Shortener:example@mail.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+}
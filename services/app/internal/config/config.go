@@ -4,15 +4,44 @@ import (
 	"fmt"
 	"hpj/hv1-link-shortener/shared/utils"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	DatabaseAddr    string
-	RedisAddr       string
-	IDOffset        uint64
-	SecretKey       string
-	RabbitMQAddr    string
-	ClickQueueLabel string
+	DatabaseAddr           string
+	AnalyticsDBAddr        string
+	RedisAddr              string
+	IDOffset               uint64
+	SecretKey              string
+	RabbitMQAddr           string
+	ClickQueueLabel        string
+	LinkPurgeQueueLabel    string
+	LinkImportQueueLabel   string
+	LinkMetadataQueueLabel string
+	OIDCIssuerURL          string
+	OIDCClientID           string
+	OIDCClientSecret       string
+	OIDCRedirectURL        string
+	URLStripParams         []string
+	CodeGenerator          string
+	CodeSecret             string
+	CodeAlphabet           string
+	CodeLength             int
+	LocalCacheSize         int
+	LocalCacheTTL          time.Duration
+	PublicBaseURL          string
+	SafetyBlocklist        []string
+	SafetyBlocklistFile    string
+	SafetyProviderURL      string
+	SafetyProviderKey      string
+	SafetyScanInterval     time.Duration
+	URLResolveHosts        bool
+	URLResolveTimeout      time.Duration
+	BulkMaxURLs            int
+	ImportMaxBytes         int64
+	ImportMaxRows          int
+	ImportSyncRows         int
 }
 
 func Load() (*Config, error) {
@@ -26,6 +55,15 @@ func Load() (*Config, error) {
 		utils.GetEnvOrDefault("DB_SSL", "disable"),
 	)
 
+	analyticsDBAddr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
+		utils.GetEnvOrDefault("DB_USER", "admin"),
+		utils.GetEnvOrDefault("DB_PASSWORD", "admin"),
+		utils.GetEnvOrDefault("DB_HOST", "admin"),
+		utils.GetEnvOrDefault("DB_PORT", "5432"),
+		utils.GetEnvOrDefault("ANALYTICS_DB", "analytics_db"),
+		utils.GetEnvOrDefault("DB_SSL", "disable"),
+	)
+
 	rabbitmqAddr := fmt.Sprintf("amqp://%s:%s@%s:%s",
 		utils.GetEnvOrDefault("RABBITMQ_USER", "guest"),
 		utils.GetEnvOrDefault("RABBITMQ_PASSWORD", "guest"),
@@ -41,13 +79,103 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	codeLength, err := strconv.Atoi(utils.GetEnvOrDefault("CODE_LENGTH", "8"))
+	if err != nil {
+		return nil, err
+	}
+
+	localCacheSize, err := strconv.Atoi(utils.GetEnvOrDefault("URL_LOCAL_CACHE_SIZE", "10000"))
+	if err != nil {
+		return nil, err
+	}
+
+	localCacheTTL, err := time.ParseDuration(utils.GetEnvOrDefault("URL_LOCAL_CACHE_TTL", "1m"))
+	if err != nil {
+		return nil, err
+	}
+
+	safetyScanInterval, err := time.ParseDuration(utils.GetEnvOrDefault("SAFETY_SCAN_INTERVAL", "24h"))
+	if err != nil {
+		return nil, err
+	}
+
+	bulkMaxURLs, err := strconv.Atoi(utils.GetEnvOrDefault("BULK_MAX_URLS", "500"))
+	if err != nil {
+		return nil, err
+	}
+
+	importMaxBytes, err := strconv.ParseInt(utils.GetEnvOrDefault("IMPORT_MAX_BYTES", "10485760"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	importMaxRows, err := strconv.Atoi(utils.GetEnvOrDefault("IMPORT_MAX_ROWS", "10000"))
+	if err != nil {
+		return nil, err
+	}
+
+	importSyncRows, err := strconv.Atoi(utils.GetEnvOrDefault("IMPORT_SYNC_ROWS", "500"))
+	if err != nil {
+		return nil, err
+	}
+
+	urlResolveHosts, err := strconv.ParseBool(utils.GetEnvOrDefault("URL_RESOLVE_HOSTS", "true"))
+	if err != nil {
+		return nil, err
+	}
+
+	urlResolveTimeout, err := time.ParseDuration(utils.GetEnvOrDefault("URL_RESOLVE_TIMEOUT", "2s"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		DatabaseAddr:    databaseAddr,
-		RedisAddr:       redisAddr,
-		IDOffset:        convertedIdOffset,
-		SecretKey:       utils.GetEnvOrDefault("JWT", "secret"),
-		RabbitMQAddr:    rabbitmqAddr,
-		ClickQueueLabel: utils.GetEnvOrDefault("CLICK_QUEUE_LABEL", "click_event"),
+		DatabaseAddr:           databaseAddr,
+		AnalyticsDBAddr:        analyticsDBAddr,
+		RedisAddr:              redisAddr,
+		IDOffset:               convertedIdOffset,
+		SecretKey:              utils.GetEnvOrDefault("JWT", "secret"),
+		RabbitMQAddr:           rabbitmqAddr,
+		ClickQueueLabel:        utils.GetEnvOrDefault("CLICK_QUEUE_LABEL", "click_event"),
+		LinkPurgeQueueLabel:    utils.GetEnvOrDefault("LINK_PURGE_QUEUE_LABEL", "link_purge"),
+		LinkImportQueueLabel:   utils.GetEnvOrDefault("LINK_IMPORT_QUEUE_LABEL", "link_import"),
+		LinkMetadataQueueLabel: utils.GetEnvOrDefault("LINK_METADATA_QUEUE_LABEL", "link_metadata"),
+		OIDCIssuerURL:          utils.GetEnvOrDefault("OIDC_ISSUER_URL", ""),
+		OIDCClientID:           utils.GetEnvOrDefault("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:       utils.GetEnvOrDefault("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:        utils.GetEnvOrDefault("OIDC_REDIRECT_URL", "http://localhost:8080/api/v1/user/oidc/callback"),
+		URLStripParams:         splitList(utils.GetEnvOrDefault("URL_STRIP_PARAMS", "utm_*,fbclid,gclid")),
+		CodeGenerator:          utils.GetEnvOrDefault("CODE_GENERATOR", "sequential"),
+		CodeSecret:             utils.GetEnvOrDefault("CODE_SECRET", ""),
+		CodeAlphabet:           utils.GetEnvOrDefault("CODE_ALPHABET", "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"),
+		CodeLength:             codeLength,
+		LocalCacheSize:         localCacheSize,
+		LocalCacheTTL:          localCacheTTL,
+		PublicBaseURL:          utils.GetEnvOrDefault("PUBLIC_BASE_URL", "http://localhost:8080"),
+		SafetyBlocklist:        splitList(utils.GetEnvOrDefault("SAFETY_BLOCKLIST", "")),
+		SafetyBlocklistFile:    utils.GetEnvOrDefault("SAFETY_BLOCKLIST_FILE", ""),
+		SafetyProviderURL:      utils.GetEnvOrDefault("SAFETY_PROVIDER_URL", ""),
+		SafetyProviderKey:      utils.GetEnvOrDefault("SAFETY_PROVIDER_KEY", ""),
+		SafetyScanInterval:     safetyScanInterval,
+		URLResolveHosts:        urlResolveHosts,
+		URLResolveTimeout:      urlResolveTimeout,
+		BulkMaxURLs:            bulkMaxURLs,
+		ImportMaxBytes:         importMaxBytes,
+		ImportMaxRows:          importMaxRows,
+		ImportSyncRows:         importSyncRows,
 	}, nil
 
 }
+
+// splitList parses a comma-separated env value, ignoring blank entries, so an
+// empty value yields an empty list.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
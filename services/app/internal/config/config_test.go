@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -26,6 +27,88 @@ func TestLoad(t *testing.T) {
 		assert.Equal(t, "localhost:6379", cfg.RedisAddr)
 		assert.Equal(t, uint64(123), cfg.IDOffset)
 		assert.Equal(t, "jwt_secret", cfg.SecretKey)
+		assert.Empty(t, cfg.OIDCIssuerURL)
+		assert.Equal(t, []string{"utm_*", "fbclid", "gclid"}, cfg.URLStripParams)
+		assert.Equal(t, "sequential", cfg.CodeGenerator)
+		assert.Equal(t, 8, cfg.CodeLength)
+		assert.Equal(t, 10000, cfg.LocalCacheSize)
+		assert.Equal(t, time.Minute, cfg.LocalCacheTTL)
+		assert.Equal(t, "http://localhost:8080", cfg.PublicBaseURL)
+		assert.Empty(t, cfg.SafetyBlocklist)
+		assert.Empty(t, cfg.SafetyProviderURL)
+		assert.Equal(t, 24*time.Hour, cfg.SafetyScanInterval)
+		assert.True(t, cfg.URLResolveHosts)
+		assert.Equal(t, 2*time.Second, cfg.URLResolveTimeout)
+		assert.Equal(t, 500, cfg.BulkMaxURLs)
+	})
+
+	t.Run("success case - deferred url resolution", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("URL_RESOLVE_HOSTS", "false")
+		t.Setenv("URL_RESOLVE_TIMEOUT", "500ms")
+
+		cfg, err := Load()
+
+		assert.NoError(t, err)
+		assert.False(t, cfg.URLResolveHosts)
+		assert.Equal(t, 500*time.Millisecond, cfg.URLResolveTimeout)
+	})
+
+	t.Run("success case - destination safety checks", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("SAFETY_BLOCKLIST", "evil.example, phish.example")
+		t.Setenv("SAFETY_PROVIDER_URL", "https://safebrowsing.example/v4/threatMatches:find")
+		t.Setenv("SAFETY_SCAN_INTERVAL", "6h")
+
+		cfg, err := Load()
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"evil.example", "phish.example"}, cfg.SafetyBlocklist)
+		assert.Equal(t, "https://safebrowsing.example/v4/threatMatches:find", cfg.SafetyProviderURL)
+		assert.Equal(t, 6*time.Hour, cfg.SafetyScanInterval)
+	})
+
+	t.Run("success case - local cache disabled", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("URL_LOCAL_CACHE_SIZE", "0")
+		t.Setenv("URL_LOCAL_CACHE_TTL", "30s")
+
+		cfg, err := Load()
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, cfg.LocalCacheSize)
+		assert.Equal(t, 30*time.Second, cfg.LocalCacheTTL)
+	})
+
+	t.Run("success case - feistel code generator", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("CODE_GENERATOR", "feistel")
+		t.Setenv("CODE_SECRET", "code_secret")
+		t.Setenv("CODE_LENGTH", "10")
+		t.Setenv("URL_STRIP_PARAMS", "")
+
+		cfg, err := Load()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "feistel", cfg.CodeGenerator)
+		assert.Equal(t, "code_secret", cfg.CodeSecret)
+		assert.Equal(t, 10, cfg.CodeLength)
+		assert.Empty(t, cfg.URLStripParams)
+	})
+
+	t.Run("success case - OIDC provider", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("OIDC_ISSUER_URL", "https://idp.example.com")
+		t.Setenv("OIDC_CLIENT_ID", "link-shortener")
+		t.Setenv("OIDC_CLIENT_SECRET", "client_secret")
+
+		cfg, err := Load()
+
+		assert.NoError(t, err)
+		assert.Equal(t, "https://idp.example.com", cfg.OIDCIssuerURL)
+		assert.Equal(t, "link-shortener", cfg.OIDCClientID)
+		assert.Equal(t, "client_secret", cfg.OIDCClientSecret)
+		assert.Equal(t, "http://localhost:8080/api/v1/user/oidc/callback", cfg.OIDCRedirectURL)
 	})
 
 	t.Run("success case - missing APP_URL", func(t *testing.T) {
@@ -73,6 +156,38 @@ func TestLoad(t *testing.T) {
 
 		_, err := Load()
 
+		assert.Error(t, err)
+	})
+	t.Run("failure case - invalid CODE_LENGTH", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("CODE_LENGTH", "eight")
+
+		_, err := Load()
+
+		assert.Error(t, err)
+	})
+	t.Run("failure case - invalid BULK_MAX_URLS", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("BULK_MAX_URLS", "lots")
+
+		_, err := Load()
+
+		assert.Error(t, err)
+	})
+	t.Run("failure case - invalid URL_RESOLVE_HOSTS", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("URL_RESOLVE_HOSTS", "sometimes")
+
+		_, err := Load()
+
+		assert.Error(t, err)
+	})
+	t.Run("failure case - invalid URL_LOCAL_CACHE_TTL", func(t *testing.T) {
+		t.Setenv("ID_OFFSET", "123")
+		t.Setenv("URL_LOCAL_CACHE_TTL", "a minute")
+
+		_, err := Load()
+
 		assert.Error(t, err)
 	})
 }
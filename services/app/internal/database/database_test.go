@@ -21,17 +21,16 @@ func TestInsertIntegration(t *testing.T) {
 		require.NoError(t, db.Close())
 	})
 
-	repo := url.NewRepository(db)
+	codes := url.NewSequentialGenerator(1000)
+	repo := url.NewRepository(db, codes)
 
-	idOffset := 1000
-	shortCode, err := repo.FindOrCreateShortCode(ctx, "https://example.com", uint64(idOffset), nil)
+	created, err := repo.FindOrCreateShortCode(ctx, url.Destination{LongURL: "https://example.com", CanonicalURL: "https://example.com/"}, url.Owner{})
 	require.NoError(t, err)
 
-	id := url.FromBase62(shortCode) - uint64(idOffset)
-
-	retrievedURL, err := repo.GetByID(ctx, int64(id))
+	retrievedURL, err := repo.GetByID(ctx, created.ID)
 	require.NoError(t, err)
 	require.Equal(t, "https://example.com", retrievedURL.LongURL)
+	require.Equal(t, created.ShortCode, retrievedURL.ShortCode.String)
 }
 
 func TestConnect_Success(t *testing.T) {
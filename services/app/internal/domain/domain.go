@@ -0,0 +1,45 @@
+package domain
+
+import "time"
+
+// verificationPrefix names the TXT record a domain is verified through:
+// _linkshortener.<hostname> must hold the domain's verification token.
+const verificationPrefix = "_linkshortener."
+
+// Domain is a custom host links can be served on. It belongs to a workspace
+// or, when WorkspaceID is nil, to a single user. Only verified domains
+// resolve.
+type Domain struct {
+	ID                int64      `json:"id"`
+	Hostname          string     `json:"hostname"`
+	UserID            *int64     `json:"user_id,omitempty"`
+	WorkspaceID       *int64     `json:"workspace_id,omitempty"`
+	VerificationToken string     `json:"verification_token"`
+	VerificationHost  string     `json:"verification_host"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+func (d *Domain) Verified() bool {
+	return d.VerifiedAt != nil
+}
+
+// Owner scopes domain management to a workspace or, when WorkspaceID is nil,
+// to the user's personal domains.
+type Owner struct {
+	UserID      int64
+	WorkspaceID *int64
+}
+
+// Owns reports whether d belongs to owner.
+func (o Owner) Owns(d *Domain) bool {
+	if o.WorkspaceID != nil {
+		return d.WorkspaceID != nil && *d.WorkspaceID == *o.WorkspaceID
+	}
+
+	return d.WorkspaceID == nil && d.UserID != nil && *d.UserID == o.UserID
+}
+
+type RegisterDomainRequest struct {
+	Hostname string `json:"hostname"`
+}
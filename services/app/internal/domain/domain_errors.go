@@ -0,0 +1,54 @@
+package domain
+
+import "log/slog"
+
+var DomainNotFound = &DomainNotFoundErr{}
+var DomainTaken = &DomainTakenErr{}
+var DomainInUse = &DomainInUseErr{}
+var NotVerified = &NotVerifiedErr{}
+var InvalidRequest = &InvalidRequestErr{}
+
+// DomainNotFoundErr is also returned for other owners' domains, so that
+// registrations cannot be probed.
+type DomainNotFoundErr struct {
+	hostname string
+}
+
+func (e *DomainNotFoundErr) Error() string {
+	slog.Debug("Domain not found", "hostname", e.hostname)
+	return "Domain not found"
+}
+
+type DomainTakenErr struct {
+	hostname string
+}
+
+func (e *DomainTakenErr) Error() string {
+	slog.Warn("Domain is already registered", "hostname", e.hostname)
+	return "Domain is already registered"
+}
+
+type DomainInUseErr struct {
+	hostname string
+}
+
+func (e *DomainInUseErr) Error() string {
+	return "Domain still has links"
+}
+
+type NotVerifiedErr struct {
+	hostname string
+}
+
+func (e *NotVerifiedErr) Error() string {
+	slog.Info("Domain verification record not found", "hostname", e.hostname)
+	return "Verification TXT record not found"
+}
+
+type InvalidRequestErr struct {
+	reason string
+}
+
+func (e *InvalidRequestErr) Error() string {
+	return e.reason
+}
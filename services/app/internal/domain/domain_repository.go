@@ -0,0 +1,154 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type DomainRepository interface {
+	Insert(ctx context.Context, domain *Domain) error
+	GetByID(ctx context.Context, id int64) (*Domain, error)
+	ListByOwner(ctx context.Context, owner Owner) ([]*Domain, error)
+	ListVerified(ctx context.Context) ([]*Domain, error)
+	HostnameVerified(ctx context.Context, hostname string) (bool, error)
+	MarkVerified(ctx context.Context, id int64) (time.Time, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+const domainColumns = "id, hostname, user_id, workspace_id, verification_token, verified_at, created_at"
+
+func scanDomain(row interface{ Scan(...any) error }) (*Domain, error) {
+	var domain Domain
+	var userID, workspaceID sql.NullInt64
+	var verifiedAt sql.NullTime
+
+	err := row.Scan(&domain.ID, &domain.Hostname, &userID, &workspaceID, &domain.VerificationToken, &verifiedAt, &domain.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		domain.UserID = &userID.Int64
+	}
+	if workspaceID.Valid {
+		domain.WorkspaceID = &workspaceID.Int64
+	}
+	if verifiedAt.Valid {
+		domain.VerifiedAt = &verifiedAt.Time
+	}
+	domain.VerificationHost = verificationPrefix + domain.Hostname
+
+	return &domain, nil
+}
+
+func (r *Repository) Insert(ctx context.Context, domain *Domain) error {
+	query := `
+		INSERT INTO domains (hostname, user_id, workspace_id, verification_token)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err := r.db.QueryRowContext(ctx, query, domain.Hostname, domain.UserID, domain.WorkspaceID, domain.VerificationToken).
+		Scan(&domain.ID, &domain.CreatedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_UNIQUE_CONSRAINT_VIOLATION_CODE {
+			return &DomainTakenErr{hostname: domain.Hostname}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (r *Repository) GetByID(ctx context.Context, id int64) (*Domain, error) {
+	domain, err := scanDomain(r.db.QueryRowContext(ctx, `SELECT `+domainColumns+` FROM domains WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &DomainNotFoundErr{}
+	}
+
+	return domain, err
+}
+
+func (r *Repository) ListByOwner(ctx context.Context, owner Owner) ([]*Domain, error) {
+	if owner.WorkspaceID != nil {
+		return r.queryDomains(ctx, `SELECT `+domainColumns+` FROM domains WHERE workspace_id = $1 ORDER BY created_at, id`, *owner.WorkspaceID)
+	}
+
+	return r.queryDomains(ctx, `SELECT `+domainColumns+` FROM domains WHERE user_id = $1 AND workspace_id IS NULL ORDER BY created_at, id`, owner.UserID)
+}
+
+func (r *Repository) ListVerified(ctx context.Context) ([]*Domain, error) {
+	return r.queryDomains(ctx, `SELECT `+domainColumns+` FROM domains WHERE verified_at IS NOT NULL`)
+}
+
+func (r *Repository) queryDomains(ctx context.Context, query string, args ...any) ([]*Domain, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var domains []*Domain
+	for rows.Next() {
+		domain, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, rows.Err()
+}
+
+func (r *Repository) HostnameVerified(ctx context.Context, hostname string) (bool, error) {
+	var verified bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM domains WHERE hostname = $1 AND verified_at IS NOT NULL)`, hostname).
+		Scan(&verified)
+
+	return verified, err
+}
+
+// MarkVerified fails with *DomainTakenErr when another claim on the hostname
+// was verified first.
+func (r *Repository) MarkVerified(ctx context.Context, id int64) (time.Time, error) {
+	var verifiedAt time.Time
+	err := r.db.QueryRowContext(ctx, `UPDATE domains SET verified_at = NOW() WHERE id = $1 RETURNING verified_at`, id).
+		Scan(&verifiedAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_UNIQUE_CONSRAINT_VIOLATION_CODE {
+			return time.Time{}, &DomainTakenErr{}
+		}
+		return time.Time{}, err
+	}
+
+	return verifiedAt, nil
+}
+
+// Delete fails with *DomainInUseErr while links are served on the domain.
+func (r *Repository) Delete(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM domains WHERE id = $1`, id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_FOREIGN_KEY_VIOLATION_CODE {
+			return &DomainInUseErr{}
+		}
+		return err
+	}
+
+	return nil
+}
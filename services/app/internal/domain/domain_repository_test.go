@@ -0,0 +1,71 @@
+package domain
+
+import (
+	"context"
+	"database/sql"
+	"hpj/hv1-link-shortener/shared/migrations"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func insertUser(t *testing.T, ctx context.Context, db *sql.DB, email string) int64 {
+	var id int64
+	err := db.QueryRowContext(ctx, `INSERT INTO users (email, password) VALUES ($1, 'hash') RETURNING id`, email).Scan(&id)
+	require.NoError(t, err)
+	return id
+}
+
+func TestRepository_DomainFlow(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db)
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	first := insertUser(t, ctx, db, "first@mail.com")
+	second := insertUser(t, ctx, db, "second@mail.com")
+
+	mine := &Domain{Hostname: "go.acme.com", UserID: &first, VerificationToken: "a"}
+	require.NoError(t, repo.Insert(ctx, mine))
+
+	theirs := &Domain{Hostname: "go.acme.com", UserID: &second, VerificationToken: "b"}
+	require.NoError(t, repo.Insert(ctx, theirs), "unverified claims may overlap")
+
+	err := repo.Insert(ctx, &Domain{Hostname: "go.acme.com", UserID: &first, VerificationToken: "c"})
+	assert.IsType(t, DomainTaken, err)
+
+	listed, err := repo.ListByOwner(ctx, Owner{UserID: first})
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, "_linkshortener.go.acme.com", listed[0].VerificationHost)
+
+	_, err = repo.MarkVerified(ctx, mine.ID)
+	require.NoError(t, err)
+
+	_, err = repo.MarkVerified(ctx, theirs.ID)
+	assert.IsType(t, DomainTaken, err)
+
+	verified, err := repo.HostnameVerified(ctx, "go.acme.com")
+	require.NoError(t, err)
+	assert.True(t, verified)
+
+	all, err := repo.ListVerified(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, mine.ID, all[0].ID)
+
+	_, err = db.ExecContext(ctx, `INSERT INTO urls (short_code, long_url, domain_id) VALUES ('abc', 'https://example.com', $1)`, mine.ID)
+	require.NoError(t, err)
+
+	assert.IsType(t, DomainInUse, repo.Delete(ctx, mine.ID))
+
+	_, err = db.ExecContext(ctx, `DELETE FROM urls WHERE domain_id = $1`, mine.ID)
+	require.NoError(t, err)
+	require.NoError(t, repo.Delete(ctx, mine.ID))
+
+	_, err = repo.GetByID(ctx, mine.ID)
+	assert.IsType(t, DomainNotFound, err)
+}
@@ -0,0 +1,281 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/sync/singleflight"
+)
+
+// domainRefreshInterval bounds how long a newly verified or deleted domain
+// takes to be picked up by Resolve on every instance.
+const domainRefreshInterval = 30 * time.Second
+
+// TXTResolver looks up DNS TXT records. *net.Resolver satisfies it; tests
+// pass a stub.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+type DomainService interface {
+	Register(ctx context.Context, owner Owner, req RegisterDomainRequest) (*Domain, error)
+	List(ctx context.Context, owner Owner) ([]*Domain, error)
+	Verify(ctx context.Context, owner Owner, id int64) (*Domain, error)
+	Delete(ctx context.Context, owner Owner, id int64) error
+	Resolve(ctx context.Context, hostname string) (*Domain, error)
+}
+
+type Service struct {
+	repo     DomainRepository
+	resolver TXTResolver
+	now      func() time.Time
+
+	mu          sync.Mutex
+	verified    map[string]*Domain
+	refreshedAt time.Time
+	// expirations counts calls to expire, so a reload that started before
+	// one does not mark the table fresh.
+	expirations int
+	refreshes   singleflight.Group
+}
+
+func NewService(repo DomainRepository, resolver TXTResolver) *Service {
+	return &Service{repo: repo, resolver: resolver, now: time.Now}
+}
+
+// Register claims hostname for owner. Several owners may claim the same
+// hostname until one of them verifies it.
+func (s *Service) Register(ctx context.Context, owner Owner, req RegisterDomainRequest) (*Domain, error) {
+	hostname, err := NormalizeHostname(req.Hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := s.repo.HostnameVerified(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if verified {
+		return nil, &DomainTakenErr{hostname: hostname}
+	}
+
+	token, err := newVerificationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	userID := owner.UserID
+	domain := &Domain{
+		Hostname:          hostname,
+		UserID:            &userID,
+		WorkspaceID:       owner.WorkspaceID,
+		VerificationToken: token,
+		VerificationHost:  verificationPrefix + hostname,
+	}
+
+	if err := s.repo.Insert(ctx, domain); err != nil {
+		return nil, err
+	}
+
+	return domain, nil
+}
+
+func (s *Service) List(ctx context.Context, owner Owner) ([]*Domain, error) {
+	return s.repo.ListByOwner(ctx, owner)
+}
+
+// Verify checks the domain's TXT record for its token. Verifying an already
+// verified domain is a no-op.
+func (s *Service) Verify(ctx context.Context, owner Owner, id int64) (*Domain, error) {
+	domain, err := s.owned(ctx, owner, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if domain.Verified() {
+		return domain, nil
+	}
+
+	records, err := s.resolver.LookupTXT(ctx, domain.VerificationHost)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			slog.Warn("Domain verification lookup failed", "hostname", domain.Hostname, "error", err)
+		}
+		return nil, &NotVerifiedErr{hostname: domain.Hostname}
+	}
+
+	if !containsToken(records, domain.VerificationToken) {
+		return nil, &NotVerifiedErr{hostname: domain.Hostname}
+	}
+
+	verifiedAt, err := s.repo.MarkVerified(ctx, domain.ID)
+	if err != nil {
+		var takenErr *DomainTakenErr
+		if errors.As(err, &takenErr) {
+			takenErr.hostname = domain.Hostname
+		}
+		return nil, err
+	}
+
+	domain.VerifiedAt = &verifiedAt
+	s.expire()
+
+	return domain, nil
+}
+
+// Delete removes a domain. It fails with *DomainInUseErr while links still
+// point at it.
+func (s *Service) Delete(ctx context.Context, owner Owner, id int64) error {
+	domain, err := s.owned(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, domain.ID); err != nil {
+		var inUseErr *DomainInUseErr
+		if errors.As(err, &inUseErr) {
+			inUseErr.hostname = domain.Hostname
+		}
+		return err
+	}
+
+	s.expire()
+	return nil
+}
+
+// Resolve returns the verified domain serving hostname. Verified domains are
+// kept in memory and reloaded every domainRefreshInterval; if a reload fails
+// the previous table keeps serving.
+func (s *Service) Resolve(ctx context.Context, hostname string) (*Domain, error) {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+
+	s.mu.Lock()
+	verified := s.verified
+	stale := verified == nil || s.now().Sub(s.refreshedAt) >= domainRefreshInterval
+	s.mu.Unlock()
+
+	if stale {
+		refreshed, err := s.refresh(ctx)
+		switch {
+		case err == nil:
+			verified = refreshed
+		case verified == nil:
+			return nil, err
+		default:
+			slog.Error("Failed to refresh verified domains", "error", err)
+		}
+	}
+
+	domain, ok := verified[hostname]
+	if !ok {
+		return nil, &DomainNotFoundErr{hostname: hostname}
+	}
+
+	return domain, nil
+}
+
+// refresh reloads the verified domains without holding the lock, so lookups
+// and expire are not held up by the database. Concurrent callers share one
+// reload, which the caller that started it cannot cut short.
+func (s *Service) refresh(ctx context.Context) (map[string]*Domain, error) {
+	verified, err, _ := s.refreshes.Do("verified", func() (any, error) {
+		s.mu.Lock()
+		expirations := s.expirations
+		s.mu.Unlock()
+
+		domains, err := s.repo.ListVerified(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		verified := make(map[string]*Domain, len(domains))
+		for _, domain := range domains {
+			verified[domain.Hostname] = domain
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.verified = verified
+		if s.expirations == expirations {
+			s.refreshedAt = s.now()
+		}
+		return verified, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return verified.(map[string]*Domain), nil
+}
+
+// expire forces the next Resolve on this instance to reload.
+func (s *Service) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.refreshedAt = time.Time{}
+	s.expirations++
+}
+
+func (s *Service) owned(ctx context.Context, owner Owner, id int64) (*Domain, error) {
+	domain, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !owner.Owns(domain) {
+		return nil, &DomainNotFoundErr{hostname: domain.Hostname}
+	}
+
+	return domain, nil
+}
+
+// NormalizeHostname lowercases hostname, drops a trailing dot and converts
+// internationalized names to punycode. IP addresses, single-label names and
+// ports are rejected.
+func NormalizeHostname(hostname string) (string, error) {
+	hostname = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(hostname)), ".")
+	if hostname == "" {
+		return "", &InvalidRequestErr{reason: "hostname is a required field"}
+	}
+
+	if net.ParseIP(hostname) != nil {
+		return "", &InvalidRequestErr{reason: "hostname must be a domain name, not an IP address"}
+	}
+
+	ascii, err := idna.Lookup.ToASCII(hostname)
+	if err != nil || len(ascii) > 253 || !strings.Contains(ascii, ".") {
+		return "", &InvalidRequestErr{reason: "hostname must be a fully qualified domain name"}
+	}
+
+	return ascii, nil
+}
+
+func containsToken(records []string, token string) bool {
+	for _, record := range records {
+		if strings.TrimSpace(record) == token {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newVerificationToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,355 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRepository struct {
+	domains       map[int64]*Domain
+	verifiedTaken bool
+	listCalls     int
+	listErr       error
+}
+
+func newMockRepository(domains ...*Domain) *mockRepository {
+	m := &mockRepository{domains: map[int64]*Domain{}}
+	for _, domain := range domains {
+		m.domains[domain.ID] = domain
+	}
+	return m
+}
+
+func (m *mockRepository) Insert(ctx context.Context, domain *Domain) error {
+	domain.ID = int64(len(m.domains) + 1)
+	m.domains[domain.ID] = domain
+	return nil
+}
+
+func (m *mockRepository) GetByID(ctx context.Context, id int64) (*Domain, error) {
+	domain, ok := m.domains[id]
+	if !ok {
+		return nil, &DomainNotFoundErr{}
+	}
+	return domain, nil
+}
+
+func (m *mockRepository) ListByOwner(ctx context.Context, owner Owner) ([]*Domain, error) {
+	var domains []*Domain
+	for _, domain := range m.domains {
+		if owner.Owns(domain) {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+func (m *mockRepository) ListVerified(ctx context.Context) ([]*Domain, error) {
+	m.listCalls++
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
+
+	var domains []*Domain
+	for _, domain := range m.domains {
+		if domain.Verified() {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+func (m *mockRepository) HostnameVerified(ctx context.Context, hostname string) (bool, error) {
+	for _, domain := range m.domains {
+		if domain.Hostname == hostname && domain.Verified() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *mockRepository) MarkVerified(ctx context.Context, id int64) (time.Time, error) {
+	if m.verifiedTaken {
+		return time.Time{}, &DomainTakenErr{}
+	}
+
+	now := time.Now()
+	m.domains[id].VerifiedAt = &now
+	return now, nil
+}
+
+func (m *mockRepository) Delete(ctx context.Context, id int64) error {
+	delete(m.domains, id)
+	return nil
+}
+
+type stubResolver map[string][]string
+
+func (r stubResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestNormalizeHostname(t *testing.T) {
+	testCases := []struct {
+		hostname string
+		want     string
+		wantErr  bool
+	}{
+		{hostname: " Go.Acme.COM. ", want: "go.acme.com"},
+		{hostname: "bücher.example", want: "xn--bcher-kva.example"},
+		{hostname: "", wantErr: true},
+		{hostname: "localhost", wantErr: true},
+		{hostname: "192.168.1.1", wantErr: true},
+		{hostname: "go.acme.com:8080", wantErr: true},
+		{hostname: "go_acme.com", wantErr: true},
+		{hostname: "-acme.com", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.hostname, func(t *testing.T) {
+			got, err := NormalizeHostname(tc.hostname)
+			if tc.wantErr {
+				assert.IsType(t, &InvalidRequestErr{}, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	verifiedAt := time.Now()
+	repo := newMockRepository(&Domain{ID: 1, Hostname: "taken.example", VerifiedAt: &verifiedAt})
+	service := NewService(repo, stubResolver{})
+
+	domain, err := service.Register(context.Background(), Owner{UserID: 3, WorkspaceID: int64Ptr(9)}, RegisterDomainRequest{Hostname: "Go.Acme.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "go.acme.com", domain.Hostname)
+	assert.Equal(t, "_linkshortener.go.acme.com", domain.VerificationHost)
+	assert.Len(t, domain.VerificationToken, 32)
+	assert.Equal(t, int64(3), *domain.UserID)
+	assert.Equal(t, int64(9), *domain.WorkspaceID)
+	assert.False(t, domain.Verified())
+
+	_, err = service.Register(context.Background(), Owner{UserID: 3}, RegisterDomainRequest{Hostname: "taken.example"})
+	assert.IsType(t, DomainTaken, err)
+
+	_, err = service.Register(context.Background(), Owner{UserID: 3}, RegisterDomainRequest{Hostname: "not a host"})
+	assert.IsType(t, InvalidRequest, err)
+}
+
+func TestVerify(t *testing.T) {
+	newDomain := func() *Domain {
+		return &Domain{ID: 1, Hostname: "go.acme.com", UserID: int64Ptr(3), VerificationToken: "token", VerificationHost: "_linkshortener.go.acme.com"}
+	}
+
+	testCases := []struct {
+		name          string
+		owner         Owner
+		records       stubResolver
+		verifiedTaken bool
+		wantErr       error
+	}{
+		{
+			name:    "token published",
+			owner:   Owner{UserID: 3},
+			records: stubResolver{"_linkshortener.go.acme.com": {"other", "token"}},
+		},
+		{
+			name:    "record missing",
+			owner:   Owner{UserID: 3},
+			records: stubResolver{},
+			wantErr: NotVerified,
+		},
+		{
+			name:    "wrong token",
+			owner:   Owner{UserID: 3},
+			records: stubResolver{"_linkshortener.go.acme.com": {"stale"}},
+			wantErr: NotVerified,
+		},
+		{
+			name:          "verified by someone else first",
+			owner:         Owner{UserID: 3},
+			records:       stubResolver{"_linkshortener.go.acme.com": {"token"}},
+			verifiedTaken: true,
+			wantErr:       DomainTaken,
+		},
+		{
+			name:    "another user's domain",
+			owner:   Owner{UserID: 4},
+			records: stubResolver{"_linkshortener.go.acme.com": {"token"}},
+			wantErr: DomainNotFound,
+		},
+		{
+			name:    "personal domain from a workspace",
+			owner:   Owner{UserID: 3, WorkspaceID: int64Ptr(9)},
+			records: stubResolver{"_linkshortener.go.acme.com": {"token"}},
+			wantErr: DomainNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMockRepository(newDomain())
+			repo.verifiedTaken = tc.verifiedTaken
+			service := NewService(repo, tc.records)
+
+			domain, err := service.Verify(context.Background(), tc.owner, 1)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.True(t, domain.Verified())
+
+			resolved, err := service.Resolve(context.Background(), "go.acme.com")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), resolved.ID)
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Now()
+	repo := newMockRepository(
+		&Domain{ID: 1, Hostname: "go.acme.com", VerifiedAt: &now},
+		&Domain{ID: 2, Hostname: "pending.acme.com"},
+	)
+	service := NewService(repo, stubResolver{})
+	service.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	domain, err := service.Resolve(ctx, "GO.ACME.COM.")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), domain.ID)
+
+	_, err = service.Resolve(ctx, "pending.acme.com")
+	assert.IsType(t, DomainNotFound, err)
+	assert.Equal(t, 1, repo.listCalls, "lookups within the refresh interval are served from memory")
+
+	now = now.Add(domainRefreshInterval)
+	repo.listErr = errors.New("database down")
+
+	domain, err = service.Resolve(ctx, "go.acme.com")
+	require.NoError(t, err, "a failed refresh keeps the previous table")
+	assert.Equal(t, int64(1), domain.ID)
+	assert.Equal(t, 2, repo.listCalls)
+}
+
+func TestResolve_InitialLoadFails(t *testing.T) {
+	repo := newMockRepository()
+	repo.listErr = errors.New("database down")
+	service := NewService(repo, stubResolver{})
+
+	_, err := service.Resolve(context.Background(), "go.acme.com")
+	assert.EqualError(t, err, "database down")
+}
+
+// blockingRepository holds its first ListVerified until release is closed.
+type blockingRepository struct {
+	*mockRepository
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (r *blockingRepository) ListVerified(ctx context.Context) ([]*Domain, error) {
+	r.once.Do(func() {
+		close(r.started)
+		<-r.release
+	})
+	return r.mockRepository.ListVerified(ctx)
+}
+
+func newBlockingService() (*Service, *blockingRepository) {
+	now := time.Now()
+	repo := &blockingRepository{
+		mockRepository: newMockRepository(&Domain{ID: 1, Hostname: "go.acme.com", VerifiedAt: &now}),
+		started:        make(chan struct{}),
+		release:        make(chan struct{}),
+	}
+	service := NewService(repo, stubResolver{})
+	service.now = func() time.Time { return now }
+	return service, repo
+}
+
+func TestResolve_ConcurrentRefresh(t *testing.T) {
+	service, repo := newBlockingService()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = service.Resolve(context.Background(), "go.acme.com")
+		}()
+	}
+
+	<-repo.started
+	close(repo.release)
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, repo.listCalls, "concurrent lookups share one reload")
+}
+
+func TestResolve_ExpireDuringRefresh(t *testing.T) {
+	service, repo := newBlockingService()
+
+	resolved := make(chan error)
+	go func() {
+		_, err := service.Resolve(context.Background(), "go.acme.com")
+		resolved <- err
+	}()
+
+	<-repo.started
+	expired := make(chan struct{})
+	go func() {
+		service.expire()
+		close(expired)
+	}()
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("expire waited for the reload")
+	}
+
+	close(repo.release)
+	require.NoError(t, <-resolved)
+
+	_, err := service.Resolve(context.Background(), "go.acme.com")
+	require.NoError(t, err)
+	assert.Equal(t, 2, repo.listCalls, "an expiry during a reload is not lost")
+}
+
+func TestDelete(t *testing.T) {
+	repo := newMockRepository(&Domain{ID: 1, Hostname: "go.acme.com", WorkspaceID: int64Ptr(9)})
+	service := NewService(repo, stubResolver{})
+
+	err := service.Delete(context.Background(), Owner{UserID: 3}, 1)
+	assert.IsType(t, DomainNotFound, err)
+
+	require.NoError(t, service.Delete(context.Background(), Owner{UserID: 3, WorkspaceID: int64Ptr(9)}, 1))
+	assert.Empty(t, repo.domains)
+}
@@ -0,0 +1,36 @@
+package linkimport
+
+import (
+	"hafiztri123/app-link-shortener/internal/url"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// Result is the outcome of one row of an import file, numbered from 1.
+type Result struct {
+	Row int `json:"row"`
+	url.CreateShortCodeBulkResult
+}
+
+// Job is an import running in the background. Its results grow as rows are
+// processed.
+type Job struct {
+	ID        string    `json:"id"`
+	UserID    int64     `json:"-"`
+	Status    JobStatus `json:"status"`
+	Total     int       `json:"total"`
+	Processed int       `json:"processed"`
+	Failed    int       `json:"failed"`
+	Results   []Result  `json:"results,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,47 @@
+package linkimport
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+var JobNotFound = &JobNotFoundErr{}
+var InvalidFile = &InvalidFileErr{}
+var UnsupportedFormat = &UnsupportedFormatErr{}
+var Forbidden = &ForbiddenErr{}
+
+// JobNotFoundErr is also returned for other users' jobs.
+type JobNotFoundErr struct {
+	id string
+}
+
+func (e *JobNotFoundErr) Error() string {
+	slog.Debug("Import job not found", "job_id", e.id)
+	return "Import job not found"
+}
+
+type InvalidFileErr struct {
+	line   int
+	reason string
+}
+
+func (e *InvalidFileErr) Error() string {
+	if e.line == 0 {
+		return e.reason
+	}
+	return fmt.Sprintf("line %d: %s", e.line, e.reason)
+}
+
+type UnsupportedFormatErr struct {
+	contentType string
+}
+
+func (e *UnsupportedFormatErr) Error() string {
+	return fmt.Sprintf("Unsupported import format %q, send text/csv or application/x-ndjson", e.contentType)
+}
+
+type ForbiddenErr struct{}
+
+func (e *ForbiddenErr) Error() string {
+	return "You are not allowed to create links in this workspace"
+}
@@ -0,0 +1,347 @@
+package linkimport
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/url"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"hpj/hv1-link-shortener/shared/models"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// batchSize is how many rows are shortened between two progress reports.
+const batchSize = 100
+
+// jobTTL is how long a job and its results can be looked up after its last
+// progress.
+const jobTTL = 24 * time.Hour
+
+// LinkImporter creates the links of import rows. url.Service is one.
+type LinkImporter interface {
+	ImportLinks(ctx context.Context, rows []models.LinkImportRow) ([]url.CreateShortCodeBulkResult, error)
+}
+
+// Memberships looks up the current role of a user in a workspace.
+// workspace.Service is one.
+type Memberships interface {
+	GetMembership(ctx context.Context, workspaceID int64, userID int64) (*workspace.Member, error)
+}
+
+// Publisher queues imports to run in the background.
+type Publisher interface {
+	PublishLinkImport(ctx context.Context, job *models.LinkImport) error
+}
+
+type ImportService interface {
+	Import(ctx context.Context, rows []models.LinkImportRow, async bool, emit func([]Result) error) (*Job, error)
+	Job(ctx context.Context, userID int64, id string) (*Job, error)
+	Handle(ctx context.Context, body []byte) error
+}
+
+type Service struct {
+	links     LinkImporter
+	validator *utils.URLValidator
+	members   Memberships
+	redis     *redis.Client
+	queue     Publisher
+	syncRows  int
+	now       func() time.Time
+}
+
+// NewService returns a service that imports files of up to syncRows rows
+// while the caller waits and queues larger ones.
+func NewService(links LinkImporter, validator *utils.URLValidator, members Memberships, redis *redis.Client, queue Publisher, syncRows int) *Service {
+	return &Service{links: links, validator: validator, members: members, redis: redis, queue: queue, syncRows: syncRows, now: time.Now}
+}
+
+// Import creates the links of rows for the caller. Unless async is set or
+// there are more than syncRows rows, they are created right away and their
+// results handed to emit a batch at a time, and the returned job is nil.
+// Otherwise the rows are queued and the job tracking them is returned.
+func (s *Service) Import(ctx context.Context, rows []models.LinkImportRow, async bool, emit func([]Result) error) (*Job, error) {
+	member := workspace.GetMemberFromContext(ctx)
+	if member != nil && !member.Role.CanEditLinks() {
+		return nil, &ForbiddenErr{}
+	}
+
+	if !async && len(rows) <= s.syncRows {
+		return nil, s.run(ctx, rows, nil, emit)
+	}
+
+	claims, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &models.LinkImport{UserID: claims.UserID, Rows: rows, Timestamp: s.now()}
+	if member != nil {
+		msg.WorkspaceID = &member.WorkspaceID
+	}
+
+	if msg.JobID, err = newJobID(); err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        msg.JobID,
+		UserID:    claims.UserID,
+		Status:    JobQueued,
+		Total:     len(rows),
+		CreatedAt: msg.Timestamp,
+		UpdatedAt: msg.Timestamp,
+	}
+	if err := s.save(ctx, job); err != nil {
+		return nil, err
+	}
+
+	if err := s.queue.PublishLinkImport(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// Job returns the caller's import job id.
+func (s *Service) Job(ctx context.Context, userID int64, id string) (*Job, error) {
+	job, err := s.header(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.redis.LRange(ctx, resultsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, value := range values {
+		var result Result
+		if err := json.Unmarshal([]byte(value), &result); err != nil {
+			return nil, err
+		}
+		job.Results = append(job.Results, result)
+	}
+
+	return job, nil
+}
+
+// header returns the caller's import job id without its results.
+func (s *Service) header(ctx context.Context, userID int64, id string) (*Job, error) {
+	value, err := s.redis.Get(ctx, jobKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, &JobNotFoundErr{id: id}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var job Job
+	stored := storedJob{Job: &job}
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+	job.UserID = stored.UserID
+	if job.UserID != userID {
+		return nil, &JobNotFoundErr{id: id}
+	}
+
+	return &job, nil
+}
+
+// Handle runs a queued import as the user that uploaded it, recording its
+// progress after every batch. The uploader's workspace role is looked up
+// again before each batch, so an import stops once they may no longer edit
+// the workspace's links.
+func (s *Service) Handle(ctx context.Context, body []byte) error {
+	var msg models.LinkImport
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return err
+	}
+
+	job, err := s.header(ctx, msg.UserID, msg.JobID)
+	if err != nil {
+		return err
+	}
+
+	ctx = context.WithValue(ctx, shared.UserContextKey, &auth.Claims{UserID: msg.UserID})
+
+	job.Status = JobRunning
+	err = s.run(ctx, msg.Rows, s.uploaderMembership(msg), func(results []Result) error {
+		job.Processed += len(results)
+		for _, result := range results {
+			if result.Status == url.BulkItemFailed {
+				job.Failed++
+			}
+		}
+		return s.saveProgress(ctx, job, results)
+	})
+
+	job.Status = JobDone
+	var forbiddenErr *ForbiddenErr
+	switch {
+	case errors.As(err, &forbiddenErr):
+		job.Status = JobFailed
+		job.Error = "you may no longer create links in the workspace, the rows before it were imported"
+		err = nil
+	case err != nil:
+		slog.Error("Link import failed", "error", err, "job_id", job.ID)
+		job.Status = JobFailed
+		job.Error = "import stopped unexpectedly, the rows before it were imported"
+	}
+	if saveErr := s.save(ctx, job); saveErr != nil {
+		return saveErr
+	}
+
+	return err
+}
+
+// uploaderMembership returns the authorize step of run for msg: it adds the
+// uploader's current membership of the workspace msg was uploaded to, if
+// any, to the context.
+func (s *Service) uploaderMembership(msg models.LinkImport) func(context.Context) (context.Context, error) {
+	return func(ctx context.Context) (context.Context, error) {
+		if msg.WorkspaceID == nil {
+			return ctx, nil
+		}
+
+		member, err := s.members.GetMembership(ctx, *msg.WorkspaceID, msg.UserID)
+		var notFoundErr *workspace.WorkspaceNotFoundErr
+		if errors.As(err, &notFoundErr) {
+			return nil, &ForbiddenErr{}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !member.Role.CanEditLinks() {
+			return nil, &ForbiddenErr{}
+		}
+
+		return context.WithValue(ctx, shared.WorkspaceContextKey, member), nil
+	}
+}
+
+// run creates the links of rows a batch at a time. Rows with an invalid
+// URL fail on their own without stopping the others. When authorize is set,
+// each batch runs in the context it returns, and its error stops the run.
+func (s *Service) run(ctx context.Context, rows []models.LinkImportRow, authorize func(context.Context) (context.Context, error), emit func([]Result) error) error {
+	batchCtx := ctx
+	for start := 0; start < len(rows); start += batchSize {
+		if authorize != nil {
+			var err error
+			if batchCtx, err = authorize(ctx); err != nil {
+				return err
+			}
+		}
+
+		batch := rows[start:min(start+batchSize, len(rows))]
+		results := make([]Result, len(batch))
+
+		var valid []models.LinkImportRow
+		var validIndex []int
+		for i, row := range batch {
+			results[i].Row = start + i + 1
+			if err := s.validator.Validate(batchCtx, row.LongURL); err != nil {
+				code := string(utils.URLMalformed)
+				var invalidErr *utils.InvalidURLErr
+				if errors.As(err, &invalidErr) {
+					code = string(invalidErr.Reason())
+				}
+				results[i].CreateShortCodeBulkResult = url.BulkFailure(row.LongURL, code, err)
+				continue
+			}
+			valid = append(valid, row)
+			validIndex = append(validIndex, i)
+		}
+
+		if len(valid) > 0 {
+			created, err := s.links.ImportLinks(batchCtx, valid)
+			if err != nil {
+				return err
+			}
+			for j, result := range created {
+				results[validIndex[j]].CreateShortCodeBulkResult = result
+			}
+		}
+
+		if err := emit(results); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// save records the status and counters of job. Its results are kept apart
+// and only ever appended to, by saveProgress.
+func (s *Service) save(ctx context.Context, job *Job) error {
+	value, err := s.marshalHeader(job)
+	if err != nil {
+		return err
+	}
+
+	return s.redis.Set(ctx, jobKey(job.ID), value, jobTTL).Err()
+}
+
+// saveProgress appends the results of a batch of job and records its
+// counters in one transaction.
+func (s *Service) saveProgress(ctx context.Context, job *Job, results []Result) error {
+	value, err := s.marshalHeader(job)
+	if err != nil {
+		return err
+	}
+
+	encoded := make([]any, len(results))
+	for i, result := range results {
+		if encoded[i], err = json.Marshal(result); err != nil {
+			return err
+		}
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.RPush(ctx, resultsKey(job.ID), encoded...)
+	pipe.Expire(ctx, resultsKey(job.ID), jobTTL)
+	pipe.Set(ctx, jobKey(job.ID), value, jobTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *Service) marshalHeader(job *Job) (string, error) {
+	job.UpdatedAt = s.now()
+
+	header := *job
+	header.Results = nil
+	value, err := json.Marshal(storedJob{Job: &header, UserID: job.UserID})
+	return string(value), err
+}
+
+// storedJob keeps the owner of a job, which is not shown to the caller.
+type storedJob struct {
+	*Job
+	UserID int64 `json:"user_id"`
+}
+
+func jobKey(id string) string {
+	return "import:" + id
+}
+
+func resultsKey(id string) string {
+	return "import:" + id + ":results"
+}
+
+func newJobID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
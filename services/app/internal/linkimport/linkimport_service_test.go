@@ -0,0 +1,240 @@
+package linkimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/url"
+	"hafiztri123/app-link-shortener/internal/utils"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"hpj/hv1-link-shortener/shared/models"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubImporter struct {
+	calls  [][]models.LinkImportRow
+	err    error
+	userID int64
+	member *workspace.Member
+}
+
+func (s *stubImporter) ImportLinks(ctx context.Context, rows []models.LinkImportRow) ([]url.CreateShortCodeBulkResult, error) {
+	s.calls = append(s.calls, rows)
+	if claims, err := auth.GetUserFromContext(ctx); err == nil {
+		s.userID = claims.UserID
+	}
+	s.member = workspace.GetMemberFromContext(ctx)
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	results := make([]url.CreateShortCodeBulkResult, len(rows))
+	for i, row := range rows {
+		results[i] = url.CreateShortCodeBulkResult{LongURL: row.LongURL, ShortCode: fmt.Sprint("c", i), Status: url.BulkItemCreated}
+	}
+	return results, nil
+}
+
+type stubQueue struct {
+	published *models.LinkImport
+}
+
+func (s *stubQueue) PublishLinkImport(ctx context.Context, job *models.LinkImport) error {
+	s.published = job
+	return nil
+}
+
+func rows(n int) []models.LinkImportRow {
+	rows := make([]models.LinkImportRow, n)
+	for i := range rows {
+		rows[i] = models.LinkImportRow{LongURL: fmt.Sprintf("https://example.com/%d", i)}
+	}
+	return rows
+}
+
+func userContext(member *workspace.Member) context.Context {
+	ctx := context.WithValue(context.Background(), shared.UserContextKey, &auth.Claims{UserID: 1})
+	if member != nil {
+		ctx = context.WithValue(ctx, shared.WorkspaceContextKey, member)
+	}
+	return ctx
+}
+
+func TestImport_Sync(t *testing.T) {
+	importer := &stubImporter{}
+	service := NewService(importer, utils.NewURLValidator(nil, 0), nil, nil, nil, 500)
+
+	input := rows(250)
+	input[120].LongURL = "http://127.0.0.1/admin"
+
+	var batches [][]Result
+	job, err := service.Import(userContext(nil), input, false, func(results []Result) error {
+		batches = append(batches, results)
+		return nil
+	})
+
+	require.NoError(t, err)
+	assert.Nil(t, job)
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 100)
+	assert.Len(t, batches[2], 50)
+
+	assert.Len(t, importer.calls[1], 99, "the invalid row is not handed to the importer")
+	assert.Equal(t, 121, batches[1][20].Row)
+	assert.Equal(t, url.BulkItemFailed, batches[1][20].Status)
+	assert.Equal(t, string(utils.URLReservedAddress), batches[1][20].Code)
+	assert.Equal(t, 122, batches[1][21].Row)
+	assert.Equal(t, "https://example.com/121", batches[1][21].LongURL)
+	assert.Equal(t, url.BulkItemCreated, batches[1][21].Status)
+}
+
+func TestImport_StopsOnError(t *testing.T) {
+	service := NewService(&stubImporter{err: errors.New("db down")}, utils.NewURLValidator(nil, 0), nil, nil, nil, 500)
+
+	_, err := service.Import(userContext(nil), rows(1), false, func([]Result) error { return nil })
+	assert.EqualError(t, err, "db down")
+}
+
+func TestImport_Forbidden(t *testing.T) {
+	service := NewService(&stubImporter{}, utils.NewURLValidator(nil, 0), nil, nil, &stubQueue{}, 500)
+
+	_, err := service.Import(userContext(&workspace.Member{WorkspaceID: 7, Role: workspace.RoleViewer}), rows(1), true, nil)
+	assert.ErrorAs(t, err, &Forbidden)
+}
+
+func TestImport_Queued(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.Regexp().ExpectSet(`import:[0-9a-f]{32}`, `"status":"queued","total":3`, jobTTL).SetVal("OK")
+
+	queue := &stubQueue{}
+	service := NewService(&stubImporter{}, utils.NewURLValidator(nil, 0), nil, redisClient, queue, 2)
+
+	member := &workspace.Member{WorkspaceID: 7, Role: workspace.RoleEditor}
+	job, err := service.Import(userContext(member), rows(3), false, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, JobQueued, job.Status)
+	assert.Equal(t, 3, job.Total)
+	require.NotNil(t, queue.published)
+	assert.Equal(t, job.ID, queue.published.JobID)
+	assert.Equal(t, int64(1), queue.published.UserID)
+	assert.Equal(t, int64(7), *queue.published.WorkspaceID)
+	assert.Len(t, queue.published.Rows, 3)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestJob(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	service := NewService(nil, nil, nil, redisClient, nil, 0)
+
+	redisMock.ExpectGet("import:abc").SetVal(`{"id":"abc","user_id":1,"status":"done","total":2,"processed":2}`)
+	redisMock.ExpectLRange("import:abc:results", 0, -1).SetVal([]string{`{"row":1,"short_code":"a","status":"created"}`, `{"row":2,"status":"failed"}`})
+	job, err := service.Job(context.Background(), 1, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, JobDone, job.Status)
+	assert.Equal(t, int64(1), job.UserID)
+	require.Len(t, job.Results, 2)
+	assert.Equal(t, "a", job.Results[0].ShortCode)
+	assert.Equal(t, 2, job.Results[1].Row)
+
+	redisMock.ExpectGet("import:abc").SetVal(`{"id":"abc","user_id":1,"status":"done"}`)
+	_, err = service.Job(context.Background(), 2, "abc")
+	assert.ErrorAs(t, err, &JobNotFound)
+
+	redisMock.ExpectGet("import:missing").RedisNil()
+	_, err = service.Job(context.Background(), 1, "missing")
+	assert.ErrorAs(t, err, &JobNotFound)
+}
+
+type stubMemberships struct {
+	roles []workspace.Role
+	calls int
+}
+
+// GetMembership returns the next of roles, or no membership once they run
+// out.
+func (s *stubMemberships) GetMembership(ctx context.Context, workspaceID int64, userID int64) (*workspace.Member, error) {
+	s.calls++
+	if s.calls > len(s.roles) {
+		return nil, &workspace.WorkspaceNotFoundErr{}
+	}
+	return &workspace.Member{WorkspaceID: workspaceID, UserID: userID, Role: s.roles[s.calls-1]}, nil
+}
+
+func TestHandle(t *testing.T) {
+	testCases := []struct {
+		name         string
+		importErr    error
+		roles        []workspace.Role
+		wantBatches  int
+		wantStatus   JobStatus
+		wantJobError string
+		wantErr      bool
+	}{
+		{name: "done", roles: []workspace.Role{workspace.RoleEditor, workspace.RoleEditor}, wantBatches: 2, wantStatus: JobDone},
+		{name: "failed", importErr: errors.New("db down"), roles: []workspace.Role{workspace.RoleEditor}, wantStatus: JobFailed, wantJobError: "import stopped unexpectedly", wantErr: true},
+		{name: "demoted while running", roles: []workspace.Role{workspace.RoleEditor, workspace.RoleViewer}, wantBatches: 1, wantStatus: JobFailed, wantJobError: "may no longer create links"},
+		{name: "removed while running", roles: []workspace.Role{workspace.RoleEditor}, wantBatches: 1, wantStatus: JobFailed, wantJobError: "may no longer create links"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redisClient, redisMock := redismock.NewClientMock()
+
+			importer := &stubImporter{err: tc.importErr}
+			members := &stubMemberships{roles: tc.roles}
+			service := NewService(importer, utils.NewURLValidator(nil, 0), members, redisClient, nil, 0)
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			service.now = func() time.Time { return now }
+
+			workspaceID := int64(7)
+			input := rows(150)
+			body, _ := json.Marshal(models.LinkImport{JobID: "abc", UserID: 1, WorkspaceID: &workspaceID, Rows: input})
+
+			redisMock.ExpectGet("import:abc").SetVal(`{"id":"abc","user_id":1,"status":"queued","total":150}`)
+
+			// Each batch appends only its own results.
+			for batch := 0; batch < tc.wantBatches; batch++ {
+				start, end := batch*batchSize, min((batch+1)*batchSize, len(input))
+				results := make([]any, end-start)
+				for i := range results {
+					encoded, _ := json.Marshal(Result{Row: start + i + 1, CreateShortCodeBulkResult: url.CreateShortCodeBulkResult{LongURL: input[start+i].LongURL, ShortCode: fmt.Sprint("c", i), Status: url.BulkItemCreated}})
+					results[i] = encoded
+				}
+
+				redisMock.ExpectTxPipeline()
+				redisMock.ExpectRPush("import:abc:results", results...).SetVal(int64(end))
+				redisMock.ExpectExpire("import:abc:results", jobTTL).SetVal(true)
+				redisMock.Regexp().ExpectSet("import:abc", fmt.Sprintf(`"status":"running","total":150,"processed":%d,`, end), jobTTL).SetVal("OK")
+				redisMock.ExpectTxPipelineExec()
+			}
+			final := fmt.Sprintf(`"status":"%s","total":150,`, tc.wantStatus)
+			if tc.wantJobError != "" {
+				final += `.*"error":".*` + tc.wantJobError
+			}
+			redisMock.Regexp().ExpectSet("import:abc", final, jobTTL).SetVal("OK")
+
+			err := service.Handle(context.Background(), body)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.Equal(t, int64(1), importer.userID)
+			assert.Len(t, importer.calls, max(tc.wantBatches, 1), "no batch runs after the role check fails")
+			require.NotNil(t, importer.member)
+			assert.Equal(t, workspaceID, importer.member.WorkspaceID)
+			assert.Equal(t, workspace.RoleEditor, importer.member.Role)
+			assert.NoError(t, redisMock.ExpectationsWereMet())
+		})
+	}
+}
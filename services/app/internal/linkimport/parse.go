@@ -0,0 +1,189 @@
+package linkimport
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hpj/hv1-link-shortener/shared/models"
+	"io"
+	"mime"
+	"strings"
+	"time"
+)
+
+// maxLineSize bounds a single NDJSON line.
+const maxLineSize = 1 << 20
+
+// Parse reads the rows of an import file: CSV with a header row naming its
+// columns, or NDJSON with one object per line. Both carry long_url and,
+// optionally, alias, title, description, tags and an RFC 3339 expires_at;
+// other columns are ignored. Files of more than maxRows rows are refused.
+func Parse(contentType string, r io.Reader, maxRows int) ([]models.LinkImportRow, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, &UnsupportedFormatErr{contentType: contentType}
+	}
+
+	var rows []models.LinkImportRow
+	switch mediaType {
+	case "text/csv", "application/csv":
+		rows, err = parseCSV(r, maxRows)
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		rows, err = parseNDJSON(r, maxRows)
+	default:
+		return nil, &UnsupportedFormatErr{contentType: contentType}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, &InvalidFileErr{reason: "file has no rows"}
+	}
+
+	return rows, nil
+}
+
+func parseCSV(r io.Reader, maxRows int) ([]models.LinkImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, &InvalidFileErr{reason: "file has no rows"}
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\uFEFF")))] = i
+	}
+	if _, ok := columns["long_url"]; !ok {
+		return nil, &InvalidFileErr{line: 1, reason: "header must have a long_url column"}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return UnescapeCell(strings.TrimSpace(record[i]))
+		}
+		return ""
+	}
+
+	var rows []models.LinkImportRow
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, csvError(err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(rows) == maxRows {
+			return nil, &InvalidFileErr{line: line, reason: fmt.Sprintf("file has more than %d rows", maxRows)}
+		}
+
+		row := models.LinkImportRow{
+			LongURL:     field(record, "long_url"),
+			Alias:       field(record, "alias"),
+			Title:       field(record, "title"),
+			Description: field(record, "description"),
+			Tags:        splitTags(field(record, "tags")),
+		}
+		if row.LongURL == "" {
+			return nil, &InvalidFileErr{line: line, reason: "long_url is required"}
+		}
+
+		if raw := field(record, "expires_at"); raw != "" {
+			expiresAt, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, &InvalidFileErr{line: line, reason: "expires_at must be an RFC 3339 timestamp"}
+			}
+			row.ExpiresAt = &expiresAt
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+// formulaPrefixes start the cells spreadsheets evaluate as formulas.
+const formulaPrefixes = "=+-@\t\r"
+
+// EscapeCell prefixes CSV cells spreadsheets would evaluate as formulas with
+// a quote, so opening an export never runs what a link's title or URL says.
+func EscapeCell(cell string) string {
+	if cell != "" && strings.IndexByte(formulaPrefixes, cell[0]) >= 0 {
+		return "'" + cell
+	}
+	return cell
+}
+
+// UnescapeCell undoes EscapeCell, so exports import again unchanged.
+func UnescapeCell(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.IndexByte(formulaPrefixes, cell[1]) >= 0 {
+		return cell[1:]
+	}
+	return cell
+}
+
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &InvalidFileErr{line: parseErr.Line, reason: parseErr.Err.Error()}
+	}
+	return err
+}
+
+// splitTags reads a tags cell, separated by commas or semicolons.
+func splitTags(cell string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(cell, func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func parseNDJSON(r io.Reader, maxRows int) ([]models.LinkImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+
+	var rows []models.LinkImportRow
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if len(rows) == maxRows {
+			return nil, &InvalidFileErr{line: line, reason: fmt.Sprintf("file has more than %d rows", maxRows)}
+		}
+
+		var row models.LinkImportRow
+		if err := json.Unmarshal([]byte(text), &row); err != nil {
+			return nil, &InvalidFileErr{line: line, reason: "invalid JSON object"}
+		}
+		row.LongURL = strings.TrimSpace(row.LongURL)
+		if row.LongURL == "" {
+			return nil, &InvalidFileErr{line: line, reason: "long_url is required"}
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, &InvalidFileErr{reason: "line is too long"}
+		}
+		return nil, err
+	}
+
+	return rows, nil
+}
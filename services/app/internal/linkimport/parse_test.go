@@ -0,0 +1,119 @@
+package linkimport
+
+import (
+	"errors"
+	"hpj/hv1-link-shortener/shared/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []models.LinkImportRow
+		wantErr     string
+	}{
+		{
+			name:        "csv with every column",
+			contentType: "text/csv; charset=utf-8",
+			body:        "\uFEFFLong_URL,alias,title,description,tags,expires_at,notes\nhttps://example.com,home,Home,Landing page,\"a, b;c\",2030-01-02T03:04:05Z,ignored\nhttps://example.org\n",
+			want: []models.LinkImportRow{
+				{LongURL: "https://example.com", Alias: "home", Title: "Home", Description: "Landing page", Tags: []string{"a", "b", "c"}, ExpiresAt: &expiresAt},
+				{LongURL: "https://example.org"},
+			},
+		},
+		{
+			name:        "csv with escaped formula cells",
+			contentType: "text/csv",
+			body:        "long_url,title,description\nhttps://example.com,'=SUM(A1),'it's\n",
+			want: []models.LinkImportRow{
+				{LongURL: "https://example.com", Title: "=SUM(A1)", Description: "'it's"},
+			},
+		},
+		{
+			name:        "csv without long_url column",
+			contentType: "text/csv",
+			body:        "url\nhttps://example.com\n",
+			wantErr:     "line 1: header must have a long_url column",
+		},
+		{
+			name:        "csv with empty long_url",
+			contentType: "text/csv",
+			body:        "long_url,alias\nhttps://example.com,\n,home\n",
+			wantErr:     "line 3: long_url is required",
+		},
+		{
+			name:        "csv with bad expiry",
+			contentType: "application/csv",
+			body:        "long_url,expires_at\nhttps://example.com,tomorrow\n",
+			wantErr:     "line 2: expires_at must be an RFC 3339 timestamp",
+		},
+		{
+			name:        "csv with only a header",
+			contentType: "text/csv",
+			body:        "long_url\n",
+			wantErr:     "file has no rows",
+		},
+		{
+			name:        "csv with too many rows",
+			contentType: "text/csv",
+			body:        "long_url\nhttps://a.example\nhttps://b.example\nhttps://c.example\nhttps://d.example\n",
+			wantErr:     "line 5: file has more than 3 rows",
+		},
+		{
+			name:        "ndjson",
+			contentType: "application/x-ndjson",
+			body:        `{"long_url":" https://example.com ","tags":["a"],"expires_at":"2030-01-02T03:04:05Z"}` + "\n\n" + `{"long_url":"https://example.org","alias":"org"}`,
+			want: []models.LinkImportRow{
+				{LongURL: "https://example.com", Tags: []string{"a"}, ExpiresAt: &expiresAt},
+				{LongURL: "https://example.org", Alias: "org"},
+			},
+		},
+		{
+			name:        "ndjson with invalid line",
+			contentType: "application/x-ndjson",
+			body:        `{"long_url":"https://example.com"}` + "\n" + `{"long_url":`,
+			wantErr:     "line 2: invalid JSON object",
+		},
+		{
+			name:        "ndjson without long_url",
+			contentType: "application/ndjson",
+			body:        `{"alias":"home"}`,
+			wantErr:     "line 1: long_url is required",
+		},
+		{
+			name:        "unsupported format",
+			contentType: "application/json",
+			body:        `[]`,
+			wantErr:     `Unsupported import format "application/json", send text/csv or application/x-ndjson`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := Parse(tc.contentType, strings.NewReader(tc.body), 3)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, rows)
+		})
+	}
+}
+
+func TestParse_ErrorTypes(t *testing.T) {
+	_, err := Parse("text/plain", strings.NewReader("https://example.com"), 10)
+	assert.True(t, errors.As(err, &UnsupportedFormat))
+
+	_, err = Parse("text/csv", strings.NewReader("long_url\n\"https://example.com\n"), 10)
+	assert.True(t, errors.As(err, &InvalidFile))
+}
@@ -23,12 +23,32 @@ var (
 		},
 		[]string{"method", "path"}, // Labels
 	)
+
+	urlCacheLookupsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "url_cache_lookups_total",
+			Help: "Short code cache lookups by cache tier and result.",
+		},
+		[]string{"tier", "result"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(httpRequestsTotal)
 
 	prometheus.MustRegister(httpRequestDuration)
+
+	prometheus.MustRegister(urlCacheLookupsTotal)
+}
+
+// RecordCacheLookup counts a hit or miss on a cache tier ("local", "redis").
+func RecordCacheLookup(tier string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	urlCacheLookupsTotal.WithLabelValues(tier, result).Inc()
 }
 
 func PrometheusMiddleware(next http.Handler) http.Handler {
@@ -0,0 +1,53 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// ConsumeLinkImports hands queued link imports to handle one at a time until
+// ctx is done. Messages handle fails on are dropped rather than redelivered:
+// an import that failed part way would create its links twice.
+func (r *RabbitMQ) ConsumeLinkImports(ctx context.Context, handle func(context.Context, []byte) error) error {
+	ch, err := r.conn.Channel()
+	if err != nil {
+		slog.Error("failed to open consumer channel", "error", err)
+		return err
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(r.queues.LinkImport, true, false, false, false, nil); err != nil {
+		slog.Error("failed to declare queue", "error", err, "queue", r.queues.LinkImport)
+		return err
+	}
+
+	if err := ch.Qos(1, 0, false); err != nil {
+		return err
+	}
+
+	msgs, err := ch.ConsumeWithContext(ctx, r.queues.LinkImport, "", false, false, false, false, nil)
+	if err != nil {
+		slog.Error("failed to consume", "error", err, "queue", r.queues.LinkImport)
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case msg, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("channel closed")
+			}
+
+			if err := handle(ctx, msg.Body); err != nil {
+				slog.Error("failed to handle message", "error", err, "queue", r.queues.LinkImport)
+				msg.Nack(false, false)
+				continue
+			}
+			msg.Ack(false)
+		}
+	}
+}
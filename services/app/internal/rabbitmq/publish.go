@@ -11,16 +11,32 @@ import (
 )
 
 func (r *RabbitMQ) PublishClickEvent(ctx context.Context, clickEvent *models.Click) error {
-	body, err := json.Marshal(clickEvent)
+	return r.publish(ctx, r.queues.Click, clickEvent)
+}
+
+func (r *RabbitMQ) PublishLinkPurge(ctx context.Context, purge *models.LinkPurge) error {
+	return r.publish(ctx, r.queues.LinkPurge, purge)
+}
+
+func (r *RabbitMQ) PublishLinkImport(ctx context.Context, job *models.LinkImport) error {
+	return r.publish(ctx, r.queues.LinkImport, job)
+}
+
+func (r *RabbitMQ) PublishLinkMetadata(ctx context.Context, fetch *models.LinkMetadataFetch) error {
+	return r.publish(ctx, r.queues.LinkMetadata, fetch)
+}
+
+func (r *RabbitMQ) publish(ctx context.Context, queueLabel string, event any) error {
+	body, err := json.Marshal(event)
 	if err != nil {
-		slog.Error("failed to marshal click event", "Err", err)
+		slog.Error("failed to marshal event", "Err", err, "queue", queueLabel)
 		return err
 	}
 
 	err = r.channel.PublishWithContext(
 		ctx,
 		"",
-		r.queueLabel,
+		queueLabel,
 		false,
 		false,
 		amqp.Publishing{
@@ -32,7 +48,7 @@ func (r *RabbitMQ) PublishClickEvent(ctx context.Context, clickEvent *models.Cli
 	)
 
 	if err != nil {
-		slog.Error("failed to publish click event", "error", err)
+		slog.Error("failed to publish event", "error", err, "queue", queueLabel)
 		return err
 	}
 
@@ -6,13 +6,23 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// Queues holds the routing keys of the queues the app publishes to. The
+// worker owns the declaration of those it consumes; LinkImport is consumed,
+// and declared, by the app itself.
+type Queues struct {
+	Click        string
+	LinkPurge    string
+	LinkImport   string
+	LinkMetadata string
+}
+
 type RabbitMQ struct {
-	conn       *amqp.Connection
-	channel    *amqp.Channel
-	queueLabel string
+	conn    *amqp.Connection
+	channel *amqp.Channel
+	queues  Queues
 }
 
-func NewRabbitMQ(addr, queueLabel string) (*RabbitMQ, error) {
+func NewRabbitMQ(addr string, queues Queues) (*RabbitMQ, error) {
 	conn, err := amqp.Dial(addr)
 	if err != nil {
 		slog.Error("error in dialing rabbit mq", "err", err)
@@ -26,9 +36,9 @@ func NewRabbitMQ(addr, queueLabel string) (*RabbitMQ, error) {
 	}
 
 	return &RabbitMQ{
-		conn:       conn,
-		channel:    ch,
-		queueLabel: queueLabel,
+		conn:    conn,
+		channel: ch,
+		queues:  queues,
 	}, nil
 }
 
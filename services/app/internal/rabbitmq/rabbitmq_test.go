@@ -8,7 +8,7 @@ import (
 )
 
 func TestRabbitMQ_NewRabbitMQ_InvalidAddr(t *testing.T) {
-	_, err := NewRabbitMQ("invalid://addr", "test-queue")
+	_, err := NewRabbitMQ("invalid://addr", Queues{Click: "test-queue"})
 	if err == nil {
 		t.Error("Expected error for invalid RabbitMQ address")
 	}
@@ -60,3 +60,18 @@ func TestRabbitMQ_PublishClickEvent_WithNilChannel(t *testing.T) {
 
 	_ = rmq.PublishClickEvent(context.Background(), clickEvent)
 }
+
+func TestRabbitMQ_PublishLinkPurge_WithNilChannel(t *testing.T) {
+	rmq := &RabbitMQ{
+		channel: nil,
+		queues:  Queues{LinkPurge: "link_purge"},
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Expected panic when publishing with nil channel")
+		}
+	}()
+
+	_ = rmq.PublishLinkPurge(context.Background(), &models.LinkPurge{Links: []models.PurgedLink{{ShortCode: "abc"}}})
+}
@@ -45,6 +45,17 @@ func Error(w http.ResponseWriter, status int, message string) {
 	writeJSON(w, status, response)
 }
 
+// ErrorCode is Error with a machine-readable code alongside the message.
+func ErrorCode(w http.ResponseWriter, status int, message string, code string) {
+	response := map[string]any{
+		"status":  "error",
+		"message": message,
+		"code":    code,
+	}
+
+	writeJSON(w, status, response)
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
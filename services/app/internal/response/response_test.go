@@ -60,6 +60,15 @@ func TestError(t *testing.T) {
 	}
 }
 
+func TestErrorCode(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	ErrorCode(rr, http.StatusBadRequest, "Invalid URL", "malformed")
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"status":"error","message":"Invalid URL","code":"malformed"}`, rr.Body.String())
+}
+
 func TestImage(t *testing.T) {
 	rr := httptest.NewRecorder()
 	Success(rr, "Success", http.StatusOK, []byte("Hello world"))
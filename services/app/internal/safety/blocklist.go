@@ -0,0 +1,116 @@
+package safety
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"regexp"
+	"strings"
+)
+
+// Shorteners are well-known URL shorteners. Links to them would hide the
+// real destination behind a second redirect that the checks never see.
+var Shorteners = []string{
+	"bit.ly", "bitly.com", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "ow.ly",
+	"rb.gy", "rebrand.ly", "s.id", "shorturl.at", "t.co", "t.ly", "tiny.cc",
+	"tinyurl.com", "v.gd",
+}
+
+// Blocklist flags URLs whose host is, or is a subdomain of, a listed domain,
+// and URLs matching a listed pattern.
+type Blocklist struct {
+	domains  map[string]string
+	patterns []*regexp.Regexp
+}
+
+// NewBlocklist flags the given domains as blocklisted and the given hosts as
+// shorteners.
+func NewBlocklist(domains []string, shorteners []string) *Blocklist {
+	b := &Blocklist{domains: map[string]string{}}
+	for _, domain := range shorteners {
+		b.domains[normalizeDomain(domain)] = CategoryShortener
+	}
+	for _, domain := range domains {
+		b.domains[normalizeDomain(domain)] = CategoryBlocklisted
+	}
+
+	return b
+}
+
+// AddPattern flags URLs matching the regular expression expr.
+func (b *Blocklist) AddPattern(expr string) error {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("invalid blocklist pattern %q: %w", expr, err)
+	}
+
+	b.patterns = append(b.patterns, pattern)
+	return nil
+}
+
+// Load reads blocklist entries, one per line: a domain, or a regular
+// expression between slashes. Blank lines and lines starting with # are
+// skipped.
+func (b *Blocklist) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case len(line) > 1 && strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/"):
+			if err := b.AddPattern(line[1 : len(line)-1]); err != nil {
+				return err
+			}
+		default:
+			b.domains[normalizeDomain(line)] = CategoryBlocklisted
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (b *Blocklist) Check(ctx context.Context, urls []string) (map[string]Finding, error) {
+	findings := map[string]Finding{}
+
+	for _, url := range urls {
+		if finding, ok := b.check(url); ok {
+			findings[url] = finding
+		}
+	}
+
+	return findings, nil
+}
+
+func (b *Blocklist) check(url string) (Finding, bool) {
+	if u, err := neturl.Parse(url); err == nil {
+		// Walk up from the host, so that listing a domain covers its
+		// subdomains.
+		host := normalizeDomain(u.Hostname())
+		for host != "" {
+			if category, ok := b.domains[host]; ok {
+				return Finding{Category: category, Detail: host}, true
+			}
+
+			_, parent, found := strings.Cut(host, ".")
+			if !found {
+				break
+			}
+			host = parent
+		}
+	}
+
+	for _, pattern := range b.patterns {
+		if pattern.MatchString(url) {
+			return Finding{Category: CategoryBlocklisted, Detail: pattern.String()}, true
+		}
+	}
+
+	return Finding{}, false
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
@@ -0,0 +1,53 @@
+package safety
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlocklist_Check(t *testing.T) {
+	blocklist := NewBlocklist([]string{"Evil.example."}, Shorteners)
+	require.NoError(t, blocklist.AddPattern(`(?i)/wp-login\.php`))
+
+	urls := []string{
+		"https://evil.example/login",
+		"https://cdn.evil.example/payload.exe",
+		"https://notevil.example/",
+		"https://bit.ly/abc",
+		"https://example.com/WP-LOGIN.php",
+		"https://example.com/",
+		"not a url",
+	}
+
+	findings, err := blocklist.Check(context.Background(), urls)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]Finding{
+		"https://evil.example/login":           {Category: CategoryBlocklisted, Detail: "evil.example"},
+		"https://cdn.evil.example/payload.exe": {Category: CategoryBlocklisted, Detail: "evil.example"},
+		"https://bit.ly/abc":                   {Category: CategoryShortener, Detail: "bit.ly"},
+		"https://example.com/WP-LOGIN.php":     {Category: CategoryBlocklisted, Detail: `(?i)/wp-login\.php`},
+	}, findings)
+}
+
+func TestBlocklist_Load(t *testing.T) {
+	blocklist := NewBlocklist(nil, nil)
+
+	err := blocklist.Load(strings.NewReader(`
+# phishing kits
+phish.example
+/\.zip$/
+`))
+	require.NoError(t, err)
+
+	findings, err := blocklist.Check(context.Background(), []string{"http://www.phish.example/", "https://files.example/a.zip", "https://files.example/a.pdf"})
+	require.NoError(t, err)
+	assert.Len(t, findings, 2)
+	assert.Equal(t, "blocklisted: phish.example", findings["http://www.phish.example/"].String())
+
+	assert.Error(t, blocklist.Load(strings.NewReader("/(unclosed/")))
+}
@@ -0,0 +1,135 @@
+package safety
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// providerBatchSize is the most URLs sent in one lookup, the limit of the
+// Safe Browsing Lookup API.
+const providerBatchSize = 500
+
+var providerThreatTypes = []string{"MALWARE", "SOCIAL_ENGINEERING", "UNWANTED_SOFTWARE", "POTENTIALLY_HARMFUL_APPLICATION"}
+
+// Provider checks URLs against a remote threat list speaking the Safe
+// Browsing v4 threatMatches:find protocol.
+type Provider struct {
+	endpoint string
+	apiKey   string
+	clientID string
+	client   *http.Client
+}
+
+// NewProvider returns a Provider posting lookups to endpoint, for example
+// https://safebrowsing.googleapis.com/v4/threatMatches:find. A nil client
+// uses one with a 10 second timeout.
+func NewProvider(endpoint string, apiKey string, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Provider{endpoint: endpoint, apiKey: apiKey, clientID: "link-shortener", client: client}
+}
+
+type threatEntry struct {
+	URL string `json:"url"`
+}
+
+type findRequest struct {
+	Client struct {
+		ClientID string `json:"clientId"`
+	} `json:"client"`
+	ThreatInfo struct {
+		ThreatTypes      []string      `json:"threatTypes"`
+		PlatformTypes    []string      `json:"platformTypes"`
+		ThreatEntryTypes []string      `json:"threatEntryTypes"`
+		ThreatEntries    []threatEntry `json:"threatEntries"`
+	} `json:"threatInfo"`
+}
+
+type findResponse struct {
+	Matches []struct {
+		ThreatType string      `json:"threatType"`
+		Threat     threatEntry `json:"threat"`
+	} `json:"matches"`
+}
+
+func (p *Provider) Check(ctx context.Context, urls []string) (map[string]Finding, error) {
+	findings := map[string]Finding{}
+
+	for start := 0; start < len(urls); start += providerBatchSize {
+		batch := urls[start:min(start+providerBatchSize, len(urls))]
+		if err := p.lookup(ctx, batch, findings); err != nil {
+			return findings, err
+		}
+	}
+
+	return findings, nil
+}
+
+func (p *Provider) lookup(ctx context.Context, urls []string, findings map[string]Finding) error {
+	var req findRequest
+	req.Client.ClientID = p.clientID
+	req.ThreatInfo.ThreatTypes = providerThreatTypes
+	req.ThreatInfo.PlatformTypes = []string{"ANY_PLATFORM"}
+	req.ThreatInfo.ThreatEntryTypes = []string{"URL"}
+	for _, url := range urls {
+		req.ThreatInfo.ThreatEntries = append(req.ThreatInfo.ThreatEntries, threatEntry{URL: url})
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	target := p.endpoint
+	if p.apiKey != "" {
+		target += "?key=" + neturl.QueryEscape(p.apiKey)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("safety provider returned %d", resp.StatusCode)
+	}
+
+	var found findResponse
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return fmt.Errorf("decoding safety provider response: %w", err)
+	}
+
+	for _, match := range found.Matches {
+		findings[match.Threat.URL] = Finding{Category: threatCategory(match.ThreatType)}
+	}
+
+	return nil
+}
+
+func threatCategory(threatType string) string {
+	switch threatType {
+	case "MALWARE":
+		return CategoryMalware
+	case "SOCIAL_ENGINEERING":
+		return CategoryPhishing
+	case "UNWANTED_SOFTWARE":
+		return CategoryUnwanted
+	default:
+		return strings.ToLower(threatType)
+	}
+}
@@ -0,0 +1,82 @@
+package safety
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_Check(t *testing.T) {
+	var got findRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "secret", r.URL.Query().Get("key"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+
+		w.Write([]byte(`{"matches": [
+			{"threatType": "SOCIAL_ENGINEERING", "threat": {"url": "https://phish.example/"}},
+			{"threatType": "MALWARE", "threat": {"url": "https://malware.example/"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	provider := NewProvider(server.URL, "secret", server.Client())
+	urls := []string{"https://phish.example/", "https://malware.example/", "https://example.com/"}
+
+	findings, err := provider.Check(context.Background(), urls)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Finding{
+		"https://phish.example/":   {Category: CategoryPhishing},
+		"https://malware.example/": {Category: CategoryMalware},
+	}, findings)
+
+	require.Len(t, got.ThreatInfo.ThreatEntries, 3)
+	assert.Equal(t, "https://example.com/", got.ThreatInfo.ThreatEntries[2].URL)
+	assert.Contains(t, got.ThreatInfo.ThreatTypes, "MALWARE")
+}
+
+func TestProvider_NoMatches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	findings, err := NewProvider(server.URL, "", server.Client()).Check(context.Background(), []string{"https://example.com/"})
+	require.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+func TestProvider_Batches(t *testing.T) {
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req findRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sizes = append(sizes, len(req.ThreatInfo.ThreatEntries))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	urls := make([]string, providerBatchSize+1)
+	for i := range urls {
+		urls[i] = "https://example.com/"
+	}
+
+	_, err := NewProvider(server.URL, "", server.Client()).Check(context.Background(), urls)
+	require.NoError(t, err)
+	assert.Equal(t, []int{providerBatchSize, 1}, sizes)
+}
+
+func TestProvider_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewProvider(server.URL, "", server.Client()).Check(context.Background(), []string{"https://example.com/"})
+	assert.EqualError(t, err, "safety provider returned 503")
+}
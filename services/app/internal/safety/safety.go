@@ -0,0 +1,64 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Finding categories. Providers may report others, named after their threat
+// types.
+const (
+	CategoryBlocklisted = "blocklisted"
+	CategoryShortener   = "shortener"
+	CategoryMalware     = "malware"
+	CategoryPhishing    = "phishing"
+	CategoryUnwanted    = "unwanted_software"
+)
+
+// Finding explains why a destination is unsafe.
+type Finding struct {
+	Category string
+	Detail   string
+}
+
+func (f Finding) String() string {
+	if f.Detail == "" {
+		return f.Category
+	}
+
+	return f.Category + ": " + f.Detail
+}
+
+// DestinationChecker decides whether URLs are safe to redirect to. Check
+// returns a Finding for every unsafe URL, keyed by the URL as given; URLs
+// missing from the result are safe.
+type DestinationChecker interface {
+	Check(ctx context.Context, urls []string) (map[string]Finding, error)
+}
+
+// Chain runs every checker and merges their findings, the first checker's
+// finding winning for a URL several flag. A checker that fails does not stop
+// the others; its error is returned alongside whatever they found.
+type Chain []DestinationChecker
+
+func (c Chain) Check(ctx context.Context, urls []string) (map[string]Finding, error) {
+	findings := map[string]Finding{}
+	var errs []error
+
+	for _, checker := range c {
+		found, err := checker.Check(ctx, urls)
+		if err != nil {
+			slog.Warn("Destination checker failed", "error", err)
+			errs = append(errs, err)
+		}
+
+		for url, finding := range found {
+			if _, ok := findings[url]; !ok {
+				findings[url] = finding
+			}
+		}
+	}
+
+	return findings, errors.Join(errs...)
+}
@@ -0,0 +1,30 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingChecker struct{}
+
+func (failingChecker) Check(ctx context.Context, urls []string) (map[string]Finding, error) {
+	return nil, errors.New("provider down")
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain{
+		failingChecker{},
+		NewBlocklist([]string{"evil.example"}, nil),
+		NewBlocklist(nil, []string{"evil.example", "bit.ly"}),
+	}
+
+	findings, err := chain.Check(context.Background(), []string{"https://evil.example/", "https://bit.ly/x", "https://example.com/"})
+	assert.EqualError(t, err, "provider down")
+	assert.Equal(t, map[string]Finding{
+		"https://evil.example/": {Category: CategoryBlocklisted, Detail: "evil.example"},
+		"https://bit.ly/x":      {Category: CategoryShortener, Detail: "bit.ly"},
+	}, findings)
+}
@@ -4,3 +4,4 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 const ClickDataKey contextKey = "clickData"
+const WorkspaceContextKey contextKey = "workspace"
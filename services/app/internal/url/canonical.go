@@ -0,0 +1,114 @@
+package url
+
+import (
+	"net"
+	neturl "net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Canonicalizer rewrites URLs into the form deduplication compares on, so
+// that trivially different spellings of a destination share one link.
+type Canonicalizer struct {
+	stripParams []string
+}
+
+// NewCanonicalizer returns a Canonicalizer that drops the given query
+// parameters. A trailing "*" matches any parameter with that prefix. A nil
+// Canonicalizer normalizes the same way but strips nothing.
+func NewCanonicalizer(stripParams []string) *Canonicalizer {
+	return &Canonicalizer{stripParams: stripParams}
+}
+
+// Canonicalize lowercases the scheme and host, converts IDN hosts to
+// punycode, drops default ports, removes stripped parameters and sorts the
+// rest of the query.
+func (c *Canonicalizer) Canonicalize(raw string) (string, error) {
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+
+	port := u.Port()
+	switch {
+	case port != "" && port != defaultPorts[u.Scheme]:
+		u.Host = net.JoinHostPort(host, port)
+	case strings.Contains(host, ":"):
+		u.Host = "[" + host + "]"
+	default:
+		u.Host = host
+	}
+
+	if u.Path == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+
+	if u.RawQuery != "" {
+		query, err := neturl.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "", err
+		}
+
+		for key := range query {
+			if c.strips(key) {
+				query.Del(key)
+			}
+		}
+
+		u.RawQuery = query.Encode()
+	}
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// canonicalHost lowercases host and converts internationalized names to
+// punycode. ASCII names and IP literals are only lowercased, so hosts the
+// IDNA rules are strict about (underscores, for one) still canonicalize.
+func canonicalHost(host string) (string, error) {
+	host = strings.ToLower(host)
+
+	for _, r := range host {
+		if r > unicode.MaxASCII {
+			return idna.Lookup.ToASCII(host)
+		}
+	}
+
+	return host, nil
+}
+
+func (c *Canonicalizer) strips(param string) bool {
+	if c == nil {
+		return false
+	}
+
+	param = strings.ToLower(param)
+
+	for _, pattern := range c.stripParams {
+		pattern = strings.ToLower(pattern)
+
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(param, prefix) {
+				return true
+			}
+		} else if param == pattern {
+			return true
+		}
+	}
+
+	return false
+}
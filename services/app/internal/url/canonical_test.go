@@ -0,0 +1,113 @@
+package url
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       string
+		stripParams []string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:  "lowercases scheme and host",
+			input: "HTTPS://Example.COM/Path",
+			want:  "https://example.com/Path",
+		},
+		{
+			name:  "sorts query parameters",
+			input: "https://example.com/a?b=1&a=2",
+			want:  "https://example.com/a?a=2&b=1",
+		},
+		{
+			name:  "drops default https port",
+			input: "https://example.com:443/a",
+			want:  "https://example.com/a",
+		},
+		{
+			name:  "drops default http port",
+			input: "http://example.com:80/a",
+			want:  "http://example.com/a",
+		},
+		{
+			name:  "keeps non-default port",
+			input: "https://example.com:8443/a",
+			want:  "https://example.com:8443/a",
+		},
+		{
+			name:  "adds root path",
+			input: "https://example.com",
+			want:  "https://example.com/",
+		},
+		{
+			name:  "converts IDN host to punycode",
+			input: "https://Bücher.example/katalog",
+			want:  "https://xn--bcher-kva.example/katalog",
+		},
+		{
+			name:  "keeps IPv6 literal",
+			input: "http://[::1]:80/a",
+			want:  "http://[::1]/a",
+		},
+		{
+			name:        "strips tracking parameters",
+			input:       "https://example.com/a?utm_source=x&UTM_Medium=y&fbclid=1&gclid=2&id=7",
+			stripParams: []string{"utm_*", "fbclid", "gclid"},
+			want:        "https://example.com/a?id=7",
+		},
+		{
+			name:  "keeps tracking parameters when none are configured",
+			input: "https://example.com/a?utm_source=x",
+			want:  "https://example.com/a?utm_source=x",
+		},
+		{
+			name:        "drops empty query",
+			input:       "https://example.com/a?utm_source=x",
+			stripParams: []string{"utm_*"},
+			want:        "https://example.com/a",
+		},
+		{
+			name:    "invalid query escape",
+			input:   "https://example.com/a?q=%zz",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := NewCanonicalizer(tc.stripParams).Canonicalize(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCanonicalize_EquivalentSpellings(t *testing.T) {
+	c := NewCanonicalizer(nil)
+
+	spellings := []string{
+		"https://Example.com/a?b=1&a=2",
+		"https://example.com/a?a=2&b=1",
+		"https://example.com:443/a?a=2&b=1",
+	}
+
+	want, err := c.Canonicalize(spellings[0])
+	require.NoError(t, err)
+
+	for _, spelling := range spellings[1:] {
+		got, err := c.Canonicalize(spelling)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, spelling)
+	}
+}
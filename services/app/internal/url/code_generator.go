@@ -0,0 +1,209 @@
+package url
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+const (
+	CodeGeneratorSequential = "sequential"
+	CodeGeneratorFeistel    = "feistel"
+)
+
+// feistelRounds is enough for the permutation to look random to anyone
+// without the key; more rounds only cost time.
+const feistelRounds = 4
+
+// CodeGenerator turns link IDs into short codes and back. Implementations are
+// bijective over the IDs they accept, so two links never share a code. Decode
+// returns an *InvalidShortCodeErr for codes the generator could not have
+// issued.
+type CodeGenerator interface {
+	Encode(id uint64) (string, error)
+	Decode(code string) (uint64, error)
+}
+
+// SequentialGenerator is the original scheme: the base62 form of id+offset.
+// Codes are short but consecutive, so they can be enumerated.
+type SequentialGenerator struct {
+	offset uint64
+}
+
+func NewSequentialGenerator(offset uint64) *SequentialGenerator {
+	return &SequentialGenerator{offset: offset}
+}
+
+func (g *SequentialGenerator) Encode(id uint64) (string, error) {
+	return toBase62(id + g.offset), nil
+}
+
+func (g *SequentialGenerator) Decode(code string) (uint64, error) {
+	n, err := FromBase62(code)
+	if err != nil {
+		return 0, err
+	}
+
+	if n < g.offset {
+		return 0, &InvalidShortCodeErr{shortCode: code, reason: "below id offset"}
+	}
+
+	return n - g.offset, nil
+}
+
+// FeistelGenerator maps IDs through a keyed Feistel permutation of the code
+// space before encoding them, so consecutive IDs give unrelated codes. Every
+// code has the configured length; pick one that differs from the lengths of
+// codes issued by the sequential generator so the two never overlap.
+type FeistelGenerator struct {
+	key      []byte
+	alphabet string
+	length   int
+	size     uint64 // len(alphabet)^length, the number of distinct codes
+	halfBits uint
+}
+
+func NewFeistelGenerator(secret string, alphabet string, length int) (*FeistelGenerator, error) {
+	if secret == "" {
+		return nil, errors.New("feistel code generator needs a secret")
+	}
+
+	if len(alphabet) < 2 {
+		return nil, errors.New("code alphabet needs at least two characters")
+	}
+
+	for i := 0; i < len(alphabet); i++ {
+		if alphabet[i] >= 0x80 || strings.IndexByte(alphabet[i+1:], alphabet[i]) >= 0 {
+			return nil, fmt.Errorf("code alphabet must be unique ASCII characters, got %q", alphabet)
+		}
+	}
+
+	if length < 1 {
+		return nil, errors.New("code length must be positive")
+	}
+
+	size := uint64(1)
+	for i := 0; i < length; i++ {
+		hi, lo := bits.Mul64(size, uint64(len(alphabet)))
+		if hi != 0 || lo > math.MaxUint64>>2 {
+			return nil, fmt.Errorf("%d characters from a %d-character alphabet is too large a code space", length, len(alphabet))
+		}
+		size = lo
+	}
+
+	return &FeistelGenerator{
+		key:      []byte(secret),
+		alphabet: alphabet,
+		length:   length,
+		size:     size,
+		halfBits: uint(bits.Len64(size-1)+1) / 2,
+	}, nil
+}
+
+func (g *FeistelGenerator) Encode(id uint64) (string, error) {
+	if id >= g.size {
+		return "", fmt.Errorf("id %d exceeds the code space of %d", id, g.size)
+	}
+
+	// Cycle-walk: the Feistel network permutes a power-of-two domain that is
+	// slightly larger than the code space, so re-apply it until the value
+	// lands inside. Every cycle passes through id, so this terminates.
+	n := g.permute(id, false)
+	for n >= g.size {
+		n = g.permute(n, false)
+	}
+
+	code := make([]byte, g.length)
+	base := uint64(len(g.alphabet))
+	for i := g.length - 1; i >= 0; i-- {
+		code[i] = g.alphabet[n%base]
+		n /= base
+	}
+
+	return string(code), nil
+}
+
+func (g *FeistelGenerator) Decode(code string) (uint64, error) {
+	if len(code) != g.length {
+		return 0, &InvalidShortCodeErr{shortCode: code, reason: "wrong length"}
+	}
+
+	var n uint64
+	base := uint64(len(g.alphabet))
+	for i := 0; i < len(code); i++ {
+		digit := strings.IndexByte(g.alphabet, code[i])
+		if digit < 0 {
+			return 0, &InvalidShortCodeErr{shortCode: code, reason: "invalid character"}
+		}
+		n = n*base + uint64(digit)
+	}
+
+	id := g.permute(n, true)
+	for id >= g.size {
+		id = g.permute(id, true)
+	}
+
+	return id, nil
+}
+
+// permute runs the balanced Feistel network over 2*halfBits bits, or its
+// inverse.
+func (g *FeistelGenerator) permute(n uint64, inverse bool) uint64 {
+	mask := uint64(1)<<g.halfBits - 1
+	left, right := n>>g.halfBits, n&mask
+
+	if !inverse {
+		for round := 0; round < feistelRounds; round++ {
+			left, right = right, left^(g.round(round, right)&mask)
+		}
+	} else {
+		for round := feistelRounds - 1; round >= 0; round-- {
+			left, right = right^(g.round(round, left)&mask), left
+		}
+	}
+
+	return left<<g.halfBits | right
+}
+
+func (g *FeistelGenerator) round(round int, half uint64) uint64 {
+	var msg [9]byte
+	msg[0] = byte(round)
+	binary.BigEndian.PutUint64(msg[1:], half)
+
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write(msg[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// FallbackGenerator issues codes with its primary generator but still accepts
+// codes issued by the generators used before it, so switching schemes does
+// not break existing links.
+type FallbackGenerator struct {
+	primary  CodeGenerator
+	previous []CodeGenerator
+}
+
+func NewFallbackGenerator(primary CodeGenerator, previous ...CodeGenerator) *FallbackGenerator {
+	return &FallbackGenerator{primary: primary, previous: previous}
+}
+
+func (g *FallbackGenerator) Encode(id uint64) (string, error) {
+	return g.primary.Encode(id)
+}
+
+func (g *FallbackGenerator) Decode(code string) (uint64, error) {
+	id, err := g.primary.Decode(code)
+	for _, previous := range g.previous {
+		if err == nil {
+			break
+		}
+		id, err = previous.Decode(code)
+	}
+
+	return id, err
+}
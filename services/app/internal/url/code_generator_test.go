@@ -0,0 +1,147 @@
+package url
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequentialGenerator(t *testing.T) {
+	g := NewSequentialGenerator(1000)
+
+	code, err := g.Encode(1)
+	require.NoError(t, err)
+	assert.Equal(t, toBase62(1001), code, "codes match the ones issued before generators existed")
+
+	id, err := g.Decode(code)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), id)
+
+	_, err = g.Decode("a-b")
+	assert.ErrorAs(t, err, &InvalidShortCode)
+
+	_, err = g.Decode("1")
+	assert.ErrorAs(t, err, &InvalidShortCode, "codes below the offset were never issued")
+}
+
+func TestFeistelGenerator_RoundTrip(t *testing.T) {
+	g, err := NewFeistelGenerator("secret", base62Chars, 6)
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for id := uint64(0); id < 5000; id++ {
+		code, err := g.Encode(id)
+		require.NoError(t, err)
+		assert.Len(t, code, 6)
+		assert.False(t, seen[code], "collision on id %d", id)
+		seen[code] = true
+
+		decoded, err := g.Decode(code)
+		require.NoError(t, err)
+		require.Equal(t, id, decoded)
+	}
+}
+
+func TestFeistelGenerator_SmallSpaceIsPermutation(t *testing.T) {
+	// 3^5 = 243 is not a power of two, so this exercises cycle-walking.
+	g, err := NewFeistelGenerator("secret", "abc", 5)
+	require.NoError(t, err)
+
+	seen := make(map[string]bool)
+	for id := uint64(0); id < 243; id++ {
+		code, err := g.Encode(id)
+		require.NoError(t, err)
+		seen[code] = true
+	}
+	assert.Len(t, seen, 243)
+
+	_, err = g.Encode(243)
+	assert.Error(t, err)
+}
+
+func TestFeistelGenerator_NotSequential(t *testing.T) {
+	g, err := NewFeistelGenerator("secret", base62Chars, 8)
+	require.NoError(t, err)
+
+	first, err := g.Encode(100)
+	require.NoError(t, err)
+	second, err := g.Encode(101)
+	require.NoError(t, err)
+
+	common := 0
+	for common < len(first) && first[common] == second[common] {
+		common++
+	}
+	assert.Less(t, common, 4, "neighbouring ids should not share a long prefix: %s %s", first, second)
+
+	other, err := NewFeistelGenerator("another secret", base62Chars, 8)
+	require.NoError(t, err)
+	otherFirst, err := other.Encode(100)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, otherFirst, "codes depend on the secret")
+}
+
+func TestFeistelGenerator_Decode(t *testing.T) {
+	g, err := NewFeistelGenerator("secret", base62Chars, 8)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		code string
+	}{
+		{name: "wrong length", code: "abc"},
+		{name: "character outside alphabet", code: "abcd-fgh"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := g.Decode(tc.code)
+			assert.ErrorAs(t, err, &InvalidShortCode)
+		})
+	}
+}
+
+func TestFallbackGenerator(t *testing.T) {
+	feistel, err := NewFeistelGenerator("secret", base62Chars, 8)
+	require.NoError(t, err)
+	sequential := NewSequentialGenerator(1000)
+	g := NewFallbackGenerator(feistel, sequential)
+
+	code, err := g.Encode(42)
+	require.NoError(t, err)
+	assert.Len(t, code, 8, "new codes come from the primary generator")
+
+	legacy, err := sequential.Encode(42)
+	require.NoError(t, err)
+
+	id, err := g.Decode(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), id)
+
+	_, err = g.Decode("not a code")
+	assert.ErrorAs(t, err, &InvalidShortCode)
+}
+
+func TestNewFeistelGenerator_Invalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		secret   string
+		alphabet string
+		length   int
+	}{
+		{name: "missing secret", alphabet: base62Chars, length: 8},
+		{name: "alphabet too short", secret: "s", alphabet: "a", length: 8},
+		{name: "repeated character", secret: "s", alphabet: "abca", length: 8},
+		{name: "non-positive length", secret: "s", alphabet: base62Chars, length: 0},
+		{name: "code space too large", secret: "s", alphabet: base62Chars, length: 11},
+		{name: "non-ASCII alphabet", secret: "s", alphabet: "éab", length: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewFeistelGenerator(tc.secret, tc.alphabet, tc.length)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package url
+
+import (
+	"container/list"
+	"hafiztri123/app-link-shortener/internal/metrics"
+	"sync"
+	"time"
+)
+
+// LocalCache is a size-bounded, in-process LRU of short code lookups that
+// sits in front of Redis. Entries also expire after a TTL, which bounds how
+// stale an instance can get if it misses an invalidation broadcast. A nil
+// LocalCache caches nothing.
+type LocalCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type localEntry struct {
+	shortCode string
+	value     string
+	expiresAt time.Time
+}
+
+func NewLocalCache(capacity int, ttl time.Duration) *LocalCache {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &LocalCache{
+		capacity: capacity,
+		ttl:      ttl,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, capacity),
+	}
+}
+
+func (c *LocalCache) Get(shortCode string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[shortCode]
+	if ok && c.now().After(elem.Value.(*localEntry).expiresAt) {
+		c.remove(elem)
+		ok = false
+	}
+
+	metrics.RecordCacheLookup("local", ok)
+	if !ok {
+		return "", false
+	}
+
+	c.order.MoveToFront(elem)
+	return elem.Value.(*localEntry).value, true
+}
+
+func (c *LocalCache) Set(shortCode string, value string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if elem, ok := c.entries[shortCode]; ok {
+		entry := elem.Value.(*localEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[shortCode] = c.order.PushFront(&localEntry{shortCode: shortCode, value: value, expiresAt: expiresAt})
+
+	if c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LocalCache) Delete(shortCodes ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, shortCode := range shortCodes {
+		if elem, ok := c.entries[shortCode]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+func (c *LocalCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*localEntry).shortCode)
+}
@@ -0,0 +1,83 @@
+package url
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLocalCache(2, time.Minute)
+
+	c.Set("a", "https://a.example")
+	c.Set("b", "https://b.example")
+
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	c.Set("c", "https://c.example")
+
+	_, ok = c.Get("b")
+	assert.False(t, ok, "b was the least recently used entry")
+
+	got, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "https://a.example", got)
+
+	_, ok = c.Get("c")
+	assert.True(t, ok)
+}
+
+func TestLocalCache_Expires(t *testing.T) {
+	now := time.Now()
+	c := NewLocalCache(10, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", "https://a.example")
+
+	now = now.Add(59 * time.Second)
+	_, ok := c.Get("a")
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Second)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	assert.Empty(t, c.entries, "expired entries are dropped on lookup")
+}
+
+func TestLocalCache_SetReplaces(t *testing.T) {
+	c := NewLocalCache(1, time.Minute)
+
+	c.Set("a", missingURL)
+	c.Set("a", "https://a.example")
+
+	got, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "https://a.example", got)
+	assert.Equal(t, 1, c.order.Len())
+}
+
+func TestLocalCache_Delete(t *testing.T) {
+	c := NewLocalCache(10, time.Minute)
+
+	c.Set("a", "https://a.example")
+	c.Set("b", "https://b.example")
+	c.Delete("a", "b", "unknown")
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+}
+
+func TestLocalCache_Disabled(t *testing.T) {
+	c := NewLocalCache(0, time.Minute)
+	assert.Nil(t, c)
+
+	c.Set("a", "https://a.example")
+	c.Delete("a")
+
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,131 @@
+package url
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxUTMLength = 200
+
+// UTM are the campaign parameters a link tags its destinations with.
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// params are the query parameters of u that are set, in their usual order.
+func (u UTM) params() [][2]string {
+	var params [][2]string
+	for _, p := range [][2]string{
+		{"utm_source", u.Source},
+		{"utm_medium", u.Medium},
+		{"utm_campaign", u.Campaign},
+		{"utm_term", u.Term},
+		{"utm_content", u.Content},
+	} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+
+	return params
+}
+
+func normalizeUTM(utm UTM) (UTM, error) {
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"utm.source", &utm.Source},
+		{"utm.medium", &utm.Medium},
+		{"utm.campaign", &utm.Campaign},
+		{"utm.term", &utm.Term},
+		{"utm.content", &utm.Content},
+	}
+
+	for _, field := range fields {
+		*field.value = strings.TrimSpace(*field.value)
+		if utf8.RuneCountInString(*field.value) > maxUTMLength {
+			return utm, &InvalidRequestErr{reason: fmt.Sprintf("%s cannot be longer than %d characters", field.name, maxUTMLength)}
+		}
+	}
+
+	return utm, nil
+}
+
+// tag sets the UTM parameters of u on raw, replacing those raw already has.
+// The rest of its query is kept as it was.
+func (u UTM) tag(raw string) (string, error) {
+	params := u.params()
+	if len(params) == 0 {
+		return raw, nil
+	}
+
+	dest, err := neturl.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+
+	replaced := make(map[string]bool, len(params))
+	for _, p := range params {
+		replaced[p[0]] = true
+	}
+
+	var pairs []string
+	for _, pair := range strings.Split(dest.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(pair, "=")
+		if key, err := neturl.QueryUnescape(key); err == nil && replaced[key] {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+
+	for _, p := range params {
+		pairs = append(pairs, neturl.QueryEscape(p[0])+"="+neturl.QueryEscape(p[1]))
+	}
+	dest.RawQuery = strings.Join(pairs, "&")
+
+	return dest.String(), nil
+}
+
+// forwardQuery adds the parameters of incoming to destination. Parameters
+// destination already has win: the link's own query is never overridden by
+// whoever opens it.
+func forwardQuery(destination string, incoming neturl.Values) string {
+	if len(incoming) == 0 {
+		return destination
+	}
+
+	dest, err := neturl.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	own := dest.Query()
+	extra := make(neturl.Values)
+	for key, values := range incoming {
+		if _, ok := own[key]; !ok {
+			extra[key] = values
+		}
+	}
+
+	if len(extra) == 0 {
+		return destination
+	}
+
+	if dest.RawQuery != "" {
+		dest.RawQuery += "&"
+	}
+	dest.RawQuery += extra.Encode()
+
+	return dest.String()
+}
@@ -0,0 +1,99 @@
+package url
+
+import (
+	neturl "net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUTMTag(t *testing.T) {
+	testCases := []struct {
+		name string
+		utm  UTM
+		raw  string
+		want string
+	}{
+		{name: "no fields", raw: "https://example.com/?b=2&a=1", want: "https://example.com/?b=2&a=1"},
+		{
+			name: "appended in order",
+			utm:  UTM{Content: "hero", Source: "newsletter", Medium: "email"},
+			raw:  "https://example.com/page",
+			want: "https://example.com/page?utm_source=newsletter&utm_medium=email&utm_content=hero",
+		},
+		{
+			name: "existing values are replaced, the rest kept",
+			utm:  UTM{Source: "newsletter"},
+			raw:  "https://example.com/?z=1&utm_source=old&utm_medium=cpc#top",
+			want: "https://example.com/?z=1&utm_medium=cpc&utm_source=newsletter#top",
+		},
+		{
+			name: "values are escaped",
+			utm:  UTM{Campaign: "spring sale & more"},
+			raw:  "https://example.com",
+			want: "https://example.com?utm_campaign=spring+sale+%26+more",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.utm.tag(tc.raw)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNormalizeUTM(t *testing.T) {
+	got, err := normalizeUTM(UTM{Source: " newsletter ", Medium: "\temail"})
+	require.NoError(t, err)
+	assert.Equal(t, UTM{Source: "newsletter", Medium: "email"}, got)
+
+	_, err = normalizeUTM(UTM{Content: strings.Repeat("a", maxUTMLength+1)})
+	assert.IsType(t, InvalidRequest, err)
+}
+
+func TestForwardQuery(t *testing.T) {
+	testCases := []struct {
+		name        string
+		destination string
+		incoming    string
+		want        string
+	}{
+		{name: "nothing to forward", destination: "https://example.com/?a=1", want: "https://example.com/?a=1"},
+		{name: "added", destination: "https://example.com/page", incoming: "ref=x&b=2", want: "https://example.com/page?b=2&ref=x"},
+		{name: "appended to the destination's own", destination: "https://example.com/?a=1", incoming: "ref=x", want: "https://example.com/?a=1&ref=x"},
+		{name: "destination wins", destination: "https://example.com/?utm_source=site", incoming: "utm_source=spam&ref=x", want: "https://example.com/?utm_source=site&ref=x"},
+		{name: "repeated values are kept", destination: "https://example.com", incoming: "tag=a&tag=b", want: "https://example.com?tag=a&tag=b"},
+		{name: "fragment stays last", destination: "https://example.com/#top", incoming: "ref=x", want: "https://example.com/?ref=x#top"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			incoming, err := neturl.ParseQuery(tc.incoming)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, forwardQuery(tc.destination, incoming))
+		})
+	}
+}
+
+func TestRedirectDestination_ForwardQuery(t *testing.T) {
+	query := neturl.Values{"ref": {"x"}}
+
+	redirect := &Redirect{
+		LongURL:      "https://example.com",
+		Rules:        []RoutingRule{{Countries: []string{"ID"}, URL: "https://example.com/id"}},
+		ForwardQuery: true,
+	}
+	destination, _ := redirect.Destination(Visitor{Country: "ID", Query: query})
+	assert.Equal(t, "https://example.com/id?ref=x", destination)
+
+	destination, _ = redirect.Destination(Visitor{Country: "US", Query: query})
+	assert.Equal(t, "https://example.com?ref=x", destination)
+
+	redirect.ForwardQuery = false
+	destination, _ = redirect.Destination(Visitor{Query: query})
+	assert.Equal(t, "https://example.com", destination, "links that do not forward keep their destination")
+}
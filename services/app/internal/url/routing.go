@@ -0,0 +1,230 @@
+package url
+
+import (
+	"fmt"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxRoutingRules = 20
+	// maxRuleValues bounds each condition of a rule.
+	maxRuleValues           = 50
+	maxOSNameLength         = 50
+	maxDestinationURLLength = 2048
+)
+
+// routingDevices are the device types the click metadata tells apart, by
+// their lower-case name.
+var routingDevices = map[string]string{
+	"mobile":  "Mobile",
+	"tablet":  "Tablet",
+	"desktop": "Desktop",
+}
+
+var (
+	countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	languageTagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+)
+
+// RoutingRule sends the visitors it matches to URL instead of the link's
+// destination. Every condition that is set must match, and a condition
+// matches when any of its values does: Countries are ISO 3166 codes,
+// Devices one of Mobile, Tablet or Desktop, OS the operating system names
+// of the click metadata, and Languages prefixes of the visitor's preferred
+// language, so "en" matches "en-GB".
+type RoutingRule struct {
+	Countries []string `json:"countries,omitempty"`
+	Devices   []string `json:"devices,omitempty"`
+	OS        []string `json:"os,omitempty"`
+	Languages []string `json:"languages,omitempty"`
+	URL       string   `json:"url"`
+}
+
+// Visitor is what routing rules and split destinations know of whoever
+// opened a link. Language is their preferred language tag, in lower case.
+// Variant names the variant they were sent to before, if any; otherwise Key,
+// which must stay the same across their visits, picks one. Query is the
+// query string they opened the link with, passed on by links that forward
+// it.
+type Visitor struct {
+	Country  string
+	Device   string
+	OS       string
+	Language string
+	Variant  string
+	Key      string
+	Query    neturl.Values
+}
+
+func (r RoutingRule) matches(v Visitor) bool {
+	return matchesAny(r.Countries, v.Country, strings.EqualFold) &&
+		matchesAny(r.Devices, v.Device, strings.EqualFold) &&
+		matchesAny(r.OS, v.OS, strings.EqualFold) &&
+		matchesAny(r.Languages, v.Language, languageMatches)
+}
+
+// matchesAny reports whether value matches one of values, or whether the
+// condition is unset.
+func matchesAny(values []string, value string, match func(want, got string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, want := range values {
+		if match(want, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// languageMatches reports whether the language tag got is want or one of
+// its variants.
+func languageMatches(want string, got string) bool {
+	got = strings.ToLower(got)
+	return got == want || strings.HasPrefix(got, want+"-")
+}
+
+// Destination is where v goes: the URL of the first rule matching them,
+// otherwise that of their variant, or LongURL when the link is not split,
+// with their query added when the link forwards it. variant names the
+// variant picked, and is empty unless one was.
+func (r *Redirect) Destination(v Visitor) (destination string, variant string) {
+	destination = r.LongURL
+
+	matched := false
+	for _, rule := range r.Rules {
+		if rule.matches(v) {
+			destination, matched = rule.URL, true
+			break
+		}
+	}
+
+	if !matched {
+		if picked := r.pickVariant(v); picked != nil {
+			destination, variant = picked.URL, picked.Name
+		}
+	}
+
+	if r.ForwardQuery {
+		destination = forwardQuery(destination, v.Query)
+	}
+
+	return destination, variant
+}
+
+// normalizeRoutingRules trims the rules and puts their values in the form
+// they are matched in. Rules must set at least one condition: the link's
+// own destination is the fallback.
+func normalizeRoutingRules(rules []RoutingRule) ([]RoutingRule, error) {
+	if len(rules) > maxRoutingRules {
+		return nil, &InvalidRequestErr{reason: fmt.Sprintf("a link cannot have more than %d routing rules", maxRoutingRules)}
+	}
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	normalized := make([]RoutingRule, len(rules))
+	for i, rule := range rules {
+		position := i + 1
+
+		rule.URL = strings.TrimSpace(rule.URL)
+		if rule.URL == "" {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("routing rule %d has no url", position)}
+		}
+
+		err := checkDestinationURL(rule.URL, fmt.Sprintf("routing rule %d url", position))
+		if err != nil {
+			return nil, err
+		}
+
+		rule.Countries, err = normalizeRuleValues(rule.Countries, position, "countries", func(v string) (string, bool) {
+			v = strings.ToUpper(v)
+			return v, countryCodePattern.MatchString(v)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rule.Devices, err = normalizeRuleValues(rule.Devices, position, "devices", func(v string) (string, bool) {
+			device, ok := routingDevices[strings.ToLower(v)]
+			return device, ok
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rule.OS, err = normalizeRuleValues(rule.OS, position, "os", func(v string) (string, bool) {
+			return v, utf8.RuneCountInString(v) <= maxOSNameLength
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		rule.Languages, err = normalizeRuleValues(rule.Languages, position, "languages", func(v string) (string, bool) {
+			v = strings.ToLower(strings.ReplaceAll(v, "_", "-"))
+			return v, languageTagPattern.MatchString(v)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(rule.Countries) == 0 && len(rule.Devices) == 0 && len(rule.OS) == 0 && len(rule.Languages) == 0 {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("routing rule %d has no conditions, the link's long_url is the fallback", position)}
+		}
+
+		normalized[i] = rule
+	}
+
+	return normalized, nil
+}
+
+// checkDestinationURL checks that raw, the field named field, is a web
+// address links can send visitors to.
+func checkDestinationURL(raw string, field string) error {
+	dest, err := neturl.Parse(raw)
+	if err != nil || (dest.Scheme != "http" && dest.Scheme != "https") || dest.Host == "" {
+		return &InvalidRequestErr{reason: field + " must be an http or https URL"}
+	}
+
+	if len(raw) > maxDestinationURLLength {
+		return &InvalidRequestErr{reason: fmt.Sprintf("%s cannot be longer than %d characters", field, maxDestinationURLLength)}
+	}
+
+	return nil
+}
+
+// normalizeRuleValues trims and deduplicates the values of the condition
+// named field, rejecting those normalize does not accept.
+func normalizeRuleValues(values []string, position int, field string, normalize func(string) (string, bool)) ([]string, error) {
+	if len(values) > maxRuleValues {
+		return nil, &InvalidRequestErr{reason: fmt.Sprintf("routing rule %d cannot list more than %d %s", position, maxRuleValues, field)}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		normalized, ok := normalize(value)
+		if !ok {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("routing rule %d has invalid %s %q", position, field, value)}
+		}
+
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+		result = append(result, normalized)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package url
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectDestination(t *testing.T) {
+	redirect := &Redirect{
+		LongURL: "https://example.com",
+		Rules: []RoutingRule{
+			{Countries: []string{"ID", "MY"}, URL: "https://example.com/asia"},
+			{Devices: []string{"Mobile"}, OS: []string{"iOS"}, URL: "https://apps.apple.com/app/id1"},
+			{Devices: []string{"Mobile", "Tablet"}, OS: []string{"Android"}, URL: "https://play.google.com/store/apps/details?id=app"},
+			{Languages: []string{"pt-br", "es"}, URL: "https://example.com/latam"},
+		},
+	}
+
+	testCases := []struct {
+		name    string
+		visitor Visitor
+		want    string
+	}{
+		{name: "country", visitor: Visitor{Country: "ID"}, want: "https://example.com/asia"},
+		{name: "first matching rule wins", visitor: Visitor{Country: "MY", Device: "Mobile", OS: "iOS"}, want: "https://example.com/asia"},
+		{name: "device and OS", visitor: Visitor{Country: "US", Device: "Mobile", OS: "iOS"}, want: "https://apps.apple.com/app/id1"},
+		{name: "every condition must match", visitor: Visitor{Country: "US", Device: "Desktop", OS: "iOS"}, want: "https://example.com"},
+		{name: "any value of a condition", visitor: Visitor{Device: "Tablet", OS: "Android"}, want: "https://play.google.com/store/apps/details?id=app"},
+		{name: "values match regardless of case", visitor: Visitor{Country: "my"}, want: "https://example.com/asia"},
+		{name: "language", visitor: Visitor{Language: "es"}, want: "https://example.com/latam"},
+		{name: "language variant", visitor: Visitor{Language: "es-mx"}, want: "https://example.com/latam"},
+		{name: "region of another variant", visitor: Visitor{Language: "pt-pt"}, want: "https://example.com"},
+		{name: "language prefix is not a subtag", visitor: Visitor{Language: "est"}, want: "https://example.com"},
+		{name: "unknown visitor", visitor: Visitor{Country: "unknown", Device: "Unknown"}, want: "https://example.com"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			destination, variant := redirect.Destination(tc.visitor)
+			assert.Equal(t, tc.want, destination)
+			assert.Empty(t, variant)
+		})
+	}
+}
+
+func TestRedirectDestination_NoRules(t *testing.T) {
+	redirect := &Redirect{LongURL: "https://example.com"}
+	destination, _ := redirect.Destination(Visitor{Country: "ID"})
+	assert.Equal(t, "https://example.com", destination)
+}
+
+func TestNormalizeRoutingRules(t *testing.T) {
+	tooMany := make([]RoutingRule, maxRoutingRules+1)
+	for i := range tooMany {
+		tooMany[i] = RoutingRule{Countries: []string{"ID"}, URL: "https://example.com"}
+	}
+
+	testCases := []struct {
+		name    string
+		rules   []RoutingRule
+		want    []RoutingRule
+		wantErr error
+	}{
+		{name: "no rules"},
+		{
+			name: "values are normalized",
+			rules: []RoutingRule{{
+				Countries: []string{" id", "ID", "my"},
+				Devices:   []string{"mobile", ""},
+				OS:        []string{" iOS "},
+				Languages: []string{"pt_BR", "EN"},
+				URL:       " https://example.com/a ",
+			}},
+			want: []RoutingRule{{
+				Countries: []string{"ID", "MY"},
+				Devices:   []string{"Mobile"},
+				OS:        []string{"iOS"},
+				Languages: []string{"pt-br", "en"},
+				URL:       "https://example.com/a",
+			}},
+		},
+		{name: "rule without conditions", rules: []RoutingRule{{Countries: []string{" "}, URL: "https://example.com"}}, wantErr: InvalidRequest},
+		{name: "rule without url", rules: []RoutingRule{{Countries: []string{"ID"}}}, wantErr: InvalidRequest},
+		{name: "url that is not a web address", rules: []RoutingRule{{Countries: []string{"ID"}, URL: "javascript:alert(1)"}}, wantErr: InvalidRequest},
+		{name: "url too long", rules: []RoutingRule{{Countries: []string{"ID"}, URL: "https://example.com/" + strings.Repeat("a", maxDestinationURLLength)}}, wantErr: InvalidRequest},
+		{name: "invalid country", rules: []RoutingRule{{Countries: []string{"Indonesia"}, URL: "https://example.com"}}, wantErr: InvalidRequest},
+		{name: "invalid device", rules: []RoutingRule{{Devices: []string{"Phone"}, URL: "https://example.com"}}, wantErr: InvalidRequest},
+		{name: "invalid language", rules: []RoutingRule{{Languages: []string{"english!"}, URL: "https://example.com"}}, wantErr: InvalidRequest},
+		{name: "too many values", rules: []RoutingRule{{Countries: make([]string, maxRuleValues+1), URL: "https://example.com"}}, wantErr: InvalidRequest},
+		{name: "too many rules", rules: tooMany, wantErr: InvalidRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeRoutingRules(tc.rules)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -1,27 +1,360 @@
 package url
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strconv"
 	"time"
 )
 
+const (
+	StatusActive   = "active"
+	StatusDisabled = "disabled"
+	// StatusFlagged links were disabled by a destination safety check. Their
+	// owners cannot re-enable them.
+	StatusFlagged = "flagged"
+)
+
+// DefaultRedirectType is temporary, so browsers do not cache the redirect
+// and every visit reaches us.
+const DefaultRedirectType = http.StatusFound
+
+var redirectTypes = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
 type URL struct {
-	ID        int64
-	ShortCode sql.NullString
-	LongURL   string
-	CreatedAt time.Time
+	ID            int64
+	ShortCode     sql.NullString
+	LongURL       string
+	Status        string
+	FlaggedReason sql.NullString
+	UserID        sql.NullInt64
+	WorkspaceID   sql.NullInt64
+	DomainID      sql.NullInt64
+	RedirectType  int
+	ExpiresAt     sql.NullTime
+	CreatedAt     time.Time
+	Title         sql.NullString
+	Description   sql.NullString
+	Tags          []string
+	OGTitle       sql.NullString
+	OGDescription sql.NullString
+	OGImageURL    sql.NullString
+	// PasswordHash is never sent to clients; Protected tells them a link has
+	// one.
+	PasswordHash sql.NullString `json:"-"`
+	Protected    bool
+	RoutingRules []RoutingRule
+	Variants     []Variant
+	ForwardQuery bool
+}
+
+// passwordStamp names the current password of the link, or is empty when it
+// has none. Access tokens carry it, so they stop working once the password
+// changes. Hashes are salted, so setting the same password again changes it
+// too.
+func (u *URL) passwordStamp() string {
+	if !u.PasswordHash.Valid {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(u.PasswordHash.String))
+	return hex.EncodeToString(sum[:16])
+}
+
+// urls are all the places the link sends visitors to: LongURL and the URLs
+// of its routing rules and variants.
+func (u *URL) urls() []string {
+	return destinationURLs(u.LongURL, u.RoutingRules, u.Variants)
+}
+
+// Destination is a URL to shorten as submitted, together with the canonical
+// form deduplication compares on and the settings of the link to create.
+// Redirects always use LongURL.
+type Destination struct {
+	LongURL      string
+	CanonicalURL string
+	DomainID     *int64
+	Options      LinkOptions
+	PasswordHash string
+}
+
+// urls are all the places the link sends visitors to: LongURL and the URLs
+// of its routing rules and variants.
+func (d Destination) urls() []string {
+	return destinationURLs(d.LongURL, d.Options.RoutingRules, d.Options.Variants)
+}
+
+func destinationURLs(longURL string, rules []RoutingRule, variants []Variant) []string {
+	urls := []string{longURL}
+	for _, rule := range rules {
+		urls = append(urls, rule.URL)
+	}
+	for _, variant := range variants {
+		urls = append(urls, variant.URL)
+	}
+
+	return urls
+}
+
+// LinkOptions are the per-link settings chosen at creation. A zero
+// RedirectType means DefaultRedirectType; a nil ExpiresAt never expires; an
+// empty Domain serves the link on the default domain; an empty Password
+// leaves the link open to anyone; without RoutingRules or Variants every
+// visitor goes to the long URL. UTM parameters are added to every
+// destination; ForwardQuery passes the query of each visit on to the
+// destination too.
+type LinkOptions struct {
+	RedirectType int
+	ExpiresAt    *time.Time
+	Domain       string
+	Details      LinkDetails
+	Password     string
+	RoutingRules []RoutingRule
+	Variants     []Variant
+	UTM          UTM
+	ForwardQuery bool
+	// Alias is the short code to give the link instead of the one derived
+	// from its ID.
+	Alias string
+}
+
+// LinkDetails describe a link: Title, Description and Tags to its owners so
+// they can find it again, OpenGraph to the platforms it is shared on. They
+// play no part in redirects.
+type LinkDetails struct {
+	Title       string
+	Description string
+	Tags        []string
+	OpenGraph   OpenGraph
+}
+
+// LinkChanges are changes to make to a link together. Nil fields are left as
+// they are.
+type LinkChanges struct {
+	Status *string
+	// PasswordHash replaces the password of the link; an empty hash removes
+	// it.
+	PasswordHash *string
+	// RoutingRules and Variants replace those of the link; empty lists remove
+	// them.
+	RoutingRules *[]RoutingRule
+	Variants     *[]Variant
+	ForwardQuery *bool
+	Details      *LinkDetails
+}
+
+// changesRedirect reports whether c touches what cached redirects hold.
+func (c LinkChanges) changesRedirect() bool {
+	return c.Status != nil || c.PasswordHash != nil || c.RoutingRules != nil || c.Variants != nil || c.ForwardQuery != nil
+}
+
+func (d LinkDetails) empty() bool {
+	return d.Title == "" && d.Description == "" && len(d.Tags) == 0 && d.OpenGraph == OpenGraph{}
+}
+
+// OpenGraph overrides what social platforms show when a link is shared.
+// Empty fields fall back to the metadata of the destination page.
+type OpenGraph struct {
+	Title       string
+	Description string
+	ImageURL    string
+}
+
+// LinkMetadata is what the destination page of a link says about itself, as
+// fetched by the worker.
+type LinkMetadata struct {
+	Title       string
+	Description string
+	FaviconURL  string
+	ImageURL    string
+}
+
+// Preview is what link previews show: where a link leads and how it is
+// presented, with the owner's overrides applied over the fetched metadata.
+type Preview struct {
+	ShortCode   string
+	LongURL     string
+	Title       string
+	Description string
+	ImageURL    string
+	FaviconURL  string
+	Protected   bool
+	// PasswordStamp is what access tokens to the link must carry.
+	PasswordStamp string
+}
+
+// LinkRef identifies a link. Short codes are unique per domain; a zero
+// DomainID is the default domain.
+type LinkRef struct {
+	DomainID  int64
+	ShortCode string
+}
+
+// key names the link in the caches. Links on the default domain keep the
+// bare short code.
+func (l LinkRef) key() string {
+	if l.DomainID == 0 {
+		return l.ShortCode
+	}
+
+	return strconv.FormatInt(l.DomainID, 10) + "/" + l.ShortCode
+}
+
+// Redirect is what a short code resolves to. It is what the link caches hold.
+// Protected links are cached with the flag and the stamp of their password
+// only, so every visit still has to prove it was given the current password.
+// Rules and variants are cached along with the long URL and picked from on
+// every visit.
+type Redirect struct {
+	LongURL   string     `json:"u"`
+	Type      int        `json:"t"`
+	ExpiresAt *time.Time `json:"e,omitempty"`
+	Protected bool       `json:"p,omitempty"`
+	// PasswordStamp is what access tokens to a protected link must carry.
+	PasswordStamp string        `json:"s,omitempty"`
+	Rules         []RoutingRule `json:"r,omitempty"`
+	Variants      []Variant     `json:"v,omitempty"`
+	// ForwardQuery passes the query of each visit on to the destination.
+	ForwardQuery bool `json:"q,omitempty"`
+	// DomainID is the domain the link was looked up on, 0 for the default
+	// domain. It is not cached: it is part of the cache key.
+	DomainID int64 `json:"-"`
+}
+
+// Permanent reports whether browsers may cache the redirect.
+func (r *Redirect) Permanent() bool {
+	return r.Type == http.StatusMovedPermanently || r.Type == http.StatusPermanentRedirect
+}
+
+// PerVisitor reports whether visitors may be sent to different places.
+func (r *Redirect) PerVisitor() bool {
+	return len(r.Rules) > 0 || len(r.Variants) > 0
+}
+
+func (r *Redirect) expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
+}
+
+// Owner scopes a link either to a workspace or, when WorkspaceID is nil, to
+// a single user (or nobody, for anonymous links).
+type Owner struct {
+	UserID      *int64
+	WorkspaceID *int64
+}
+
+const (
+	SortNewest = "newest"
+	SortOldest = "oldest"
+)
+
+// HistoryQuery is the caller-facing history request. Cursor is the opaque
+// next_cursor of a previous page; From is inclusive and To exclusive. Links
+// must carry every one of Tags.
+type HistoryQuery struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Search string
+	Status string
+	Tags   []string
+	From   *time.Time
+	To     *time.Time
+}
+
+// HistoryCursor is the keyset position of the last row of a page.
+type HistoryCursor struct {
+	CreatedAt time.Time `json:"c"`
+	ID        int64     `json:"i"`
+	Sort      string    `json:"s"`
+}
+
+// HistoryFilter is a validated HistoryQuery scoped to a user's personal links
+// or, when WorkspaceID is set, to a workspace.
+type HistoryFilter struct {
+	UserID      int64
+	WorkspaceID *int64
+	Search      string
+	Status      string
+	Tags        []string
+	From        *time.Time
+	To          *time.Time
+	Ascending   bool
+	After       *HistoryCursor
+	Limit       int
+}
+
+type HistoryPage struct {
+	Data       []*URL `json:"data"`
+	Count      int    `json:"count"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ExportRow is one link of an export with its click total.
+type ExportRow struct {
+	Link   *URL
+	Clicks int64
+}
+
+// UpdateURLRequest changes the fields it sets and leaves the others alone.
+// Tags replace the link's tags as a whole; an empty Password removes the
+// link's password.
+type UpdateURLRequest struct {
+	Status        string    `json:"status,omitempty"`
+	Title         *string   `json:"title,omitempty"`
+	Description   *string   `json:"description,omitempty"`
+	Tags          *[]string `json:"tags,omitempty"`
+	OGTitle       *string   `json:"og_title,omitempty"`
+	OGDescription *string   `json:"og_description,omitempty"`
+	OGImage       *string   `json:"og_image,omitempty"`
+	Password      *string   `json:"password,omitempty"`
+	// RoutingRules replaces the rules of the link; an empty list removes
+	// them.
+	RoutingRules *[]RoutingRule `json:"routing_rules,omitempty"`
+	// Variants replaces the split destinations of the link; an empty list
+	// sends every visitor to the long URL again.
+	Variants     *[]Variant `json:"variants,omitempty"`
+	ForwardQuery *bool      `json:"forward_query,omitempty"`
 }
 
+// CreateURLRequest shortens LongURL. By default the caller's existing link to
+// the same destination is returned; ForceNew always mints a new short code.
+// The other fields are optional; links that set any of them are never
+// deduplicated.
 type CreateURLRequest struct {
-	LongURL string `json:"long_url"`
+	LongURL       string        `json:"long_url"`
+	ForceNew      bool          `json:"force_new"`
+	RedirectType  int           `json:"redirect_type,omitempty"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+	Domain        string        `json:"domain,omitempty"`
+	Title         string        `json:"title,omitempty"`
+	Description   string        `json:"description,omitempty"`
+	Tags          []string      `json:"tags,omitempty"`
+	OGTitle       string        `json:"og_title,omitempty"`
+	OGDescription string        `json:"og_description,omitempty"`
+	OGImage       string        `json:"og_image,omitempty"`
+	Password      string        `json:"password,omitempty"`
+	RoutingRules  []RoutingRule `json:"routing_rules,omitempty"`
+	Variants      []Variant     `json:"variants,omitempty"`
+	UTM           UTM           `json:"utm,omitempty"`
+	ForwardQuery  bool          `json:"forward_query,omitempty"`
 }
 
 type CreateURLResponse struct {
 	ShortCode string `json:"short_code"`
+	ShortURL  string `json:"short_url"`
 }
 
 type CreateURLRequest_Bulk struct {
 	LongURLs []string `json:"long_urls"`
+	ForceNew bool     `json:"force_new"`
 }
 
 type CreateURLResponse_Bulk struct {
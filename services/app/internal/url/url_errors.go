@@ -0,0 +1,117 @@
+package url
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+var LinkDisabled = &LinkDisabledErr{}
+var LinkExpired = &LinkExpiredErr{}
+var LinkFlagged = &LinkFlaggedErr{}
+var UnsafeDestination = &UnsafeDestinationErr{}
+var Forbidden = &ForbiddenErr{}
+var InvalidRequest = &InvalidRequestErr{}
+var InvalidShortCode = &InvalidShortCodeErr{}
+var IncorrectPassword = &IncorrectPasswordErr{}
+var InvalidAlias = &InvalidAliasErr{}
+var AliasTaken = &AliasTakenErr{}
+
+type LinkDisabledErr struct {
+	shortCode string
+}
+
+func (e *LinkDisabledErr) Error() string {
+	slog.Info("Short URL is disabled", "short_code", e.shortCode)
+	return "Short URL has been disabled"
+}
+
+type LinkExpiredErr struct {
+	shortCode string
+}
+
+func (e *LinkExpiredErr) Error() string {
+	slog.Info("Short URL has expired", "short_code", e.shortCode)
+	return "Short URL has expired"
+}
+
+// LinkFlaggedErr is returned for links disabled by a destination safety
+// check.
+type LinkFlaggedErr struct {
+	shortCode string
+	reason    string
+}
+
+func (e *LinkFlaggedErr) Error() string {
+	slog.Info("Short URL is flagged as unsafe", "short_code", e.shortCode, "reason", e.reason)
+	return "Short URL has been disabled because its destination was flagged as unsafe"
+}
+
+// UnsafeDestinationErr rejects shortening a URL a safety check flagged.
+type UnsafeDestinationErr struct {
+	longURL string
+	reason  string
+}
+
+func (e *UnsafeDestinationErr) Error() string {
+	slog.Warn("Rejected unsafe destination", "url", e.longURL, "reason", e.reason)
+	return fmt.Sprintf("Destination %q was flagged as unsafe (%s)", e.longURL, e.reason)
+}
+
+type ForbiddenErr struct {
+	shortCode string
+}
+
+func (e *ForbiddenErr) Error() string {
+	slog.Warn("Caller is not allowed to manage the link", "short_code", e.shortCode)
+	return "You are not allowed to manage this link"
+}
+
+type InvalidRequestErr struct {
+	reason string
+}
+
+func (e *InvalidRequestErr) Error() string {
+	return e.reason
+}
+
+// IncorrectPasswordErr rejects an attempt to unlock a protected link.
+type IncorrectPasswordErr struct {
+	shortCode string
+}
+
+func (e *IncorrectPasswordErr) Error() string {
+	slog.Info("Incorrect password for protected link", "short_code", e.shortCode)
+	return "Incorrect password"
+}
+
+// InvalidShortCodeErr is returned for codes no generator could have issued.
+// Callers treat it as not found without touching the cache or the database.
+type InvalidShortCodeErr struct {
+	shortCode string
+	reason    string
+}
+
+func (e *InvalidShortCodeErr) Error() string {
+	slog.Debug("Rejected malformed short code", "short_code", e.shortCode, "reason", e.reason)
+	return "Short URL not found"
+}
+
+// InvalidAliasErr is returned for custom aliases that would not resolve
+// because no generator could have issued them.
+type InvalidAliasErr struct {
+	alias string
+}
+
+func (e *InvalidAliasErr) Error() string {
+	return fmt.Sprintf("Alias %q is not a valid short code", e.alias)
+}
+
+// AliasTakenErr is returned for custom aliases another link on the same
+// domain already has.
+type AliasTakenErr struct {
+	alias string
+}
+
+func (e *AliasTakenErr) Error() string {
+	return fmt.Sprintf("Alias %q is already taken", e.alias)
+}
@@ -3,48 +3,301 @@ package url
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"hafiztri123/app-link-shortener/internal/utils"
 	"log/slog"
 	"strings"
+	"unicode"
 
 	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type URLRepository interface {
-	FindOrCreateShortCode(context.Context, string, uint64, *int64) (string, error)
-	FindOrCreateShortCode_Bulk(context.Context, []string, uint64, *int64) ([]CreateShortCodeBulkResult, error)
+	FindOrCreateShortCode(context.Context, Destination, Owner) (CreatedLink, error)
+	FindOrCreateShortCode_Bulk(context.Context, []Destination, Owner) ([]CreateShortCodeBulkResult, error)
+	CreateShortCode(context.Context, Destination, Owner) (CreatedLink, error)
+	CreateShortCode_Bulk(context.Context, []Destination, Owner) ([]CreateShortCodeBulkResult, error)
 	GetByID(context.Context, int64) (*URL, error)
-	GetByUserID_Bulk(context.Context, int64) ([]*URL, error)
+	GetByLink(context.Context, LinkRef) (*URL, error)
+	ListActiveAfter(context.Context, int64, int) ([]*URL, error)
+	Flag(context.Context, int64, string) error
+	ListHistory(context.Context, HistoryFilter) ([]*URL, error)
+	CountHistory(context.Context, HistoryFilter) (int, error)
+	Update(context.Context, int64, LinkChanges) error
+	ListTags(context.Context, []int64) (map[int64][]string, error)
+	GetMetadata(context.Context, int64) (*LinkMetadata, error)
+	Delete(context.Context, int64) error
 }
 
 type Repository struct {
-	DB *sql.DB
+	DB    *sql.DB
+	codes CodeGenerator
 }
 
-func NewRepository(db *sql.DB) *Repository {
-	return &Repository{DB: db}
+func NewRepository(db *sql.DB, codes CodeGenerator) *Repository {
+	return &Repository{DB: db, codes: codes}
 }
 
-func (r *Repository) GetByID(ctx context.Context, id int64) (*URL, error) {
-	query := "SELECT id, short_code, long_url, created_at FROM urls where id = $1"
+const urlColumns = "id, short_code, long_url, status, flagged_reason, user_id, workspace_id, domain_id, redirect_type, expires_at, created_at, title, description, og_title, og_description, og_image_url, password_hash, routing_rules, variants, forward_query"
 
-	var url URL
+// urlTables joins links to their details, which most links have none of.
+const urlTables = "urls LEFT JOIN link_details ON link_details.url_id = urls.id"
 
-	err := r.DB.QueryRowContext(ctx, query, id).Scan(&url.ID, &url.ShortCode, &url.LongURL, &url.CreatedAt)
+func scanURL(row interface{ Scan(...any) error }) (*URL, error) {
+	var url URL
+	var rules, variants []byte
 
+	err := row.Scan(&url.ID, &url.ShortCode, &url.LongURL, &url.Status, &url.FlaggedReason, &url.UserID, &url.WorkspaceID, &url.DomainID, &url.RedirectType, &url.ExpiresAt, &url.CreatedAt, &url.Title, &url.Description, &url.OGTitle, &url.OGDescription, &url.OGImageURL, &url.PasswordHash, &rules, &variants, &url.ForwardQuery)
 	if err != nil {
 		return nil, err
 	}
+	url.Protected = url.PasswordHash.Valid
+
+	if rules != nil {
+		if err := json.Unmarshal(rules, &url.RoutingRules); err != nil {
+			return nil, fmt.Errorf("decoding routing rules of url %d: %w", url.ID, err)
+		}
+	}
+
+	if variants != nil {
+		if err := json.Unmarshal(variants, &url.Variants); err != nil {
+			return nil, fmt.Errorf("decoding variants of url %d: %w", url.ID, err)
+		}
+	}
 
 	return &url, nil
 }
 
-func (r *Repository) GetByUserID_Bulk(ctx context.Context, userId int64) ([]*URL, error) {
-	fetchQuery := `SELECT id, short_code, long_url, created_at FROM urls WHERE user_id = $1`
+func (r *Repository) GetByID(ctx context.Context, id int64) (*URL, error) {
+	query := "SELECT " + urlColumns + " FROM " + urlTables + " WHERE id = $1"
+
+	return scanURL(r.DB.QueryRowContext(ctx, query, id))
+}
+
+func (r *Repository) GetByLink(ctx context.Context, link LinkRef) (*URL, error) {
+	if link.DomainID == 0 {
+		query := "SELECT " + urlColumns + " FROM " + urlTables + " WHERE short_code = $1 AND domain_id IS NULL"
+		return scanURL(r.DB.QueryRowContext(ctx, query, link.ShortCode))
+	}
+
+	query := "SELECT " + urlColumns + " FROM " + urlTables + " WHERE domain_id = $1 AND short_code = $2"
+	return scanURL(r.DB.QueryRowContext(ctx, query, link.DomainID, link.ShortCode))
+}
+
+// ListHistory returns up to filter.Limit links in (created_at, id) order,
+// starting after filter.After. Personal history only covers links created
+// outside any workspace; those belong to the workspace's history instead.
+func (r *Repository) ListHistory(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+	where, args := filter.where()
+
+	direction, comparison := "DESC", "<"
+	if filter.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+
+	if filter.After != nil {
+		args = append(args, filter.After.CreatedAt, filter.After.ID)
+		where += fmt.Sprintf(" AND (created_at, id) %s ($%d, $%d)", comparison, len(args)-1, len(args))
+	}
+
+	args = append(args, filter.Limit)
+	fetchQuery := fmt.Sprintf(`SELECT %s FROM %s WHERE %s ORDER BY created_at %s, id %s LIMIT $%d`,
+		urlColumns, urlTables, where, direction, direction, len(args))
+
+	return r.queryURLs(ctx, fetchQuery, args...)
+}
+
+// CountHistory counts every link matching the filter, ignoring the cursor.
+func (r *Repository) CountHistory(ctx context.Context, filter HistoryFilter) (int, error) {
+	where, args := filter.where()
+
+	var total int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+urlTables+` WHERE `+where, args...).Scan(&total)
+	return total, err
+}
+
+// jsonColumn is the value of a column holding list as JSON: NULL when the
+// list is empty.
+func jsonColumn[T any](list []T) (any, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(encoded), nil
+}
+
+// Update makes changes to link id in one transaction, so they apply all
+// together or not at all.
+func (r *Repository) Update(ctx context.Context, id int64, changes LinkChanges) error {
+	args := []any{id}
+	var assignments []string
+	assign := func(assignment string, value any) {
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf(assignment, len(args)))
+	}
+
+	if changes.Status != nil {
+		assign("status = $%d", *changes.Status)
+		// Deduplication must not hand out a dead short code; the link
+		// stays its own if it is enabled again.
+		if *changes.Status != StatusActive {
+			assignments = append(assignments, "reusable = FALSE")
+		}
+	}
+	if changes.PasswordHash != nil {
+		assign("password_hash = NULLIF($%d, '')", *changes.PasswordHash)
+	}
+	if changes.RoutingRules != nil {
+		encoded, err := jsonColumn(*changes.RoutingRules)
+		if err != nil {
+			return err
+		}
+		assign("routing_rules = $%d", encoded)
+	}
+	if changes.Variants != nil {
+		encoded, err := jsonColumn(*changes.Variants)
+		if err != nil {
+			return err
+		}
+		assign("variants = $%d", encoded)
+	}
+	if changes.ForwardQuery != nil {
+		assign("forward_query = $%d", *changes.ForwardQuery)
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(assignments) > 0 {
+		query := `UPDATE urls SET ` + strings.Join(assignments, ", ") + ` WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	if changes.Details != nil {
+		if err := saveDetails(ctx, tx, id, *changes.Details); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// saveDetails stores details as the only ones of the link, dropping its rows
+// when there are none.
+func saveDetails(ctx context.Context, tx *sql.Tx, id int64, details LinkDetails) error {
+	og := details.OpenGraph
+	if details.Title == "" && details.Description == "" && og == (OpenGraph{}) {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM link_details WHERE url_id = $1`, id); err != nil {
+			return err
+		}
+	} else {
+		upsertQuery := `INSERT INTO link_details (url_id, title, description, og_title, og_description, og_image_url) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (url_id) DO UPDATE SET title = EXCLUDED.title, description = EXCLUDED.description,
+				og_title = EXCLUDED.og_title, og_description = EXCLUDED.og_description, og_image_url = EXCLUDED.og_image_url`
+		if _, err := tx.ExecContext(ctx, upsertQuery, id, details.Title, details.Description, og.Title, og.Description, og.ImageURL); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_tags WHERE url_id = $1`, id); err != nil {
+		return err
+	}
+	if len(details.Tags) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(details.Tags))
+	args := []any{id}
+	for i, tag := range details.Tags {
+		args = append(args, tag)
+		values[i] = fmt.Sprintf("($1, $%d)", len(args))
+	}
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO link_tags (url_id, tag) VALUES `+strings.Join(values, ", "), args...)
+	return err
+}
+
+// GetMetadata returns the metadata fetched from the destination of link id,
+// or sql.ErrNoRows when none was.
+func (r *Repository) GetMetadata(ctx context.Context, id int64) (*LinkMetadata, error) {
+	var meta LinkMetadata
+	err := r.DB.QueryRowContext(ctx,
+		`SELECT title, description, favicon_url, image_url FROM link_metadata WHERE url_id = $1`, id,
+	).Scan(&meta.Title, &meta.Description, &meta.FaviconURL, &meta.ImageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// ListTags returns the tags of each of ids, in alphabetical order. Links
+// without tags are left out.
+func (r *Repository) ListTags(ctx context.Context, ids []int64) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+	if len(ids) == 0 {
+		return tags, nil
+	}
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	query := fmt.Sprintf(`SELECT url_id, tag FROM link_tags WHERE url_id IN (%s) ORDER BY url_id, tag`,
+		utils.SelectPlaceholderBuilder(len(ids), 1))
 
-	rows, err := r.DB.QueryContext(ctx, fetchQuery, userId)
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// ListActiveAfter returns up to limit active links with an ID above afterID,
+// in ID order, for walking every link in batches.
+func (r *Repository) ListActiveAfter(ctx context.Context, afterID int64, limit int) ([]*URL, error) {
+	query := "SELECT " + urlColumns + " FROM " + urlTables + " WHERE status = 'active' AND short_code IS NOT NULL AND id > $1 ORDER BY id LIMIT $2"
+
+	return r.queryURLs(ctx, query, afterID, limit)
+}
+
+// Flag disables a link whose destination was found unsafe, recording why.
+func (r *Repository) Flag(ctx context.Context, id int64, reason string) error {
+	_, err := r.DB.ExecContext(ctx, `UPDATE urls SET status = 'flagged', flagged_reason = $1, flagged_at = NOW(), reusable = FALSE WHERE id = $2`, reason, id)
+	return err
+}
+
+func (r *Repository) Delete(ctx context.Context, id int64) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM urls WHERE id = $1`, id)
+	return err
+}
+
+func (r *Repository) queryURLs(ctx context.Context, query string, args ...any) ([]*URL, error) {
+	rows, err := r.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		slog.Error("database operation error occured", "error", err)
 		return nil, err
@@ -54,14 +307,12 @@ func (r *Repository) GetByUserID_Bulk(ctx context.Context, userId int64) ([]*URL
 	var urls []*URL
 
 	for rows.Next() {
-		var url URL
-
-		err := rows.Scan(&url.ID, &url.ShortCode, &url.LongURL, &url.CreatedAt)
+		url, err := scanURL(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		urls = append(urls, &url)
+		urls = append(urls, url)
 	}
 
 	if err = rows.Err(); err != nil {
@@ -72,148 +323,326 @@ func (r *Repository) GetByUserID_Bulk(ctx context.Context, userId int64) ([]*URL
 
 }
 
-func (r *Repository) FindOrCreateShortCode(ctx context.Context, longURL string, idOffset uint64, userId *int64) (string, error) {
+// FindOrCreateShortCode returns the owner's reusable link for the
+// destination, creating it when the owner has none. Links are matched on the
+// canonical URL and deduplication is scoped to the owner: the same
+// destination shortened by someone else gets its own link. Reusable links
+// always have the default options on the default domain, so dest.Options and
+// dest.DomainID are not stored.
+func (r *Repository) FindOrCreateShortCode(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		slog.Error("Failed to begin transaction", "error", err)
-		return "", err
+		return CreatedLink{}, err
 	}
 
 	defer tx.Rollback()
 
+	ownerFilter, ownerArg := owner.filter(2)
+	findQuery := `SELECT id, short_code FROM urls WHERE canonical_url = $1 AND reusable AND ` + ownerFilter
+
+	var existing CreatedLink
 	var shortCode sql.NullString
-	err = tx.QueryRowContext(ctx, `SELECT short_code FROM urls WHERE long_url = $1 AND user_id IS NOT DISTINCT FROM $2`, longURL, userId).Scan(&shortCode)
+	err = tx.QueryRowContext(ctx, findQuery, dest.CanonicalURL, ownerArg).Scan(&existing.ID, &shortCode)
 
 	if err == nil && shortCode.Valid {
-		return shortCode.String, nil
+		existing.ShortCode = shortCode.String
+		return existing, nil
 	}
 
 	if err != nil && err != sql.ErrNoRows {
-		return "", err
+		return CreatedLink{}, err
 	}
 
 	var id int64
-	err = tx.QueryRowContext(ctx, `INSERT INTO urls (long_url, user_id) VALUES ($1, $2) RETURNING id`, longURL, userId).Scan(&id)
+	insertQuery := `INSERT INTO urls (long_url, canonical_url, user_id, workspace_id) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery, dest.LongURL, dest.CanonicalURL, owner.UserID, owner.WorkspaceID).Scan(&id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		// A concurrent request by the same owner won the insert; its row is
+		// committed with a short code by the time the conflict is reported.
+		tx.Rollback()
+		var winner CreatedLink
+		err = r.DB.QueryRowContext(ctx, findQuery, dest.CanonicalURL, ownerArg).Scan(&winner.ID, &winner.ShortCode)
+		return winner, err
+	}
 
 	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_UNIQUE_CONSRAINT_VIOLATION_CODE {
-			tx.Rollback()
-			var existingShortCode string
-			err = r.DB.QueryRowContext(ctx, `SELECT short_code FROM urls WHERE long_url = $1`, longURL).Scan(&existingShortCode)
-			return existingShortCode, err
-		}
-
-		return "", err
+		return CreatedLink{}, err
 	}
 
-	newShortcode := toBase62(uint64(id) + idOffset)
-	_, err = tx.ExecContext(ctx, `UPDATE urls SET short_code = $1 WHERE id = $2`, newShortcode, id)
+	created, err := r.assignShortCode(ctx, tx, id, nil)
 	if err != nil {
-		return "", err
+		return CreatedLink{}, err
 	}
 
 	if err := tx.Commit(); err != nil {
-		return "", err
+		return CreatedLink{}, err
 	}
 
-	return newShortcode, nil
+	return created, nil
 }
 
-func (r *Repository) FindOrCreateShortCode_Bulk(ctx context.Context, longURLs []string, idOffset uint64, userId *int64) ([]CreateShortCodeBulkResult, error) {
+// CreateShortCode always inserts a new link with dest.Options. The row is not
+// reusable, so later deduplicating requests for the same destination never
+// return it.
+func (r *Repository) CreateShortCode(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+	defer tx.Rollback()
+
+	created, err := r.insertFreshURL(ctx, tx, dest, owner)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	return created, tx.Commit()
+}
+
+func (r *Repository) CreateShortCode_Bulk(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
 	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	if len(longURLs) > 0 {
-		err = r.insertURLsIgnoreConflicts(ctx, tx, longURLs, userId)
+	result := make([]CreateShortCodeBulkResult, len(dests))
+	for i, dest := range dests {
+		created, err := r.insertFreshURL(ctx, tx, dest, owner)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = CreateShortCodeBulkResult{ID: created.ID, LongURL: dest.LongURL, ShortCode: created.ShortCode, Status: BulkItemCreated}
+	}
+
+	return result, tx.Commit()
+}
+
+func (r *Repository) insertFreshURL(ctx context.Context, tx *sql.Tx, dest Destination, owner Owner) (CreatedLink, error) {
+	rules, err := jsonColumn(dest.Options.RoutingRules)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	variants, err := jsonColumn(dest.Options.Variants)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	var id int64
+	insertQuery := `INSERT INTO urls (long_url, canonical_url, user_id, workspace_id, domain_id, redirect_type, expires_at, password_hash, routing_rules, variants, forward_query, reusable) VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11, FALSE) RETURNING id`
+	err = tx.QueryRowContext(ctx, insertQuery, dest.LongURL, dest.CanonicalURL, owner.UserID, owner.WorkspaceID, dest.DomainID, dest.Options.RedirectType, dest.Options.ExpiresAt, dest.PasswordHash, rules, variants, dest.Options.ForwardQuery).Scan(&id)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	var created CreatedLink
+	if dest.Options.Alias != "" {
+		created, err = r.claimAlias(ctx, tx, id, dest.DomainID, dest.Options.Alias)
+	} else {
+		created, err = r.assignShortCode(ctx, tx, id, dest.DomainID)
+	}
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	if !dest.Options.Details.empty() {
+		if err := saveDetails(ctx, tx, created.ID, dest.Options.Details); err != nil {
+			return CreatedLink{}, err
+		}
+	}
+
+	return created, nil
+}
+
+// assignShortCode gives the fresh row id the short code derived from its ID.
+// A custom alias may already hold that code on the row's domain; the row
+// then moves to the next ID until its code is free, so generated codes never
+// take an alias over.
+func (r *Repository) assignShortCode(ctx context.Context, tx *sql.Tx, id int64, domainID *int64) (CreatedLink, error) {
+	for {
+		shortCode, err := r.codes.Encode(uint64(id))
 		if err != nil {
+			return CreatedLink{}, err
+		}
+
+		taken, err := shortCodeTaken(ctx, tx, domainID, shortCode)
+		if err != nil {
+			return CreatedLink{}, err
+		}
+
+		if !taken {
+			if _, err := tx.ExecContext(ctx, `UPDATE urls SET short_code = $1 WHERE id = $2`, shortCode, id); err != nil {
+				return CreatedLink{}, err
+			}
+
+			return CreatedLink{ID: id, ShortCode: shortCode}, nil
+		}
+
+		if err := tx.QueryRowContext(ctx, `UPDATE urls SET id = nextval('urls_id_seq') WHERE id = $1 RETURNING id`, id).Scan(&id); err != nil {
+			return CreatedLink{}, err
+		}
+	}
+}
+
+// claimAlias gives the fresh row id the custom short code alias, provided no
+// link on the row's domain has it yet.
+func (r *Repository) claimAlias(ctx context.Context, tx *sql.Tx, id int64, domainID *int64, alias string) (CreatedLink, error) {
+	taken, err := shortCodeTaken(ctx, tx, domainID, alias)
+	if err != nil {
+		return CreatedLink{}, err
+	}
+
+	if taken {
+		return CreatedLink{}, &AliasTakenErr{alias: alias}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE urls SET short_code = $1 WHERE id = $2`, alias, id); err != nil {
+		// A concurrent request claimed the alias after the check.
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_UNIQUE_CONSRAINT_VIOLATION_CODE {
+			return CreatedLink{}, &AliasTakenErr{alias: alias}
+		}
+		return CreatedLink{}, err
+	}
+
+	return CreatedLink{ID: id, ShortCode: alias}, nil
+}
+
+// shortCodeTaken reports whether a link on domainID, or on the default domain
+// when it is nil, has shortCode.
+func shortCodeTaken(ctx context.Context, tx *sql.Tx, domainID *int64, shortCode string) (bool, error) {
+	var taken bool
+	var err error
+	if domainID == nil {
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM urls WHERE short_code = $1 AND domain_id IS NULL)`, shortCode).Scan(&taken)
+	} else {
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM urls WHERE domain_id = $1 AND short_code = $2)`, *domainID, shortCode).Scan(&taken)
+	}
+
+	return taken, err
+}
+
+// bulkChunkSize is how many destinations each statement of a bulk shortening
+// covers, keeping them well under the Postgres limit of 65535 parameters.
+const bulkChunkSize = 1000
+
+// FindOrCreateShortCode_Bulk is FindOrCreateShortCode for many destinations
+// in one transaction, working through them in chunks. Existing links are
+// only reused when they belong to owner.
+func (r *Repository) FindOrCreateShortCode_Bulk(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	urlToLink := make(map[string]CreatedLink)
+	for start := 0; start < len(dests); start += bulkChunkSize {
+		chunk := dests[start:min(start+bulkChunkSize, len(dests))]
+		if err := r.findOrCreateChunk(ctx, tx, chunk, owner, urlToLink); err != nil {
 			return nil, err
 		}
 	}
 
-	placeholder := utils.SelectPlaceholderBuilder(len(longURLs), 1)
+	result := make([]CreateShortCodeBulkResult, len(dests))
+	for i, dest := range dests {
+		link := urlToLink[dest.CanonicalURL]
+		result[i] = CreateShortCodeBulkResult{
+			ID:        link.ID,
+			LongURL:   dest.LongURL,
+			ShortCode: link.ShortCode,
+			Status:    BulkItemCreated,
+		}
+	}
+
+	return result, tx.Commit()
+
+}
+
+// findOrCreateChunk inserts the destinations of chunk owner does not have a
+// link for yet and records the link of each in urlToLink, keyed by
+// canonical URL.
+func (r *Repository) findOrCreateChunk(ctx context.Context, tx *sql.Tx, chunk []Destination, owner Owner, urlToLink map[string]CreatedLink) error {
+	if err := r.insertURLsIgnoreConflicts(ctx, tx, chunk, owner); err != nil {
+		return err
+	}
+
+	canonicalURLs := make([]string, len(chunk))
+	for i, dest := range chunk {
+		canonicalURLs[i] = dest.CanonicalURL
+	}
+
+	placeholder := utils.SelectPlaceholderBuilder(len(canonicalURLs), 1)
+	ownerFilter, ownerArg := owner.filter(len(canonicalURLs) + 1)
 	selectQuery := fmt.Sprintf(`
-		SELECT id, short_code, long_url
+		SELECT id, short_code, canonical_url
 		FROM urls
-		WHERE long_url IN (%s)
-	`, placeholder)
+		WHERE canonical_url IN (%s) AND reusable AND %s
+	`, placeholder, ownerFilter)
 
 	args := []any{}
-	args = append(args, utils.StringSliceToAny(longURLs)...)
+	args = append(args, utils.StringSliceToAny(canonicalURLs)...)
+	args = append(args, ownerArg)
 
 	rows, err := tx.QueryContext(ctx, selectQuery, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	defer rows.Close()
 
 	var urlsToUpdate []struct {
-		id      int64
-		longURL string
+		id           int64
+		canonicalURL string
 	}
 
-	urlToShortCode := make(map[string]string)
-
 	for rows.Next() {
 		var id int64
 		var shortCode sql.NullString
-		var longURL string
+		var canonicalURL string
 
-		if err := rows.Scan(&id, &shortCode, &longURL); err != nil {
-			return nil, err
+		if err := rows.Scan(&id, &shortCode, &canonicalURL); err != nil {
+			return err
 		}
 
 		if shortCode.Valid {
-			urlToShortCode[longURL] = shortCode.String
+			urlToLink[canonicalURL] = CreatedLink{ID: id, ShortCode: shortCode.String}
 		} else {
 			urlsToUpdate = append(urlsToUpdate, struct {
-				id      int64
-				longURL string
+				id           int64
+				canonicalURL string
 			}{
-				id:      id,
-				longURL: longURL,
+				id:           id,
+				canonicalURL: canonicalURL,
 			})
 		}
 	}
-
-	if len(urlsToUpdate) > 0 {
-		err = r.bulkUpdateShortCodes(ctx, tx, urlsToUpdate, idOffset, urlToShortCode)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	result := make([]CreateShortCodeBulkResult, len(longURLs))
-	for i, url := range longURLs {
-		result[i] = CreateShortCodeBulkResult{
-			LongURL:   url,
-			ShortCode: urlToShortCode[url],
-		}
+	if err := rows.Err(); err != nil {
+		return err
 	}
 
-	return result, tx.Commit()
-
+	return r.bulkUpdateShortCodes(ctx, tx, urlsToUpdate, urlToLink)
 }
 
-func (r *Repository) insertURLsIgnoreConflicts(ctx context.Context, tx *sql.Tx, longURLs []string, userId *int64) error {
-	fieldCount := 2
-	placeholderGroups := make([]string, len(longURLs))
-	args := make([]any, 0, len(longURLs)*fieldCount)
+func (r *Repository) insertURLsIgnoreConflicts(ctx context.Context, tx *sql.Tx, dests []Destination, owner Owner) error {
+	fieldCount := 4
+	placeholderGroups := make([]string, len(dests))
+	args := make([]any, 0, len(dests)*fieldCount)
 
-	for i, url := range longURLs {
+	for i, dest := range dests {
 		baseIndex := i * fieldCount
-		placeholderGroups[i] = fmt.Sprintf("($%d, $%d)", baseIndex+1, baseIndex+2)
-		args = append(args, url, userId)
+		placeholderGroups[i] = fmt.Sprintf("($%d, $%d, $%d, $%d)", baseIndex+1, baseIndex+2, baseIndex+3, baseIndex+4)
+		args = append(args, dest.LongURL, dest.CanonicalURL, owner.UserID, owner.WorkspaceID)
 	}
 
 	query := fmt.Sprintf(`
-		INSERT INTO urls (long_url, user_id)
+		INSERT INTO urls (long_url, canonical_url, user_id, workspace_id)
 		VALUES %s
-		ON CONFLICT (long_url) DO NOTHING
+		ON CONFLICT DO NOTHING
 	`, strings.Join(placeholderGroups, ","))
 
 	_, err := tx.ExecContext(ctx, query, args...)
@@ -221,24 +650,49 @@ func (r *Repository) insertURLsIgnoreConflicts(ctx context.Context, tx *sql.Tx,
 }
 
 func (r *Repository) bulkUpdateShortCodes(ctx context.Context, tx *sql.Tx, urlsToUpdate []struct {
-	id      int64
-	longURL string
-}, idOffset uint64, urlToShortCode map[string]string) error {
+	id           int64
+	canonicalURL string
+}, urlToLink map[string]CreatedLink) error {
 	if len(urlsToUpdate) == 0 {
 		return nil
 	}
 
-	caseClauses := make([]string, len(urlsToUpdate))
-	inClauses := make([]string, len(urlsToUpdate))
+	shortCodes := make([]string, len(urlsToUpdate))
+	for i, item := range urlsToUpdate {
+		shortCode, err := r.codes.Encode(uint64(item.id))
+		if err != nil {
+			return err
+		}
+		shortCodes[i] = shortCode
+	}
+
+	taken, err := takenDefaultShortCodes(ctx, tx, shortCodes)
+	if err != nil {
+		return err
+	}
+
+	var caseClauses, inClauses []string
 	args := make([]any, 0, len(urlsToUpdate)*2)
 
 	for i, item := range urlsToUpdate {
-		shortCode := toBase62(uint64(item.id) + idOffset)
-		urlToShortCode[item.longURL] = shortCode
+		// Rows whose code an alias holds are moved to a free one on their own.
+		if taken[shortCodes[i]] {
+			created, err := r.assignShortCode(ctx, tx, item.id, nil)
+			if err != nil {
+				return err
+			}
+			urlToLink[item.canonicalURL] = created
+			continue
+		}
+		urlToLink[item.canonicalURL] = CreatedLink{ID: item.id, ShortCode: shortCodes[i]}
 
-		caseClauses[i] = fmt.Sprintf("WHEN $%d THEN $%d", i*2+1, i*2+2)
-		inClauses[i] = fmt.Sprintf("$%d", i*2+1)
-		args = append(args, item.id, shortCode)
+		caseClauses = append(caseClauses, fmt.Sprintf("WHEN $%d THEN $%d", len(args)+1, len(args)+2))
+		inClauses = append(inClauses, fmt.Sprintf("$%d", len(args)+1))
+		args = append(args, item.id, shortCodes[i])
+	}
+
+	if len(caseClauses) == 0 {
+		return nil
 	}
 
 	query := fmt.Sprintf(`
@@ -247,6 +701,111 @@ func (r *Repository) bulkUpdateShortCodes(ctx context.Context, tx *sql.Tx, urlsT
 		WHERE id IN (%s)
 	`, strings.Join(caseClauses, " "), strings.Join(inClauses, ","))
 
-	_, err := tx.ExecContext(ctx, query, args...)
+	_, err = tx.ExecContext(ctx, query, args...)
 	return err
 }
+
+// takenDefaultShortCodes returns which of shortCodes links on the default
+// domain already have.
+func takenDefaultShortCodes(ctx context.Context, tx *sql.Tx, shortCodes []string) (map[string]bool, error) {
+	query := fmt.Sprintf(`SELECT short_code FROM urls WHERE domain_id IS NULL AND short_code IN (%s)`, utils.SelectPlaceholderBuilder(len(shortCodes), 1))
+
+	rows, err := tx.QueryContext(ctx, query, utils.StringSliceToAny(shortCodes)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taken := map[string]bool{}
+	for rows.Next() {
+		var shortCode string
+		if err := rows.Scan(&shortCode); err != nil {
+			return nil, err
+		}
+		taken[shortCode] = true
+	}
+
+	return taken, rows.Err()
+}
+
+// filter returns the WHERE fragment matching rows owned by o, using the
+// placeholder $argIndex, together with its argument.
+func (o Owner) filter(argIndex int) (string, any) {
+	if o.WorkspaceID != nil {
+		return fmt.Sprintf("workspace_id = $%d", argIndex), *o.WorkspaceID
+	}
+
+	return fmt.Sprintf("workspace_id IS NULL AND user_id IS NOT DISTINCT FROM $%d", argIndex), o.UserID
+}
+
+func (f HistoryFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+
+	if f.WorkspaceID != nil {
+		args = append(args, *f.WorkspaceID)
+		conditions = append(conditions, fmt.Sprintf("workspace_id = $%d", len(args)))
+	} else {
+		args = append(args, f.UserID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d AND workspace_id IS NULL", len(args)))
+	}
+
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	for _, tag := range f.Tags {
+		args = append(args, tag)
+		conditions = append(conditions, fmt.Sprintf("EXISTS (SELECT 1 FROM link_tags WHERE link_tags.url_id = urls.id AND link_tags.tag = $%d)", len(args)))
+	}
+
+	if f.Search != "" {
+		// Words of the destination, title and description match by prefix,
+		// so results narrow as the query is typed; short codes and
+		// destinations also match by substring, as they did before.
+		args = append(args, "%"+escapeLike(f.Search)+"%")
+		search := fmt.Sprintf("short_code ILIKE $%d OR urls.long_url ILIKE $%d", len(args), len(args))
+
+		if query := prefixTSQuery(f.Search); query != "" {
+			args = append(args, query)
+			search += fmt.Sprintf(" OR urls.search_vector @@ to_tsquery('simple', $%d) OR link_details.search_vector @@ to_tsquery('simple', $%d)", len(args), len(args))
+		}
+
+		conditions = append(conditions, "("+search+")")
+	}
+
+	if f.From != nil {
+		args = append(args, *f.From)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+
+	if f.To != nil {
+		args = append(args, *f.To)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// prefixTSQuery turns search input into a tsquery matching text that has a
+// word starting with each of its words. Anything but letters and digits
+// separates words, as in the indexed destinations.
+func prefixTSQuery(search string) string {
+	words := strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes user input match literally inside a LIKE pattern.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -1,22 +1,39 @@
 package url
 
 import (
+	"database/sql"
+	"fmt"
 	"hpj/hv1-link-shortener/shared/migrations"
 	"sync"
 	"testing"
+	"time"
 
 	"hafiztri123/app-link-shortener/internal/auth"
 	"hafiztri123/app-link-shortener/internal/user"
 	_ "hafiztri123/app-link-shortener/internal/utils"
+	"hafiztri123/app-link-shortener/internal/workspace"
 
 	_ "github.com/mattn/go-sqlite3" // Driver for in-memory SQLite
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// dest wraps a URL that is already in canonical form.
+func dest(longURL string) Destination {
+	return Destination{LongURL: longURL, CanonicalURL: longURL}
+}
+
+func dests(longURLs ...string) []Destination {
+	result := make([]Destination, len(longURLs))
+	for i, longURL := range longURLs {
+		result[i] = dest(longURL)
+	}
+	return result
+}
+
 func TestRepository_IntegrationFlow(t *testing.T) {
 	db, ctx := migrations.SetupTestDB(t)
-	repo := NewRepository(db)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
 
 	t.Cleanup(func() {
 		require.NoError(t, db.Close())
@@ -24,26 +41,27 @@ func TestRepository_IntegrationFlow(t *testing.T) {
 
 	longURL := "https://www.google.com/search?q=golang-testing"
 
-	shortCode1, err := repo.FindOrCreateShortCode(ctx, longURL, 1000, nil)
+	created1, err := repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{})
 	require.NoError(t, err)
-	require.NotEmpty(t, shortCode1)
+	require.NotEmpty(t, created1.ShortCode)
 
-	shortCode2, err := repo.FindOrCreateShortCode(ctx, longURL, 1000, nil)
+	created2, err := repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{})
 	assert.NoError(t, err)
-	assert.Equal(t, shortCode1, shortCode2, "Expected: %s, Actual: %s", shortCode1, shortCode2)
+	assert.Equal(t, created1, created2, "the same link is returned again")
 
-	retrievedURL, err := repo.GetByID(ctx, 1)
+	retrievedURL, err := repo.GetByID(ctx, created1.ID)
 	require.NoError(t, err)
 	require.NotNil(t, retrievedURL, "Expected: URL")
 	require.Equal(t, int64(1), retrievedURL.ID)
 	require.Equal(t, longURL, retrievedURL.LongURL)
 	require.True(t, retrievedURL.ShortCode.Valid)
-	require.Equal(t, shortCode1, retrievedURL.ShortCode.String)
+	require.Equal(t, created1.ShortCode, retrievedURL.ShortCode.String)
+	require.Equal(t, StatusActive, retrievedURL.Status)
 }
 
 func TestRepository_FetchHistoryURL(t *testing.T) {
 	db, ctx := migrations.SetupTestDB(t)
-	repo := NewRepository(db)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
 	userId := int64(1)
 
 	t.Cleanup(func() {
@@ -52,42 +70,42 @@ func TestRepository_FetchHistoryURL(t *testing.T) {
 
 	tokenService := auth.NewTokenService("secret")
 
-	userService := user.NewService(db, user.NewRepository(db), tokenService)
+	userService := user.NewService(db, user.NewRepository(db), tokenService, nil)
 
 	err := userService.Register(ctx, user.RegisterRequest{
-		Email:    "test",
+		Email:    "test@mail.com",
 		Password: "password",
 	})
-	token, err := userService.Login(ctx, user.LoginRequest{
-		Email:    "test",
+	login, err := userService.Login(ctx, user.LoginRequest{
+		Email:    "test@mail.com",
 		Password: "password",
 	})
 
 	assert.NoError(t, err)
 
-	claims, err := tokenService.ValidateToken(token)
+	claims, err := tokenService.ValidateToken(login.Token)
 	assert.NoError(t, err)
 	userId = claims.UserID
 
 	longURL := "https://www.google.com/search?q=golang-testing"
 	longURL2 := "https://www.google.com/search?q=golang-testing-2"
 
-	shortCode1, err := repo.FindOrCreateShortCode(ctx, longURL, 1000, &userId)
+	created1, err := repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{UserID: &userId})
 	require.NoError(t, err)
-	require.NotEmpty(t, shortCode1)
+	require.NotEmpty(t, created1.ShortCode)
 
-	shortCode2, err := repo.FindOrCreateShortCode(ctx, longURL2, 1000, &userId)
+	created2, err := repo.FindOrCreateShortCode(ctx, dest(longURL2), Owner{UserID: &userId})
 	require.NoError(t, err)
-	require.NotEmpty(t, shortCode2)
+	require.NotEmpty(t, created2.ShortCode)
 
-	urls, err := repo.GetByUserID_Bulk(ctx, userId)
+	urls, err := repo.ListHistory(ctx, HistoryFilter{UserID: userId, Limit: 10})
 	require.NoError(t, err)
 	assert.Equal(t, 2, len(urls))
 }
 
 func TestRepository_Shortening_Bulk(t *testing.T) {
 	db, ctx := migrations.SetupTestDB(t)
-	repo := NewRepository(db)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
 
 	t.Cleanup(func() {
 		require.NoError(t, db.Close())
@@ -96,12 +114,12 @@ func TestRepository_Shortening_Bulk(t *testing.T) {
 	longURL := "https://www.google.com/search?q=golang-testing"
 	longURL2 := "https://www.google.com/search?q=golang-testing-2"
 
-	result, err := repo.FindOrCreateShortCode_Bulk(ctx, []string{longURL, longURL2}, 1000, nil)
+	result, err := repo.FindOrCreateShortCode_Bulk(ctx, dests(longURL, longURL2), Owner{})
 	require.NoError(t, err)
 	assert.Equal(t, 2, len(result))
 
 	longURL3 := "https://www.google.com/search?q=golang-testing-"
-	result, err = repo.FindOrCreateShortCode_Bulk(ctx, []string{longURL3}, 1000, nil)
+	result, err = repo.FindOrCreateShortCode_Bulk(ctx, dests(longURL3), Owner{})
 
 	require.NoError(t, err)
 	assert.Equal(t, 1, len(result))
@@ -109,9 +127,53 @@ func TestRepository_Shortening_Bulk(t *testing.T) {
 	t.Log(result)
 }
 
+func TestRepository_Shortening_BulkChunks(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	userRepo := user.NewRepository(db)
+	require.NoError(t, userRepo.Insert(ctx, "bulk@mail.com", "hash"))
+	u, err := userRepo.GetByEmail(ctx, "bulk@mail.com")
+	require.NoError(t, err)
+	userID := int64(u.Id)
+	owner := Owner{UserID: &userID}
+
+	existing, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/0"), owner)
+	require.NoError(t, err)
+
+	// Spans several chunks, more than the Postgres parameter limit allows in
+	// one statement, and repeats destinations across chunk boundaries.
+	longURLs := make([]string, 2*bulkChunkSize+bulkChunkSize/2)
+	for i := range longURLs {
+		longURLs[i] = fmt.Sprintf("https://example.com/%d", i%(bulkChunkSize+10))
+	}
+
+	result, err := repo.FindOrCreateShortCode_Bulk(ctx, dests(longURLs...), owner)
+	require.NoError(t, err)
+	require.Len(t, result, len(longURLs))
+
+	codes := map[string]string{}
+	for i, r := range result {
+		require.NotEmpty(t, r.ShortCode, i)
+		if code, ok := codes[r.LongURL]; ok {
+			assert.Equal(t, code, r.ShortCode, "repeated destinations share a link")
+		}
+		codes[r.LongURL] = r.ShortCode
+	}
+	assert.Equal(t, existing.ShortCode, codes["https://example.com/0"])
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: codes["https://example.com/1"]})
+	require.NoError(t, err)
+	assert.Equal(t, sql.NullInt64{Int64: userID, Valid: true}, link.UserID, "links keep the caller as owner")
+}
+
 func TestRepository_Shortening_Race(t *testing.T) {
 	db, ctx := migrations.SetupTestDB(t)
-	repo := NewRepository(db)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
 
 	t.Cleanup(func() {
 		require.NoError(t, db.Close())
@@ -125,7 +187,7 @@ func TestRepository_Shortening_Race(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			for j := 0; j < 100; j++ {
-				_, err := repo.FindOrCreateShortCode(ctx, "https://www.google.com/search?q=golang-testing", 1000, nil)
+				_, err := repo.FindOrCreateShortCode(ctx, dest("https://www.google.com/search?q=golang-testing"), Owner{})
 				require.NoError(t, err)
 			}
 		}()
@@ -134,23 +196,23 @@ func TestRepository_Shortening_Race(t *testing.T) {
 	wg.Wait()
 }
 
-func TestRepository_GetByUserID_Bulk_ScanError(t *testing.T) {
+func TestRepository_ListHistory_Empty(t *testing.T) {
 	db, ctx := migrations.SetupTestDB(t)
-	repo := NewRepository(db)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
 
 	t.Cleanup(func() {
 		require.NoError(t, db.Close())
 	})
 
 	// Test with non-existent user ID - should return empty slice
-	urls, err := repo.GetByUserID_Bulk(ctx, 999)
+	urls, err := repo.ListHistory(ctx, HistoryFilter{UserID: 999, Limit: 10})
 	require.NoError(t, err)
 	assert.Equal(t, 0, len(urls))
 }
 
 func TestRepository_GetByID_NotFound(t *testing.T) {
 	db, ctx := migrations.SetupTestDB(t)
-	repo := NewRepository(db)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
 
 	t.Cleanup(func() {
 		require.NoError(t, db.Close())
@@ -160,3 +222,505 @@ func TestRepository_GetByID_NotFound(t *testing.T) {
 	_, err := repo.GetByID(ctx, 999)
 	require.Error(t, err)
 }
+
+func TestRepository_WorkspaceScope(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	userRepo := user.NewRepository(db)
+	require.NoError(t, userRepo.Insert(ctx, "member@mail.com", "hash"))
+	member, err := userRepo.GetByEmail(ctx, "member@mail.com")
+	require.NoError(t, err)
+	userID := int64(member.Id)
+
+	team, err := workspace.NewRepository(db).Create(ctx, "Marketing", userID)
+	require.NoError(t, err)
+
+	personalCode, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/personal"), Owner{UserID: &userID})
+	require.NoError(t, err)
+
+	teamCode, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/team"), Owner{UserID: &userID, WorkspaceID: &team.ID})
+	require.NoError(t, err)
+
+	personal, err := repo.ListHistory(ctx, HistoryFilter{UserID: userID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, personal, 1)
+	assert.Equal(t, personalCode.ShortCode, personal[0].ShortCode.String)
+
+	shared, err := repo.ListHistory(ctx, HistoryFilter{UserID: userID, WorkspaceID: &team.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, shared, 1)
+	assert.Equal(t, teamCode.ShortCode, shared[0].ShortCode.String)
+	assert.Equal(t, team.ID, shared[0].WorkspaceID.Int64)
+
+	require.NoError(t, repo.Update(ctx, shared[0].ID, LinkChanges{Status: ptr(StatusDisabled)}))
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: teamCode.ShortCode})
+	require.NoError(t, err)
+	assert.Equal(t, StatusDisabled, link.Status)
+
+	require.NoError(t, repo.Delete(ctx, link.ID))
+	_, err = repo.GetByLink(ctx, LinkRef{ShortCode: teamCode.ShortCode})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestRepository_DedupSkipsInactiveLinks(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	disabled, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/disabled"), Owner{})
+	require.NoError(t, err)
+	require.NoError(t, repo.Update(ctx, disabled.ID, LinkChanges{Status: ptr(StatusDisabled)}))
+
+	flagged, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/flagged"), Owner{})
+	require.NoError(t, err)
+	require.NoError(t, repo.Flag(ctx, flagged.ID, "malware"))
+
+	again, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/disabled"), Owner{})
+	require.NoError(t, err)
+	assert.NotEqual(t, disabled, again, "a disabled link is not handed out again")
+
+	bulk, err := repo.FindOrCreateShortCode_Bulk(ctx, []Destination{dest("https://example.com/flagged")}, Owner{})
+	require.NoError(t, err)
+	assert.NotEqual(t, flagged.ShortCode, bulk[0].ShortCode, "a flagged link is not handed out again")
+
+	require.NoError(t, repo.Update(ctx, disabled.ID, LinkChanges{Status: ptr(StatusActive)}))
+	reenabled, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/disabled"), Owner{})
+	require.NoError(t, err)
+	assert.Equal(t, again, reenabled, "re-enabling does not collide with the link that replaced it")
+}
+
+func TestRepository_Aliases(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	first, err := repo.CreateShortCode(ctx, dest("https://example.com/first"), Owner{})
+	require.NoError(t, err)
+
+	// The alias row takes the next ID, so the alias is the code of the row
+	// after it.
+	next := toBase62(uint64(first.ID) + 2 + 1000)
+	aliased := dest("https://example.com/aliased")
+	aliased.Options.Alias = next
+	alias, err := repo.CreateShortCode(ctx, aliased, Owner{})
+	require.NoError(t, err)
+	assert.Equal(t, next, alias.ShortCode)
+
+	_, err = repo.CreateShortCode(ctx, aliased, Owner{})
+	assert.IsType(t, AliasTaken, err)
+
+	generated, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/generated"), Owner{})
+	require.NoError(t, err)
+	assert.NotEqual(t, next, generated.ShortCode, "generated codes skip aliases")
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: next})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/aliased", link.LongURL)
+
+	bulkNext := toBase62(uint64(generated.ID) + 2 + 1000)
+	aliased.Options.Alias = bulkNext
+	_, err = repo.CreateShortCode(ctx, aliased, Owner{})
+	require.NoError(t, err)
+
+	bulk, err := repo.FindOrCreateShortCode_Bulk(ctx, []Destination{dest("https://example.com/bulk")}, Owner{})
+	require.NoError(t, err)
+	assert.NotEqual(t, bulkNext, bulk[0].ShortCode, "bulk generated codes skip aliases")
+
+	link, err = repo.GetByLink(ctx, LinkRef{ShortCode: bulk[0].ShortCode})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/bulk", link.LongURL)
+}
+
+func TestRepository_DomainScope(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	var domainID int64
+	err := db.QueryRowContext(ctx, `INSERT INTO domains (hostname, verification_token, verified_at) VALUES ('go.acme.com', 'token', NOW()) RETURNING id`).Scan(&domainID)
+	require.NoError(t, err)
+
+	onDomain := dest("https://example.com/acme")
+	onDomain.DomainID = &domainID
+	onDomain.Options.RedirectType = DefaultRedirectType
+
+	created, err := repo.CreateShortCode(ctx, onDomain, Owner{})
+	require.NoError(t, err)
+
+	link, err := repo.GetByLink(ctx, LinkRef{DomainID: domainID, ShortCode: created.ShortCode})
+	require.NoError(t, err)
+	assert.Equal(t, domainID, link.DomainID.Int64)
+
+	_, err = repo.GetByLink(ctx, LinkRef{ShortCode: created.ShortCode})
+	assert.ErrorIs(t, err, sql.ErrNoRows, "links on a custom domain do not resolve on the default one")
+}
+
+func TestRepository_PerOwnerDedup(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	userRepo := user.NewRepository(db)
+	ids := make([]int64, 2)
+	for i, email := range []string{"first@mail.com", "second@mail.com"} {
+		require.NoError(t, userRepo.Insert(ctx, email, "hash"))
+		u, err := userRepo.GetByEmail(ctx, email)
+		require.NoError(t, err)
+		ids[i] = int64(u.Id)
+	}
+
+	longURL := "https://example.com/shared-destination"
+
+	firstCode, err := repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{UserID: &ids[0]})
+	require.NoError(t, err)
+
+	secondCode, err := repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{UserID: &ids[1]})
+	require.NoError(t, err)
+	assert.NotEqual(t, firstCode.ShortCode, secondCode.ShortCode, "another user's link must not be returned")
+
+	again, err := repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{UserID: &ids[0]})
+	require.NoError(t, err)
+	assert.Equal(t, firstCode.ShortCode, again.ShortCode)
+
+	fresh, err := repo.CreateShortCode(ctx, dest(longURL), Owner{UserID: &ids[0]})
+	require.NoError(t, err)
+	assert.NotEqual(t, firstCode.ShortCode, fresh.ShortCode)
+
+	again, err = repo.FindOrCreateShortCode(ctx, dest(longURL), Owner{UserID: &ids[0]})
+	require.NoError(t, err)
+	assert.Equal(t, firstCode.ShortCode, again.ShortCode, "force-new links are never reused")
+
+	bulk, err := repo.FindOrCreateShortCode_Bulk(ctx, dests(longURL, "https://example.com/other"), Owner{UserID: &ids[1]})
+	require.NoError(t, err)
+	require.Len(t, bulk, 2)
+	codes := map[string]string{}
+	for _, r := range bulk {
+		codes[r.LongURL] = r.ShortCode
+	}
+	assert.Equal(t, secondCode.ShortCode, codes[longURL])
+
+	freshBulk, err := repo.CreateShortCode_Bulk(ctx, dests(longURL, longURL), Owner{UserID: &ids[1]})
+	require.NoError(t, err)
+	require.Len(t, freshBulk, 2)
+	assert.NotEqual(t, freshBulk[0].ShortCode, freshBulk[1].ShortCode)
+}
+
+func TestRepository_CanonicalDedup(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	first := Destination{LongURL: "https://Example.com/a?b=1&a=2", CanonicalURL: "https://example.com/a?a=2&b=1"}
+	second := Destination{LongURL: "https://example.com:443/a?a=2&b=1", CanonicalURL: "https://example.com/a?a=2&b=1"}
+
+	firstCode, err := repo.FindOrCreateShortCode(ctx, first, Owner{})
+	require.NoError(t, err)
+
+	secondCode, err := repo.FindOrCreateShortCode(ctx, second, Owner{})
+	require.NoError(t, err)
+	assert.Equal(t, firstCode.ShortCode, secondCode.ShortCode)
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: firstCode.ShortCode})
+	require.NoError(t, err)
+	assert.Equal(t, first.LongURL, link.LongURL, "the first spelling is kept for redirects")
+
+	bulk, err := repo.FindOrCreateShortCode_Bulk(ctx, []Destination{second, first}, Owner{})
+	require.NoError(t, err)
+	require.Len(t, bulk, 2)
+	assert.Equal(t, second.LongURL, bulk[0].LongURL)
+	assert.Equal(t, firstCode.ShortCode, bulk[0].ShortCode)
+	assert.Equal(t, firstCode.ShortCode, bulk[1].ShortCode)
+}
+
+func TestRepository_SwitchCodeGenerator(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	sequential := NewRepository(db, NewSequentialGenerator(1000))
+	oldCode, err := sequential.FindOrCreateShortCode(ctx, dest("https://example.com/old"), Owner{})
+	require.NoError(t, err)
+
+	feistel, err := NewFeistelGenerator("secret", base62Chars, 8)
+	require.NoError(t, err)
+	repo := NewRepository(db, feistel)
+
+	newCode, err := repo.FindOrCreateShortCode(ctx, dest("https://example.com/new"), Owner{})
+	require.NoError(t, err)
+	assert.Len(t, newCode.ShortCode, 8)
+
+	for code, longURL := range map[string]string{oldCode.ShortCode: "https://example.com/old", newCode.ShortCode: "https://example.com/new"} {
+		link, err := repo.GetByLink(ctx, LinkRef{ShortCode: code})
+		require.NoError(t, err)
+		assert.Equal(t, longURL, link.LongURL)
+	}
+}
+
+func TestRepository_ListHistory_Keyset(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	userRepo := user.NewRepository(db)
+	require.NoError(t, userRepo.Insert(ctx, "history@mail.com", "hash"))
+	owner, err := userRepo.GetByEmail(ctx, "history@mail.com")
+	require.NoError(t, err)
+	userID := int64(owner.Id)
+
+	// Two links share a timestamp so the id tie-breaker is exercised.
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := []time.Time{base, base.Add(time.Hour), base.Add(time.Hour), base.Add(2 * time.Hour), base.Add(3 * time.Hour)}
+	for i, at := range createdAt {
+		_, err := db.ExecContext(ctx, `INSERT INTO urls (short_code, long_url, user_id, created_at, status) VALUES ($1, $2, $3, $4, $5)`,
+			fmt.Sprintf("code%d", i), fmt.Sprintf("https://example.com/page_%d", i), userID, at, StatusActive)
+		require.NoError(t, err)
+	}
+	_, err = db.ExecContext(ctx, `UPDATE urls SET status = $1 WHERE short_code = 'code4'`, StatusDisabled)
+	require.NoError(t, err)
+
+	var seen []string
+	filter := HistoryFilter{UserID: userID, Limit: 2}
+	for {
+		page, err := repo.ListHistory(ctx, filter)
+		require.NoError(t, err)
+		for _, link := range page {
+			seen = append(seen, link.ShortCode.String)
+		}
+		if len(page) < filter.Limit {
+			break
+		}
+		last := page[len(page)-1]
+		filter.After = &HistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+	assert.Equal(t, []string{"code4", "code3", "code2", "code1", "code0"}, seen)
+
+	oldest, err := repo.ListHistory(ctx, HistoryFilter{UserID: userID, Ascending: true, Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, oldest, 2)
+	assert.Equal(t, "code0", oldest[0].ShortCode.String)
+
+	total, err := repo.CountHistory(ctx, HistoryFilter{UserID: userID, Status: StatusActive})
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+
+	// "_" must match literally, not as a single-character wildcard.
+	total, err = repo.CountHistory(ctx, HistoryFilter{UserID: userID, Search: "page_3"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	total, err = repo.CountHistory(ctx, HistoryFilter{UserID: userID, Search: "CODE1"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+
+	from, to := base.Add(time.Hour), base.Add(3*time.Hour)
+	total, err = repo.CountHistory(ctx, HistoryFilter{UserID: userID, From: &from, To: &to})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+}
+
+func TestRepository_LinkDetails(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	userRepo := user.NewRepository(db)
+	require.NoError(t, userRepo.Insert(ctx, "details@mail.com", "hash"))
+	owner, err := userRepo.GetByEmail(ctx, "details@mail.com")
+	require.NoError(t, err)
+	userID := int64(owner.Id)
+	ownerScope := Owner{UserID: &userID}
+
+	launch := dest("https://blog.example.com/posts/launch")
+	launch.Options.Details = LinkDetails{Title: "Quarterly launch", Description: "Announcement", Tags: []string{"blog", "q3"}}
+	launchCode, err := repo.CreateShortCode(ctx, launch, ownerScope)
+	require.NoError(t, err)
+
+	pricing := dest("https://shop.example.org/pricing")
+	pricing.Options.Details = LinkDetails{Tags: []string{"q3"}}
+	_, err = repo.CreateShortCode(ctx, pricing, ownerScope)
+	require.NoError(t, err)
+
+	_, err = repo.FindOrCreateShortCode(ctx, dest("https://docs.example.net/guide"), ownerScope)
+	require.NoError(t, err)
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: launchCode.ShortCode})
+	require.NoError(t, err)
+	assert.Equal(t, "Quarterly launch", link.Title.String)
+	assert.Equal(t, "Announcement", link.Description.String)
+
+	tags, err := repo.ListTags(ctx, []int64{link.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"blog", "q3"}, tags[link.ID])
+
+	count := func(filter HistoryFilter) int {
+		filter.UserID = userID
+		total, err := repo.CountHistory(ctx, filter)
+		require.NoError(t, err)
+		return total
+	}
+
+	assert.Equal(t, 2, count(HistoryFilter{Tags: []string{"q3"}}))
+	assert.Equal(t, 1, count(HistoryFilter{Tags: []string{"q3", "blog"}}))
+	assert.Equal(t, 0, count(HistoryFilter{Tags: []string{"missing"}}))
+	assert.Equal(t, 1, count(HistoryFilter{Search: "quarter"}), "title words match by prefix")
+	assert.Equal(t, 1, count(HistoryFilter{Search: "announcement"}))
+	assert.Equal(t, 1, count(HistoryFilter{Search: "shop pricing"}), "destination words")
+	assert.Equal(t, 3, count(HistoryFilter{Search: "example"}))
+	assert.Equal(t, 1, count(HistoryFilter{Search: "ricing"}), "destinations still match by substring")
+
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{Details: &LinkDetails{Title: "Renamed", Tags: []string{"archive"}}}))
+
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", link.Title.String)
+	assert.Equal(t, "", link.Description.String)
+	assert.Equal(t, 0, count(HistoryFilter{Search: "quarterly"}))
+	assert.Equal(t, 1, count(HistoryFilter{Tags: []string{"archive"}}))
+
+	og := OpenGraph{Title: "Card title", ImageURL: "https://cdn.example.com/card.png"}
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{Details: &LinkDetails{OpenGraph: og}}))
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Card title", link.OGTitle.String)
+	assert.Equal(t, "https://cdn.example.com/card.png", link.OGImageURL.String)
+	assert.Equal(t, "", link.Title.String)
+
+	_, err = repo.GetMetadata(ctx, link.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	_, err = db.ExecContext(ctx, `INSERT INTO link_metadata (url_id, title, favicon_url) VALUES ($1, 'Page', 'https://blog.example.com/favicon.ico')`, link.ID)
+	require.NoError(t, err)
+	meta, err := repo.GetMetadata(ctx, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, &LinkMetadata{Title: "Page", FaviconURL: "https://blog.example.com/favicon.ico"}, meta)
+
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{Details: &LinkDetails{}}))
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.False(t, link.Title.Valid)
+	assert.False(t, link.OGTitle.Valid)
+}
+
+func TestRepository_Password(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	protected := dest("https://example.com/private")
+	protected.PasswordHash = "hash"
+	created, err := repo.CreateShortCode(ctx, protected, Owner{})
+	require.NoError(t, err)
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: created.ShortCode})
+	require.NoError(t, err)
+	assert.True(t, link.Protected)
+	assert.Equal(t, "hash", link.PasswordHash.String)
+
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{PasswordHash: ptr("")}))
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.False(t, link.Protected)
+	assert.False(t, link.PasswordHash.Valid)
+}
+
+func TestRepository_RoutingRulesAndVariants(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	rules := []RoutingRule{
+		{Countries: []string{"ID", "MY"}, URL: "https://example.com/asia"},
+		{Devices: []string{"Mobile"}, OS: []string{"iOS"}, URL: "https://apps.apple.com/app/id1"},
+	}
+	variants := []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 70},
+		{Name: "b", URL: "https://example.com/b", Weight: 30},
+	}
+	routed := dest("https://example.com")
+	routed.Options.RoutingRules = rules
+	routed.Options.Variants = variants
+	created, err := repo.CreateShortCode(ctx, routed, Owner{})
+	require.NoError(t, err)
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: created.ShortCode})
+	require.NoError(t, err)
+	assert.Equal(t, rules, link.RoutingRules)
+	assert.Equal(t, variants, link.Variants)
+
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{RoutingRules: ptr(rules[1:])}))
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.Equal(t, rules[1:], link.RoutingRules)
+
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{RoutingRules: &[]RoutingRule{}, Variants: &[]Variant{}, ForwardQuery: ptr(true)}))
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.Nil(t, link.RoutingRules)
+	assert.Nil(t, link.Variants)
+	assert.True(t, link.ForwardQuery, "changes made together are all applied")
+}
+
+func TestRepository_ForwardQuery(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db, NewSequentialGenerator(1000))
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	forwarding := dest("https://example.com")
+	forwarding.Options.ForwardQuery = true
+	created, err := repo.CreateShortCode(ctx, forwarding, Owner{})
+	require.NoError(t, err)
+
+	link, err := repo.GetByLink(ctx, LinkRef{ShortCode: created.ShortCode})
+	require.NoError(t, err)
+	assert.True(t, link.ForwardQuery)
+
+	require.NoError(t, repo.Update(ctx, link.ID, LinkChanges{ForwardQuery: ptr(false)}))
+	link, err = repo.GetByID(ctx, link.ID)
+	require.NoError(t, err)
+	assert.False(t, link.ForwardQuery)
+}
+
+func TestPrefixTSQuery(t *testing.T) {
+	assert.Equal(t, "", prefixTSQuery("  -- "))
+	assert.Equal(t, "launch:*", prefixTSQuery("Launch"))
+	assert.Equal(t, "page:* & 3:*", prefixTSQuery("page_3"))
+	assert.Equal(t, "example:* & com:* & été:*", prefixTSQuery("example.com/Été"))
+	assert.Equal(t, "it:* & s:*", prefixTSQuery("it's & | !"))
+}
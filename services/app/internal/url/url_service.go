@@ -2,120 +2,633 @@ package url
 
 import (
 	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/domain"
+	"hafiztri123/app-link-shortener/internal/metrics"
+	"hafiztri123/app-link-shortener/internal/safety"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"hpj/hv1-link-shortener/shared/models"
 	"log/slog"
+	neturl "net/url"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/skip2/go-qrcode"
+	"golang.org/x/sync/singleflight"
 )
 
+// Statuses of the items of a bulk shortening.
+const (
+	BulkItemCreated = "created"
+	BulkItemFailed  = "failed"
+)
+
+// Codes of bulk items the service rejects.
+const (
+	BulkInvalidURL        = "invalid_url"
+	BulkUnsafeDestination = "unsafe_destination"
+	BulkInvalidAlias      = "invalid_alias"
+	BulkAliasTaken        = "alias_taken"
+)
+
+// CreatedLink is a link the repository found or created for a destination.
+type CreatedLink struct {
+	ID        int64
+	ShortCode string
+}
+
 type CreateShortCodeBulkResult struct {
+	// ID is the row of the link, for work that follows its creation.
+	ID        int64  `json:"-"`
 	LongURL   string `json:"long_url"`
-	ShortCode string `json:"short_code"`
+	ShortCode string `json:"short_code,omitempty"`
+	ShortURL  string `json:"short_url,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+}
+
+// BulkFailure is the result of a bulk item that was not shortened.
+func BulkFailure(longURL string, code string, err error) CreateShortCodeBulkResult {
+	return CreateShortCodeBulkResult{LongURL: longURL, Status: BulkItemFailed, Error: err.Error(), Code: code}
 }
 
 type URLService interface {
-	CreateShortCode(context.Context, string) (string, error)
-	CreateShortCode_Bulk(context.Context, []string) ([]CreateShortCodeBulkResult, error)
-	FetchLongURL(context.Context, string) (string, error)
-	FetchUserURLHistory(context.Context, int64) ([]*URL, error)
+	CreateShortCode(context.Context, string, bool, LinkOptions) (string, error)
+	CreateShortCode_Bulk(context.Context, []string, bool) ([]CreateShortCodeBulkResult, error)
+	ImportLinks(context.Context, []models.LinkImportRow) ([]CreateShortCodeBulkResult, error)
+	ExportLinks(context.Context, int64, func([]ExportRow) error) error
+	FetchRedirect(context.Context, string, string) (*Redirect, error)
+	FetchPreview(context.Context, string, string) (*Preview, error)
+	FetchUserURLHistory(context.Context, int64, HistoryQuery) (*HistoryPage, error)
 	GenerateQRCode(string) ([]byte, error)
+	InvalidateCache(context.Context, []LinkRef) error
+	UpdateLink(context.Context, string, string, UpdateURLRequest) error
+	UnlockLink(context.Context, string, string, string) (string, error)
+	DeleteLink(context.Context, string, string) (LinkRef, error)
+}
+
+// DomainResolver finds the verified custom domain serving a hostname.
+type DomainResolver interface {
+	Resolve(ctx context.Context, hostname string) (*domain.Domain, error)
+}
+
+// ClickCounter reports how often links were opened.
+type ClickCounter interface {
+	ClickTotals(ctx context.Context, links []LinkRef) (map[LinkRef]int64, error)
+}
+
+// MetadataQueue hands new links to the worker, which fetches the title,
+// description and images of their destination.
+type MetadataQueue interface {
+	PublishLinkMetadata(ctx context.Context, fetch *models.LinkMetadataFetch) error
 }
 
 type Service struct {
-	repo     URLRepository
-	redis    *redis.Client
-	idOffset uint64
+	repo          URLRepository
+	redis         *redis.Client
+	codes         CodeGenerator
+	local         *LocalCache
+	canonicalizer *Canonicalizer
+	domains       DomainResolver
+	checker       safety.DestinationChecker
+	clicks        ClickCounter
+	metadata      MetadataQueue
+	fills         singleflight.Group
 }
 
-func NewService(repo URLRepository, redis *redis.Client, idOffset uint64) *Service {
-	return &Service{repo: repo, redis: redis, idOffset: idOffset}
+// NewService wires a Service. local may be nil to look links up in Redis
+// only, domains nil to serve links on the default domain only, checker nil
+// to accept every destination, clicks nil to export links without click
+// totals, and metadata nil to never fetch destination metadata.
+func NewService(repo URLRepository, redis *redis.Client, codes CodeGenerator, local *LocalCache, canonicalizer *Canonicalizer, domains DomainResolver, checker safety.DestinationChecker, clicks ClickCounter, metadata MetadataQueue) *Service {
+	return &Service{repo: repo, redis: redis, codes: codes, local: local, canonicalizer: canonicalizer, domains: domains, checker: checker, clicks: clicks, metadata: metadata}
 }
 
-func (s *Service) CreateShortCode(ctx context.Context, longURL string) (string, error) {
-	user, _ := auth.GetUserFromContext(ctx)
+// CreateShortCode returns the caller's existing link to longURL, or a new one
+// when they have none. With forceNew, or options other than the defaults, a
+// distinct link is always created.
+func (s *Service) CreateShortCode(ctx context.Context, longURL string, forceNew bool, opts LinkOptions) (string, error) {
+	owner, err := ownerFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	dest, err := s.destination(longURL, opts)
+	if err != nil {
+		return "", err
+	}
+
+	// Aliases are checked like the codes of incoming redirects, so every
+	// alias accepted here resolves.
+	if opts.Alias != "" {
+		if _, err := s.codes.Decode(opts.Alias); err != nil {
+			return "", &InvalidAliasErr{alias: opts.Alias}
+		}
+	}
+
+	if err := s.checkDestinations(ctx, []Destination{dest}); err != nil {
+		return "", err
+	}
 
-	var userID *int64
-	if user != nil {
-		userID = &user.UserID
+	if opts.Domain != "" {
+		if dest.DomainID, err = s.linkDomain(ctx, opts.Domain, owner); err != nil {
+			return "", err
+		}
 	}
 
-	shortCode, err := s.repo.FindOrCreateShortCode(ctx, longURL, s.idOffset, userID)
+	var created CreatedLink
+	if forceNew || !dest.Options.isDefault() {
+		created, err = s.repo.CreateShortCode(ctx, dest, owner)
+	} else {
+		created, err = s.repo.FindOrCreateShortCode(ctx, dest, owner)
+	}
 	if err != nil {
 		slog.Error("Failed to find or create short code", "error", err, "url", longURL)
 		return "", err
 	}
 
-	return shortCode, nil
+	// A lookup may have cached the code as missing before the link existed.
+	link := LinkRef{ShortCode: created.ShortCode}
+	if dest.DomainID != nil {
+		link.DomainID = *dest.DomainID
+	}
+	s.InvalidateCache(ctx, []LinkRef{link})
+	s.requestMetadata([]CreateShortCodeBulkResult{{ID: created.ID, LongURL: dest.LongURL, ShortCode: created.ShortCode}})
+
+	return created.ShortCode, nil
+}
+
+// requestMetadata queues the fetch of the destination metadata of created
+// links. It does not wait for the queue, so links are created even when it
+// is down; the worker skips links it fetched recently.
+func (s *Service) requestMetadata(created []CreateShortCodeBulkResult) {
+	if s.metadata == nil || len(created) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	fetches := make([]*models.LinkMetadataFetch, len(created))
+	for i, result := range created {
+		fetches[i] = &models.LinkMetadataFetch{URLID: result.ID, LongURL: result.LongURL, Timestamp: now}
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		for _, fetch := range fetches {
+			if err := s.metadata.PublishLinkMetadata(ctx, fetch); err != nil {
+				slog.Error("failed to publish link metadata fetch", "error", err, "url_id", fetch.URLID)
+				return
+			}
+		}
+	}()
+}
+
+// linkDomain returns the ID of the verified domain named hostname, provided
+// owner may create links on it.
+func (s *Service) linkDomain(ctx context.Context, hostname string, owner Owner) (*int64, error) {
+	if s.domains == nil || owner.UserID == nil {
+		return nil, &InvalidRequestErr{reason: fmt.Sprintf("Unknown domain %q", hostname)}
+	}
+
+	d, err := s.domains.Resolve(ctx, hostname)
+	if err != nil {
+		var notFoundErr *domain.DomainNotFoundErr
+		if errors.As(err, &notFoundErr) {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("Unknown domain %q", hostname)}
+		}
+		return nil, err
+	}
+
+	if !(domain.Owner{UserID: *owner.UserID, WorkspaceID: owner.WorkspaceID}).Owns(d) {
+		return nil, &InvalidRequestErr{reason: fmt.Sprintf("Unknown domain %q", hostname)}
+	}
+
+	return &d.ID, nil
+}
+
+// linkRef identifies the link served at shortCode on hostname, where an
+// empty hostname is the default domain. Hostnames that serve no links yield
+// sql.ErrNoRows, like unknown codes.
+func (s *Service) linkRef(ctx context.Context, hostname string, shortCode string) (LinkRef, error) {
+	if hostname == "" {
+		return LinkRef{ShortCode: shortCode}, nil
+	}
+
+	if s.domains == nil {
+		return LinkRef{}, sql.ErrNoRows
+	}
+
+	d, err := s.domains.Resolve(ctx, hostname)
+	if err != nil {
+		var notFoundErr *domain.DomainNotFoundErr
+		if errors.As(err, &notFoundErr) {
+			return LinkRef{}, sql.ErrNoRows
+		}
+		return LinkRef{}, err
+	}
+
+	return LinkRef{DomainID: d.ID, ShortCode: shortCode}, nil
+}
+
+const (
+	urlCacheTTL      = 1 * time.Hour
+	negativeCacheTTL = 30 * time.Second
+	fillLockTTL      = 10 * time.Second
+	fillWaitTimeout  = 2 * time.Second
+
+	// invalidationChannel carries the short codes of changed links to every
+	// instance, so each can drop them from its local cache.
+	invalidationChannel = "url:invalidate"
+
+	// missingURL is cached for codes that have no link, so repeated lookups
+	// of nonexistent codes stay off the database. It cannot be a real URL.
+	missingURL = "\x00"
+)
+
+// releaseLockScript deletes a fill lock only while it still holds the
+// caller's token, so a request whose lock expired cannot drop another's.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// FetchRedirect resolves a short code on hostname, or on the default domain
+// when hostname is empty. Codes are looked up rather than decoded, so they
+// keep resolving whichever generator issued them. Codes no generator could
+// have issued are rejected before the cache or the database is consulted.
+func (s *Service) FetchRedirect(ctx context.Context, hostname string, shortCode string) (*Redirect, error) {
+	if _, err := s.codes.Decode(shortCode); err != nil {
+		return nil, err
+	}
+
+	link, err := s.linkRef(ctx, hostname, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	redirect, err := s.lookupRedirect(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cached entries can outlive the link by up to the cache TTL.
+	if redirect.expired(time.Now()) {
+		return nil, &LinkExpiredErr{shortCode: link.ShortCode}
+	}
+
+	// Cached redirects are shared between callers, so each gets its own copy.
+	found := *redirect
+	found.DomainID = link.DomainID
+	return &found, nil
+}
+
+func (s *Service) lookupRedirect(ctx context.Context, link LinkRef) (*Redirect, error) {
+	if cached, ok := s.local.Get(link.key()); ok {
+		redirect, _, err := fromCacheValue(cached)
+		return redirect, err
+	}
+
+	if redirect, ok, err := s.cachedRedirect(ctx, link); ok {
+		return redirect, err
+	}
+
+	// Concurrent misses for the same link in this process share one fill. It
+	// must not be cut short by whichever caller happened to start it.
+	redirect, err, _ := s.fills.Do(link.key(), func() (any, error) {
+		return s.fillCache(context.WithoutCancel(ctx), link)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return redirect.(*Redirect), nil
+}
+
+// cachedRedirect reports whether Redis holds an answer for link, copying it
+// into the local cache. A cached miss is returned as sql.ErrNoRows.
+func (s *Service) cachedRedirect(ctx context.Context, link LinkRef) (*Redirect, bool, error) {
+	cacheKey := "url:" + link.key()
+
+	cached, err := s.redis.Get(ctx, cacheKey).Result()
+	if err != nil {
+		if err != redis.Nil {
+			slog.Warn("Cache is missing", "error", err, "key", cacheKey)
+		}
+		metrics.RecordCacheLookup("redis", false)
+		return nil, false, nil
+	}
+
+	redirect, ok, err := fromCacheValue(cached)
+	metrics.RecordCacheLookup("redis", ok)
+	if ok {
+		s.local.Set(link.key(), cached)
+	}
+
+	return redirect, ok, err
+}
+
+// fromCacheValue decodes a cache entry. It reports false for values that do
+// not answer the lookup.
+func fromCacheValue(value string) (*Redirect, bool, error) {
+	switch {
+	case value == "":
+		return nil, false, nil
+	case value == missingURL:
+		return nil, true, sql.ErrNoRows
+	case !strings.HasPrefix(value, "{"):
+		// Entries written before links had redirect settings hold just the
+		// destination.
+		return &Redirect{LongURL: value, Type: DefaultRedirectType}, true, nil
+	}
+
+	var redirect Redirect
+	if err := json.Unmarshal([]byte(value), &redirect); err != nil {
+		slog.Warn("Ignoring malformed cache entry", "error", err)
+		return nil, false, nil
+	}
+
+	return &redirect, true, nil
 }
 
-func (s *Service) FetchLongURL(ctx context.Context, shortCode string) (string, error) {
-	id := FromBase62(shortCode) - s.idOffset
+// toCacheValue encodes a redirect for the cache. It returns "" for redirects
+// that cannot be cached.
+func toCacheValue(redirect *Redirect) string {
+	value, err := json.Marshal(redirect)
+	if err != nil {
+		slog.Warn("Failed to encode cache entry", "error", err)
+		return ""
+	}
 
-	cacheKey := "url:" + shortCode
-	lockKey := "lock:" + shortCode
+	return string(value)
+}
 
-	cachedUrl, err := s.redis.Get(ctx, cacheKey).Result()
-	if err == nil {
-		return cachedUrl, nil
+// redirectCacheTTL keeps a redirect cached no longer than the link lives.
+func redirectCacheTTL(redirect *Redirect, now time.Time) time.Duration {
+	if redirect.ExpiresAt != nil {
+		return min(urlCacheTTL, redirect.ExpiresAt.Sub(now))
 	}
 
-	if err != redis.Nil {
-		slog.Warn("Cache is missing", "error", err, "key", cacheKey)
+	return urlCacheTTL
+}
+
+// fillCache loads a link from the database under a lock, so that across
+// instances one request per link reaches Postgres. The others wait for the
+// lock holder to publish the result on the link's fill channel.
+func (s *Service) fillCache(ctx context.Context, link LinkRef) (*Redirect, error) {
+	lockKey := "lock:" + link.key()
+
+	token, err := auth.RandomToken()
+	if err != nil {
+		return nil, err
 	}
 
-	lockAcquired, err := s.redis.SetNX(ctx, lockKey, "1", 10*time.Second).Result()
+	lockAcquired, err := s.redis.SetNX(ctx, lockKey, token, fillLockTTL).Result()
 	if err != nil {
 		slog.Warn("Redis SetNX for lock failed", "error", err, "key", lockKey)
-		return s.getIDFromDatabase(ctx, int64(id))
+		return s.getRedirectFromDatabase(ctx, link)
 	}
 
-	if lockAcquired {
-		defer s.redis.Del(ctx, lockKey)
-		longUrl, err := s.getIDFromDatabase(ctx, int64(id))
-		if err != nil {
-			slog.Error("Database failed", "error", err, "id", id)
-			return "", err
+	if !lockAcquired {
+		if redirect, ok, err := s.waitForFill(ctx, link); ok {
+			return redirect, err
 		}
+		return s.getRedirectFromDatabase(ctx, link)
+	}
 
-		err = s.redis.Set(ctx, cacheKey, longUrl, 1*time.Hour).Err()
+	defer func() {
+		if err := releaseLockScript.Run(ctx, s.redis, []string{lockKey}, token).Err(); err != nil {
+			slog.Warn("Redis failed to release lock", "error", err, "key", lockKey)
+		}
+	}()
 
-		if err != nil {
-			slog.Warn("Redis failed to cache", "error", err, "cacheKey", cacheKey)
+	redirect, err := s.getRedirectFromDatabase(ctx, link)
+	switch {
+	case err == nil:
+		s.publishFill(ctx, link, toCacheValue(redirect), redirectCacheTTL(redirect, time.Now()))
+	case errors.Is(err, sql.ErrNoRows):
+		s.publishFill(ctx, link, missingURL, negativeCacheTTL)
+	default:
+		var disabledErr *LinkDisabledErr
+		var flaggedErr *LinkFlaggedErr
+		var expiredErr *LinkExpiredErr
+		if !errors.As(err, &disabledErr) && !errors.As(err, &flaggedErr) && !errors.As(err, &expiredErr) {
+			slog.Error("Database failed", "error", err, "short_code", link.ShortCode, "domain_id", link.DomainID)
 		}
+		// Nothing cacheable: wake the waiters so they query for themselves
+		// rather than sit out the timeout.
+		s.publishFill(ctx, link, "", 0)
+	}
 
-		return longUrl, nil
+	return redirect, err
+}
+
+// publishFill caches value for ttl, unless it is empty or ttl has run out,
+// and announces it to requests waiting on the link.
+func (s *Service) publishFill(ctx context.Context, link LinkRef, value string, ttl time.Duration) {
+	key := link.key()
+
+	// A zero expiration would make the entry permanent.
+	if value != "" && ttl > 0 {
+		s.local.Set(key, value)
+		if err := s.redis.Set(ctx, "url:"+key, value, ttl).Err(); err != nil {
+			slog.Warn("Redis failed to cache", "error", err, "key", key)
+		}
 	}
 
-	timeout := time.After(2 * time.Second)
-	ticker := time.NewTicker(50 * time.Millisecond)
-	defer ticker.Stop()
+	if err := s.redis.Publish(ctx, "fill:"+key, value).Err(); err != nil {
+		slog.Warn("Redis failed to publish cache fill", "error", err, "key", key)
+	}
+}
 
-	for {
-		select {
-		case <-timeout:
-			return s.getIDFromDatabase(ctx, int64(id))
-		case <-ticker.C:
-			cachedUrl, err := s.redis.Get(ctx, cacheKey).Result()
-			if err == nil {
-				return cachedUrl, nil
-			}
+// waitForFill waits for another request's fill of link. It reports false
+// when there is no answer to use, and the caller should query itself.
+func (s *Service) waitForFill(ctx context.Context, link LinkRef) (*Redirect, bool, error) {
+	sub := s.redis.Subscribe(ctx, "fill:"+link.key())
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil {
+		slog.Warn("Redis failed to subscribe to cache fills", "error", err, "key", link.key())
+		return nil, false, nil
+	}
+
+	// The lock holder may have finished before the subscription was in place.
+	if redirect, ok, err := s.cachedRedirect(ctx, link); ok {
+		return redirect, true, err
+	}
+
+	timeout := time.NewTimer(fillWaitTimeout)
+	defer timeout.Stop()
+
+	select {
+	case msg := <-sub.Channel():
+		redirect, ok, err := fromCacheValue(msg.Payload)
+		if ok {
+			s.local.Set(link.key(), msg.Payload)
+		}
+		return redirect, ok, err
+	case <-timeout.C:
+		return nil, false, nil
+	}
+}
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// FetchUserURLHistory returns one page of the links of the workspace selected
+// for the request, or of the user's personal links when none is selected.
+func (s *Service) FetchUserURLHistory(ctx context.Context, userId int64, query HistoryQuery) (*HistoryPage, error) {
+	filter, err := newHistoryFilter(query)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.UserID = userId
+	if member := workspace.GetMemberFromContext(ctx); member != nil {
+		filter.WorkspaceID = &member.WorkspaceID
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	pageSize := filter.Limit
+	filter.Limit = pageSize + 1
+
+	urls, err := s.repo.ListHistory(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.repo.CountHistory(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &HistoryPage{Data: urls, Total: total}
+
+	if len(urls) > pageSize {
+		page.Data = urls[:pageSize]
+		last := page.Data[pageSize-1]
+		page.NextCursor = encodeHistoryCursor(HistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID, Sort: sortOrDefault(query.Sort)})
+	}
+
+	if err := s.attachTags(ctx, page.Data); err != nil {
+		return nil, err
+	}
+
+	page.Count = len(page.Data)
+
+	return page, nil
+}
+
+func newHistoryFilter(query HistoryQuery) (HistoryFilter, error) {
+	tags, err := normalizeTags(query.Tags)
+	if err != nil {
+		return HistoryFilter{}, err
+	}
+
+	filter := HistoryFilter{
+		Search: strings.TrimSpace(query.Search),
+		Status: query.Status,
+		Tags:   tags,
+		From:   query.From,
+		To:     query.To,
+		Limit:  query.Limit,
+	}
+
+	switch {
+	case filter.Limit == 0:
+		filter.Limit = defaultHistoryLimit
+	case filter.Limit < 0 || filter.Limit > maxHistoryLimit:
+		return filter, &InvalidRequestErr{reason: fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit)}
+	}
+
+	sort := sortOrDefault(query.Sort)
+	switch sort {
+	case SortNewest:
+	case SortOldest:
+		filter.Ascending = true
+	default:
+		return filter, &InvalidRequestErr{reason: "sort must be one of newest or oldest"}
+	}
+
+	if filter.Status != "" && filter.Status != StatusActive && filter.Status != StatusDisabled && filter.Status != StatusFlagged {
+		return filter, &InvalidRequestErr{reason: "status must be one of active, disabled or flagged"}
+	}
+
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, &InvalidRequestErr{reason: "from must be before to"}
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeHistoryCursor(query.Cursor)
+		if err != nil || cursor.Sort != sort {
+			return filter, &InvalidRequestErr{reason: "cursor is invalid"}
 		}
+		filter.After = cursor
+	}
+
+	return filter, nil
+}
+
+// attachTags loads the tags of urls.
+func (s *Service) attachTags(ctx context.Context, urls []*URL) error {
+	if len(urls) == 0 {
+		return nil
 	}
 
+	ids := make([]int64, len(urls))
+	for i, url := range urls {
+		ids[i] = url.ID
+	}
+
+	tags, err := s.repo.ListTags(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		url.Tags = tags[url.ID]
+	}
+
+	return nil
 }
 
-func (s *Service) FetchUserURLHistory(ctx context.Context, userId int64) ([]*URL, error) {
+func sortOrDefault(sort string) string {
+	if sort == "" {
+		return SortNewest
+	}
+	return sort
+}
 
-	urls, err := s.repo.GetByUserID_Bulk(ctx, userId)
+// The cursor is opaque to clients; it only has to survive a round trip.
+func encodeHistoryCursor(cursor HistoryCursor) string {
+	payload, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
 
+func decodeHistoryCursor(encoded string) (*HistoryCursor, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
 	if err != nil {
 		return nil, err
 	}
 
-	return urls, nil
+	var cursor HistoryCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return nil, err
+	}
 
+	return &cursor, nil
 }
 
 func (s *Service) GenerateQRCode(url string) ([]byte, error) {
@@ -129,28 +642,849 @@ func (s *Service) GenerateQRCode(url string) ([]byte, error) {
 	return qrBytes, nil
 }
 
-func (s *Service) CreateShortCode_Bulk(ctx context.Context, longUrl []string) ([]CreateShortCodeBulkResult, error) {
-	user, _ := auth.GetUserFromContext(ctx)
+// CreateShortCode_Bulk shortens each of longUrl, returning one result per
+// URL in the same order. URLs that cannot be shortened are reported as failed
+// items rather than failing the others.
+func (s *Service) CreateShortCode_Bulk(ctx context.Context, longUrl []string, forceNew bool) ([]CreateShortCodeBulkResult, error) {
+	owner, err := ownerFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]CreateShortCodeBulkResult, len(longUrl))
+	var dests []Destination
+	var pending []int
+	for i, u := range longUrl {
+		dest, err := s.destination(u, LinkOptions{})
+		if err != nil {
+			results[i] = BulkFailure(u, BulkInvalidURL, err)
+			continue
+		}
+		dests = append(dests, dest)
+		pending = append(pending, i)
+	}
+
+	findings := s.unsafeDestinations(ctx, dests)
+	safe := dests[:0]
+	accepted := pending[:0]
+	for j, dest := range dests {
+		if finding, ok := findings[dest.LongURL]; ok {
+			results[pending[j]] = BulkFailure(dest.LongURL, BulkUnsafeDestination, &UnsafeDestinationErr{longURL: dest.LongURL, reason: finding.String()})
+			continue
+		}
+		safe = append(safe, dest)
+		accepted = append(accepted, pending[j])
+	}
 
-	var userID *int64
-	if user != nil {
-		userID = &user.UserID
+	if len(safe) == 0 {
+		return results, nil
 	}
 
-	results, err := s.repo.FindOrCreateShortCode_Bulk(ctx, longUrl, s.idOffset, userID)
+	var created []CreateShortCodeBulkResult
+	if forceNew {
+		created, err = s.repo.CreateShortCode_Bulk(ctx, safe, owner)
+	} else {
+		created, err = s.repo.FindOrCreateShortCode_Bulk(ctx, safe, owner)
+	}
 	if err != nil {
 		return nil, err
 	}
+
+	links := make([]LinkRef, len(created))
+	for j, result := range created {
+		results[accepted[j]] = result
+		links[j] = LinkRef{ShortCode: result.ShortCode}
+	}
+	s.InvalidateCache(ctx, links)
+	s.requestMetadata(created)
+
+	return results, nil
+}
+
+// ImportLinks creates a link for each row of an import file, reporting each
+// row on its own like CreateShortCode_Bulk. Rows with only a destination
+// reuse the caller's existing link to it; rows with an alias, a title, a
+// description, tags or an expiry get a link of their own. Aliases become
+// the short code of the link on the default domain.
+func (s *Service) ImportLinks(ctx context.Context, rows []models.LinkImportRow) ([]CreateShortCodeBulkResult, error) {
+	results := make([]CreateShortCodeBulkResult, len(rows))
+	for i, row := range rows {
+		opts := LinkOptions{
+			ExpiresAt: row.ExpiresAt,
+			Details:   LinkDetails{Title: row.Title, Description: row.Description, Tags: row.Tags},
+			Alias:     row.Alias,
+		}
+		shortCode, err := s.CreateShortCode(ctx, row.LongURL, false, opts)
+		var invalidErr *InvalidRequestErr
+		var unsafeErr *UnsafeDestinationErr
+		var invalidAliasErr *InvalidAliasErr
+		var aliasTakenErr *AliasTakenErr
+		switch {
+		case err == nil:
+			results[i] = CreateShortCodeBulkResult{LongURL: row.LongURL, ShortCode: shortCode, Status: BulkItemCreated}
+		case errors.As(err, &invalidErr):
+			results[i] = BulkFailure(row.LongURL, BulkInvalidURL, err)
+		case errors.As(err, &unsafeErr):
+			results[i] = BulkFailure(row.LongURL, BulkUnsafeDestination, err)
+		case errors.As(err, &invalidAliasErr):
+			results[i] = BulkFailure(row.LongURL, BulkInvalidAlias, err)
+		case errors.As(err, &aliasTakenErr):
+			results[i] = BulkFailure(row.LongURL, BulkAliasTaken, err)
+		default:
+			return nil, err
+		}
+	}
+
 	return results, nil
 }
 
-func (s *Service) getIDFromDatabase(ctx context.Context, id int64) (string, error) {
-	url, err := s.repo.GetByID(ctx, id)
+const exportPageSize = 500
+
+// ExportLinks hands every link in the caller's scope to write, oldest first
+// and a page at a time, together with how often each was opened. It stops
+// at the first error of write.
+func (s *Service) ExportLinks(ctx context.Context, userId int64, write func([]ExportRow) error) error {
+	filter := HistoryFilter{UserID: userId, Ascending: true, Limit: exportPageSize}
+	if member := workspace.GetMemberFromContext(ctx); member != nil {
+		filter.WorkspaceID = &member.WorkspaceID
+	}
+
+	for {
+		links, err := s.repo.ListHistory(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+
+		refs := make([]LinkRef, len(links))
+		for i, link := range links {
+			refs[i] = LinkRef{DomainID: link.DomainID.Int64, ShortCode: link.ShortCode.String}
+		}
+
+		totals := map[LinkRef]int64{}
+		if s.clicks != nil {
+			if totals, err = s.clicks.ClickTotals(ctx, refs); err != nil {
+				return err
+			}
+		}
+
+		if err := s.attachTags(ctx, links); err != nil {
+			return err
+		}
+
+		rows := make([]ExportRow, len(links))
+		for i, link := range links {
+			rows[i] = ExportRow{Link: link, Clicks: totals[refs[i]]}
+		}
+		if err := write(rows); err != nil {
+			return err
+		}
+
+		if len(links) < exportPageSize {
+			return nil
+		}
+		last := links[len(links)-1]
+		filter.After = &HistoryCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+}
+
+func (s *Service) destination(longURL string, opts LinkOptions) (Destination, error) {
+	utm, err := normalizeUTM(opts.UTM)
+	if err != nil {
+		return Destination{}, err
+	}
+	opts.UTM = utm
+
+	// Campaign parameters are part of where the link goes, so they are
+	// added before anything else looks at the URL.
+	tagged, err := opts.UTM.tag(longURL)
+	if err != nil {
+		return Destination{}, &InvalidRequestErr{reason: fmt.Sprintf("Invalid URL %q", longURL)}
+	}
+	longURL = tagged
 
+	canonicalURL, err := s.canonicalizer.Canonicalize(longURL)
 	if err != nil {
-		return "", err
+		return Destination{}, &InvalidRequestErr{reason: fmt.Sprintf("Invalid URL %q", longURL)}
+	}
+
+	if opts.RedirectType == 0 {
+		opts.RedirectType = DefaultRedirectType
+	}
+
+	if !redirectTypes[opts.RedirectType] {
+		return Destination{}, &InvalidRequestErr{reason: "redirect_type must be one of 301, 302, 307 or 308"}
+	}
+
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return Destination{}, &InvalidRequestErr{reason: "expires_at must be in the future"}
+	}
+
+	if opts.Details, err = normalizeDetails(opts.Details); err != nil {
+		return Destination{}, err
+	}
+
+	if opts.RoutingRules, err = normalizeRoutingRules(opts.RoutingRules); err != nil {
+		return Destination{}, err
 	}
 
-	return url.LongURL, nil
+	if opts.Variants, err = normalizeVariants(opts.Variants); err != nil {
+		return Destination{}, err
+	}
+
+	for i := range opts.RoutingRules {
+		if opts.RoutingRules[i].URL, err = opts.UTM.tag(opts.RoutingRules[i].URL); err != nil {
+			return Destination{}, err
+		}
+	}
+
+	for i := range opts.Variants {
+		if opts.Variants[i].URL, err = opts.UTM.tag(opts.Variants[i].URL); err != nil {
+			return Destination{}, err
+		}
+	}
+
+	dest := Destination{LongURL: longURL, CanonicalURL: canonicalURL, Options: opts}
+	if opts.Password != "" {
+		if dest.PasswordHash, err = hashLinkPassword(opts.Password); err != nil {
+			return Destination{}, err
+		}
+	}
+
+	return dest, nil
+}
+
+const minPasswordLength = 4
+
+// hashLinkPassword checks a link password is usable and hashes it for
+// storage.
+func hashLinkPassword(password string) (string, error) {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return "", &InvalidRequestErr{reason: fmt.Sprintf("password must be at least %d characters", minPasswordLength)}
+	}
+
+	if len(password) > auth.MaxPasswordBytes {
+		return "", &InvalidRequestErr{reason: fmt.Sprintf("password cannot be longer than %d bytes", auth.MaxPasswordBytes)}
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return "", fmt.Errorf("hashing link password: %w", err)
+	}
+
+	return hash, nil
+}
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 2000
+	maxTags              = 20
+	maxTagLength         = 50
+	maxImageURLLength    = 2048
+)
+
+// normalizeDetails trims the details and lowercases tags, dropping empty and
+// repeated ones, so that tag filters match however a tag was typed.
+func normalizeDetails(details LinkDetails) (LinkDetails, error) {
+	details.Title = strings.TrimSpace(details.Title)
+	details.Description = strings.TrimSpace(details.Description)
+
+	if utf8.RuneCountInString(details.Title) > maxTitleLength {
+		return details, &InvalidRequestErr{reason: fmt.Sprintf("title cannot be longer than %d characters", maxTitleLength)}
+	}
+
+	if utf8.RuneCountInString(details.Description) > maxDescriptionLength {
+		return details, &InvalidRequestErr{reason: fmt.Sprintf("description cannot be longer than %d characters", maxDescriptionLength)}
+	}
+
+	tags, err := normalizeTags(details.Tags)
+	if err != nil {
+		return details, err
+	}
+	details.Tags = tags
+
+	if details.OpenGraph, err = normalizeOpenGraph(details.OpenGraph); err != nil {
+		return details, err
+	}
+
+	return details, nil
+}
+
+// normalizeOpenGraph trims the overrides and checks the image is a web
+// address platforms can load.
+func normalizeOpenGraph(og OpenGraph) (OpenGraph, error) {
+	og.Title = strings.TrimSpace(og.Title)
+	og.Description = strings.TrimSpace(og.Description)
+	og.ImageURL = strings.TrimSpace(og.ImageURL)
+
+	if utf8.RuneCountInString(og.Title) > maxTitleLength {
+		return og, &InvalidRequestErr{reason: fmt.Sprintf("og_title cannot be longer than %d characters", maxTitleLength)}
+	}
+
+	if utf8.RuneCountInString(og.Description) > maxDescriptionLength {
+		return og, &InvalidRequestErr{reason: fmt.Sprintf("og_description cannot be longer than %d characters", maxDescriptionLength)}
+	}
+
+	if og.ImageURL != "" {
+		image, err := neturl.Parse(og.ImageURL)
+		if err != nil || (image.Scheme != "http" && image.Scheme != "https") || image.Host == "" {
+			return og, &InvalidRequestErr{reason: "og_image must be an http or https URL"}
+		}
+		if len(og.ImageURL) > maxImageURLLength {
+			return og, &InvalidRequestErr{reason: fmt.Sprintf("og_image cannot be longer than %d characters", maxImageURLLength)}
+		}
+	}
+
+	return og, nil
+}
+
+func normalizeTags(raw []string) ([]string, error) {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, tag := range raw {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("tags cannot be longer than %d characters", maxTagLength)}
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	if len(tags) > maxTags {
+		return nil, &InvalidRequestErr{reason: fmt.Sprintf("a link cannot have more than %d tags", maxTags)}
+	}
+
+	return tags, nil
+}
+
+// checkDestinations rejects the first destination the safety checks flag.
+func (s *Service) checkDestinations(ctx context.Context, dests []Destination) error {
+	findings := s.unsafeDestinations(ctx, dests)
+	for _, dest := range dests {
+		for _, longURL := range dest.urls() {
+			if finding, ok := findings[longURL]; ok {
+				return &UnsafeDestinationErr{longURL: longURL, reason: finding.String()}
+			}
+		}
+	}
+
+	return nil
+}
+
+// unsafeDestinations returns what the safety checks found, by long URL.
+// When a check cannot run the destinations are let through: the periodic
+// scan catches up on them.
+func (s *Service) unsafeDestinations(ctx context.Context, dests []Destination) map[string]safety.Finding {
+	if s.checker == nil || len(dests) == 0 {
+		return nil
+	}
+
+	var urls []string
+	for _, dest := range dests {
+		urls = append(urls, dest.urls()...)
+	}
+
+	findings, err := s.checker.Check(ctx, urls)
+	if err != nil {
+		slog.Warn("Destination safety check incomplete", "error", err)
+	}
+
+	return findings
+}
+
+const (
+	safetyScanBatchSize = 500
+	safetyScanLockKey   = "lock:safety-scan"
+)
+
+// ScanDestinations re-checks every active link every interval until ctx is
+// done, flagging those whose destination has since turned unsafe. Across
+// instances one scan runs per interval. It returns at once without a
+// checker.
+func (s *Service) ScanDestinations(ctx context.Context, interval time.Duration) {
+	if s.checker == nil || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The lock is left to expire, so the instances that lose the race
+			// skip this interval's scan rather than run it after the winner.
+			acquired, err := s.redis.SetNX(ctx, safetyScanLockKey, time.Now().Unix(), interval).Result()
+			if err != nil {
+				slog.Warn("Redis SetNX for safety scan lock failed", "error", err)
+				continue
+			}
+			if !acquired {
+				continue
+			}
+
+			flagged, err := s.scanDestinations(ctx)
+			if err != nil {
+				slog.Error("Destination safety scan failed", "error", err, "flagged", flagged)
+				continue
+			}
+			slog.Info("Destination safety scan finished", "flagged", flagged)
+		}
+	}
+}
+
+// scanDestinations checks every active link once and returns how many it
+// flagged.
+func (s *Service) scanDestinations(ctx context.Context) (int, error) {
+	var flagged int
+	var afterID int64
+
+	for {
+		links, err := s.repo.ListActiveAfter(ctx, afterID, safetyScanBatchSize)
+		if err != nil {
+			return flagged, err
+		}
+		if len(links) == 0 {
+			return flagged, nil
+		}
+		afterID = links[len(links)-1].ID
+
+		var urls []string
+		for _, link := range links {
+			urls = append(urls, link.urls()...)
+		}
+
+		findings, err := s.checker.Check(ctx, urls)
+		if err != nil {
+			slog.Warn("Destination safety check incomplete", "error", err)
+		}
+
+		var changed []LinkRef
+		for _, link := range links {
+			finding, ok := firstFinding(findings, link.urls())
+			if !ok {
+				continue
+			}
+
+			if err := s.repo.Flag(ctx, link.ID, finding.String()); err != nil {
+				return flagged, err
+			}
+			flagged++
+
+			slog.Warn("Flagged link with unsafe destination", "short_code", link.ShortCode.String, "reason", finding.String())
+			changed = append(changed, LinkRef{DomainID: link.DomainID.Int64, ShortCode: link.ShortCode.String})
+		}
+
+		s.InvalidateCache(ctx, changed)
+	}
+}
+
+// firstFinding returns what the safety checks found about the first of urls
+// they flagged.
+func firstFinding(findings map[string]safety.Finding, urls []string) (safety.Finding, bool) {
+	for _, u := range urls {
+		if finding, ok := findings[u]; ok {
+			return finding, true
+		}
+	}
+
+	return safety.Finding{}, false
+}
+
+func (o LinkOptions) isDefault() bool {
+	return o.RedirectType == DefaultRedirectType && o.ExpiresAt == nil && o.Domain == "" && o.Details.empty() && o.Password == "" &&
+		len(o.RoutingRules) == 0 && len(o.Variants) == 0 && o.UTM == UTM{} && !o.ForwardQuery && o.Alias == ""
+}
+
+// InvalidateCache drops the cached destinations of the given links, so that
+// links disabled or deleted outside the redirect path stop resolving. Other
+// instances are told to drop them from their local caches too.
+func (s *Service) InvalidateCache(ctx context.Context, links []LinkRef) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	localKeys := make([]string, len(links))
+	keys := make([]string, len(links))
+	for i, link := range links {
+		localKeys[i] = link.key()
+		keys[i] = "url:" + link.key()
+	}
+
+	s.local.Delete(localKeys...)
+
+	if err := s.redis.Del(ctx, keys...).Err(); err != nil {
+		slog.Warn("Redis failed to invalidate cache", "error", err, "count", len(keys))
+		return err
+	}
+
+	payload, err := json.Marshal(localKeys)
+	if err != nil {
+		return err
+	}
+
+	if err := s.redis.Publish(ctx, invalidationChannel, payload).Err(); err != nil {
+		slog.Warn("Redis failed to broadcast cache invalidation", "error", err, "count", len(localKeys))
+		return err
+	}
+
+	return nil
+}
+
+// ListenForInvalidations evicts links changed by other instances from the
+// local cache until ctx is done. It returns at once without a local cache.
+func (s *Service) ListenForInvalidations(ctx context.Context) {
+	if s.local == nil {
+		return
+	}
+
+	sub := s.redis.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			var keys []string
+			if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+				slog.Warn("Ignoring malformed cache invalidation", "error", err, "payload", msg.Payload)
+				continue
+			}
+
+			s.local.Delete(keys...)
+		}
+	}
+}
+
+// UpdateLink enables or disables the link at shortCode on hostname, or on
+// the default domain when hostname is empty, and changes its details,
+// password, routing rules, variants and whether it forwards queries.
+func (s *Service) UpdateLink(ctx context.Context, hostname string, shortCode string, req UpdateURLRequest) error {
+	changesDetails := req.Title != nil || req.Description != nil || req.Tags != nil ||
+		req.OGTitle != nil || req.OGDescription != nil || req.OGImage != nil
+	if req.Status == "" && !changesDetails && req.Password == nil && req.RoutingRules == nil && req.Variants == nil && req.ForwardQuery == nil {
+		return &InvalidRequestErr{reason: "nothing to update, set status, title, description, tags, password, routing rules, variants, forward_query or an Open Graph override"}
+	}
+
+	if req.Status != "" && req.Status != StatusActive && req.Status != StatusDisabled {
+		return &InvalidRequestErr{reason: "status must be either active or disabled"}
+	}
+
+	link, url, err := s.authorizeLinkChange(ctx, hostname, shortCode)
+	if err != nil {
+		return err
+	}
+
+	var changes LinkChanges
+	if req.Status != "" {
+		if url.Status == StatusFlagged {
+			return &LinkFlaggedErr{shortCode: shortCode, reason: url.FlaggedReason.String}
+		}
+		changes.Status = &req.Status
+	}
+
+	if changesDetails {
+		details, err := s.updatedDetails(ctx, url, req)
+		if err != nil {
+			return err
+		}
+		changes.Details = &details
+	}
+
+	if req.Password != nil {
+		var passwordHash string
+		if *req.Password != "" {
+			if passwordHash, err = hashLinkPassword(*req.Password); err != nil {
+				return err
+			}
+		}
+		changes.PasswordHash = &passwordHash
+	}
+
+	var rules []RoutingRule
+	if req.RoutingRules != nil {
+		if rules, err = normalizeRoutingRules(*req.RoutingRules); err != nil {
+			return err
+		}
+		changes.RoutingRules = &rules
+	}
+
+	var variants []Variant
+	if req.Variants != nil {
+		if variants, err = normalizeVariants(*req.Variants); err != nil {
+			return err
+		}
+		changes.Variants = &variants
+	}
+
+	if len(rules) > 0 || len(variants) > 0 {
+		dest := Destination{LongURL: url.LongURL, Options: LinkOptions{RoutingRules: rules, Variants: variants}}
+		if err := s.checkDestinations(ctx, []Destination{dest}); err != nil {
+			return err
+		}
+	}
+
+	changes.ForwardQuery = req.ForwardQuery
+
+	if err := s.repo.Update(ctx, url.ID, changes); err != nil {
+		return err
+	}
+
+	// Cached redirects carry the status, password, rules, variants and query
+	// forwarding of the link, but none of its details.
+	if changes.changesRedirect() {
+		s.InvalidateCache(ctx, []LinkRef{link})
+	}
+
+	return nil
+}
+
+// updatedDetails are the details of url with the changes of req applied.
+func (s *Service) updatedDetails(ctx context.Context, url *URL, req UpdateURLRequest) (LinkDetails, error) {
+	details := LinkDetails{
+		Title:       url.Title.String,
+		Description: url.Description.String,
+		OpenGraph:   OpenGraph{Title: url.OGTitle.String, Description: url.OGDescription.String, ImageURL: url.OGImageURL.String},
+	}
+	if req.Title != nil {
+		details.Title = *req.Title
+	}
+	if req.Description != nil {
+		details.Description = *req.Description
+	}
+	if req.OGTitle != nil {
+		details.OpenGraph.Title = *req.OGTitle
+	}
+	if req.OGDescription != nil {
+		details.OpenGraph.Description = *req.OGDescription
+	}
+	if req.OGImage != nil {
+		details.OpenGraph.ImageURL = *req.OGImage
+	}
+
+	if req.Tags != nil {
+		details.Tags = *req.Tags
+	} else {
+		tags, err := s.repo.ListTags(ctx, []int64{url.ID})
+		if err != nil {
+			return LinkDetails{}, err
+		}
+		details.Tags = tags[url.ID]
+	}
+
+	return normalizeDetails(details)
+}
+
+// DeleteLink removes the link at shortCode on hostname and returns it, so its
+// analytics can be dropped too.
+func (s *Service) DeleteLink(ctx context.Context, hostname string, shortCode string) (LinkRef, error) {
+	link, url, err := s.authorizeLinkChange(ctx, hostname, shortCode)
+	if err != nil {
+		return LinkRef{}, err
+	}
+
+	if err := s.repo.Delete(ctx, url.ID); err != nil {
+		return LinkRef{}, err
+	}
+
+	s.InvalidateCache(ctx, []LinkRef{link})
+	return link, nil
+}
+
+// authorizeLinkChange loads the link and checks the caller may modify it:
+// personal links only by their creator, workspace links only by an editor of
+// that workspace acting in its scope.
+func (s *Service) authorizeLinkChange(ctx context.Context, hostname string, shortCode string) (LinkRef, *URL, error) {
+	user, err := auth.GetUserFromContext(ctx)
+	if err != nil {
+		return LinkRef{}, nil, err
+	}
+
+	link, err := s.linkRef(ctx, hostname, shortCode)
+	if err != nil {
+		return LinkRef{}, nil, err
+	}
+
+	url, err := s.repo.GetByLink(ctx, link)
+	if err != nil {
+		return LinkRef{}, nil, err
+	}
+
+	if url.WorkspaceID.Valid {
+		member := workspace.GetMemberFromContext(ctx)
+		if member == nil || member.WorkspaceID != url.WorkspaceID.Int64 {
+			return LinkRef{}, nil, &ForbiddenErr{shortCode: shortCode}
+		}
+
+		if !member.Role.CanEditLinks() {
+			return LinkRef{}, nil, &ForbiddenErr{shortCode: shortCode}
+		}
+
+		return link, url, nil
+	}
+
+	if !url.UserID.Valid || url.UserID.Int64 != user.UserID {
+		return LinkRef{}, nil, &ForbiddenErr{shortCode: shortCode}
+	}
+
+	return link, url, nil
+}
+
+// ownerFromContext derives who a new link belongs to: the selected workspace
+// when the caller may edit its links, otherwise the caller (or nobody).
+func ownerFromContext(ctx context.Context) (Owner, error) {
+	var owner Owner
+
+	if user, _ := auth.GetUserFromContext(ctx); user != nil {
+		owner.UserID = &user.UserID
+	}
+
+	if member := workspace.GetMemberFromContext(ctx); member != nil {
+		if !member.Role.CanEditLinks() {
+			return Owner{}, &ForbiddenErr{}
+		}
+		owner.WorkspaceID = &member.WorkspaceID
+	}
+
+	return owner, nil
+}
+
+func (s *Service) getRedirectFromDatabase(ctx context.Context, link LinkRef) (*Redirect, error) {
+	url, err := s.repo.GetByLink(ctx, link)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unavailable(url, time.Now()); err != nil {
+		return nil, err
+	}
+
+	redirect := &Redirect{LongURL: url.LongURL, Type: url.RedirectType, Protected: url.Protected, PasswordStamp: url.passwordStamp(), Rules: url.RoutingRules, Variants: url.Variants, ForwardQuery: url.ForwardQuery}
+	if url.ExpiresAt.Valid {
+		redirect.ExpiresAt = &url.ExpiresAt.Time
+	}
+
+	return redirect, nil
+}
+
+// unavailable reports why url cannot be opened at now, or nil when it can.
+func unavailable(url *URL, now time.Time) error {
+	switch url.Status {
+	case StatusDisabled:
+		return &LinkDisabledErr{shortCode: url.ShortCode.String}
+	case StatusFlagged:
+		return &LinkFlaggedErr{shortCode: url.ShortCode.String, reason: url.FlaggedReason.String}
+	}
+
+	if url.ExpiresAt.Valid && !now.Before(url.ExpiresAt.Time) {
+		return &LinkExpiredErr{shortCode: url.ShortCode.String}
+	}
+
+	return nil
+}
+
+// FetchPreview describes the link at shortCode on hostname, or on the default
+// domain when hostname is empty, for link previews. Links that could not be
+// opened fail like FetchRedirect. Previews are not cached: only crawlers and
+// people asking for a preview request them.
+func (s *Service) FetchPreview(ctx context.Context, hostname string, shortCode string) (*Preview, error) {
+	if _, err := s.codes.Decode(shortCode); err != nil {
+		return nil, err
+	}
+
+	link, err := s.linkRef(ctx, hostname, shortCode)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := s.repo.GetByLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unavailable(url, time.Now()); err != nil {
+		return nil, err
+	}
+
+	meta, err := s.repo.GetMetadata(ctx, url.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		meta = &LinkMetadata{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &Preview{
+		ShortCode:     shortCode,
+		LongURL:       url.LongURL,
+		Title:         firstNonEmpty(url.OGTitle.String, meta.Title),
+		Description:   firstNonEmpty(url.OGDescription.String, meta.Description),
+		ImageURL:      firstNonEmpty(url.OGImageURL.String, meta.ImageURL),
+		FaviconURL:    meta.FaviconURL,
+		Protected:     url.Protected,
+		PasswordStamp: url.passwordStamp(),
+	}, nil
+}
+
+// UnlockLink checks password against the password of the link at shortCode
+// on hostname, or on the default domain when hostname is empty. Links that
+// could not be opened fail like FetchRedirect; links without a password
+// unlock with any. It returns the stamp of the password, for access tokens.
+func (s *Service) UnlockLink(ctx context.Context, hostname string, shortCode string, password string) (string, error) {
+	if _, err := s.codes.Decode(shortCode); err != nil {
+		return "", err
+	}
+
+	link, err := s.linkRef(ctx, hostname, shortCode)
+	if err != nil {
+		return "", err
+	}
+
+	url, err := s.repo.GetByLink(ctx, link)
+	if err != nil {
+		return "", err
+	}
+
+	if err := unavailable(url, time.Now()); err != nil {
+		return "", err
+	}
+
+	if !url.PasswordHash.Valid {
+		return "", nil
+	}
+
+	matched, err := auth.CheckPassword(url.PasswordHash.String, password)
+	if err != nil {
+		return "", err
+	}
+
+	if !matched {
+		return "", &IncorrectPasswordErr{shortCode: shortCode}
+	}
+
+	return url.passwordStamp(), nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
 
+	return ""
 }
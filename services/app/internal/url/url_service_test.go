@@ -2,22 +2,45 @@ package url
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"hafiztri123/app-link-shortener/internal/auth"
+	"hafiztri123/app-link-shortener/internal/domain"
+	"hafiztri123/app-link-shortener/internal/safety"
+	"hafiztri123/app-link-shortener/internal/shared"
+	"hafiztri123/app-link-shortener/internal/workspace"
+	"hpj/hv1-link-shortener/shared/models"
+
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type MockRepository struct {
 	InsertFunc                    func(context.Context, string) (int64, error)
 	UpdateShortCodeFunc           func(context.Context, int64, string) error
 	GetByIDFunc                   func(context.Context, int64) (*URL, error)
-	FindOrCreateShortCodeFunc     func(context.Context, string, uint64, *int64) (string, error)
-	GetByUserIDBulkFunc           func(context.Context, int64) ([]*URL, error)
-	FindOrCreateShortCodeBulkFunc func(context.Context, []string, uint64, *int64) ([]CreateShortCodeBulkResult, error)
+	FindOrCreateShortCodeFunc     func(context.Context, Destination, Owner) (CreatedLink, error)
+	ListHistoryFunc               func(context.Context, HistoryFilter) ([]*URL, error)
+	CountHistoryFunc              func(context.Context, HistoryFilter) (int, error)
+	FindOrCreateShortCodeBulkFunc func(context.Context, []Destination, Owner) ([]CreateShortCodeBulkResult, error)
+	CreateShortCodeFunc           func(context.Context, Destination, Owner) (CreatedLink, error)
+	CreateShortCodeBulkFunc       func(context.Context, []Destination, Owner) ([]CreateShortCodeBulkResult, error)
+	GetByLinkFunc                 func(context.Context, LinkRef) (*URL, error)
+	UpdateFunc                    func(context.Context, int64, LinkChanges) error
+	DeleteFunc                    func(context.Context, int64) error
+	ListActiveAfterFunc           func(context.Context, int64, int) ([]*URL, error)
+	FlagFunc                      func(context.Context, int64, string) error
+	ListTagsFunc                  func(context.Context, []int64) (map[int64][]string, error)
+	GetMetadataFunc               func(context.Context, int64) (*LinkMetadata, error)
 }
 
 func (m *MockRepository) Insert(ctx context.Context, longURL string) (int64, error) {
@@ -32,22 +55,73 @@ func (m *MockRepository) GetByID(ctx context.Context, id int64) (*URL, error) {
 	return m.GetByIDFunc(ctx, id)
 }
 
-func (m *MockRepository) FindOrCreateShortCode(ctx context.Context, longURL string, idOffset uint64, userId *int64) (string, error) {
-	return m.FindOrCreateShortCodeFunc(ctx, longURL, idOffset, nil)
+func (m *MockRepository) FindOrCreateShortCode(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+	return m.FindOrCreateShortCodeFunc(ctx, dest, owner)
+}
+
+func (m *MockRepository) FindOrCreateShortCode_Bulk(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
+	return m.FindOrCreateShortCodeBulkFunc(ctx, dests, owner)
+}
+
+func (m *MockRepository) CreateShortCode(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+	return m.CreateShortCodeFunc(ctx, dest, owner)
+}
+
+func (m *MockRepository) CreateShortCode_Bulk(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
+	return m.CreateShortCodeBulkFunc(ctx, dests, owner)
+}
+
+func (m *MockRepository) ListHistory(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+	return m.ListHistoryFunc(ctx, filter)
+}
+
+func (m *MockRepository) CountHistory(ctx context.Context, filter HistoryFilter) (int, error) {
+	if m.CountHistoryFunc == nil {
+		return 0, nil
+	}
+	return m.CountHistoryFunc(ctx, filter)
+}
+
+func (m *MockRepository) GetByLink(ctx context.Context, link LinkRef) (*URL, error) {
+	return m.GetByLinkFunc(ctx, link)
+}
+
+func (m *MockRepository) Update(ctx context.Context, id int64, changes LinkChanges) error {
+	return m.UpdateFunc(ctx, id, changes)
 }
 
-func (m *MockRepository) FindOrCreateShortCode_Bulk(ctx context.Context, longURLs []string, idOffset uint64, userId *int64) ([]CreateShortCodeBulkResult, error) {
-	return m.FindOrCreateShortCodeBulkFunc(ctx, longURLs, idOffset, nil)
+func (m *MockRepository) Delete(ctx context.Context, id int64) error {
+	return m.DeleteFunc(ctx, id)
 }
 
-func (m *MockRepository) GetByUserID_Bulk(ctx context.Context, userId int64) ([]*URL, error) {
-	return m.GetByUserIDBulkFunc(ctx, userId)
+func (m *MockRepository) ListActiveAfter(ctx context.Context, afterID int64, limit int) ([]*URL, error) {
+	return m.ListActiveAfterFunc(ctx, afterID, limit)
+}
+
+func (m *MockRepository) Flag(ctx context.Context, id int64, reason string) error {
+	return m.FlagFunc(ctx, id, reason)
+}
+
+func (m *MockRepository) ListTags(ctx context.Context, ids []int64) (map[int64][]string, error) {
+	if m.ListTagsFunc == nil {
+		return nil, nil
+	}
+	return m.ListTagsFunc(ctx, ids)
+}
+
+func (m *MockRepository) GetMetadata(ctx context.Context, id int64) (*LinkMetadata, error) {
+	if m.GetMetadataFunc == nil {
+		return nil, sql.ErrNoRows
+	}
+	return m.GetMetadataFunc(ctx, id)
 }
 
 func TestCreateShortcode(t *testing.T) {
 	testCases := []struct {
 		name      string
 		longUrl   string
+		forceNew  bool
+		opts      LinkOptions
 		setupMock func(*MockRepository)
 		want      string
 		wantErr   error
@@ -56,20 +130,63 @@ func TestCreateShortcode(t *testing.T) {
 			name:    "success",
 			longUrl: "https://example.com/success",
 			setupMock: func(mock *MockRepository) {
-				mock.FindOrCreateShortCodeFunc = func(ctx context.Context, longURL string, idOffset uint64, userId *int64) (string, error) {
-					return "success", nil
+				mock.FindOrCreateShortCodeFunc = func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+					return CreatedLink{ShortCode: "success"}, nil
 				}
 			},
 			want:    "success",
 			wantErr: nil,
 		},
 
+		{
+			name:     "force new skips deduplication",
+			longUrl:  "https://example.com/success",
+			forceNew: true,
+			setupMock: func(mock *MockRepository) {
+				mock.CreateShortCodeFunc = func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+					return CreatedLink{ShortCode: "fresh"}, nil
+				}
+			},
+			want: "fresh",
+		},
+
+		{
+			name:    "non-default options skip deduplication",
+			longUrl: "https://example.com/success",
+			opts:    LinkOptions{RedirectType: 301},
+			setupMock: func(mock *MockRepository) {
+				mock.CreateShortCodeFunc = func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+					if dest.Options.RedirectType != 301 {
+						return CreatedLink{}, errors.New("options were not passed on")
+					}
+					return CreatedLink{ShortCode: "permanent"}, nil
+				}
+			},
+			want: "permanent",
+		},
+
+		{
+			name:      "unsupported redirect type",
+			longUrl:   "https://example.com/success",
+			opts:      LinkOptions{RedirectType: 303},
+			setupMock: func(mock *MockRepository) {},
+			wantErr:   &InvalidRequestErr{},
+		},
+
+		{
+			name:      "expiry in the past",
+			longUrl:   "https://example.com/success",
+			opts:      LinkOptions{ExpiresAt: &time.Time{}},
+			setupMock: func(mock *MockRepository) {},
+			wantErr:   &InvalidRequestErr{},
+		},
+
 		{
 			name:    "database error",
 			longUrl: "https://example.com/failure",
 			setupMock: func(mock *MockRepository) {
-				mock.FindOrCreateShortCodeFunc = func(ctx context.Context, longURL string, idOffset uint64, userId *int64) (string, error) {
-					return "", errors.New("database error")
+				mock.FindOrCreateShortCodeFunc = func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+					return CreatedLink{}, errors.New("database error")
 				}
 			},
 			want:    "",
@@ -81,22 +198,30 @@ func TestCreateShortcode(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			MockRepository := &MockRepository{}
 			tc.setupMock(MockRepository)
-			service := &Service{repo: MockRepository, idOffset: 1000}
-			got, err := service.CreateShortCode(context.Background(), tc.longUrl)
+			redisClient, redisMock := redismock.NewClientMock()
+			service := &Service{repo: MockRepository, redis: redisClient}
+
+			if tc.wantErr == nil {
+				redisMock.ExpectDel("url:" + tc.want).SetVal(1)
+				redisMock.ExpectPublish(invalidationChannel, []byte(`["`+tc.want+`"]`)).SetVal(0)
+			}
+
+			got, err := service.CreateShortCode(context.Background(), tc.longUrl, tc.forceNew, tc.opts)
 
 			if tc.wantErr != nil {
-				assert.Error(t, err)
+				assert.IsType(t, tc.wantErr, err)
 				return
 			}
 
 			assert.NoError(t, err)
 			assert.Equal(t, tc.want, got)
+			assert.NoError(t, redisMock.ExpectationsWereMet(), "a cached miss for the new code is dropped")
 		})
 	}
 
 }
 
-func TestFetchLongURL(t *testing.T) {
+func TestFetchRedirect(t *testing.T) {
 	testCases := []struct {
 		name        string
 		shortCode   string
@@ -114,16 +239,26 @@ func TestFetchLongURL(t *testing.T) {
 			expectedErr: nil,
 		},
 
+		{
+			name:      "cached miss skips the database",
+			shortCode: "g8",
+			setupMock: func(mock *MockRepository, redisMock redismock.ClientMock) {
+				redisMock.ExpectGet("url:g8").SetVal(missingURL)
+			},
+			expectedErr: sql.ErrNoRows,
+		},
+
 		{
 			name:      "database cache miss, lock acquired",
 			shortCode: "g8",
 			setupMock: func(repoMock *MockRepository, redisMock redismock.ClientMock) {
 				redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
-				redisMock.ExpectSetNX("lock:g8", "1", 10*time.Second).SetVal(true)
-				redisMock.ExpectSet("url:g8", "https://db.com", 1*time.Hour).SetVal("OK")
-				redisMock.ExpectDel("lock:g8").SetVal(1)
-				repoMock.GetByIDFunc = func(ctx context.Context, id int64) (*URL, error) {
-					return &URL{LongURL: "https://db.com"}, nil
+				redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(true)
+				redisMock.ExpectSet("url:g8", `{"u":"https://db.com","t":302}`, urlCacheTTL).SetVal("OK")
+				redisMock.ExpectPublish("fill:g8", `{"u":"https://db.com","t":302}`).SetVal(0)
+				redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:g8"}, ".+").SetVal(int64(1))
+				repoMock.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
+					return &URL{LongURL: "https://db.com", RedirectType: DefaultRedirectType}, nil
 				}
 			},
 			expectedURL: "https://db.com",
@@ -131,15 +266,51 @@ func TestFetchLongURL(t *testing.T) {
 		},
 
 		{
-			name:      "cache miss, lock not acquired, timeout",
+			name:      "routing rules are cached with the link",
 			shortCode: "g8",
 			setupMock: func(repoMock *MockRepository, redisMock redismock.ClientMock) {
+				cached := `{"u":"https://db.com","t":302,"r":[{"countries":["ID"],"url":"https://db.co.id"}]}`
 				redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
-				redisMock.ExpectSetNX("lock:g8", "1", 10*time.Second).SetVal(false)
+				redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(true)
+				redisMock.ExpectSet("url:g8", cached, urlCacheTTL).SetVal("OK")
+				redisMock.ExpectPublish("fill:g8", cached).SetVal(0)
+				redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:g8"}, ".+").SetVal(int64(1))
+				repoMock.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
+					return &URL{
+						LongURL:      "https://db.com",
+						RedirectType: DefaultRedirectType,
+						RoutingRules: []RoutingRule{{Countries: []string{"ID"}, URL: "https://db.co.id"}},
+					}, nil
+				}
+			},
+			expectedURL: "https://db.com",
+			expectedErr: nil,
+		},
+
+		{
+			name:      "unknown code is cached as missing",
+			shortCode: "g8",
+			setupMock: func(repoMock *MockRepository, redisMock redismock.ClientMock) {
 				redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
+				redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(true)
+				redisMock.ExpectSet("url:g8", missingURL, negativeCacheTTL).SetVal("OK")
+				redisMock.ExpectPublish("fill:g8", missingURL).SetVal(0)
+				redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:g8"}, ".+").SetVal(int64(1))
+				repoMock.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
+					return nil, sql.ErrNoRows
+				}
+			},
+			expectedErr: sql.ErrNoRows,
+		},
+
+		{
+			name:      "cache miss, lock not acquired, no fill published",
+			shortCode: "g8",
+			setupMock: func(repoMock *MockRepository, redisMock redismock.ClientMock) {
 				redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
+				redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(false)
 
-				repoMock.GetByIDFunc = func(ctx context.Context, id int64) (*URL, error) {
+				repoMock.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
 					return &URL{LongURL: "https://db-fallback.com"}, nil
 				}
 			},
@@ -153,20 +324,56 @@ func TestFetchLongURL(t *testing.T) {
 			redisClient, redisMock := redismock.NewClientMock()
 			mockRepository := &MockRepository{}
 			tc.setupMock(mockRepository, redisMock)
-			service := NewService(mockRepository, redisClient, 0)
+			service := NewService(mockRepository, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
 
-			longURL, err := service.FetchLongURL(context.Background(), tc.shortCode)
+			redirect, err := service.FetchRedirect(context.Background(), "", tc.shortCode)
 
-			assert.Equal(t, tc.expectedURL, longURL)
+			if tc.expectedURL != "" {
+				require.NotNil(t, redirect)
+				assert.Equal(t, tc.expectedURL, redirect.LongURL)
+			} else {
+				assert.Nil(t, redirect)
+			}
 			if tc.expectedErr != nil {
 				assert.EqualError(t, err, tc.expectedErr.Error())
 			} else {
 				assert.NoError(t, err)
 			}
+			assert.NoError(t, redisMock.ExpectationsWereMet())
 		})
 	}
 }
 
+func TestFetchRedirect_CollapsesConcurrentMisses(t *testing.T) {
+	// No expectations: every Redis call fails, so only the in-process
+	// singleflight stands between the callers and the database.
+	redisClient, _ := redismock.NewClientMock()
+
+	var calls atomic.Int32
+	repo := &MockRepository{
+		GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) {
+			calls.Add(1)
+			time.Sleep(200 * time.Millisecond)
+			return &URL{LongURL: "https://db.com"}, nil
+		},
+	}
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			redirect, err := service.FetchRedirect(context.Background(), "", "g8")
+			assert.NoError(t, err)
+			assert.Equal(t, "https://db.com", redirect.LongURL)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
 func TestFetchUserURLHistory(t *testing.T) {
 	testCases := []struct {
 		name string
@@ -192,20 +399,108 @@ func TestFetchUserURLHistory(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			MockRepository := &MockRepository{}
-			MockRepository.GetByUserIDBulkFunc = func(ctx context.Context, userID int64) ([]*URL, error) {
+			MockRepository.ListHistoryFunc = func(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+				assert.Equal(t, int64(1), filter.UserID)
+				assert.Nil(t, filter.WorkspaceID)
 				return tc.urls, tc.err
 			}
+			MockRepository.CountHistoryFunc = func(ctx context.Context, filter HistoryFilter) (int, error) {
+				return len(tc.urls), nil
+			}
 
-			service := NewService(MockRepository, nil, 0)
+			service := NewService(MockRepository, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
 
-			urls, err := service.FetchUserURLHistory(context.Background(), 1)
+			page, err := service.FetchUserURLHistory(context.Background(), 1, HistoryQuery{})
 
-			assert.Equal(t, tc.urls, urls)
 			if tc.err != nil {
 				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.urls, page.Data)
+			assert.Equal(t, len(tc.urls), page.Total)
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestFetchUserURLHistory_Pagination(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	links := make([]*URL, 5)
+	for i := range links {
+		links[i] = &URL{ID: int64(i + 1), CreatedAt: base.Add(time.Duration(i) * time.Hour)}
+	}
+
+	// newest first, keyset on (created_at, id)
+	repo := &MockRepository{
+		ListHistoryFunc: func(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+			var page []*URL
+			for i := len(links) - 1; i >= 0; i-- {
+				link := links[i]
+				if filter.After != nil && !link.CreatedAt.Before(filter.After.CreatedAt) {
+					continue
+				}
+				if len(page) == filter.Limit {
+					break
+				}
+				page = append(page, link)
 			}
+			return page, nil
+		},
+		CountHistoryFunc: func(ctx context.Context, filter HistoryFilter) (int, error) {
+			return len(links), nil
+		},
+	}
+
+	service := NewService(repo, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+	ctx := context.Background()
+
+	var seen []int64
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		page, err := service.FetchUserURLHistory(ctx, 1, HistoryQuery{Limit: 2, Cursor: cursor})
+		assert.NoError(t, err)
+		assert.Equal(t, 5, page.Total)
+		assert.LessOrEqual(t, page.Count, 2)
+
+		for _, link := range page.Data {
+			seen = append(seen, link.ID)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	assert.Equal(t, []int64{5, 4, 3, 2, 1}, seen)
+}
+
+func TestFetchUserURLHistory_InvalidQuery(t *testing.T) {
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	oldestCursor := encodeHistoryCursor(HistoryCursor{CreatedAt: now, ID: 1, Sort: SortOldest})
+
+	testCases := []struct {
+		name  string
+		query HistoryQuery
+	}{
+		{name: "limit too large", query: HistoryQuery{Limit: maxHistoryLimit + 1}},
+		{name: "negative limit", query: HistoryQuery{Limit: -1}},
+		{name: "unknown sort", query: HistoryQuery{Sort: "alphabetical"}},
+		{name: "unknown status", query: HistoryQuery{Status: "archived"}},
+		{name: "inverted date range", query: HistoryQuery{From: &now, To: &earlier}},
+		{name: "garbage cursor", query: HistoryQuery{Cursor: "not-a-cursor"}},
+		{name: "cursor from another sort", query: HistoryQuery{Cursor: oldestCursor}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewService(&MockRepository{}, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+			_, err := service.FetchUserURLHistory(context.Background(), 1, tc.query)
+			assert.IsType(t, InvalidRequest, err)
 		})
 	}
 }
@@ -229,7 +524,7 @@ func TestGenerateQRCode(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		service := NewService(nil, nil, 0)
+		service := NewService(nil, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
 		t.Run(tc.name, func(t *testing.T) {
 			_, err := service.GenerateQRCode(tc.url)
 			if tc.wantErr {
@@ -244,11 +539,13 @@ func TestGenerateQRCode(t *testing.T) {
 
 func TestGenerateShortCodeBulk(t *testing.T) {
 	testCases := []struct {
-		name    string
-		input   []string
-		result  []CreateShortCodeBulkResult
-		err     error
-		wantErr bool
+		name      string
+		input     []string
+		wantDests []string
+		result    []CreateShortCodeBulkResult
+		err       error
+		want      []CreateShortCodeBulkResult
+		wantErr   bool
 	}{
 		{
 			name: "success",
@@ -257,25 +554,42 @@ func TestGenerateShortCodeBulk(t *testing.T) {
 				"https://example.com/2",
 				"https://example.com/3",
 			},
+			wantDests: []string{"https://example.com/1", "https://example.com/2", "https://example.com/3"},
 			result: []CreateShortCodeBulkResult{
-				{ShortCode: "1", LongURL: "https://example.com/1"},
-				{ShortCode: "2", LongURL: "https://example.com/2"},
-				{ShortCode: "3", LongURL: "https://example.com/3"},
+				{ShortCode: "1", LongURL: "https://example.com/1", Status: BulkItemCreated},
+				{ShortCode: "2", LongURL: "https://example.com/2", Status: BulkItemCreated},
+				{ShortCode: "3", LongURL: "https://example.com/3", Status: BulkItemCreated},
+			},
+			want: []CreateShortCodeBulkResult{
+				{ShortCode: "1", LongURL: "https://example.com/1", Status: BulkItemCreated},
+				{ShortCode: "2", LongURL: "https://example.com/2", Status: BulkItemCreated},
+				{ShortCode: "3", LongURL: "https://example.com/3", Status: BulkItemCreated},
 			},
-			err:     nil,
-			wantErr: false,
 		},
-
 		{
-			name: "invalid url",
+			name: "invalid url fails on its own",
 			input: []string{
-				"",
+				"https://example.com/?q=%zz",
 				"https://example.com/2",
 				"https://example.com/3",
 			},
-			result:  nil,
-			err:     errors.New("invalid url"),
-			wantErr: true,
+			wantDests: []string{"https://example.com/2", "https://example.com/3"},
+			result: []CreateShortCodeBulkResult{
+				{ShortCode: "2", LongURL: "https://example.com/2", Status: BulkItemCreated},
+				{ShortCode: "3", LongURL: "https://example.com/3", Status: BulkItemCreated},
+			},
+			want: []CreateShortCodeBulkResult{
+				{LongURL: "https://example.com/?q=%zz", Status: BulkItemFailed, Error: `Invalid URL "https://example.com/?q=%zz"`, Code: BulkInvalidURL},
+				{ShortCode: "2", LongURL: "https://example.com/2", Status: BulkItemCreated},
+				{ShortCode: "3", LongURL: "https://example.com/3", Status: BulkItemCreated},
+			},
+		},
+		{
+			name:      "repository error",
+			input:     []string{"https://example.com/1"},
+			wantDests: []string{"https://example.com/1"},
+			err:       errors.New("database error"),
+			wantErr:   true,
 		},
 	}
 
@@ -284,26 +598,31 @@ func TestGenerateShortCodeBulk(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepository := &MockRepository{
-				FindOrCreateShortCodeBulkFunc: func(ctx context.Context, s []string, u uint64, i *int64) ([]CreateShortCodeBulkResult, error) {
+				FindOrCreateShortCodeBulkFunc: func(ctx context.Context, d []Destination, o Owner) ([]CreateShortCodeBulkResult, error) {
+					var got []string
+					for _, dest := range d {
+						got = append(got, dest.LongURL)
+					}
+					assert.Equal(t, tc.wantDests, got)
 					return tc.result, tc.err
 				},
 			}
 
-			service := NewService(mockRepository, redis, 0)
-			result, err := service.CreateShortCode_Bulk(context.Background(), tc.input)
+			service := NewService(mockRepository, redis, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, nil, nil, nil)
+			result, err := service.CreateShortCode_Bulk(context.Background(), tc.input, false)
 
-			assert.Equal(t, tc.result, result)
 			if tc.wantErr {
 				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
+				return
 			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, result)
 		})
 	}
 
 }
 
-func TestFetchLongURL_CacheError(t *testing.T) {
+func TestFetchRedirect_CacheError(t *testing.T) {
 	redisClient, redisMock := redismock.NewClientMock()
 	mockRepository := &MockRepository{}
 
@@ -311,17 +630,17 @@ func TestFetchLongURL_CacheError(t *testing.T) {
 	redisMock.ExpectGet("url:g8").SetErr(errors.New("redis connection error"))
 
 	// Mock database fallback to also return an error
-	mockRepository.GetByIDFunc = func(ctx context.Context, id int64) (*URL, error) {
+	mockRepository.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
 		return nil, errors.New("database error")
 	}
 
-	service := NewService(mockRepository, redisClient, 0)
+	service := NewService(mockRepository, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
 
-	_, err := service.FetchLongURL(context.Background(), "g8")
+	_, err := service.FetchRedirect(context.Background(), "", "g8")
 	assert.Error(t, err)
 }
 
-func TestFetchLongURL_DatabaseError(t *testing.T) {
+func TestFetchRedirect_DatabaseError(t *testing.T) {
 	redisClient, redisMock := redismock.NewClientMock()
 	mockRepository := &MockRepository{}
 
@@ -329,13 +648,1274 @@ func TestFetchLongURL_DatabaseError(t *testing.T) {
 	redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
 
 	// Mock database to return an error
-	mockRepository.GetByIDFunc = func(ctx context.Context, id int64) (*URL, error) {
+	mockRepository.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
 		return nil, errors.New("database error")
 	}
 
-	service := NewService(mockRepository, redisClient, 0)
+	service := NewService(mockRepository, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
 
-	_, err := service.FetchLongURL(context.Background(), "g8")
+	_, err := service.FetchRedirect(context.Background(), "", "g8")
 	assert.Error(t, err)
 	assert.Equal(t, "database error", err.Error())
 }
+
+func TestFetchRedirect_Disabled(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	mockRepository := &MockRepository{}
+
+	redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
+	redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(true)
+	// Disabled links are not cached, but waiters are still woken.
+	redisMock.ExpectPublish("fill:g8", "").SetVal(0)
+	redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:g8"}, ".+").SetVal(int64(1))
+
+	mockRepository.GetByLinkFunc = func(ctx context.Context, link LinkRef) (*URL, error) {
+		return &URL{LongURL: "https://db.com", Status: StatusDisabled}, nil
+	}
+
+	service := NewService(mockRepository, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	_, err := service.FetchRedirect(context.Background(), "", "g8")
+	assert.IsType(t, LinkDisabled, err)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestFetchRedirect_Flagged(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
+	redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(true)
+	redisMock.ExpectPublish("fill:g8", "").SetVal(0)
+	redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:g8"}, ".+").SetVal(int64(1))
+
+	repo := &MockRepository{
+		GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) {
+			return &URL{LongURL: "https://evil.example", Status: StatusFlagged, FlaggedReason: sql.NullString{String: "phishing: evil.example", Valid: true}}, nil
+		},
+	}
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	_, err := service.FetchRedirect(context.Background(), "", "g8")
+	assert.ErrorAs(t, err, &LinkFlagged)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestFetchRedirect_Expired(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Minute)
+
+	t.Run("expired link is not cached", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		redisMock.ExpectGet("url:g8").SetErr(redis.Nil)
+		redisMock.Regexp().ExpectSetNX("lock:g8", ".+", fillLockTTL).SetVal(true)
+		redisMock.ExpectPublish("fill:g8", "").SetVal(0)
+		redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:g8"}, ".+").SetVal(int64(1))
+
+		repo := &MockRepository{
+			GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) {
+				return &URL{LongURL: "https://db.com", RedirectType: DefaultRedirectType, ExpiresAt: sql.NullTime{Time: expiredAt, Valid: true}}, nil
+			},
+		}
+		service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+		_, err := service.FetchRedirect(context.Background(), "", "g8")
+		assert.ErrorAs(t, err, &LinkExpired)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("cached entry outliving the link", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		redisMock.ExpectGet("url:g8").SetVal(toCacheValue(&Redirect{LongURL: "https://cached.com", Type: DefaultRedirectType, ExpiresAt: &expiredAt}))
+
+		service := NewService(&MockRepository{}, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+		_, err := service.FetchRedirect(context.Background(), "", "g8")
+		assert.ErrorAs(t, err, &LinkExpired)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+}
+
+func TestRedirectCacheTTL(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(10 * time.Minute)
+	later := now.Add(48 * time.Hour)
+
+	assert.Equal(t, urlCacheTTL, redirectCacheTTL(&Redirect{}, now))
+	assert.Equal(t, 10*time.Minute, redirectCacheTTL(&Redirect{ExpiresAt: &soon}, now))
+	assert.Equal(t, urlCacheTTL, redirectCacheTTL(&Redirect{ExpiresAt: &later}, now))
+}
+
+func TestFromCacheValue(t *testing.T) {
+	redirect, ok, err := fromCacheValue("https://legacy.com")
+	require.True(t, ok)
+	require.NoError(t, err)
+	assert.Equal(t, &Redirect{LongURL: "https://legacy.com", Type: DefaultRedirectType}, redirect, "entries written before redirect settings")
+
+	expiresAt := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := &Redirect{LongURL: "https://example.com", Type: 308, ExpiresAt: &expiresAt}
+	redirect, ok, err = fromCacheValue(toCacheValue(want))
+	require.True(t, ok)
+	require.NoError(t, err)
+	assert.Equal(t, want, redirect)
+
+	_, ok, _ = fromCacheValue("{not json")
+	assert.False(t, ok, "malformed entries are treated as a miss")
+}
+
+func TestFetchRedirect_InvalidCode(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	service := NewService(&MockRepository{}, redisClient, NewSequentialGenerator(1000), nil, nil, nil, nil, nil, nil)
+
+	for _, code := range []string{"", "a-b", "1", "zzzzzzzzzzzzzzzzzzzzzzzz"} {
+		_, err := service.FetchRedirect(context.Background(), "", code)
+		assert.ErrorAs(t, err, &InvalidShortCode, code)
+	}
+
+	// Neither the cache nor the repository may be consulted.
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestFetchRedirect_LocalCache(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	local := NewLocalCache(10, time.Minute)
+
+	redisMock.ExpectGet("url:g8").SetVal("https://cached.com")
+
+	service := NewService(&MockRepository{}, redisClient, NewSequentialGenerator(0), local, nil, nil, nil, nil, nil)
+
+	// The first lookup is answered by Redis and kept in process, so the
+	// second never reaches Redis.
+	for range 2 {
+		redirect, err := service.FetchRedirect(context.Background(), "", "g8")
+		require.NoError(t, err)
+		assert.Equal(t, "https://cached.com", redirect.LongURL)
+	}
+
+	local.Set("missing", missingURL)
+	_, err := service.FetchRedirect(context.Background(), "", "missing")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestInvalidateCache_DropsLocalEntries(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	local := NewLocalCache(10, time.Minute)
+	local.Set("a", "https://a.example")
+
+	redisMock.ExpectDel("url:a").SetVal(1)
+	redisMock.ExpectPublish(invalidationChannel, []byte(`["a"]`)).SetVal(1)
+
+	service := NewService(nil, redisClient, NewSequentialGenerator(0), local, nil, nil, nil, nil, nil)
+
+	assert.NoError(t, service.InvalidateCache(context.Background(), []LinkRef{{ShortCode: "a"}}))
+	_, ok := local.Get("a")
+	assert.False(t, ok)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestInvalidateCache(t *testing.T) {
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectDel("url:a", "url:b").SetVal(2)
+	redisMock.ExpectPublish(invalidationChannel, []byte(`["a","b"]`)).SetVal(0)
+
+	service := NewService(nil, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	assert.NoError(t, service.InvalidateCache(context.Background(), []LinkRef{{ShortCode: "a"}, {ShortCode: "b"}}))
+	assert.NoError(t, service.InvalidateCache(context.Background(), nil))
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func withCaller(userID int64, member *workspace.Member) context.Context {
+	ctx := context.WithValue(context.Background(), shared.UserContextKey, &auth.Claims{UserID: userID})
+	if member != nil {
+		ctx = context.WithValue(ctx, shared.WorkspaceContextKey, member)
+	}
+	return ctx
+}
+
+func TestCreateShortCode_Canonicalizes(t *testing.T) {
+	var got []Destination
+	repo := &MockRepository{
+		FindOrCreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			got = append(got, dest)
+			return CreatedLink{ShortCode: "abc"}, nil
+		},
+		FindOrCreateShortCodeBulkFunc: func(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
+			got = append(got, dests...)
+			return nil, nil
+		},
+	}
+	redisClient, _ := redismock.NewClientMock()
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer([]string{"utm_*"}), nil, nil, nil, nil)
+
+	_, err := service.CreateShortCode(context.Background(), "https://Example.com:443/a?utm_source=x&b=1&a=2", false, LinkOptions{})
+	require.NoError(t, err)
+
+	_, err = service.CreateShortCode_Bulk(context.Background(), []string{"HTTPS://example.com/a?a=2&b=1"}, false)
+	require.NoError(t, err)
+
+	require.Len(t, got, 2)
+	assert.Equal(t, "https://Example.com:443/a?utm_source=x&b=1&a=2", got[0].LongURL, "the original is kept for redirects")
+	assert.Equal(t, "https://example.com/a?a=2&b=1", got[0].CanonicalURL)
+	assert.Equal(t, got[0].CanonicalURL, got[1].CanonicalURL)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com/?q=%zz", false, LinkOptions{})
+	assert.ErrorAs(t, err, &InvalidRequest)
+}
+
+func TestCreateShortCode_WorkspaceScope(t *testing.T) {
+	testCases := []struct {
+		name          string
+		member        *workspace.Member
+		wantWorkspace *int64
+		wantErr       error
+	}{
+		{
+			name: "personal scope",
+		},
+		{
+			name:          "workspace editor",
+			member:        &workspace.Member{WorkspaceID: 7, Role: workspace.RoleEditor},
+			wantWorkspace: func() *int64 { id := int64(7); return &id }(),
+		},
+		{
+			name:    "workspace viewer cannot create",
+			member:  &workspace.Member{WorkspaceID: 7, Role: workspace.RoleViewer},
+			wantErr: Forbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotOwner Owner
+			mockRepository := &MockRepository{
+				FindOrCreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+					gotOwner = owner
+					return CreatedLink{ShortCode: "abc"}, nil
+				},
+			}
+
+			redisClient, _ := redismock.NewClientMock()
+			service := NewService(mockRepository, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+			_, err := service.CreateShortCode(withCaller(1, tc.member), "https://example.com", false, LinkOptions{})
+
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(1), *gotOwner.UserID)
+			assert.Equal(t, tc.wantWorkspace, gotOwner.WorkspaceID)
+		})
+	}
+}
+
+func TestFetchUserURLHistory_WorkspaceScope(t *testing.T) {
+	mockRepository := &MockRepository{
+		ListHistoryFunc: func(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+			assert.Equal(t, int64(7), *filter.WorkspaceID)
+			return []*URL{{LongURL: "https://example.com/team"}}, nil
+		},
+	}
+
+	service := NewService(mockRepository, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+	page, err := service.FetchUserURLHistory(withCaller(1, &workspace.Member{WorkspaceID: 7, Role: workspace.RoleViewer}), 1, HistoryQuery{})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Data, 1)
+}
+
+func TestManageLink(t *testing.T) {
+	personal := &URL{ID: 1, UserID: sql.NullInt64{Int64: 1, Valid: true}}
+	team := &URL{ID: 2, UserID: sql.NullInt64{Int64: 2, Valid: true}, WorkspaceID: sql.NullInt64{Int64: 7, Valid: true}}
+
+	testCases := []struct {
+		name    string
+		link    *URL
+		member  *workspace.Member
+		status  string
+		wantErr error
+	}{
+		{name: "creator disables personal link", link: personal, status: StatusDisabled},
+		{name: "invalid status", link: personal, status: "archived", wantErr: InvalidRequest},
+		{name: "other user's personal link", link: &URL{ID: 3, UserID: sql.NullInt64{Int64: 9, Valid: true}}, status: StatusDisabled, wantErr: Forbidden},
+		{name: "workspace editor", link: team, member: &workspace.Member{WorkspaceID: 7, Role: workspace.RoleEditor}, status: StatusDisabled},
+		{name: "workspace viewer", link: team, member: &workspace.Member{WorkspaceID: 7, Role: workspace.RoleViewer}, status: StatusDisabled, wantErr: Forbidden},
+		{name: "workspace link outside its scope", link: team, status: StatusDisabled, wantErr: Forbidden},
+		{name: "editor of another workspace", link: team, member: &workspace.Member{WorkspaceID: 8, Role: workspace.RoleOwner}, status: StatusDisabled, wantErr: Forbidden},
+		{name: "flagged link cannot be re-enabled", link: &URL{ID: 4, UserID: sql.NullInt64{Int64: 1, Valid: true}, Status: StatusFlagged}, status: StatusActive, wantErr: LinkFlagged},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redisClient, redisMock := redismock.NewClientMock()
+			redisMock.ExpectDel("url:abc").SetVal(1)
+			redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+
+			var updated, deleted bool
+			mockRepository := &MockRepository{
+				GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) { return tc.link, nil },
+				UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+					updated = true
+					return nil
+				},
+				DeleteFunc: func(ctx context.Context, id int64) error {
+					deleted = true
+					return nil
+				},
+			}
+
+			service := NewService(mockRepository, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+			ctx := withCaller(1, tc.member)
+
+			err := service.UpdateLink(ctx, "", "abc", UpdateURLRequest{Status: tc.status})
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.False(t, updated)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.True(t, updated)
+
+			redisMock.ExpectDel("url:abc").SetVal(1)
+			redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+			link, err := service.DeleteLink(ctx, "", "abc")
+			assert.NoError(t, err)
+			assert.Equal(t, LinkRef{ShortCode: "abc"}, link)
+			assert.True(t, deleted)
+		})
+	}
+}
+
+type stubDomains map[string]*domain.Domain
+
+func (d stubDomains) Resolve(ctx context.Context, hostname string) (*domain.Domain, error) {
+	if found, ok := d[hostname]; ok {
+		return found, nil
+	}
+	return nil, domain.DomainNotFound
+}
+
+func TestFetchRedirect_Domain(t *testing.T) {
+	owner := int64(1)
+	domains := stubDomains{"go.acme.com": {ID: 7, Hostname: "go.acme.com", UserID: &owner}}
+
+	t.Run("resolves the code on the domain", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		redisMock.ExpectGet("url:7/g8").SetErr(redis.Nil)
+		redisMock.Regexp().ExpectSetNX("lock:7/g8", ".+", fillLockTTL).SetVal(true)
+		redisMock.ExpectSet("url:7/g8", `{"u":"https://acme.example","t":302}`, urlCacheTTL).SetVal("OK")
+		redisMock.ExpectPublish("fill:7/g8", `{"u":"https://acme.example","t":302}`).SetVal(0)
+		redisMock.Regexp().ExpectEvalSha(releaseLockScript.Hash(), []string{"lock:7/g8"}, ".+").SetVal(int64(1))
+
+		repo := &MockRepository{
+			GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) {
+				assert.Equal(t, LinkRef{DomainID: 7, ShortCode: "g8"}, link)
+				return &URL{LongURL: "https://acme.example", RedirectType: DefaultRedirectType}, nil
+			},
+		}
+		service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, domains, nil, nil, nil)
+
+		redirect, err := service.FetchRedirect(context.Background(), "go.acme.com", "g8")
+		require.NoError(t, err)
+		assert.Equal(t, "https://acme.example", redirect.LongURL)
+		assert.Equal(t, int64(7), redirect.DomainID, "clicks are recorded against the domain")
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("unknown domain", func(t *testing.T) {
+		redisClient, redisMock := redismock.NewClientMock()
+		service := NewService(&MockRepository{}, redisClient, NewSequentialGenerator(0), nil, nil, domains, nil, nil, nil)
+
+		_, err := service.FetchRedirect(context.Background(), "evil.example", "g8")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.NoError(t, redisMock.ExpectationsWereMet())
+	})
+
+	t.Run("custom domains disabled", func(t *testing.T) {
+		service := NewService(&MockRepository{}, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+		_, err := service.FetchRedirect(context.Background(), "go.acme.com", "g8")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestCreateShortCode_Domain(t *testing.T) {
+	owner, workspaceID := int64(1), int64(5)
+	domains := stubDomains{
+		"go.acme.com":   {ID: 7, Hostname: "go.acme.com", UserID: &owner},
+		"team.acme.com": {ID: 8, Hostname: "team.acme.com", UserID: &owner, WorkspaceID: &workspaceID},
+	}
+	editor := &workspace.Member{WorkspaceID: workspaceID, Role: workspace.RoleEditor}
+
+	testCases := []struct {
+		name       string
+		ctx        context.Context
+		domain     string
+		wantDomain int64
+		wantErr    bool
+	}{
+		{name: "personal domain", ctx: withCaller(1, nil), domain: "go.acme.com", wantDomain: 7},
+		{name: "workspace domain", ctx: withCaller(2, editor), domain: "team.acme.com", wantDomain: 8},
+		{name: "someone else's domain", ctx: withCaller(2, nil), domain: "go.acme.com", wantErr: true},
+		{name: "personal domain in workspace scope", ctx: withCaller(1, editor), domain: "go.acme.com", wantErr: true},
+		{name: "anonymous caller", ctx: context.Background(), domain: "go.acme.com", wantErr: true},
+		{name: "unverified domain", ctx: withCaller(1, nil), domain: "new.acme.com", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redisClient, redisMock := redismock.NewClientMock()
+			repo := &MockRepository{
+				CreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+					require.NotNil(t, dest.DomainID)
+					assert.Equal(t, tc.wantDomain, *dest.DomainID)
+					return CreatedLink{ShortCode: "abc"}, nil
+				},
+			}
+			service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, domains, nil, nil, nil)
+
+			if !tc.wantErr {
+				key := fmt.Sprintf("%d/abc", tc.wantDomain)
+				redisMock.ExpectDel("url:" + key).SetVal(0)
+				redisMock.ExpectPublish(invalidationChannel, []byte(`["`+key+`"]`)).SetVal(0)
+			}
+
+			code, err := service.CreateShortCode(tc.ctx, "https://example.com", false, LinkOptions{Domain: tc.domain})
+			if tc.wantErr {
+				assert.IsType(t, &InvalidRequestErr{}, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "abc", code)
+			assert.NoError(t, redisMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateShortCode_UnsafeDestination(t *testing.T) {
+	var created int
+	repo := &MockRepository{
+		FindOrCreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			created++
+			return CreatedLink{ShortCode: "abc"}, nil
+		},
+		FindOrCreateShortCodeBulkFunc: func(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
+			created += len(dests)
+			return nil, nil
+		},
+	}
+	checker := safety.NewBlocklist([]string{"evil.example"}, nil)
+	redisClient, _ := redismock.NewClientMock()
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, checker, nil, nil)
+
+	_, err := service.CreateShortCode(context.Background(), "https://login.evil.example/account", false, LinkOptions{})
+	assert.ErrorAs(t, err, &UnsafeDestination)
+
+	results, err := service.CreateShortCode_Bulk(context.Background(), []string{"https://evil.example/x"}, false)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, BulkItemFailed, results[0].Status)
+	assert.Equal(t, BulkUnsafeDestination, results[0].Code)
+	assert.Zero(t, created)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{
+		RoutingRules: []RoutingRule{{Countries: []string{"ID"}, URL: "https://evil.example/id"}},
+	})
+	assert.ErrorAs(t, err, &UnsafeDestination)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{
+		Variants: []Variant{{URL: "https://example.com/a", Weight: 1}, {URL: "https://evil.example/b", Weight: 1}},
+	})
+	assert.ErrorAs(t, err, &UnsafeDestination)
+	assert.Zero(t, created)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, created)
+}
+
+func TestScanDestinations(t *testing.T) {
+	links := []*URL{
+		{ID: 1, LongURL: "https://example.com", ShortCode: sql.NullString{String: "a", Valid: true}},
+		{ID: 2, LongURL: "https://evil.example/x", ShortCode: sql.NullString{String: "b", Valid: true}},
+		{ID: 3, LongURL: "https://bit.ly/abc", ShortCode: sql.NullString{String: "c", Valid: true}},
+		{ID: 4, LongURL: "https://example.org", ShortCode: sql.NullString{String: "d", Valid: true}, RoutingRules: []RoutingRule{
+			{Countries: []string{"ID"}, URL: "https://evil.example/id"},
+		}},
+	}
+
+	flags := map[int64]string{}
+	repo := &MockRepository{
+		ListActiveAfterFunc: func(ctx context.Context, afterID int64, limit int) ([]*URL, error) {
+			if afterID == 0 {
+				return links, nil
+			}
+			assert.Equal(t, int64(4), afterID)
+			return nil, nil
+		},
+		FlagFunc: func(ctx context.Context, id int64, reason string) error {
+			flags[id] = reason
+			return nil
+		},
+	}
+
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectDel("url:b", "url:c", "url:d").SetVal(3)
+	redisMock.ExpectPublish(invalidationChannel, []byte(`["b","c","d"]`)).SetVal(0)
+
+	checker := safety.NewBlocklist([]string{"evil.example"}, []string{"bit.ly"})
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, checker, nil, nil)
+
+	flagged, err := service.scanDestinations(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, flagged)
+	assert.Equal(t, map[int64]string{2: "blocklisted: evil.example", 3: "shortener: bit.ly", 4: "blocklisted: evil.example"}, flags)
+	assert.NoError(t, redisMock.ExpectationsWereMet())
+}
+
+func TestImportLinks(t *testing.T) {
+	var expiries []*time.Time
+	repo := &MockRepository{
+		FindOrCreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			expiries = append(expiries, dest.Options.ExpiresAt)
+			return CreatedLink{ShortCode: "abc"}, nil
+		},
+		CreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			switch dest.Options.Alias {
+			case "":
+				expiries = append(expiries, dest.Options.ExpiresAt)
+				return CreatedLink{ShortCode: "def"}, nil
+			case "taken":
+				return CreatedLink{}, &AliasTakenErr{alias: "taken"}
+			default:
+				return CreatedLink{ShortCode: dest.Options.Alias}, nil
+			}
+		},
+	}
+	checker := safety.NewBlocklist([]string{"evil.example"}, nil)
+	redisClient, _ := redismock.NewClientMock()
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, checker, nil, nil)
+
+	expiresAt := time.Now().Add(time.Hour)
+	results, err := service.ImportLinks(context.Background(), []models.LinkImportRow{
+		{LongURL: "https://example.com"},
+		{LongURL: "https://example.org", ExpiresAt: &expiresAt},
+		{LongURL: "https://example.net", Alias: "home"},
+		{LongURL: "https://evil.example/x"},
+		{LongURL: "https://example.net", Alias: "taken"},
+		{LongURL: "https://example.net", Alias: "not-a-code"},
+	})
+
+	require.NoError(t, err)
+	require.Len(t, results, 6)
+	assert.Equal(t, CreateShortCodeBulkResult{LongURL: "https://example.com", ShortCode: "abc", Status: BulkItemCreated}, results[0])
+	assert.Equal(t, "def", results[1].ShortCode)
+	assert.Equal(t, CreateShortCodeBulkResult{LongURL: "https://example.net", ShortCode: "home", Status: BulkItemCreated}, results[2])
+	assert.Equal(t, BulkUnsafeDestination, results[3].Code)
+	assert.Equal(t, BulkAliasTaken, results[4].Code)
+	assert.Equal(t, BulkInvalidAlias, results[5].Code)
+	assert.Equal(t, []*time.Time{nil, &expiresAt}, expiries)
+
+	repo.FindOrCreateShortCodeFunc = func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+		return CreatedLink{}, errors.New("db down")
+	}
+	_, err = service.ImportLinks(context.Background(), []models.LinkImportRow{{LongURL: "https://example.com"}})
+	assert.EqualError(t, err, "db down")
+}
+
+type stubClickCounter map[LinkRef]int64
+
+func (s stubClickCounter) ClickTotals(ctx context.Context, links []LinkRef) (map[LinkRef]int64, error) {
+	totals := map[LinkRef]int64{}
+	for _, link := range links {
+		if total, ok := s[link]; ok {
+			totals[link] = total
+		}
+	}
+	return totals, nil
+}
+
+func TestExportLinks(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	links := make([]*URL, exportPageSize+2)
+	for i := range links {
+		links[i] = &URL{ID: int64(i + 1), ShortCode: sql.NullString{String: fmt.Sprint("c", i+1), Valid: true}, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+	}
+	links[1].DomainID = sql.NullInt64{Int64: 4, Valid: true}
+
+	var filters []HistoryFilter
+	repo := &MockRepository{
+		ListHistoryFunc: func(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+			filters = append(filters, filter)
+			var page []*URL
+			for _, link := range links {
+				if filter.After != nil && !link.CreatedAt.After(filter.After.CreatedAt) {
+					continue
+				}
+				if len(page) == filter.Limit {
+					break
+				}
+				page = append(page, link)
+			}
+			return page, nil
+		},
+	}
+	service := NewService(repo, nil, NewSequentialGenerator(0), nil, nil, nil, nil, stubClickCounter{
+		{ShortCode: "c1"}:   3,
+		{ShortCode: "c2"}:   5,
+		{ShortCode: "c502"}: 9,
+	}, nil)
+
+	ctx := context.WithValue(context.Background(), shared.WorkspaceContextKey, &workspace.Member{WorkspaceID: 7, Role: workspace.RoleViewer})
+	var exported []ExportRow
+	err := service.ExportLinks(ctx, 1, func(rows []ExportRow) error {
+		exported = append(exported, rows...)
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Len(t, exported, len(links))
+	assert.Equal(t, int64(3), exported[0].Clicks)
+	assert.Equal(t, int64(0), exported[1].Clicks, "clicks of the same code on another domain do not count")
+	assert.Equal(t, int64(9), exported[len(links)-1].Clicks)
+
+	require.Len(t, filters, 2)
+	assert.True(t, filters[0].Ascending)
+	assert.Equal(t, int64(7), *filters[0].WorkspaceID)
+	assert.Equal(t, int64(exportPageSize), filters[1].After.ID)
+
+	err = service.ExportLinks(ctx, 1, func(rows []ExportRow) error { return errors.New("client gone") })
+	assert.EqualError(t, err, "client gone")
+}
+
+func TestNormalizeDetails(t *testing.T) {
+	testCases := []struct {
+		name    string
+		details LinkDetails
+		want    LinkDetails
+		wantErr string
+	}{
+		{
+			name:    "trimmed with tags lowercased and deduplicated",
+			details: LinkDetails{Title: "  Launch  ", Description: " notes ", Tags: []string{" Blog", "blog", "", "Q3 Launch"}},
+			want:    LinkDetails{Title: "Launch", Description: "notes", Tags: []string{"blog", "q3 launch"}},
+		},
+		{
+			name:    "title too long",
+			details: LinkDetails{Title: strings.Repeat("é", maxTitleLength+1)},
+			wantErr: "title cannot be longer than 200 characters",
+		},
+		{
+			name:    "description too long",
+			details: LinkDetails{Description: strings.Repeat("a", maxDescriptionLength+1)},
+			wantErr: "description cannot be longer than 2000 characters",
+		},
+		{
+			name:    "tag too long",
+			details: LinkDetails{Tags: []string{strings.Repeat("a", maxTagLength+1)}},
+			wantErr: "tags cannot be longer than 50 characters",
+		},
+		{
+			name: "too many tags",
+			details: LinkDetails{Tags: func() []string {
+				var tags []string
+				for i := 0; i <= maxTags; i++ {
+					tags = append(tags, fmt.Sprint("tag", i))
+				}
+				return tags
+			}()},
+			wantErr: "a link cannot have more than 20 tags",
+		},
+		{
+			name:    "open graph overrides trimmed",
+			details: LinkDetails{OpenGraph: OpenGraph{Title: " Card ", ImageURL: " https://cdn.example.com/card.png "}},
+			want:    LinkDetails{OpenGraph: OpenGraph{Title: "Card", ImageURL: "https://cdn.example.com/card.png"}},
+		},
+		{
+			name:    "open graph title too long",
+			details: LinkDetails{OpenGraph: OpenGraph{Title: strings.Repeat("a", maxTitleLength+1)}},
+			wantErr: "og_title cannot be longer than 200 characters",
+		},
+		{
+			name:    "open graph image not a web address",
+			details: LinkDetails{OpenGraph: OpenGraph{ImageURL: "javascript:alert(1)"}},
+			wantErr: "og_image must be an http or https URL",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeDetails(tc.details)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCreateShortCode_Details(t *testing.T) {
+	var stored LinkDetails
+	repo := &MockRepository{
+		CreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			stored = dest.Options.Details
+			return CreatedLink{ShortCode: "abc"}, nil
+		},
+	}
+	redisClient, _ := redismock.NewClientMock()
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, nil, nil, nil)
+
+	// Links with details are never deduplicated, so FindOrCreateShortCode
+	// must not be called.
+	shortCode, err := service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{
+		Details: LinkDetails{Title: " Home ", Tags: []string{"Docs"}},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "abc", shortCode)
+	assert.Equal(t, LinkDetails{Title: "Home", Tags: []string{"docs"}}, stored)
+}
+
+// stubMetadataQueue hands published fetches to the test.
+type stubMetadataQueue chan *models.LinkMetadataFetch
+
+func (q stubMetadataQueue) PublishLinkMetadata(ctx context.Context, fetch *models.LinkMetadataFetch) error {
+	q <- fetch
+	return nil
+}
+
+func TestCreateShortCode_RequestsMetadata(t *testing.T) {
+	// The code was minted by another generator, so it does not decode to the
+	// ID of the link; the repository's ID is what the fetch must carry.
+	repo := &MockRepository{
+		FindOrCreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			return CreatedLink{ID: 42, ShortCode: "legacy"}, nil
+		},
+	}
+	redisClient, _ := redismock.NewClientMock()
+	queue := make(stubMetadataQueue, 1)
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, nil, nil, queue)
+
+	_, err := service.CreateShortCode(context.Background(), "https://example.com/page", false, LinkOptions{})
+	require.NoError(t, err)
+
+	select {
+	case fetch := <-queue:
+		assert.Equal(t, int64(42), fetch.URLID)
+		assert.Equal(t, "https://example.com/page", fetch.LongURL)
+	case <-time.After(time.Second):
+		t.Fatal("no metadata fetch was published")
+	}
+}
+
+func TestCreateShortCode_Bulk_RequestsMetadata(t *testing.T) {
+	repo := &MockRepository{
+		FindOrCreateShortCodeBulkFunc: func(ctx context.Context, dests []Destination, owner Owner) ([]CreateShortCodeBulkResult, error) {
+			return []CreateShortCodeBulkResult{{ID: 42, LongURL: dests[0].LongURL, ShortCode: "legacy", Status: BulkItemCreated}}, nil
+		},
+	}
+	redisClient, _ := redismock.NewClientMock()
+	queue := make(stubMetadataQueue, 1)
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, nil, nil, queue)
+
+	_, err := service.CreateShortCode_Bulk(context.Background(), []string{"https://example.com/page"}, false)
+	require.NoError(t, err)
+
+	select {
+	case fetch := <-queue:
+		assert.Equal(t, int64(42), fetch.URLID)
+	case <-time.After(time.Second):
+		t.Fatal("no metadata fetch was published")
+	}
+}
+
+func TestUpdateLink_Details(t *testing.T) {
+	link := &URL{
+		ID:          1,
+		UserID:      sql.NullInt64{Int64: 1, Valid: true},
+		Status:      StatusFlagged,
+		Title:       sql.NullString{String: "Old title", Valid: true},
+		Description: sql.NullString{String: "Kept", Valid: true},
+	}
+
+	testCases := []struct {
+		name    string
+		req     UpdateURLRequest
+		want    LinkDetails
+		wantErr error
+	}{
+		{
+			name: "title only keeps the other details",
+			req:  UpdateURLRequest{Title: ptr("New title")},
+			want: LinkDetails{Title: "New title", Description: "Kept", Tags: []string{"docs"}},
+		},
+		{
+			name: "tags replaced",
+			req:  UpdateURLRequest{Tags: &[]string{"Blog"}},
+			want: LinkDetails{Title: "Old title", Description: "Kept", Tags: []string{"blog"}},
+		},
+		{
+			name: "details cleared",
+			req:  UpdateURLRequest{Title: ptr(""), Description: ptr(""), Tags: &[]string{}},
+			want: LinkDetails{},
+		},
+		{name: "nothing to update", req: UpdateURLRequest{}, wantErr: InvalidRequest},
+		{name: "invalid details", req: UpdateURLRequest{Title: ptr(strings.Repeat("a", maxTitleLength+1))}, wantErr: InvalidRequest},
+		{name: "status of a flagged link", req: UpdateURLRequest{Status: StatusActive, Title: ptr("x")}, wantErr: LinkFlagged},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var updated *LinkDetails
+			repo := &MockRepository{
+				GetByLinkFunc: func(ctx context.Context, ref LinkRef) (*URL, error) { return link, nil },
+				ListTagsFunc: func(ctx context.Context, ids []int64) (map[int64][]string, error) {
+					return map[int64][]string{1: {"docs"}}, nil
+				},
+				UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+					updated = changes.Details
+					return nil
+				},
+			}
+			service := NewService(repo, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+			err := service.UpdateLink(withCaller(1, nil), "", "abc", tc.req)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Nil(t, updated)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, updated)
+			assert.Equal(t, tc.want.Title, updated.Title)
+			assert.Equal(t, tc.want.Description, updated.Description)
+			assert.ElementsMatch(t, tc.want.Tags, updated.Tags)
+		})
+	}
+}
+
+func TestFetchUserURLHistory_Tags(t *testing.T) {
+	repo := &MockRepository{
+		ListHistoryFunc: func(ctx context.Context, filter HistoryFilter) ([]*URL, error) {
+			assert.Equal(t, []string{"docs", "q3"}, filter.Tags)
+			return []*URL{{ID: 1}, {ID: 2}}, nil
+		},
+		ListTagsFunc: func(ctx context.Context, ids []int64) (map[int64][]string, error) {
+			assert.Equal(t, []int64{1, 2}, ids)
+			return map[int64][]string{1: {"docs", "q3"}}, nil
+		},
+	}
+	service := NewService(repo, nil, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	page, err := service.FetchUserURLHistory(context.Background(), 1, HistoryQuery{Tags: []string{"Docs", " q3 ", "docs"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs", "q3"}, page.Data[0].Tags)
+	assert.Nil(t, page.Data[1].Tags)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestFetchPreview(t *testing.T) {
+	codes := NewSequentialGenerator(0)
+	shortCode, err := codes.Encode(7)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		link     *URL
+		metadata *LinkMetadata
+		want     *Preview
+		wantErr  error
+	}{
+		{
+			name: "overrides win over fetched metadata",
+			link: &URL{
+				ID:            7,
+				LongURL:       "https://example.com/post",
+				Status:        StatusActive,
+				OGTitle:       sql.NullString{String: "Custom card", Valid: true},
+				OGDescription: sql.NullString{String: "", Valid: true},
+			},
+			metadata: &LinkMetadata{Title: "Page title", Description: "Page description", ImageURL: "https://example.com/og.png", FaviconURL: "https://example.com/favicon.ico"},
+			want: &Preview{
+				ShortCode:   shortCode,
+				LongURL:     "https://example.com/post",
+				Title:       "Custom card",
+				Description: "Page description",
+				ImageURL:    "https://example.com/og.png",
+				FaviconURL:  "https://example.com/favicon.ico",
+			},
+		},
+		{
+			name: "nothing fetched yet",
+			link: &URL{ID: 7, LongURL: "https://example.com/post", Status: StatusActive},
+			want: &Preview{ShortCode: shortCode, LongURL: "https://example.com/post"},
+		},
+		{
+			name:    "disabled link",
+			link:    &URL{ID: 7, Status: StatusDisabled},
+			wantErr: LinkDisabled,
+		},
+		{
+			name:    "expired link",
+			link:    &URL{ID: 7, Status: StatusActive, ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}},
+			wantErr: LinkExpired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepository{
+				GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) {
+					assert.Equal(t, LinkRef{ShortCode: shortCode}, link)
+					return tc.link, nil
+				},
+			}
+			if tc.metadata != nil {
+				repo.GetMetadataFunc = func(ctx context.Context, id int64) (*LinkMetadata, error) {
+					assert.Equal(t, int64(7), id)
+					return tc.metadata, nil
+				}
+			}
+			service := NewService(repo, nil, codes, nil, nil, nil, nil, nil, nil)
+
+			preview, err := service.FetchPreview(context.Background(), "", shortCode)
+
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, preview)
+		})
+	}
+}
+
+func TestCreateShortCode_Password(t *testing.T) {
+	var stored string
+	repo := &MockRepository{
+		CreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			stored = dest.PasswordHash
+			return CreatedLink{ShortCode: "abc"}, nil
+		},
+	}
+	redisClient, _ := redismock.NewClientMock()
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, nil, nil, nil)
+
+	// Protected links are never deduplicated, so FindOrCreateShortCode must
+	// not be called.
+	_, err := service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{Password: "hunter22"})
+	require.NoError(t, err)
+
+	assert.NotEqual(t, "hunter22", stored, "only the hash may be stored")
+	matched, err := auth.CheckPassword(stored, "hunter22")
+	require.NoError(t, err)
+	assert.True(t, matched)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{Password: "abc"})
+	assert.IsType(t, InvalidRequest, err)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{Password: strings.Repeat("a", auth.MaxPasswordBytes+1)})
+	assert.IsType(t, InvalidRequest, err)
+}
+
+func TestUnlockLink(t *testing.T) {
+	codes := NewSequentialGenerator(0)
+	shortCode, err := codes.Encode(7)
+	require.NoError(t, err)
+
+	hash, err := auth.HashPassword("hunter22")
+	require.NoError(t, err)
+	protected := sql.NullString{String: hash, Valid: true}
+
+	testCases := []struct {
+		name     string
+		link     *URL
+		password string
+		wantErr  error
+	}{
+		{name: "correct password", link: &URL{ID: 7, Status: StatusActive, PasswordHash: protected}, password: "hunter22"},
+		{name: "incorrect password", link: &URL{ID: 7, Status: StatusActive, PasswordHash: protected}, password: "hunter23", wantErr: IncorrectPassword},
+		{name: "link without a password", link: &URL{ID: 7, Status: StatusActive}, password: "anything"},
+		{name: "disabled link", link: &URL{ID: 7, Status: StatusDisabled, PasswordHash: protected}, password: "hunter22", wantErr: LinkDisabled},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &MockRepository{
+				GetByLinkFunc: func(ctx context.Context, link LinkRef) (*URL, error) {
+					assert.Equal(t, LinkRef{ShortCode: shortCode}, link)
+					return tc.link, nil
+				},
+			}
+			service := NewService(repo, nil, codes, nil, nil, nil, nil, nil, nil)
+
+			stamp, err := service.UnlockLink(context.Background(), "", shortCode, tc.password)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.link.passwordStamp(), stamp)
+		})
+	}
+}
+
+func TestPasswordStamp(t *testing.T) {
+	first, err := auth.HashPassword("hunter22")
+	require.NoError(t, err)
+	again, err := auth.HashPassword("hunter22")
+	require.NoError(t, err)
+
+	link := &URL{PasswordHash: sql.NullString{String: first, Valid: true}}
+	stamp := link.passwordStamp()
+	assert.NotEmpty(t, stamp)
+	assert.NotContains(t, stamp, first)
+
+	link.PasswordHash.String = again
+	assert.NotEqual(t, stamp, link.passwordStamp(), "setting the password again revokes access")
+
+	assert.Empty(t, (&URL{}).passwordStamp())
+}
+
+func TestUpdateLink_Password(t *testing.T) {
+	link := &URL{ID: 1, UserID: sql.NullInt64{Int64: 1, Valid: true}, Status: StatusActive}
+
+	testCases := []struct {
+		name     string
+		password string
+		wantHash bool
+		wantErr  error
+	}{
+		{name: "set password", password: "hunter22", wantHash: true},
+		{name: "remove password", password: ""},
+		{name: "password too short", password: "abc", wantErr: InvalidRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stored *string
+			repo := &MockRepository{
+				GetByLinkFunc: func(ctx context.Context, ref LinkRef) (*URL, error) { return link, nil },
+				UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+					stored = changes.PasswordHash
+					return nil
+				},
+			}
+			redisClient, redisMock := redismock.NewClientMock()
+			if tc.wantErr == nil {
+				redisMock.ExpectDel("url:abc").SetVal(1)
+				redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+			}
+			service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+			err := service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{Password: ptr(tc.password)})
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Nil(t, stored)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, stored)
+			if tc.wantHash {
+				matched, err := auth.CheckPassword(*stored, tc.password)
+				require.NoError(t, err)
+				assert.True(t, matched)
+			} else {
+				assert.Empty(t, *stored)
+			}
+			assert.NoError(t, redisMock.ExpectationsWereMet(), "a cached redirect must learn the link is protected")
+		})
+	}
+}
+
+func TestUpdateLink_RoutingRules(t *testing.T) {
+	link := &URL{ID: 1, UserID: sql.NullInt64{Int64: 1, Valid: true}, Status: StatusActive, LongURL: "https://example.com"}
+
+	testCases := []struct {
+		name    string
+		rules   []RoutingRule
+		want    []RoutingRule
+		wantErr error
+	}{
+		{
+			name:  "set rules",
+			rules: []RoutingRule{{Countries: []string{"id"}, URL: "https://example.co.id"}},
+			want:  []RoutingRule{{Countries: []string{"ID"}, URL: "https://example.co.id"}},
+		},
+		{name: "remove rules", rules: []RoutingRule{}},
+		{name: "invalid rule", rules: []RoutingRule{{URL: "https://example.co.id"}}, wantErr: InvalidRequest},
+		{name: "unsafe destination", rules: []RoutingRule{{Countries: []string{"ID"}, URL: "https://evil.example/id"}}, wantErr: UnsafeDestination},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stored *[]RoutingRule
+			repo := &MockRepository{
+				GetByLinkFunc: func(ctx context.Context, ref LinkRef) (*URL, error) { return link, nil },
+				UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+					stored = changes.RoutingRules
+					return nil
+				},
+			}
+			redisClient, redisMock := redismock.NewClientMock()
+			if tc.wantErr == nil {
+				redisMock.ExpectDel("url:abc").SetVal(1)
+				redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+			}
+			checker := safety.NewBlocklist([]string{"evil.example"}, nil)
+			service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, checker, nil, nil)
+
+			err := service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{RoutingRules: &tc.rules})
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Nil(t, stored)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, stored)
+			assert.Equal(t, tc.want, *stored)
+			assert.NoError(t, redisMock.ExpectationsWereMet(), "cached redirects must pick up the new rules")
+		})
+	}
+}
+
+func TestUpdateLink_Variants(t *testing.T) {
+	link := &URL{ID: 1, UserID: sql.NullInt64{Int64: 1, Valid: true}, Status: StatusActive, LongURL: "https://example.com"}
+
+	var stored []Variant
+	repo := &MockRepository{
+		GetByLinkFunc: func(ctx context.Context, ref LinkRef) (*URL, error) { return link, nil },
+		UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+			stored = *changes.Variants
+			return nil
+		},
+	}
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectDel("url:abc").SetVal(1)
+	redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	err := service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{Variants: &[]Variant{
+		{URL: "https://example.com/a", Weight: 70},
+		{URL: "https://example.com/b", Weight: 30},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []Variant{
+		{Name: "a", URL: "https://example.com/a", Weight: 70},
+		{Name: "b", URL: "https://example.com/b", Weight: 30},
+	}, stored)
+	assert.NoError(t, redisMock.ExpectationsWereMet(), "cached redirects must pick up the new variants")
+
+	err = service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{Variants: &[]Variant{{URL: "https://example.com/a", Weight: 1}}})
+	assert.IsType(t, InvalidRequest, err)
+}
+
+func TestCreateShortCode_UTM(t *testing.T) {
+	var stored Destination
+	repo := &MockRepository{
+		CreateShortCodeFunc: func(ctx context.Context, dest Destination, owner Owner) (CreatedLink, error) {
+			stored = dest
+			return CreatedLink{ShortCode: "abc"}, nil
+		},
+	}
+	redisClient, _ := redismock.NewClientMock()
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, NewCanonicalizer(nil), nil, nil, nil, nil)
+
+	// Tagged links are never deduplicated, so FindOrCreateShortCode must not
+	// be called.
+	shortCode, err := service.CreateShortCode(context.Background(), "https://example.com/?ref=home&utm_source=old", false, LinkOptions{
+		UTM: UTM{Source: " newsletter ", Campaign: "spring sale"},
+		RoutingRules: []RoutingRule{
+			{Countries: []string{"ID"}, URL: "https://example.com/id"},
+		},
+		ForwardQuery: true,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "abc", shortCode)
+	assert.Equal(t, "https://example.com/?ref=home&utm_source=newsletter&utm_campaign=spring+sale", stored.LongURL)
+	assert.Equal(t, "https://example.com/id?utm_source=newsletter&utm_campaign=spring+sale", stored.Options.RoutingRules[0].URL)
+	assert.True(t, stored.Options.ForwardQuery)
+
+	_, err = service.CreateShortCode(context.Background(), "https://example.com", false, LinkOptions{
+		UTM: UTM{Term: strings.Repeat("a", maxUTMLength+1)},
+	})
+	assert.IsType(t, InvalidRequest, err)
+}
+
+func TestUpdateLink_ForwardQuery(t *testing.T) {
+	link := &URL{ID: 1, UserID: sql.NullInt64{Int64: 1, Valid: true}, Status: StatusActive, LongURL: "https://example.com"}
+
+	var stored *bool
+	repo := &MockRepository{
+		GetByLinkFunc: func(ctx context.Context, ref LinkRef) (*URL, error) { return link, nil },
+		UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+			stored = changes.ForwardQuery
+			return nil
+		},
+	}
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectDel("url:abc").SetVal(1)
+	redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	forward := true
+	err := service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{ForwardQuery: &forward})
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.True(t, *stored)
+	assert.NoError(t, redisMock.ExpectationsWereMet(), "cached redirects must pick up the new setting")
+}
+
+func TestUpdateLink_Together(t *testing.T) {
+	link := &URL{ID: 1, UserID: sql.NullInt64{Int64: 1, Valid: true}, Status: StatusActive, LongURL: "https://example.com"}
+
+	var writes []LinkChanges
+	repo := &MockRepository{
+		GetByLinkFunc: func(ctx context.Context, ref LinkRef) (*URL, error) { return link, nil },
+		UpdateFunc: func(ctx context.Context, id int64, changes LinkChanges) error {
+			writes = append(writes, changes)
+			return nil
+		},
+	}
+	redisClient, redisMock := redismock.NewClientMock()
+	redisMock.ExpectDel("url:abc").SetVal(1)
+	redisMock.ExpectPublish(invalidationChannel, []byte(`["abc"]`)).SetVal(0)
+	service := NewService(repo, redisClient, NewSequentialGenerator(0), nil, nil, nil, nil, nil, nil)
+
+	err := service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{
+		Status:       StatusDisabled,
+		Title:        ptr("Launch"),
+		Password:     ptr(""),
+		ForwardQuery: ptr(true),
+	})
+	require.NoError(t, err)
+	require.Len(t, writes, 1, "all changes are written at once")
+	assert.Equal(t, StatusDisabled, *writes[0].Status)
+	assert.Equal(t, "Launch", writes[0].Details.Title)
+	assert.Empty(t, *writes[0].PasswordHash)
+	assert.True(t, *writes[0].ForwardQuery)
+	assert.Nil(t, writes[0].RoutingRules)
+	assert.NoError(t, redisMock.ExpectationsWereMet(), "the cache is invalidated once")
+
+	writes = nil
+	repo.UpdateFunc = func(ctx context.Context, id int64, changes LinkChanges) error {
+		return errors.New("boom")
+	}
+	err = service.UpdateLink(withCaller(1, nil), "", "abc", UpdateURLRequest{Status: StatusActive})
+	assert.Error(t, err)
+	assert.NoError(t, redisMock.ExpectationsWereMet(), "nothing is invalidated when the write fails")
+}
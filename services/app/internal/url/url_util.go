@@ -1,7 +1,7 @@
 package url
 
 import (
-	"math"
+	"math/bits"
 	"strings"
 )
 
@@ -25,14 +25,29 @@ func toBase62(urlId uint64) string {
 	return reverse(sb.String())
 }
 
-func FromBase62(shortCode string) uint64 {
+// FromBase62 decodes a base62 short code. It rejects empty codes, characters
+// outside the alphabet and values that do not fit in a uint64.
+func FromBase62(shortCode string) (uint64, error) {
+	if shortCode == "" {
+		return 0, &InvalidShortCodeErr{shortCode: shortCode, reason: "empty"}
+	}
+
 	var n uint64
-	for i, char := range shortCode {
-		power := len(shortCode) - (i + 1)
-		pos := strings.IndexRune(base62Chars, char)
-		n += uint64(pos) * uint64(math.Pow(float64(base), float64(power)))
+	for i := 0; i < len(shortCode); i++ {
+		pos := strings.IndexByte(base62Chars, shortCode[i])
+		if pos < 0 {
+			return 0, &InvalidShortCodeErr{shortCode: shortCode, reason: "invalid character"}
+		}
+
+		hi, lo := bits.Mul64(n, base)
+		sum, carry := bits.Add64(lo, uint64(pos), 0)
+		if hi != 0 || carry != 0 {
+			return 0, &InvalidShortCodeErr{shortCode: shortCode, reason: "overflow"}
+		}
+		n = sum
 	}
-	return n
+
+	return n, nil
 }
 
 func reverse(s string) string {
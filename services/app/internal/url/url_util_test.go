@@ -0,0 +1,79 @@
+package url
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromBase62(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "zero", input: "0", want: 0},
+		{name: "single digit", input: "Z", want: 61},
+		{name: "two digits", input: "10", want: 62},
+		{name: "max uint64", input: toBase62(math.MaxUint64), want: math.MaxUint64},
+		{name: "empty", input: "", wantErr: true},
+		{name: "invalid character", input: "ab-c", wantErr: true},
+		{name: "non-ASCII character", input: "abé", wantErr: true},
+		{name: "overflow", input: "zzzzzzzzzzzz", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := FromBase62(tc.input)
+			if tc.wantErr {
+				assert.ErrorAs(t, err, &InvalidShortCode)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func FuzzBase62RoundTrip(f *testing.F) {
+	for _, seed := range []uint64{0, 1, 61, 62, 1000000000000, math.MaxUint64} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, n uint64) {
+		got, err := FromBase62(toBase62(n))
+		if err != nil {
+			t.Fatalf("decoding %q: %v", toBase62(n), err)
+		}
+		if got != n {
+			t.Fatalf("round trip of %d gave %d", n, got)
+		}
+	})
+}
+
+func FuzzFromBase62(f *testing.F) {
+	for _, seed := range []string{"", "0", "g8", "zzzzzzzzzzzz", "ab-c", "\xff"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, code string) {
+		n, err := FromBase62(code)
+		if err != nil {
+			return
+		}
+
+		// Valid codes re-encode to themselves, minus any leading zeros.
+		want := strings.TrimLeft(code, "0")
+		if want == "" {
+			want = "0"
+		}
+		if got := toBase62(n); got != want {
+			t.Fatalf("%q decoded to %d, which encodes as %q", code, n, got)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package url
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxVariants      = 10
+	maxVariantWeight = 10000
+)
+
+var variantNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Variant is one of the destinations a split link sends its visitors to.
+// Visitors are spread across variants in proportion to their Weight, and
+// keep going to the same one.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// pickVariant returns the variant of r that v goes to, or nil when r is not
+// split. Visitors who already have a variant keep it while it exists; the
+// others are placed by their key, so the same key always lands on the same
+// variant for as long as the weights stay the same.
+func (r *Redirect) pickVariant(v Visitor) *Variant {
+	if len(r.Variants) == 0 {
+		return nil
+	}
+
+	total := 0
+	for i := range r.Variants {
+		if v.Variant != "" && r.Variants[i].Name == v.Variant {
+			return &r.Variants[i]
+		}
+		total += r.Variants[i].Weight
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(v.Key))
+	point := h.Sum64() % uint64(total)
+
+	for i := range r.Variants {
+		weight := uint64(r.Variants[i].Weight)
+		if point < weight {
+			return &r.Variants[i]
+		}
+		point -= weight
+	}
+
+	return &r.Variants[len(r.Variants)-1]
+}
+
+// normalizeVariants checks the variants of a split link and names those
+// without a name after their position: a, b, c and so on.
+func normalizeVariants(variants []Variant) ([]Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+
+	if len(variants) < 2 {
+		return nil, &InvalidRequestErr{reason: "a split link needs at least 2 variants"}
+	}
+
+	if len(variants) > maxVariants {
+		return nil, &InvalidRequestErr{reason: fmt.Sprintf("a link cannot have more than %d variants", maxVariants)}
+	}
+
+	normalized := make([]Variant, len(variants))
+	seen := make(map[string]bool)
+	for i, variant := range variants {
+		position := i + 1
+
+		variant.Name = strings.ToLower(strings.TrimSpace(variant.Name))
+		if variant.Name == "" {
+			variant.Name = defaultVariantName(i)
+		}
+		if !variantNamePattern.MatchString(variant.Name) {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("variant %d name must be up to 32 letters, digits, dashes or underscores", position)}
+		}
+		if seen[variant.Name] {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("variant name %q is used more than once", variant.Name)}
+		}
+		seen[variant.Name] = true
+
+		variant.URL = strings.TrimSpace(variant.URL)
+		if err := checkDestinationURL(variant.URL, fmt.Sprintf("variant %d url", position)); err != nil {
+			return nil, err
+		}
+
+		if variant.Weight < 1 || variant.Weight > maxVariantWeight {
+			return nil, &InvalidRequestErr{reason: fmt.Sprintf("variant %d weight must be between 1 and %d", position, maxVariantWeight)}
+		}
+
+		normalized[i] = variant
+	}
+
+	return normalized, nil
+}
+
+// defaultVariantName names the variant at index i. There are never more
+// variants than letters.
+func defaultVariantName(i int) string {
+	return string(rune('a' + i))
+}
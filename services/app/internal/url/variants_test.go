@@ -0,0 +1,106 @@
+package url
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectDestination_Variants(t *testing.T) {
+	redirect := &Redirect{
+		LongURL: "https://example.com",
+		Rules:   []RoutingRule{{Countries: []string{"ID"}, URL: "https://example.co.id"}},
+		Variants: []Variant{
+			{Name: "a", URL: "https://example.com/a", Weight: 70},
+			{Name: "b", URL: "https://example.com/b", Weight: 30},
+		},
+	}
+
+	t.Run("routing rules come first", func(t *testing.T) {
+		destination, variant := redirect.Destination(Visitor{Country: "ID", Variant: "b"})
+		assert.Equal(t, "https://example.co.id", destination)
+		assert.Empty(t, variant)
+	})
+
+	t.Run("visitors keep their variant", func(t *testing.T) {
+		destination, variant := redirect.Destination(Visitor{Variant: "b", Key: "anyone"})
+		assert.Equal(t, "https://example.com/b", destination)
+		assert.Equal(t, "b", variant)
+	})
+
+	t.Run("the same key always gets the same variant", func(t *testing.T) {
+		first, firstVariant := redirect.Destination(Visitor{Key: "visitor-1"})
+		for range 10 {
+			destination, variant := redirect.Destination(Visitor{Key: "visitor-1"})
+			assert.Equal(t, first, destination)
+			assert.Equal(t, firstVariant, variant)
+		}
+	})
+
+	t.Run("variants that no longer exist are picked again", func(t *testing.T) {
+		_, variant := redirect.Destination(Visitor{Variant: "c", Key: "visitor-1"})
+		_, want := redirect.Destination(Visitor{Key: "visitor-1"})
+		assert.Equal(t, want, variant)
+	})
+
+	t.Run("visitors are split by weight", func(t *testing.T) {
+		counts := map[string]int{}
+		for i := range 10000 {
+			_, variant := redirect.Destination(Visitor{Key: fmt.Sprintf("sho.rt\x00abc\x00203.0.113.%d\x00agent-%d", i%256, i)})
+			counts[variant]++
+		}
+		assert.InDelta(t, 7000, counts["a"], 300)
+		assert.InDelta(t, 3000, counts["b"], 300)
+	})
+}
+
+func TestNormalizeVariants(t *testing.T) {
+	tooMany := make([]Variant, maxVariants+1)
+	for i := range tooMany {
+		tooMany[i] = Variant{URL: "https://example.com", Weight: 1}
+	}
+
+	testCases := []struct {
+		name     string
+		variants []Variant
+		want     []Variant
+		wantErr  error
+	}{
+		{name: "no variants"},
+		{
+			name: "unnamed variants are named after their position",
+			variants: []Variant{
+				{URL: " https://example.com/a ", Weight: 70},
+				{Name: " Control ", URL: "https://example.com/b", Weight: 30},
+				{URL: "https://example.com/c", Weight: 5},
+			},
+			want: []Variant{
+				{Name: "a", URL: "https://example.com/a", Weight: 70},
+				{Name: "control", URL: "https://example.com/b", Weight: 30},
+				{Name: "c", URL: "https://example.com/c", Weight: 5},
+			},
+		},
+		{name: "a single variant", variants: []Variant{{URL: "https://example.com", Weight: 1}}, wantErr: InvalidRequest},
+		{name: "too many variants", variants: tooMany, wantErr: InvalidRequest},
+		{name: "duplicate names", variants: []Variant{{Name: "x", URL: "https://example.com", Weight: 1}, {Name: "X", URL: "https://example.org", Weight: 1}}, wantErr: InvalidRequest},
+		{name: "invalid name", variants: []Variant{{Name: "landing page", URL: "https://example.com", Weight: 1}, {URL: "https://example.org", Weight: 1}}, wantErr: InvalidRequest},
+		{name: "url that is not a web address", variants: []Variant{{URL: "ftp://example.com", Weight: 1}, {URL: "https://example.org", Weight: 1}}, wantErr: InvalidRequest},
+		{name: "zero weight", variants: []Variant{{URL: "https://example.com"}, {URL: "https://example.org", Weight: 1}}, wantErr: InvalidRequest},
+		{name: "weight too large", variants: []Variant{{URL: "https://example.com", Weight: maxVariantWeight + 1}, {URL: "https://example.org", Weight: 1}}, wantErr: InvalidRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := normalizeVariants(tc.variants)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
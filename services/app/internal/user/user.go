@@ -3,12 +3,29 @@ package user
 import "time"
 
 type User struct {
-	Id         int       `json:"id"`
-	Email      string    `json:"email"`
-	Password   string    `json:"-"`
-	Created_at time.Time `json:"created_at"`
+	Id           int       `json:"id"`
+	Email        string    `json:"email"`
+	DisplayName  *string   `json:"display_name"`
+	Password     string    `json:"-"`
+	TokenVersion int       `json:"-"`
+	Created_at   time.Time `json:"created_at"`
+
+	TOTPSecret       string `json:"-"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
 }
 
+// LinkPolicy decides what happens to a user's links when the account is deleted.
+type LinkPolicy string
+
+const (
+	// LinkPolicyKeep leaves the links resolving, detached from any owner.
+	LinkPolicyKeep LinkPolicy = "keep"
+	// LinkPolicyDisable keeps the rows but stops them from resolving.
+	LinkPolicyDisable LinkPolicy = "disable"
+	// LinkPolicyDelete removes the links and, downstream, their click analytics.
+	LinkPolicyDelete LinkPolicy = "delete"
+)
+
 type RegisterRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
@@ -19,6 +36,89 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// LoginResponse carries either a session token or, when the account has
+// two-factor authentication enabled, a short-lived challenge token to be
+// redeemed together with a code. Single sign-on logins matching an existing
+// account by email get a link token instead, redeemed with its password.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token             string `json:"token,omitempty"`
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	LinkRequired      bool   `json:"link_required,omitempty"`
+	LinkToken         string `json:"link_token,omitempty"`
+}
+
+type LinkIdentityRequest struct {
+	LinkToken string `json:"link_token"`
+	Password  string `json:"password"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode []byte `json:"qr_code"`
+}
+
+type ConfirmTwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorConfirmation struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type UpdateProfileRequest struct {
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	// CurrentPassword, Code and SignedInAt confirm an email change the same
+	// way they confirm an account deletion.
+	CurrentPassword string    `json:"current_password"`
+	Code            string    `json:"code"`
+	SignedInAt      time.Time `json:"-"`
+}
+
+// ProfileUpdate is the updated profile. Token replaces the caller's session
+// when the email changed, since every earlier token was revoked.
+type ProfileUpdate struct {
+	*User
+	Token string `json:"token,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string     `json:"password"`
+	Links    LinkPolicy `json:"links"`
+	// Code confirms the deletion of a password-less account with two-factor
+	// authentication.
+	Code string `json:"code"`
+	// SignedInAt is when the session making the request signed in. It
+	// confirms the deletion of other password-less accounts.
+	SignedInAt time.Time `json:"-"`
+}
+
+// DeleteAccountResult lists the links touched by the link policy so callers
+// can evict them from caches and analytics.
+type DeleteAccountResult struct {
+	Policy LinkPolicy
+	Links  []LinkRef
+}
+
+// LinkRef identifies a link. DomainID is nil for links on the default domain.
+type LinkRef struct {
+	ShortCode string
+	DomainID  *int64
 }
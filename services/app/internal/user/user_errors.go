@@ -6,6 +6,11 @@ var EmailAlreadyExists = &EmailAlreadyExistsErr{}
 var UserNotFound = &UserNotFoundErr{}
 var InvalidCredentials = &InvalidCredentialErr{}
 var UnexpectedError = &UnexpectedErr{}
+var InvalidRequest = &InvalidRequestErr{}
+var UnverifiedEmail = &UnverifiedEmailErr{}
+var InvalidTwoFactorCode = &InvalidTwoFactorCodeErr{}
+var ReauthenticationRequired = &ReauthenticationRequiredErr{}
+var TooManyTwoFactorAttempts = &TooManyTwoFactorAttemptsErr{}
 
 type EmailAlreadyExistsErr struct {
 	email string
@@ -41,3 +46,40 @@ func (e *UnexpectedErr) Error() string {
 	slog.Error("unexpected error has occured", "action", e.action, "error", e.err)
 	return "Unexpected error has occured"
 }
+
+type InvalidRequestErr struct {
+	reason string
+}
+
+func (e *InvalidRequestErr) Error() string {
+	return e.reason
+}
+
+type UnverifiedEmailErr struct {
+	email string
+}
+
+func (e *UnverifiedEmailErr) Error() string {
+	slog.Error("Identity provider did not verify the email", "email", e.email)
+	return "Email address is not verified by the identity provider"
+}
+
+type InvalidTwoFactorCodeErr struct{}
+
+func (e *InvalidTwoFactorCodeErr) Error() string {
+	slog.Error("Two-factor code rejected")
+	return "Invalid two-factor code"
+}
+
+type TooManyTwoFactorAttemptsErr struct{}
+
+func (e *TooManyTwoFactorAttemptsErr) Error() string {
+	slog.Warn("Two-factor attempts exhausted")
+	return "Too many two-factor attempts, try again later"
+}
+
+type ReauthenticationRequiredErr struct{}
+
+func (e *ReauthenticationRequiredErr) Error() string {
+	return "Sign in again to confirm this change"
+}
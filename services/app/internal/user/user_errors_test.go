@@ -32,6 +32,16 @@ func TestErrors(t *testing.T) {
 			err:         UnexpectedError,
 			wantErrBody: "Unexpected error has occured",
 		},
+		{
+			name:        "Reauthentication required",
+			err:         ReauthenticationRequired,
+			wantErrBody: "Sign in again to confirm this change",
+		},
+		{
+			name:        "Too many two-factor attempts",
+			err:         TooManyTwoFactorAttempts,
+			wantErrBody: "Too many two-factor attempts, try again later",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 
 	"hafiztri123/app-link-shortener/internal/utils"
 
@@ -13,6 +14,18 @@ import (
 type UserRepository interface {
 	Insert(ctx context.Context, email string, password string) error
 	GetByEmail(ctx context.Context, email string) (*User, error)
+	GetByID(ctx context.Context, id int64) (*User, error)
+	Update(ctx context.Context, id int64, email string, displayName *string) (*User, error)
+	UpdatePassword(ctx context.Context, id int64, password string) (int, error)
+	Delete(ctx context.Context, id int64, policy LinkPolicy) ([]LinkRef, error)
+	GetByIdentity(ctx context.Context, issuer string, subject string) (*User, error)
+	LinkIdentity(ctx context.Context, id int64, issuer string, subject string) error
+	InsertWithIdentity(ctx context.Context, email string, issuer string, subject string) (*User, error)
+	SetTOTPSecret(ctx context.Context, id int64, secret string) error
+	EnableTOTP(ctx context.Context, id int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, id int64) error
+	ConsumeTOTPStep(ctx context.Context, id int64, step int64) (bool, error)
+	ConsumeRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error)
 }
 
 type Repository struct {
@@ -25,6 +38,40 @@ func NewRepository(db *sql.DB) *Repository {
 	}
 }
 
+const userColumns = `id, email, display_name, password, token_version, created_at, totp_secret, totp_enabled`
+
+const prefixedUserColumns = `u.id, u.email, u.display_name, u.password, u.token_version, u.created_at, u.totp_secret, u.totp_enabled`
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var user User
+	var displayName sql.NullString
+	var password sql.NullString
+	var totpSecret sql.NullString
+
+	err := row.Scan(
+		&user.Id,
+		&user.Email,
+		&displayName,
+		&password,
+		&user.TokenVersion,
+		&user.Created_at,
+		&totpSecret,
+		&user.TwoFactorEnabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if displayName.Valid {
+		user.DisplayName = &displayName.String
+	}
+
+	user.Password = password.String
+	user.TOTPSecret = totpSecret.String
+
+	return &user, nil
+}
+
 func (r *Repository) Insert(ctx context.Context, email string, password string) error {
 	insertQuery := `INSERT INTO users (email, password) VALUES ($1, $2)`
 
@@ -41,25 +88,401 @@ func (r *Repository) Insert(ctx context.Context, email string, password string)
 }
 
 func (r *Repository) GetByEmail(ctx context.Context, email string) (*User, error) {
-	getQuery := `SELECT id, email, password, created_at FROM users WHERE email = $1`
+	getQuery := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
 
-	var user User
+	user, err := scanUser(r.db.QueryRowContext(ctx, getQuery, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &InvalidCredentialErr{}
 
-	err := r.db.QueryRowContext(ctx, getQuery, email).Scan(
-		&user.Id,
-		&user.Email,
-		&user.Password,
-		&user.Created_at,
-	)
+		}
 
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *Repository) GetByID(ctx context.Context, id int64) (*User, error) {
+	getQuery := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, getQuery, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, &InvalidCredentialErr{}
+			return nil, &UserNotFoundErr{}
+		}
 
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *Repository) Update(ctx context.Context, id int64, email string, displayName *string) (*User, error) {
+	// Tokens name the email they were issued for, so changing it bumps
+	// token_version like a password change does.
+	updateQuery := `UPDATE users SET email = $1, display_name = $2,
+		token_version = CASE WHEN email = $3 THEN token_version ELSE token_version + 1 END
+		WHERE id = $4 RETURNING ` + userColumns
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, updateQuery, email, displayName, email, id))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_UNIQUE_CONSRAINT_VIOLATION_CODE {
+			return nil, &EmailAlreadyExistsErr{email: email}
+		}
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &UserNotFoundErr{}
 		}
 
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// UpdatePassword stores the new hash and bumps token_version, which
+// invalidates every token issued before the change. It returns the new version.
+func (r *Repository) UpdatePassword(ctx context.Context, id int64, password string) (int, error) {
+	updateQuery := `UPDATE users SET password = $1, token_version = token_version + 1 WHERE id = $2 RETURNING token_version`
+
+	var tokenVersion int
+	err := r.db.QueryRowContext(ctx, updateQuery, password, id).Scan(&tokenVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, &UserNotFoundErr{}
+		}
+
+		return 0, err
+	}
+
+	return tokenVersion, nil
+}
+
+// Delete removes the user and applies the link policy to their personal urls
+// in the same transaction. Workspace links belong to the workspace and are
+// left alone, unless the user was its last member: such workspaces are
+// deleted and their links go with the user's. Workspaces the user was the
+// only owner of pass to their highest-ranked remaining member. It returns the
+// links the policy touched.
+func (r *Repository) Delete(ctx context.Context, id int64, policy LinkPolicy) ([]LinkRef, error) {
+	switch policy {
+	case LinkPolicyKeep, LinkPolicyDisable, LinkPolicyDelete:
+	default:
+		return nil, fmt.Errorf("unknown link policy %q", policy)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	abandoned, err := handOverWorkspaces(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := applyLinkPolicy(ctx, tx, policy, `user_id = $1 AND workspace_id IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The links of abandoned workspaces must stop being reusable before the
+	// workspace goes: deleting it clears their workspace_id, which would
+	// otherwise make them personal links of whoever created them, next to
+	// that member's own link to the same destination.
+	for _, workspaceID := range abandoned {
+		workspaceLinks, err := applyLinkPolicy(ctx, tx, policy, `workspace_id = $1`, workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, workspaceLinks...)
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM workspaces WHERE id = $1`, workspaceID); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return nil, &UserNotFoundErr{}
+	}
+
+	return links, tx.Commit()
+}
+
+// handOverWorkspaces makes sure no workspace is left without an owner when
+// the user is deleted: in each workspace the user is the only owner of, the
+// member with the highest role, the longest-standing on ties, becomes owner.
+// It returns the workspaces with no other member to take over.
+func handOverWorkspaces(ctx context.Context, tx *sql.Tx, id int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT m.workspace_id FROM workspace_members m
+		WHERE m.user_id = $1 AND m.role = 'owner'
+		AND NOT EXISTS (
+			SELECT 1 FROM workspace_members o
+			WHERE o.workspace_id = m.workspace_id AND o.role = 'owner' AND o.user_id <> $2
+		)`, id, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []int64
+	for rows.Next() {
+		var workspaceID int64
+		if err := rows.Scan(&workspaceID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		owned = append(owned, workspaceID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var abandoned []int64
+	for _, workspaceID := range owned {
+		var heir int64
+		err := tx.QueryRowContext(ctx, `SELECT user_id FROM workspace_members
+			WHERE workspace_id = $1 AND user_id <> $2
+			ORDER BY CASE role WHEN 'admin' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END, created_at, user_id
+			LIMIT 1`, workspaceID, id).Scan(&heir)
+		if errors.Is(err, sql.ErrNoRows) {
+			abandoned = append(abandoned, workspaceID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE workspace_members SET role = 'owner' WHERE workspace_id = $1 AND user_id = $2`, workspaceID, heir); err != nil {
+			return nil, err
+		}
+	}
+
+	return abandoned, nil
+}
+
+// applyLinkPolicy applies policy to the urls matching condition, a filter on
+// $1, and returns the links it touched. Links it leaves in place lose their
+// owner and are never reused again.
+func applyLinkPolicy(ctx context.Context, tx *sql.Tx, policy LinkPolicy, condition string, arg int64) ([]LinkRef, error) {
+	var linkQuery string
+	switch policy {
+	case LinkPolicyKeep:
+		// Orphaned links stop being reused: several former owners may have
+		// shortened the same destination, and none of them speaks for it now.
+		linkQuery = `UPDATE urls SET user_id = NULL, reusable = FALSE WHERE ` + condition + ` RETURNING short_code, domain_id`
+	case LinkPolicyDisable:
+		// Disabled links are orphaned the same way, so deduplication never
+		// hands out their dead short codes.
+		linkQuery = `UPDATE urls SET status = 'disabled', user_id = NULL, reusable = FALSE WHERE ` + condition + ` RETURNING short_code, domain_id`
+	case LinkPolicyDelete:
+		linkQuery = `DELETE FROM urls WHERE ` + condition + ` RETURNING short_code, domain_id`
+	}
+
+	rows, err := tx.QueryContext(ctx, linkQuery, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []LinkRef
+	for rows.Next() {
+		var shortCode sql.NullString
+		var domainID sql.NullInt64
+		if err := rows.Scan(&shortCode, &domainID); err != nil {
+			return nil, err
+		}
+
+		if !shortCode.Valid {
+			continue
+		}
+
+		link := LinkRef{ShortCode: shortCode.String}
+		if domainID.Valid {
+			link.DomainID = &domainID.Int64
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+func (r *Repository) GetByIdentity(ctx context.Context, issuer string, subject string) (*User, error) {
+	getQuery := `SELECT ` + prefixedUserColumns + ` FROM users u
+		JOIN user_identities i ON i.user_id = u.id
+		WHERE i.issuer = $1 AND i.subject = $2`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, getQuery, issuer, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &UserNotFoundErr{}
+		}
+
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *Repository) LinkIdentity(ctx context.Context, id int64, issuer string, subject string) error {
+	insertQuery := `INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)`
+
+	_, err := r.db.ExecContext(ctx, insertQuery, id, issuer, subject)
+	return err
+}
+
+// InsertWithIdentity provisions a password-less user for a single sign-on
+// identity. The user can only log in through the identity provider until a
+// password is set.
+func (r *Repository) InsertWithIdentity(ctx context.Context, email string, issuer string, subject string) (*User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	insertQuery := `INSERT INTO users (email) VALUES ($1) RETURNING ` + userColumns
+
+	user, err := scanUser(tx.QueryRowContext(ctx, insertQuery, email))
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == utils.PG_UNIQUE_CONSRAINT_VIOLATION_CODE {
+			return nil, &EmailAlreadyExistsErr{email: email}
+		}
+
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)`, user.Id, issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+// SetTOTPSecret stores a pending secret. Two-factor stays disabled until the
+// secret is confirmed with EnableTOTP.
+func (r *Repository) SetTOTPSecret(ctx context.Context, id int64, secret string) error {
+	updateQuery := `UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $2`
+
+	result, err := r.db.ExecContext(ctx, updateQuery, secret, id)
+	if err != nil {
+		return err
+	}
+
+	return requireUser(result)
+}
+
+// EnableTOTP turns two-factor on and replaces any previous recovery codes.
+func (r *Repository) EnableTOTP(ctx context.Context, id int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET totp_enabled = TRUE WHERE id = $1 AND totp_secret IS NOT NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := requireUser(result); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, id, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *Repository) DisableTOTP(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	if err := requireUser(result); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeTOTPStep records step as used. It reports false when the step, or a
+// later one, was already used, which stops a captured code from being replayed.
+func (r *Repository) ConsumeTOTPStep(ctx context.Context, id int64, step int64) (bool, error) {
+	updateQuery := `UPDATE users SET totp_last_step = $1
+		WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $3)`
+
+	result, err := r.db.ExecContext(ctx, updateQuery, step, id, step)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// ConsumeRecoveryCode marks an unused recovery code as used. It reports false
+// when no unused code matches.
+func (r *Repository) ConsumeRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error) {
+	updateQuery := `UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, updateQuery, id, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func requireUser(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return &UserNotFoundErr{}
+	}
+
+	return nil
 }
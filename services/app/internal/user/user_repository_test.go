@@ -13,7 +13,7 @@ import (
 )
 
 func setupTestDB(t *testing.T) *sql.DB {
-	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=on", t.Name())
 	db, err := sql.Open("sqlite3_proxy", dsn)
 	require.NoError(t, err)
 
@@ -21,14 +21,98 @@ func setupTestDB(t *testing.T) *sql.DB {
 		CREATE TABLE users (
 		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
 		email TEXT UNIQUE NOT NULL,
-		password TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		display_name TEXT,
+		password TEXT,
+		token_version INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		totp_secret TEXT,
+		totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		totp_last_step INTEGER
+		)
+	`
+
+	createURLsTableSQL := `
+		CREATE TABLE urls (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		short_code TEXT UNIQUE,
+		long_url TEXT NOT NULL,
+		canonical_url TEXT,
+		status TEXT NOT NULL DEFAULT 'active',
+		reusable BOOLEAN NOT NULL DEFAULT TRUE,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		domain_id INTEGER,
+		workspace_id INTEGER REFERENCES workspaces(id) ON DELETE SET NULL
+		)
+	`
+
+	createWorkspacesTableSQL := `
+		CREATE TABLE workspaces (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL
+		)
+	`
+
+	createWorkspaceMembersTableSQL := `
+		CREATE TABLE workspace_members (
+		workspace_id INTEGER NOT NULL REFERENCES workspaces(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (workspace_id, user_id)
+		)
+	`
+
+	createIdentitiesTableSQL := `
+		CREATE TABLE user_identities (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (issuer, subject)
 		)
 	`
 
 	_, err = db.ExecContext(context.Background(), createTableSQL)
 	require.NoError(t, err)
 
+	createRecoveryCodesTableSQL := `
+		CREATE TABLE user_recovery_codes (
+		id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (user_id, code_hash)
+		)
+	`
+
+	_, err = db.ExecContext(context.Background(), createIdentitiesTableSQL)
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), createRecoveryCodesTableSQL)
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), createWorkspacesTableSQL)
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), createWorkspaceMembersTableSQL)
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(context.Background(), createURLsTableSQL)
+	require.NoError(t, err)
+
+	// The deduplication indexes of the urls table, which deleting an account
+	// must not trip over.
+	for _, index := range []string{
+		`CREATE UNIQUE INDEX idx_urls_workspace_canonical_url ON urls (workspace_id, canonical_url) WHERE reusable AND workspace_id IS NOT NULL`,
+		`CREATE UNIQUE INDEX idx_urls_user_canonical_url ON urls (user_id, canonical_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NOT NULL`,
+		`CREATE UNIQUE INDEX idx_urls_anonymous_canonical_url ON urls (canonical_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NULL`,
+	} {
+		_, err = db.ExecContext(context.Background(), index)
+		require.NoError(t, err)
+	}
+
 	t.Cleanup(func() {
 		db.Close()
 	})
@@ -61,3 +145,306 @@ func TestRepository_IntegrationFlow(t *testing.T) {
 	assert.Error(t, err)
 
 }
+
+func TestRepository_ProfileAndPassword(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Insert(ctx, "first@mail.com", "hash"))
+	require.NoError(t, repo.Insert(ctx, "second@mail.com", "hash"))
+
+	user, err := repo.GetByEmail(ctx, "first@mail.com")
+	require.NoError(t, err)
+	assert.Nil(t, user.DisplayName)
+	assert.Equal(t, 0, user.TokenVersion)
+
+	name := "First"
+	updated, err := repo.Update(ctx, int64(user.Id), "renamed@mail.com", &name)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed@mail.com", updated.Email)
+	require.NotNil(t, updated.DisplayName)
+	assert.Equal(t, name, *updated.DisplayName)
+	assert.Equal(t, 1, updated.TokenVersion)
+
+	renamed := "Second"
+	updated, err = repo.Update(ctx, int64(user.Id), "renamed@mail.com", &renamed)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated.TokenVersion, "keeping the email keeps tokens valid")
+
+	version, err := repo.UpdatePassword(ctx, int64(user.Id), "new-hash")
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+
+	fetched, err := repo.GetByID(ctx, int64(user.Id))
+	require.NoError(t, err)
+	assert.Equal(t, "new-hash", fetched.Password)
+	assert.Equal(t, 2, fetched.TokenVersion)
+
+	_, err = repo.GetByID(ctx, 999)
+	assert.IsType(t, UserNotFound, err)
+}
+
+func TestRepository_Delete(t *testing.T) {
+	testCases := []struct {
+		name       string
+		policy     LinkPolicy
+		wantRows   int
+		wantStatus string
+		wantOwner  bool
+	}{
+		{name: "keep", policy: LinkPolicyKeep, wantRows: 2, wantStatus: "active"},
+		{name: "disable", policy: LinkPolicyDisable, wantRows: 2, wantStatus: "disabled"},
+		{name: "delete", policy: LinkPolicyDelete, wantRows: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			repo := NewRepository(db)
+			ctx := context.Background()
+
+			require.NoError(t, repo.Insert(ctx, "owner@mail.com", "hash"))
+			owner, err := repo.GetByEmail(ctx, "owner@mail.com")
+			require.NoError(t, err)
+
+			_, err = db.ExecContext(ctx, `INSERT INTO urls (short_code, long_url, user_id, domain_id) VALUES ('a', 'https://a.com', $1, NULL), ('b', 'https://b.com', $2, 7)`, owner.Id, owner.Id)
+			require.NoError(t, err)
+
+			links, err := repo.Delete(ctx, int64(owner.Id), tc.policy)
+			require.NoError(t, err)
+			domainID := int64(7)
+			assert.ElementsMatch(t, []LinkRef{{ShortCode: "a"}, {ShortCode: "b", DomainID: &domainID}}, links)
+
+			_, err = repo.GetByID(ctx, int64(owner.Id))
+			assert.IsType(t, UserNotFound, err)
+
+			var count int
+			require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM urls WHERE user_id IS NULL`).Scan(&count))
+			assert.Equal(t, tc.wantRows, count)
+
+			if tc.wantStatus != "" {
+				var status string
+				require.NoError(t, db.QueryRowContext(ctx, `SELECT status FROM urls WHERE short_code = 'a'`).Scan(&status))
+				assert.Equal(t, tc.wantStatus, status)
+			}
+		})
+	}
+}
+
+func TestRepository_DeleteDisablesNextToAnonymousLink(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Insert(ctx, "owner@mail.com", "hash"))
+	owner, err := repo.GetByEmail(ctx, "owner@mail.com")
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(ctx, `INSERT INTO urls (short_code, long_url, canonical_url, user_id) VALUES
+		('anon', 'https://a.com', 'https://a.com/', NULL),
+		('mine', 'https://a.com', 'https://a.com/', $1)`, owner.Id)
+	require.NoError(t, err)
+
+	links, err := repo.Delete(ctx, int64(owner.Id), LinkPolicyDisable)
+	require.NoError(t, err, "the disabled link must not collide with the anonymous one")
+	assert.Equal(t, []LinkRef{{ShortCode: "mine"}}, links)
+
+	var reusable []string
+	rows, err := db.QueryContext(ctx, `SELECT short_code FROM urls WHERE reusable`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var code string
+		require.NoError(t, rows.Scan(&code))
+		reusable = append(reusable, code)
+	}
+	require.NoError(t, rows.Close())
+	assert.Equal(t, []string{"anon"}, reusable, "anonymous deduplication must not hand out the disabled link")
+}
+
+func TestRepository_DeleteWorkspaceMember(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	ids := make(map[string]int64)
+	for _, name := range []string{"leaver", "admin", "viewer", "coowner"} {
+		require.NoError(t, repo.Insert(ctx, name+"@mail.com", "hash"))
+		u, err := repo.GetByEmail(ctx, name+"@mail.com")
+		require.NoError(t, err)
+		ids[name] = int64(u.Id)
+	}
+
+	// 1: the leaver is the only owner. 2: another owner stays. 3: nobody
+	// else is left.
+	_, err := db.ExecContext(ctx, `INSERT INTO workspaces (id, name) VALUES (1, 'handed over'), (2, 'shared'), (3, 'solo')`)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES
+		(1, $1, 'owner', '2024-01-01'), (1, $2, 'viewer', '2024-01-02'), (1, $3, 'admin', '2024-01-03'),
+		(2, $4, 'owner', '2024-01-01'), (2, $5, 'owner', '2024-01-02'),
+		(3, $6, 'owner', '2024-01-01')`,
+		ids["leaver"], ids["viewer"], ids["admin"], ids["leaver"], ids["coowner"], ids["leaver"])
+	require.NoError(t, err)
+
+	_, err = db.ExecContext(ctx, `INSERT INTO urls (short_code, long_url, user_id, workspace_id) VALUES
+		('personal', 'https://a.com', $1, NULL),
+		('team', 'https://b.com', $2, 1),
+		('shared', 'https://c.com', $3, 2),
+		('solo', 'https://d.com', $4, 3)`,
+		ids["leaver"], ids["leaver"], ids["leaver"], ids["leaver"])
+	require.NoError(t, err)
+
+	links, err := repo.Delete(ctx, ids["leaver"], LinkPolicyDisable)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []LinkRef{{ShortCode: "personal"}, {ShortCode: "solo"}}, links)
+
+	statuses := make(map[string]string)
+	rows, err := db.QueryContext(ctx, `SELECT short_code, status FROM urls`)
+	require.NoError(t, err)
+	for rows.Next() {
+		var code, status string
+		require.NoError(t, rows.Scan(&code, &status))
+		statuses[code] = status
+	}
+	require.NoError(t, rows.Close())
+	assert.Equal(t, map[string]string{"personal": "disabled", "team": "active", "shared": "active", "solo": "disabled"}, statuses)
+
+	var role string
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT role FROM workspace_members WHERE workspace_id = 1 AND user_id = $1`, ids["admin"]).Scan(&role))
+	assert.Equal(t, "owner", role, "the highest-ranked member takes over")
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT role FROM workspace_members WHERE workspace_id = 1 AND user_id = $1`, ids["viewer"]).Scan(&role))
+	assert.Equal(t, "viewer", role)
+
+	var owners int
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM workspace_members WHERE workspace_id = 2 AND role = 'owner'`).Scan(&owners))
+	assert.Equal(t, 1, owners, "workspaces with another owner are left as they are")
+
+	var workspaces int
+	require.NoError(t, db.QueryRowContext(ctx, `SELECT COUNT(*) FROM workspaces WHERE id = 3`).Scan(&workspaces))
+	assert.Zero(t, workspaces, "workspaces without members are removed")
+}
+
+func TestRepository_DeleteAbandonedWorkspaceLinks(t *testing.T) {
+	for _, policy := range []LinkPolicy{LinkPolicyKeep, LinkPolicyDisable} {
+		t.Run(string(policy), func(t *testing.T) {
+			db := setupTestDB(t)
+			repo := NewRepository(db)
+			ctx := context.Background()
+
+			ids := make(map[string]int64)
+			for _, name := range []string{"leaver", "creator"} {
+				require.NoError(t, repo.Insert(ctx, name+"@mail.com", "hash"))
+				u, err := repo.GetByEmail(ctx, name+"@mail.com")
+				require.NoError(t, err)
+				ids[name] = int64(u.Id)
+			}
+
+			// The creator made a link in the workspace and has since left it;
+			// they also have a personal link to the same destination.
+			_, err := db.ExecContext(ctx, `INSERT INTO workspaces (id, name) VALUES (1, 'solo')`)
+			require.NoError(t, err)
+			_, err = db.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role) VALUES (1, $1, 'owner')`, ids["leaver"])
+			require.NoError(t, err)
+			_, err = db.ExecContext(ctx, `INSERT INTO urls (short_code, long_url, canonical_url, user_id, workspace_id) VALUES
+				('team', 'https://a.com', 'https://a.com/', $1, 1),
+				('personal', 'https://a.com', 'https://a.com/', $2, NULL)`, ids["creator"], ids["creator"])
+			require.NoError(t, err)
+
+			links, err := repo.Delete(ctx, ids["leaver"], policy)
+			require.NoError(t, err, "the workspace link must not collide with the creator's own")
+			assert.Equal(t, []LinkRef{{ShortCode: "team"}}, links)
+
+			var reusable []string
+			rows, err := db.QueryContext(ctx, `SELECT short_code FROM urls WHERE reusable AND user_id = $1`, ids["creator"])
+			require.NoError(t, err)
+			for rows.Next() {
+				var code string
+				require.NoError(t, rows.Scan(&code))
+				reusable = append(reusable, code)
+			}
+			require.NoError(t, rows.Close())
+			assert.Equal(t, []string{"personal"}, reusable, "the creator's deduplication must not return the workspace link")
+		})
+	}
+}
+
+func TestRepository_Identities(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	_, err := repo.GetByIdentity(ctx, "https://idp", "sub-1")
+	assert.IsType(t, UserNotFound, err)
+
+	provisioned, err := repo.InsertWithIdentity(ctx, "sso@mail.com", "https://idp", "sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, "sso@mail.com", provisioned.Email)
+	assert.Empty(t, provisioned.Password)
+
+	found, err := repo.GetByIdentity(ctx, "https://idp", "sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, provisioned.Id, found.Id)
+
+	require.NoError(t, repo.Insert(ctx, "local@mail.com", "hash"))
+	local, err := repo.GetByEmail(ctx, "local@mail.com")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.LinkIdentity(ctx, int64(local.Id), "https://idp", "sub-2"))
+	linked, err := repo.GetByIdentity(ctx, "https://idp", "sub-2")
+	require.NoError(t, err)
+	assert.Equal(t, local.Id, linked.Id)
+	assert.Equal(t, "hash", linked.Password)
+
+	_, err = repo.InsertWithIdentity(ctx, "other@mail.com", "https://idp", "sub-2")
+	assert.Error(t, err)
+}
+
+func TestRepository_TwoFactor(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRepository(db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.Insert(ctx, "2fa@mail.com", "hash"))
+	user, err := repo.GetByEmail(ctx, "2fa@mail.com")
+	require.NoError(t, err)
+	id := int64(user.Id)
+
+	require.NoError(t, repo.SetTOTPSecret(ctx, id, "SECRET"))
+	user, err = repo.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET", user.TOTPSecret)
+	assert.False(t, user.TwoFactorEnabled)
+
+	require.NoError(t, repo.EnableTOTP(ctx, id, []string{"hash-a", "hash-b"}))
+	user, err = repo.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.True(t, user.TwoFactorEnabled)
+
+	fresh, err := repo.ConsumeTOTPStep(ctx, id, 10)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+
+	fresh, err = repo.ConsumeTOTPStep(ctx, id, 10)
+	require.NoError(t, err)
+	assert.False(t, fresh, "the same step must not be accepted twice")
+
+	used, err := repo.ConsumeRecoveryCode(ctx, id, "hash-a")
+	require.NoError(t, err)
+	assert.True(t, used)
+
+	used, err = repo.ConsumeRecoveryCode(ctx, id, "hash-a")
+	require.NoError(t, err)
+	assert.False(t, used, "a recovery code is single use")
+
+	require.NoError(t, repo.DisableTOTP(ctx, id))
+	user, err = repo.GetByID(ctx, id)
+	require.NoError(t, err)
+	assert.False(t, user.TwoFactorEnabled)
+	assert.Empty(t, user.TOTPSecret)
+
+	used, err = repo.ConsumeRecoveryCode(ctx, id, "hash-b")
+	require.NoError(t, err)
+	assert.False(t, used, "disabling two-factor drops the recovery codes")
+
+	assert.IsType(t, UserNotFound, repo.SetTOTPSecret(ctx, 999, "SECRET"))
+}
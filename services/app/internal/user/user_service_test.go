@@ -2,19 +2,40 @@ package user
 
 import (
 	"context"
+	"errors"
 	"hafiztri123/app-link-shortener/internal/auth"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type mockRepository struct {
-	getByEmailResult *User
-	getByEmailErr    error
-	insertErr        error
+	getByEmailResult     *User
+	getByEmailErr        error
+	insertErr            error
+	getByIDResult        *User
+	getByIDErr           error
+	updateErr            error
+	updatePasswordResult int
+	updatePasswordErr    error
+	deleteResult         []LinkRef
+	deleteErr            error
+	deletedPolicy        LinkPolicy
+	getByIdentityResult  *User
+	getByIdentityErr     error
+	linkIdentityErr      error
+	linkedUserID         int64
+	provisionedEmail     string
+	totpSecret           string
+	enabledHashes        []string
+	totpDisabled         bool
+	lastStep             int64
+	recoveryHashes       map[string]bool
 }
 
 func (m *mockRepository) Insert(ctx context.Context, email string, password string) error {
@@ -25,15 +46,111 @@ func (m *mockRepository) GetByEmail(ctx context.Context, email string) (*User, e
 	return m.getByEmailResult, m.getByEmailErr
 }
 
+func (m *mockRepository) GetByID(ctx context.Context, id int64) (*User, error) {
+	return m.getByIDResult, m.getByIDErr
+}
+
+func (m *mockRepository) Update(ctx context.Context, id int64, email string, displayName *string) (*User, error) {
+	if m.updateErr != nil {
+		return nil, m.updateErr
+	}
+	tokenVersion := m.getByIDResult.TokenVersion
+	if email != m.getByIDResult.Email {
+		tokenVersion++
+	}
+	return &User{Id: int(id), Email: email, DisplayName: displayName, TokenVersion: tokenVersion}, nil
+}
+
+func (m *mockRepository) UpdatePassword(ctx context.Context, id int64, password string) (int, error) {
+	return m.updatePasswordResult, m.updatePasswordErr
+}
+
+func (m *mockRepository) Delete(ctx context.Context, id int64, policy LinkPolicy) ([]LinkRef, error) {
+	m.deletedPolicy = policy
+	return m.deleteResult, m.deleteErr
+}
+
+func (m *mockRepository) GetByIdentity(ctx context.Context, issuer string, subject string) (*User, error) {
+	return m.getByIdentityResult, m.getByIdentityErr
+}
+
+func (m *mockRepository) LinkIdentity(ctx context.Context, id int64, issuer string, subject string) error {
+	m.linkedUserID = id
+	return m.linkIdentityErr
+}
+
+func (m *mockRepository) InsertWithIdentity(ctx context.Context, email string, issuer string, subject string) (*User, error) {
+	if m.insertErr != nil {
+		return nil, m.insertErr
+	}
+	m.provisionedEmail = email
+	return &User{Id: 3, Email: email}, nil
+}
+
+func (m *mockRepository) SetTOTPSecret(ctx context.Context, id int64, secret string) error {
+	m.totpSecret = secret
+	return m.updateErr
+}
+
+func (m *mockRepository) EnableTOTP(ctx context.Context, id int64, recoveryCodeHashes []string) error {
+	m.enabledHashes = recoveryCodeHashes
+	return m.updateErr
+}
+
+func (m *mockRepository) DisableTOTP(ctx context.Context, id int64) error {
+	m.totpDisabled = true
+	return m.updateErr
+}
+
+func (m *mockRepository) ConsumeTOTPStep(ctx context.Context, id int64, step int64) (bool, error) {
+	if step <= m.lastStep {
+		return false, nil
+	}
+	m.lastStep = step
+	return true, nil
+}
+
+func (m *mockRepository) ConsumeRecoveryCode(ctx context.Context, id int64, codeHash string) (bool, error) {
+	if !m.recoveryHashes[codeHash] {
+		return false, nil
+	}
+	delete(m.recoveryHashes, codeHash)
+	return true, nil
+}
+
 type mockJWT struct {
-	token string
-	err   error
+	token        string
+	err          error
+	tokenVersion int
 }
 
-func (m *mockJWT) GenerateToken(userID int64, email string) (string, error) {
+func (m *mockJWT) GenerateToken(userID int64, email string, tokenVersion int) (string, error) {
+	m.tokenVersion = tokenVersion
 	return m.token, m.err
 }
 
+func (m *mockJWT) GenerateChallengeToken(userID int64) (string, error) {
+	return "challenge", m.err
+}
+
+func (m *mockJWT) ValidateChallengeToken(tokenString string) (int64, error) {
+	if tokenString != "challenge" {
+		return 0, errors.New("invalid challenge token")
+	}
+	return 1, nil
+}
+
+func (m *mockJWT) GenerateIdentityLinkToken(userID int64, issuer string, subject string) (string, error) {
+	return "link", m.err
+}
+
+func (m *mockJWT) ValidateIdentityLinkToken(tokenString string) (*auth.IdentityLinkClaims, error) {
+	if tokenString != "link" {
+		return nil, errors.New("invalid identity link token")
+	}
+	return &auth.IdentityLinkClaims{UserID: 2, IdentityIssuer: "https://idp", IdentitySubject: "sub"}, nil
+}
+
 func (m *mockJWT) ValidateToken(tokenString string) (*auth.Claims, error) {
 	return nil, nil
 }
@@ -43,7 +160,7 @@ func TestRegister(t *testing.T) {
 	data := &User{
 		Id:         1,
 		Email:      "example@yahoo.com",
-		Password:   "example",
+		Password:   "example-password",
 		Created_at: time.Now(),
 	}
 
@@ -67,6 +184,21 @@ func TestRegister(t *testing.T) {
 			insertErr:        EmailAlreadyExists,
 			wantErr:          EmailAlreadyExists,
 		},
+		{
+			name:             "invalid email",
+			getByEmailResult: &User{Email: "not-an-email", Password: "example-password"},
+			wantErr:          InvalidRequest,
+		},
+		{
+			name:             "password too short",
+			getByEmailResult: &User{Email: "example@yahoo.com", Password: "short"},
+			wantErr:          InvalidRequest,
+		},
+		{
+			name:             "password too long for bcrypt",
+			getByEmailResult: &User{Email: "example@yahoo.com", Password: strings.Repeat("a", 73)},
+			wantErr:          InvalidRequest,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -77,14 +209,18 @@ func TestRegister(t *testing.T) {
 				insertErr:        tc.insertErr,
 			}
 
-			srv := NewService(nil, mockRepo, nil)
+			srv := NewService(nil, mockRepo, nil, nil)
 
 			err := srv.Register(context.Background(), RegisterRequest{
 				Email:    tc.getByEmailResult.Email,
 				Password: tc.getByEmailResult.Password,
 			})
 
-			assert.ErrorIs(t, tc.wantErr, err)
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.IsType(t, tc.wantErr, err)
 		})
 	}
 }
@@ -171,7 +307,7 @@ func TestLogin(t *testing.T) {
 				err:   nil,
 			}
 
-			srv := NewService(nil, mockRepo, mockJwt)
+			srv := NewService(nil, mockRepo, mockJwt, nil)
 
 			_, err := srv.Login(context.Background(), tc.request)
 
@@ -184,3 +320,626 @@ func TestLogin(t *testing.T) {
 	}
 
 }
+
+func TestUpdateProfile(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("current"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	existing := &User{Id: 1, Email: "old@mail.com", Password: string(hashedPassword), TokenVersion: 3}
+	newEmail := "new@mail.com"
+	badEmail := "Jane <new@mail.com>"
+	blank := "  "
+	name := " Jane "
+
+	testCases := []struct {
+		name            string
+		request         UpdateProfileRequest
+		updateErr       error
+		wantEmail       string
+		wantDisplayName *string
+		wantToken       string
+		wantErr         error
+	}{
+		{
+			name:      "update email issues a new token",
+			request:   UpdateProfileRequest{Email: &newEmail, CurrentPassword: "current"},
+			wantEmail: newEmail,
+			wantToken: "token",
+		},
+		{
+			name:            "update display name trims whitespace",
+			request:         UpdateProfileRequest{DisplayName: &name},
+			wantEmail:       "old@mail.com",
+			wantDisplayName: func() *string { s := "Jane"; return &s }(),
+		},
+		{
+			name:    "email change without current password",
+			request: UpdateProfileRequest{Email: &newEmail},
+			wantErr: InvalidCredentials,
+		},
+		{
+			name:    "email change with wrong current password",
+			request: UpdateProfileRequest{Email: &newEmail, CurrentPassword: "wrong"},
+			wantErr: InvalidCredentials,
+		},
+		{
+			name:    "blank email rejected",
+			request: UpdateProfileRequest{Email: &blank},
+			wantErr: InvalidRequest,
+		},
+		{
+			name:    "malformed email rejected",
+			request: UpdateProfileRequest{Email: &badEmail, CurrentPassword: "current"},
+			wantErr: InvalidRequest,
+		},
+		{
+			name:      "email taken",
+			request:   UpdateProfileRequest{Email: &newEmail, CurrentPassword: "current"},
+			updateErr: EmailAlreadyExists,
+			wantErr:   EmailAlreadyExists,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &mockRepository{getByIDResult: existing, updateErr: tc.updateErr}
+			mockJwt := &mockJWT{token: "token"}
+			srv := NewService(nil, mockRepo, mockJwt, nil)
+
+			update, err := srv.UpdateProfile(context.Background(), 1, tc.request)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantEmail, update.Email)
+			assert.Equal(t, tc.wantDisplayName, update.DisplayName)
+			assert.Equal(t, tc.wantToken, update.Token)
+			if tc.wantToken != "" {
+				assert.Equal(t, existing.TokenVersion+1, mockJwt.tokenVersion)
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("current"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name           string
+		request        ChangePasswordRequest
+		wantErr        error
+		wantNewVersion int
+	}{
+		{
+			name:           "success bumps token version",
+			request:        ChangePasswordRequest{CurrentPassword: "current", NewPassword: "next-password"},
+			wantNewVersion: 4,
+		},
+		{
+			name:    "wrong current password",
+			request: ChangePasswordRequest{CurrentPassword: "wrong", NewPassword: "next-password"},
+			wantErr: InvalidCredentials,
+		},
+		{
+			name:    "new password too short",
+			request: ChangePasswordRequest{CurrentPassword: "current", NewPassword: "next"},
+			wantErr: InvalidRequest,
+		},
+		{
+			name:    "new password too long for bcrypt",
+			request: ChangePasswordRequest{CurrentPassword: "current", NewPassword: strings.Repeat("a", 73)},
+			wantErr: InvalidRequest,
+		},
+		{
+			name:    "missing new password",
+			request: ChangePasswordRequest{CurrentPassword: "current"},
+			wantErr: InvalidRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &mockRepository{
+				getByIDResult:        &User{Id: 1, Email: "example@mail.com", Password: string(hashedPassword), TokenVersion: 3},
+				updatePasswordResult: 4,
+			}
+			mockJwt := &mockJWT{token: "token"}
+			srv := NewService(nil, mockRepo, mockJwt, nil)
+
+			token, err := srv.ChangePassword(context.Background(), 1, tc.request)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "token", token)
+			assert.Equal(t, tc.wantNewVersion, mockJwt.tokenVersion)
+		})
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		request    DeleteAccountRequest
+		wantPolicy LinkPolicy
+		wantErr    error
+	}{
+		{
+			name:       "defaults to disabling links",
+			request:    DeleteAccountRequest{Password: "secret"},
+			wantPolicy: LinkPolicyDisable,
+		},
+		{
+			name:       "delete links",
+			request:    DeleteAccountRequest{Password: "secret", Links: LinkPolicyDelete},
+			wantPolicy: LinkPolicyDelete,
+		},
+		{
+			name:    "unknown policy",
+			request: DeleteAccountRequest{Password: "secret", Links: "archive"},
+			wantErr: InvalidRequest,
+		},
+		{
+			name:    "wrong password",
+			request: DeleteAccountRequest{Password: "wrong"},
+			wantErr: InvalidCredentials,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &mockRepository{
+				getByIDResult: &User{Id: 1, Password: string(hashedPassword)},
+				deleteResult:  []LinkRef{{ShortCode: "abc"}},
+			}
+			srv := NewService(nil, mockRepo, nil, nil)
+
+			result, err := srv.DeleteAccount(context.Background(), 1, tc.request)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantPolicy, mockRepo.deletedPolicy)
+			assert.Equal(t, tc.wantPolicy, result.Policy)
+			assert.Equal(t, []LinkRef{{ShortCode: "abc"}}, result.Links)
+		})
+	}
+}
+
+func TestDeleteAccount_PasswordLess(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	secret, err := auth.GenerateTOTPSecret()
+	require.NoError(t, err)
+	code, err := auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		user    *User
+		request DeleteAccountRequest
+		wantErr error
+	}{
+		{
+			name:    "signed in just now",
+			user:    &User{Id: 1},
+			request: DeleteAccountRequest{SignedInAt: now.Add(-time.Minute)},
+		},
+		{
+			name:    "signed in too long ago",
+			user:    &User{Id: 1},
+			request: DeleteAccountRequest{SignedInAt: now.Add(-time.Hour)},
+			wantErr: ReauthenticationRequired,
+		},
+		{
+			name:    "two-factor code",
+			user:    &User{Id: 1, TOTPSecret: secret, TwoFactorEnabled: true},
+			request: DeleteAccountRequest{Code: code, SignedInAt: now.Add(-time.Hour)},
+		},
+		{
+			name:    "two-factor accounts need their code",
+			user:    &User{Id: 1, TOTPSecret: secret, TwoFactorEnabled: true},
+			request: DeleteAccountRequest{Code: "000000", SignedInAt: now},
+			wantErr: InvalidTwoFactorCode,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := &mockRepository{getByIDResult: tc.user}
+			srv := NewService(nil, mockRepo, nil, nil)
+			srv.now = func() time.Time { return now }
+
+			_, err := srv.DeleteAccount(context.Background(), 1, tc.request)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Empty(t, mockRepo.deletedPolicy)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, LinkPolicyDisable, mockRepo.deletedPolicy)
+		})
+	}
+}
+
+func TestValidateSession(t *testing.T) {
+	testCases := []struct {
+		name       string
+		user       *User
+		getByIDErr error
+		claims     *auth.Claims
+		wantErr    bool
+	}{
+		{
+			name:   "matching token version",
+			user:   &User{Id: 1, TokenVersion: 2},
+			claims: &auth.Claims{UserID: 1, TokenVersion: 2},
+		},
+		{
+			name:    "password changed since token was issued",
+			user:    &User{Id: 1, TokenVersion: 3},
+			claims:  &auth.Claims{UserID: 1, TokenVersion: 2},
+			wantErr: true,
+		},
+		{
+			name:       "user deleted",
+			getByIDErr: UserNotFound,
+			claims:     &auth.Claims{UserID: 1},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewService(nil, &mockRepository{getByIDResult: tc.user, getByIDErr: tc.getByIDErr}, nil, nil)
+
+			err := srv.ValidateSession(context.Background(), tc.claims)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoginWithOIDC(t *testing.T) {
+	verified := &auth.OIDCIdentity{Issuer: "https://idp", Subject: "sub", Email: "staff@mail.com", EmailVerified: true}
+
+	testCases := []struct {
+		name            string
+		identity        *auth.OIDCIdentity
+		repo            *mockRepository
+		want            *LoginResponse
+		wantErr         error
+		wantProvisioned string
+	}{
+		{
+			name:     "known identity",
+			identity: verified,
+			repo:     &mockRepository{getByIdentityResult: &User{Id: 1, Email: "staff@mail.com"}},
+			want:     &LoginResponse{Token: "token"},
+		},
+		{
+			name:     "known identity with two-factor",
+			identity: verified,
+			repo:     &mockRepository{getByIdentityResult: &User{Id: 1, Email: "staff@mail.com", TwoFactorEnabled: true}},
+			want:     &LoginResponse{TwoFactorRequired: true, ChallengeToken: "challenge"},
+		},
+		{
+			name:     "existing account asks for its password",
+			identity: verified,
+			repo: &mockRepository{
+				getByIdentityErr: UserNotFound,
+				getByEmailResult: &User{Id: 2, Email: "staff@mail.com", Password: "hash"},
+			},
+			want: &LoginResponse{LinkRequired: true, LinkToken: "link"},
+		},
+		{
+			name:     "existing password-less account",
+			identity: verified,
+			repo: &mockRepository{
+				getByIdentityErr: UserNotFound,
+				getByEmailResult: &User{Id: 2, Email: "staff@mail.com"},
+			},
+			wantErr: EmailAlreadyExists,
+		},
+		{
+			name:     "provisions new account",
+			identity: verified,
+			repo: &mockRepository{
+				getByIdentityErr: UserNotFound,
+				getByEmailErr:    InvalidCredentials,
+			},
+			want:            &LoginResponse{Token: "token"},
+			wantProvisioned: "staff@mail.com",
+		},
+		{
+			name:     "unverified email",
+			identity: &auth.OIDCIdentity{Issuer: "https://idp", Subject: "sub", Email: "staff@mail.com"},
+			repo:     &mockRepository{},
+			wantErr:  UnverifiedEmail,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewService(nil, tc.repo, &mockJWT{token: "token"}, nil)
+
+			login, err := srv.LoginWithOIDC(context.Background(), tc.identity)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, login)
+			assert.Zero(t, tc.repo.linkedUserID, "identities are never linked by email alone")
+			assert.Equal(t, tc.wantProvisioned, tc.repo.provisionedEmail)
+		})
+	}
+}
+
+func TestLinkOIDCIdentity(t *testing.T) {
+	password := "admin"
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 4)
+	require.NoError(t, err)
+
+	account := &User{Id: 2, Email: "staff@mail.com", Password: string(hashedPassword)}
+
+	testCases := []struct {
+		name       string
+		req        LinkIdentityRequest
+		account    *User
+		want       *LoginResponse
+		wantErr    error
+		wantLinked int64
+	}{
+		{
+			name:       "links with the password",
+			req:        LinkIdentityRequest{LinkToken: "link", Password: password},
+			account:    account,
+			want:       &LoginResponse{Token: "token"},
+			wantLinked: 2,
+		},
+		{
+			name:       "still asks for the second factor",
+			req:        LinkIdentityRequest{LinkToken: "link", Password: password},
+			account:    &User{Id: 2, Email: "staff@mail.com", Password: string(hashedPassword), TwoFactorEnabled: true},
+			want:       &LoginResponse{TwoFactorRequired: true, ChallengeToken: "challenge"},
+			wantLinked: 2,
+		},
+		{
+			name:    "wrong password",
+			req:     LinkIdentityRequest{LinkToken: "link", Password: "guess"},
+			account: account,
+			wantErr: InvalidCredentials,
+		},
+		{
+			name:    "invalid link token",
+			req:     LinkIdentityRequest{LinkToken: "forged", Password: password},
+			account: account,
+			wantErr: InvalidCredentials,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := &mockRepository{getByIDResult: tc.account}
+			srv := NewService(nil, repo, &mockJWT{token: "token"}, nil)
+
+			login, err := srv.LinkOIDCIdentity(context.Background(), tc.req)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Zero(t, repo.linkedUserID)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, login)
+			assert.Equal(t, tc.wantLinked, repo.linkedUserID)
+		})
+	}
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	password := "admin"
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 4)
+	require.NoError(t, err)
+
+	secret, err := auth.GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code, err := auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	enabled := &User{Id: 1, Email: "example@mail.com", Password: string(hashedPassword), TOTPSecret: secret, TwoFactorEnabled: true}
+
+	t.Run("login returns a challenge", func(t *testing.T) {
+		srv := NewService(nil, &mockRepository{getByEmailResult: enabled}, &mockJWT{token: "token"}, nil)
+
+		login, err := srv.Login(context.Background(), LoginRequest{Email: enabled.Email, Password: password})
+		require.NoError(t, err)
+		assert.True(t, login.TwoFactorRequired)
+		assert.Equal(t, "challenge", login.ChallengeToken)
+		assert.Empty(t, login.Token)
+	})
+
+	testCases := []struct {
+		name      string
+		challenge string
+		code      string
+		repo      *mockRepository
+		attempts  int64
+		wantErr   error
+	}{
+		{
+			name:      "valid totp code",
+			challenge: "challenge",
+			code:      code,
+			repo:      &mockRepository{getByIDResult: enabled},
+			attempts:  1,
+		},
+		{
+			name:      "valid totp code after too many attempts",
+			challenge: "challenge",
+			code:      code,
+			repo:      &mockRepository{getByIDResult: enabled},
+			attempts:  maxTwoFactorAttempts + 1,
+			wantErr:   TooManyTwoFactorAttempts,
+		},
+		{
+			name:      "replayed totp code",
+			challenge: "challenge",
+			code:      code,
+			repo:      &mockRepository{getByIDResult: enabled, lastStep: now.Unix() / 30},
+			attempts:  1,
+			wantErr:   InvalidTwoFactorCode,
+		},
+		{
+			name:      "wrong totp code",
+			challenge: "challenge",
+			code:      "000000",
+			repo:      &mockRepository{getByIDResult: enabled},
+			attempts:  maxTwoFactorAttempts,
+			wantErr:   InvalidTwoFactorCode,
+		},
+		{
+			name:      "recovery code",
+			challenge: "challenge",
+			code:      "ABCD-EFGH-JK",
+			repo: &mockRepository{
+				getByIDResult:  enabled,
+				recoveryHashes: map[string]bool{hashRecoveryCode("abcdefghjk"): true},
+			},
+			attempts: 2,
+		},
+		{
+			name:      "invalid challenge token",
+			challenge: "forged",
+			code:      code,
+			repo:      &mockRepository{getByIDResult: enabled},
+			wantErr:   InvalidCredentials,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			redisClient, redisMock := redismock.NewClientMock()
+			if tc.attempts > 0 {
+				redisMock.ExpectTxPipeline()
+				redisMock.ExpectIncr("two_factor_attempts:1").SetVal(tc.attempts)
+				redisMock.ExpectExpire("two_factor_attempts:1", twoFactorAttemptWindow).SetVal(true)
+				redisMock.ExpectTxPipelineExec()
+			}
+			if tc.wantErr == nil {
+				redisMock.ExpectDel("two_factor_attempts:1").SetVal(1)
+			}
+
+			srv := NewService(nil, tc.repo, &mockJWT{token: "token"}, redisClient)
+			srv.now = func() time.Time { return now }
+
+			token, err := srv.VerifyTwoFactor(context.Background(), TwoFactorLoginRequest{ChallengeToken: tc.challenge, Code: tc.code})
+			assert.NoError(t, redisMock.ExpectationsWereMet())
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "token", token)
+		})
+	}
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	repo := &mockRepository{getByIDResult: &User{Id: 1, Email: "example@mail.com"}}
+	srv := NewService(nil, repo, &mockJWT{}, nil)
+	srv.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := srv.ConfirmTwoFactor(ctx, 1, ConfirmTwoFactorRequest{Code: "123456"})
+	assert.IsType(t, InvalidRequest, err, "confirming before enrolling")
+
+	enrollment, err := srv.EnrollTwoFactor(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, repo.totpSecret, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/")
+	assert.NotEmpty(t, enrollment.QRCode)
+
+	repo.getByIDResult.TOTPSecret = enrollment.Secret
+
+	_, err = srv.ConfirmTwoFactor(ctx, 1, ConfirmTwoFactorRequest{Code: "000000"})
+	assert.IsType(t, InvalidTwoFactorCode, err)
+
+	code, err := auth.TOTPCode(enrollment.Secret, now)
+	require.NoError(t, err)
+
+	confirmation, err := srv.ConfirmTwoFactor(ctx, 1, ConfirmTwoFactorRequest{Code: code})
+	require.NoError(t, err)
+	require.Len(t, confirmation.RecoveryCodes, recoveryCodeCount)
+	require.Len(t, repo.enabledHashes, recoveryCodeCount)
+	for i, recoveryCode := range confirmation.RecoveryCodes {
+		assert.NotEqual(t, recoveryCode, repo.enabledHashes[i], "recovery codes are stored hashed")
+		assert.Equal(t, hashRecoveryCode(recoveryCode), repo.enabledHashes[i])
+	}
+
+	repo.getByIDResult.TwoFactorEnabled = true
+	_, err = srv.EnrollTwoFactor(ctx, 1)
+	assert.IsType(t, InvalidRequest, err, "enrolling twice")
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	password := "admin"
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 4)
+	require.NoError(t, err)
+
+	newRepo := func() *mockRepository {
+		return &mockRepository{
+			getByIDResult:  &User{Id: 1, Password: string(hashedPassword), TOTPSecret: "SECRET", TwoFactorEnabled: true},
+			recoveryHashes: map[string]bool{hashRecoveryCode("recovery12"): true},
+		}
+	}
+
+	t.Run("with password and recovery code", func(t *testing.T) {
+		repo := newRepo()
+		err := NewService(nil, repo, nil, nil).DisableTwoFactor(context.Background(), 1, DisableTwoFactorRequest{Password: password, Code: "recovery12"})
+		require.NoError(t, err)
+		assert.True(t, repo.totpDisabled)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		repo := newRepo()
+		err := NewService(nil, repo, nil, nil).DisableTwoFactor(context.Background(), 1, DisableTwoFactorRequest{Password: "wrong", Code: "recovery12"})
+		assert.IsType(t, InvalidCredentials, err)
+		assert.False(t, repo.totpDisabled)
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		repo := newRepo()
+		err := NewService(nil, repo, nil, nil).DisableTwoFactor(context.Background(), 1, DisableTwoFactorRequest{Password: password, Code: "nope"})
+		assert.IsType(t, InvalidTwoFactorCode, err)
+		assert.False(t, repo.totpDisabled)
+	})
+
+	t.Run("password-less account with code", func(t *testing.T) {
+		repo := newRepo()
+		repo.getByIDResult.Password = ""
+		err := NewService(nil, repo, nil, nil).DisableTwoFactor(context.Background(), 1, DisableTwoFactorRequest{Code: "recovery12"})
+		require.NoError(t, err)
+		assert.True(t, repo.totpDisabled)
+	})
+}
@@ -1,3 +1,4 @@
 package utils
 
 const PG_UNIQUE_CONSRAINT_VIOLATION_CODE = "23505"
+const PG_FOREIGN_KEY_VIOLATION_CODE = "23503"
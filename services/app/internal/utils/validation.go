@@ -1,39 +1,160 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	sharedutils "hpj/hv1-link-shortener/shared/utils"
+	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
+	"strings"
+	"time"
 )
 
-func IsValidURL(s string) bool {
+// URLReason is the machine-readable code of a rejected URL.
+type URLReason string
+
+const (
+	URLEmpty             URLReason = "empty"
+	URLMalformed         URLReason = "malformed"
+	URLUnsupportedScheme URLReason = "unsupported_scheme"
+	URLMissingHost       URLReason = "missing_host"
+	URLCredentials       URLReason = "credentials"
+	URLReservedAddress   URLReason = "reserved_address"
+	URLUnresolvable      URLReason = "unresolvable"
+)
+
+type InvalidURLErr struct {
+	reason URLReason
+	detail string
+}
+
+func (e *InvalidURLErr) Error() string {
+	return e.detail
+}
+
+func (e *InvalidURLErr) Reason() URLReason {
+	return e.reason
+}
+
+var InvalidURL = &InvalidURLErr{}
+
+// IPResolver looks up the addresses of a host. net.DefaultResolver is one.
+type IPResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// URLValidator accepts absolute http(s) URLs to public hosts.
+type URLValidator struct {
+	resolver IPResolver
+	timeout  time.Duration
+}
+
+// NewURLValidator returns a validator that resolves host names with
+// resolver, giving up after timeout. A nil resolver defers resolution: only
+// IP literals and names that are local by definition are rejected, leaving
+// the addresses to be checked where the destination is actually fetched.
+func NewURLValidator(resolver IPResolver, timeout time.Duration) *URLValidator {
+	return &URLValidator{resolver: resolver, timeout: timeout}
+}
+
+// Validate returns an *InvalidURLErr describing why s is rejected, or nil.
+// When the host cannot be resolved for any reason other than it not
+// existing, such as the resolver timing out, s is let through so that link
+// creation does not depend on DNS being up.
+func (v *URLValidator) Validate(ctx context.Context, s string) error {
+	if s == "" {
+		return &InvalidURLErr{reason: URLEmpty, detail: "URL cannot be empty"}
+	}
+
 	u, err := url.ParseRequestURI(s)
 	if err != nil {
-		return false
+		return &InvalidURLErr{reason: URLMalformed, detail: "URL must be absolute"}
 	}
 
 	if u.Scheme != "http" && u.Scheme != "https" {
-		return false
+		return &InvalidURLErr{reason: URLUnsupportedScheme, detail: "URL scheme must be http or https"}
 	}
 
-	if u.Host == "" {
-		return false
+	if u.User != nil {
+		return &InvalidURLErr{reason: URLCredentials, detail: "URL must not contain credentials"}
 	}
 
-	hostName, _, err := net.SplitHostPort(u.Host)
-	if err != nil {
-		hostName = u.Host
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return &InvalidURLErr{reason: URLMissingHost, detail: "URL must have a host"}
 	}
 
-	ips, err := net.LookupIP(hostName)
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return checkAddrs(addr)
+	}
+
+	if isNumericHost(host) {
+		// Forms like 2130706433 or 0x7f.1 are not valid names, but clients
+		// read them as IPv4 addresses.
+		return &InvalidURLErr{reason: URLMalformed, detail: "URL host must be a domain name or a dotted IP address"}
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &InvalidURLErr{reason: URLReservedAddress, detail: "URL host must be public"}
+	}
+
+	if v.resolver == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	ipAddrs, err := v.resolver.LookupIPAddr(ctx, host)
 	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return &InvalidURLErr{reason: URLUnresolvable, detail: fmt.Sprintf("URL host %q does not exist", host)}
+		}
+		slog.Warn("Could not resolve URL host, accepting it", "host", host, "error", err)
+		return nil
+	}
+
+	addrs := make([]netip.Addr, 0, len(ipAddrs))
+	for _, ipAddr := range ipAddrs {
+		if addr, ok := netip.AddrFromSlice(ipAddr.IP); ok {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return checkAddrs(addrs...)
+}
+
+func checkAddrs(addrs ...netip.Addr) error {
+	for _, addr := range addrs {
+		if sharedutils.IsReservedAddr(addr) {
+			return &InvalidURLErr{reason: URLReservedAddress, detail: "URL host must be public"}
+		}
+	}
+
+	return nil
+}
+
+// isNumericHost reports whether the last label of host is a number, which
+// no registered domain has.
+func isNumericHost(host string) bool {
+	last := host[strings.LastIndex(host, ".")+1:]
+	if last == "" {
 		return false
 	}
 
-	for _, ip := range ips {
-		if ip.IsPrivate() || ip.IsLoopback() {
+	digits := strings.TrimPrefix(last, "0x")
+	if digits == "" {
+		return true
+	}
+	for _, r := range digits {
+		if !strings.ContainsRune("0123456789abcdef", r) {
 			return false
 		}
 	}
 
-	return true
+	return last != digits || strings.Trim(digits, "0123456789") == ""
 }
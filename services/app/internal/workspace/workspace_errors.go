@@ -0,0 +1,56 @@
+package workspace
+
+import "log/slog"
+
+var WorkspaceNotFound = &WorkspaceNotFoundErr{}
+var Forbidden = &ForbiddenErr{}
+var InvalidRequest = &InvalidRequestErr{}
+var InvitationNotFound = &InvitationNotFoundErr{}
+var LastOwner = &LastOwnerErr{}
+var MemberNotFound = &MemberNotFoundErr{}
+
+// WorkspaceNotFoundErr is also returned to non-members so that workspace ids
+// cannot be probed.
+type WorkspaceNotFoundErr struct {
+	workspaceID int64
+}
+
+func (e *WorkspaceNotFoundErr) Error() string {
+	slog.Warn("Workspace not found or caller is not a member", "workspace_id", e.workspaceID)
+	return "Workspace not found"
+}
+
+type ForbiddenErr struct {
+	action string
+}
+
+func (e *ForbiddenErr) Error() string {
+	slog.Warn("Workspace role does not allow the action", "action", e.action)
+	return "Your role in this workspace does not allow this action"
+}
+
+type InvalidRequestErr struct {
+	reason string
+}
+
+func (e *InvalidRequestErr) Error() string {
+	return e.reason
+}
+
+type InvitationNotFoundErr struct{}
+
+func (e *InvitationNotFoundErr) Error() string {
+	return "Invitation not found, expired or issued to another email"
+}
+
+type LastOwnerErr struct{}
+
+func (e *LastOwnerErr) Error() string {
+	return "A workspace must keep at least one owner"
+}
+
+type MemberNotFoundErr struct{}
+
+func (e *MemberNotFoundErr) Error() string {
+	return "Member not found"
+}
@@ -0,0 +1,233 @@
+package workspace
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type WorkspaceRepository interface {
+	Create(ctx context.Context, name string, ownerID int64) (*Workspace, error)
+	ListByUserID(ctx context.Context, userID int64) ([]*Workspace, error)
+	GetMember(ctx context.Context, workspaceID int64, userID int64) (*Member, error)
+	ListMembers(ctx context.Context, workspaceID int64) ([]*Member, error)
+	CountOwners(ctx context.Context, workspaceID int64) (int, error)
+	UpdateMemberRole(ctx context.Context, workspaceID int64, userID int64, role Role) error
+	DeleteMember(ctx context.Context, workspaceID int64, userID int64) error
+	InsertInvitation(ctx context.Context, invitation *Invitation, tokenHash string, invitedBy int64) error
+	AcceptInvitation(ctx context.Context, tokenHash string, userID int64, email string) (*Member, error)
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: db}
+}
+
+// Create inserts the workspace and its first owner in one transaction.
+func (r *Repository) Create(ctx context.Context, name string, ownerID int64) (*Workspace, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	workspace := &Workspace{Name: name, Role: RoleOwner}
+	err = tx.QueryRowContext(ctx, `INSERT INTO workspaces (name) VALUES ($1) RETURNING id, created_at`, name).
+		Scan(&workspace.ID, &workspace.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO workspace_members (workspace_id, user_id, role) VALUES ($1, $2, $3)`,
+		workspace.ID, ownerID, RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return workspace, tx.Commit()
+}
+
+func (r *Repository) ListByUserID(ctx context.Context, userID int64) ([]*Workspace, error) {
+	query := `
+		SELECT w.id, w.name, m.role, w.created_at
+		FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = $1
+		ORDER BY w.created_at, w.id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workspaces []*Workspace
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.Role, &workspace.CreatedAt); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, &workspace)
+	}
+
+	return workspaces, rows.Err()
+}
+
+func (r *Repository) GetMember(ctx context.Context, workspaceID int64, userID int64) (*Member, error) {
+	query := `
+		SELECT m.workspace_id, m.user_id, u.email, m.role, m.created_at
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1 AND m.user_id = $2
+	`
+
+	var member Member
+	err := r.db.QueryRowContext(ctx, query, workspaceID, userID).
+		Scan(&member.WorkspaceID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &WorkspaceNotFoundErr{workspaceID: workspaceID}
+		}
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func (r *Repository) ListMembers(ctx context.Context, workspaceID int64) ([]*Member, error) {
+	query := `
+		SELECT m.workspace_id, m.user_id, u.email, m.role, m.created_at
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1
+		ORDER BY m.created_at, m.user_id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*Member
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.WorkspaceID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+
+	return members, rows.Err()
+}
+
+func (r *Repository) CountOwners(ctx context.Context, workspaceID int64) (int, error) {
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM workspace_members WHERE workspace_id = $1 AND role = $2`,
+		workspaceID, RoleOwner).Scan(&count)
+	return count, err
+}
+
+func (r *Repository) UpdateMemberRole(ctx context.Context, workspaceID int64, userID int64, role Role) error {
+	result, err := r.db.ExecContext(ctx, `UPDATE workspace_members SET role = $1 WHERE workspace_id = $2 AND user_id = $3`,
+		role, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (r *Repository) DeleteMember(ctx context.Context, workspaceID int64, userID int64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`,
+		workspaceID, userID)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+func (r *Repository) InsertInvitation(ctx context.Context, invitation *Invitation, tokenHash string, invitedBy int64) error {
+	query := `
+		INSERT INTO workspace_invitations (workspace_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+
+	return r.db.QueryRowContext(ctx, query,
+		invitation.WorkspaceID,
+		invitation.Email,
+		invitation.Role,
+		tokenHash,
+		invitedBy,
+		invitation.ExpiresAt,
+	).Scan(&invitation.ID)
+}
+
+// AcceptInvitation consumes a pending invitation addressed to email and adds
+// the user to the workspace. Accepting while already a member keeps the
+// existing role.
+func (r *Repository) AcceptInvitation(ctx context.Context, tokenHash string, userID int64, email string) (*Member, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var invitationID int64
+	member := &Member{UserID: userID, Email: email}
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, workspace_id, role
+		FROM workspace_invitations
+		WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > $2 AND LOWER(email) = LOWER($3)
+		FOR UPDATE
+	`, tokenHash, time.Now(), email).Scan(&invitationID, &member.WorkspaceID, &member.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &InvitationNotFoundErr{}
+		}
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO NOTHING
+	`, member.WorkspaceID, userID, member.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE workspace_invitations SET accepted_at = NOW() WHERE id = $1`, invitationID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `SELECT role, created_at FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`,
+		member.WorkspaceID, userID).Scan(&member.Role, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, tx.Commit()
+}
+
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return &MemberNotFoundErr{}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package workspace
+
+import (
+	"context"
+	"database/sql"
+	"hpj/hv1-link-shortener/shared/migrations"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func insertUser(t *testing.T, ctx context.Context, db *sql.DB, email string) int64 {
+	var id int64
+	err := db.QueryRowContext(ctx, `INSERT INTO users (email, password) VALUES ($1, 'hash') RETURNING id`, email).Scan(&id)
+	require.NoError(t, err)
+	return id
+}
+
+func TestRepository_MembershipFlow(t *testing.T) {
+	db, ctx := migrations.SetupTestDB(t)
+	repo := NewRepository(db)
+
+	t.Cleanup(func() {
+		require.NoError(t, db.Close())
+	})
+
+	ownerID := insertUser(t, ctx, db, "owner@mail.com")
+	inviteeID := insertUser(t, ctx, db, "invitee@mail.com")
+
+	workspace, err := repo.Create(ctx, "Marketing", ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, RoleOwner, workspace.Role)
+
+	owner, err := repo.GetMember(ctx, workspace.ID, ownerID)
+	require.NoError(t, err)
+	assert.Equal(t, RoleOwner, owner.Role)
+
+	_, err = repo.GetMember(ctx, workspace.ID, inviteeID)
+	assert.IsType(t, WorkspaceNotFound, err)
+
+	invitation := &Invitation{WorkspaceID: workspace.ID, Email: "invitee@mail.com", Role: RoleEditor}
+	invitation.ExpiresAt = owner.CreatedAt.AddDate(0, 0, 7)
+	require.NoError(t, repo.InsertInvitation(ctx, invitation, hashToken("token"), ownerID))
+
+	_, err = repo.AcceptInvitation(ctx, hashToken("token"), ownerID, "owner@mail.com")
+	assert.IsType(t, InvitationNotFound, err)
+
+	member, err := repo.AcceptInvitation(ctx, hashToken("token"), inviteeID, "INVITEE@mail.com")
+	require.NoError(t, err)
+	assert.Equal(t, RoleEditor, member.Role)
+
+	_, err = repo.AcceptInvitation(ctx, hashToken("token"), inviteeID, "invitee@mail.com")
+	assert.IsType(t, InvitationNotFound, err)
+
+	members, err := repo.ListMembers(ctx, workspace.ID)
+	require.NoError(t, err)
+	assert.Len(t, members, 2)
+
+	workspaces, err := repo.ListByUserID(ctx, inviteeID)
+	require.NoError(t, err)
+	require.Len(t, workspaces, 1)
+	assert.Equal(t, RoleEditor, workspaces[0].Role)
+
+	require.NoError(t, repo.UpdateMemberRole(ctx, workspace.ID, inviteeID, RoleOwner))
+	owners, err := repo.CountOwners(ctx, workspace.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, owners)
+
+	require.NoError(t, repo.DeleteMember(ctx, workspace.ID, inviteeID))
+	assert.IsType(t, MemberNotFound, repo.DeleteMember(ctx, workspace.ID, inviteeID))
+}
@@ -0,0 +1,211 @@
+package workspace
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+type WorkspaceService interface {
+	Create(ctx context.Context, userID int64, req CreateWorkspaceRequest) (*Workspace, error)
+	ListForUser(ctx context.Context, userID int64) ([]*Workspace, error)
+	GetMembership(ctx context.Context, workspaceID int64, userID int64) (*Member, error)
+	ListMembers(ctx context.Context, workspaceID int64, actorID int64) ([]*Member, error)
+	Invite(ctx context.Context, workspaceID int64, actorID int64, req InviteRequest) (*Invitation, error)
+	AcceptInvitation(ctx context.Context, userID int64, email string, req AcceptInvitationRequest) (*Member, error)
+	UpdateMemberRole(ctx context.Context, workspaceID int64, actorID int64, memberID int64, req UpdateMemberRequest) error
+	RemoveMember(ctx context.Context, workspaceID int64, actorID int64, memberID int64) error
+}
+
+type Service struct {
+	repo WorkspaceRepository
+}
+
+func NewService(repo WorkspaceRepository) *Service {
+	return &Service{repo: repo}
+}
+
+func (s *Service) Create(ctx context.Context, userID int64, req CreateWorkspaceRequest) (*Workspace, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, &InvalidRequestErr{reason: "name is a required field"}
+	}
+
+	return s.repo.Create(ctx, name, userID)
+}
+
+func (s *Service) ListForUser(ctx context.Context, userID int64) ([]*Workspace, error) {
+	return s.repo.ListByUserID(ctx, userID)
+}
+
+func (s *Service) GetMembership(ctx context.Context, workspaceID int64, userID int64) (*Member, error) {
+	return s.repo.GetMember(ctx, workspaceID, userID)
+}
+
+func (s *Service) ListMembers(ctx context.Context, workspaceID int64, actorID int64) ([]*Member, error) {
+	if _, err := s.repo.GetMember(ctx, workspaceID, actorID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.ListMembers(ctx, workspaceID)
+}
+
+// Invite issues a single-use invitation. The plain token is only returned
+// here; the database keeps its SHA-256 hash.
+func (s *Service) Invite(ctx context.Context, workspaceID int64, actorID int64, req InviteRequest) (*Invitation, error) {
+	actor, err := s.repo.GetMember(ctx, workspaceID, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actor.Role.CanManageMembers() {
+		return nil, &ForbiddenErr{action: "invite member"}
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		return nil, &InvalidRequestErr{reason: "email is a required field"}
+	}
+
+	if !req.Role.Valid() || req.Role == RoleOwner {
+		return nil, &InvalidRequestErr{reason: "role must be one of admin, editor or viewer"}
+	}
+
+	if !actor.Role.AtLeast(req.Role) {
+		return nil, &ForbiddenErr{action: "invite member with a higher role"}
+	}
+
+	token, tokenHash, err := newInvitationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &Invitation{
+		WorkspaceID: workspaceID,
+		Email:       email,
+		Role:        req.Role,
+		ExpiresAt:   time.Now().Add(invitationTTL).UTC(),
+	}
+
+	if err := s.repo.InsertInvitation(ctx, invitation, tokenHash, actorID); err != nil {
+		return nil, err
+	}
+
+	invitation.Token = token
+	return invitation, nil
+}
+
+func (s *Service) AcceptInvitation(ctx context.Context, userID int64, email string, req AcceptInvitationRequest) (*Member, error) {
+	if req.Token == "" {
+		return nil, &InvalidRequestErr{reason: "token is a required field"}
+	}
+
+	return s.repo.AcceptInvitation(ctx, hashToken(req.Token), userID, email)
+}
+
+func (s *Service) UpdateMemberRole(ctx context.Context, workspaceID int64, actorID int64, memberID int64, req UpdateMemberRequest) error {
+	if !req.Role.Valid() {
+		return &InvalidRequestErr{reason: "role must be one of owner, admin, editor or viewer"}
+	}
+
+	actor, member, err := s.authorizeMemberChange(ctx, workspaceID, actorID, memberID)
+	if err != nil {
+		return err
+	}
+
+	if !actor.Role.AtLeast(req.Role) {
+		return &ForbiddenErr{action: "grant a role higher than your own"}
+	}
+
+	if member.Role == RoleOwner && req.Role != RoleOwner {
+		if err := s.ensureAnotherOwner(ctx, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	return s.repo.UpdateMemberRole(ctx, workspaceID, memberID, req.Role)
+}
+
+// RemoveMember lets managers remove others and any member leave on their own.
+func (s *Service) RemoveMember(ctx context.Context, workspaceID int64, actorID int64, memberID int64) error {
+	var member *Member
+
+	if actorID == memberID {
+		self, err := s.repo.GetMember(ctx, workspaceID, actorID)
+		if err != nil {
+			return err
+		}
+		member = self
+	} else {
+		_, target, err := s.authorizeMemberChange(ctx, workspaceID, actorID, memberID)
+		if err != nil {
+			return err
+		}
+		member = target
+	}
+
+	if member.Role == RoleOwner {
+		if err := s.ensureAnotherOwner(ctx, workspaceID); err != nil {
+			return err
+		}
+	}
+
+	return s.repo.DeleteMember(ctx, workspaceID, memberID)
+}
+
+// authorizeMemberChange checks that the actor manages members and outranks
+// the target; owners may change anyone, including other owners.
+func (s *Service) authorizeMemberChange(ctx context.Context, workspaceID int64, actorID int64, memberID int64) (*Member, *Member, error) {
+	actor, err := s.repo.GetMember(ctx, workspaceID, actorID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !actor.Role.CanManageMembers() {
+		return nil, nil, &ForbiddenErr{action: "manage members"}
+	}
+
+	member, err := s.repo.GetMember(ctx, workspaceID, memberID)
+	if err != nil {
+		return nil, nil, &MemberNotFoundErr{}
+	}
+
+	if actor.Role != RoleOwner && member.Role.AtLeast(actor.Role) {
+		return nil, nil, &ForbiddenErr{action: "manage a member with an equal or higher role"}
+	}
+
+	return actor, member, nil
+}
+
+func (s *Service) ensureAnotherOwner(ctx context.Context, workspaceID int64) error {
+	owners, err := s.repo.CountOwners(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	if owners <= 1 {
+		return &LastOwnerErr{}
+	}
+
+	return nil
+}
+
+func newInvitationToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token := hex.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,218 @@
+package workspace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRepository struct {
+	members         map[int64]*Member
+	owners          int
+	createdName     string
+	insertedHash    string
+	acceptedHash    string
+	updatedRole     Role
+	deletedMemberID int64
+}
+
+func newMockRepository(members ...*Member) *mockRepository {
+	m := &mockRepository{members: map[int64]*Member{}}
+	for _, member := range members {
+		m.members[member.UserID] = member
+		if member.Role == RoleOwner {
+			m.owners++
+		}
+	}
+	return m
+}
+
+func (m *mockRepository) Create(ctx context.Context, name string, ownerID int64) (*Workspace, error) {
+	m.createdName = name
+	return &Workspace{ID: 1, Name: name, Role: RoleOwner}, nil
+}
+
+func (m *mockRepository) ListByUserID(ctx context.Context, userID int64) ([]*Workspace, error) {
+	return nil, nil
+}
+
+func (m *mockRepository) GetMember(ctx context.Context, workspaceID int64, userID int64) (*Member, error) {
+	member, ok := m.members[userID]
+	if !ok {
+		return nil, &WorkspaceNotFoundErr{workspaceID: workspaceID}
+	}
+	return member, nil
+}
+
+func (m *mockRepository) ListMembers(ctx context.Context, workspaceID int64) ([]*Member, error) {
+	var members []*Member
+	for _, member := range m.members {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (m *mockRepository) CountOwners(ctx context.Context, workspaceID int64) (int, error) {
+	return m.owners, nil
+}
+
+func (m *mockRepository) UpdateMemberRole(ctx context.Context, workspaceID int64, userID int64, role Role) error {
+	m.updatedRole = role
+	return nil
+}
+
+func (m *mockRepository) DeleteMember(ctx context.Context, workspaceID int64, userID int64) error {
+	m.deletedMemberID = userID
+	return nil
+}
+
+func (m *mockRepository) InsertInvitation(ctx context.Context, invitation *Invitation, tokenHash string, invitedBy int64) error {
+	m.insertedHash = tokenHash
+	invitation.ID = 1
+	return nil
+}
+
+func (m *mockRepository) AcceptInvitation(ctx context.Context, tokenHash string, userID int64, email string) (*Member, error) {
+	m.acceptedHash = tokenHash
+	return &Member{WorkspaceID: 1, UserID: userID, Email: email, Role: RoleEditor}, nil
+}
+
+var (
+	owner  = &Member{WorkspaceID: 1, UserID: 1, Role: RoleOwner}
+	admin  = &Member{WorkspaceID: 1, UserID: 2, Role: RoleAdmin}
+	editor = &Member{WorkspaceID: 1, UserID: 3, Role: RoleEditor}
+	viewer = &Member{WorkspaceID: 1, UserID: 4, Role: RoleViewer}
+)
+
+func TestRole(t *testing.T) {
+	assert.True(t, RoleOwner.AtLeast(RoleAdmin))
+	assert.False(t, RoleViewer.AtLeast(RoleEditor))
+	assert.True(t, RoleEditor.CanEditLinks())
+	assert.False(t, RoleViewer.CanEditLinks())
+	assert.True(t, RoleAdmin.CanManageMembers())
+	assert.False(t, RoleEditor.CanManageMembers())
+	assert.False(t, Role("guest").Valid())
+}
+
+func TestCreate(t *testing.T) {
+	repo := newMockRepository()
+	srv := NewService(repo)
+
+	_, err := srv.Create(context.Background(), 1, CreateWorkspaceRequest{Name: "  "})
+	assert.IsType(t, InvalidRequest, err)
+
+	workspace, err := srv.Create(context.Background(), 1, CreateWorkspaceRequest{Name: " Marketing "})
+	require.NoError(t, err)
+	assert.Equal(t, "Marketing", repo.createdName)
+	assert.Equal(t, RoleOwner, workspace.Role)
+}
+
+func TestInvite(t *testing.T) {
+	testCases := []struct {
+		name    string
+		actorID int64
+		request InviteRequest
+		wantErr error
+	}{
+		{name: "admin invites editor", actorID: admin.UserID, request: InviteRequest{Email: "new@mail.com", Role: RoleEditor}},
+		{name: "editor cannot invite", actorID: editor.UserID, request: InviteRequest{Email: "new@mail.com", Role: RoleViewer}, wantErr: Forbidden},
+		{name: "owner role cannot be invited", actorID: owner.UserID, request: InviteRequest{Email: "new@mail.com", Role: RoleOwner}, wantErr: InvalidRequest},
+		{name: "missing email", actorID: owner.UserID, request: InviteRequest{Role: RoleViewer}, wantErr: InvalidRequest},
+		{name: "non member", actorID: 99, request: InviteRequest{Email: "new@mail.com", Role: RoleViewer}, wantErr: WorkspaceNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMockRepository(owner, admin, editor, viewer)
+			srv := NewService(repo)
+
+			invitation, err := srv.Invite(context.Background(), 1, tc.actorID, tc.request)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.NotEmpty(t, invitation.Token)
+			assert.Equal(t, hashToken(invitation.Token), repo.insertedHash)
+			assert.NotEqual(t, invitation.Token, repo.insertedHash)
+
+			_, err = srv.AcceptInvitation(context.Background(), 5, "new@mail.com", AcceptInvitationRequest{Token: invitation.Token})
+			require.NoError(t, err)
+			assert.Equal(t, repo.insertedHash, repo.acceptedHash)
+		})
+	}
+}
+
+func TestUpdateMemberRole(t *testing.T) {
+	testCases := []struct {
+		name     string
+		actorID  int64
+		memberID int64
+		role     Role
+		owners   int
+		wantErr  error
+	}{
+		{name: "admin promotes viewer to editor", actorID: admin.UserID, memberID: viewer.UserID, role: RoleEditor},
+		{name: "admin cannot grant owner", actorID: admin.UserID, memberID: viewer.UserID, role: RoleOwner, wantErr: Forbidden},
+		{name: "admin cannot change another admin", actorID: admin.UserID, memberID: admin.UserID, role: RoleViewer, wantErr: Forbidden},
+		{name: "editor cannot manage", actorID: editor.UserID, memberID: viewer.UserID, role: RoleEditor, wantErr: Forbidden},
+		{name: "last owner cannot be demoted", actorID: owner.UserID, memberID: owner.UserID, role: RoleAdmin, wantErr: LastOwner},
+		{name: "owner demoted when another owner exists", actorID: owner.UserID, memberID: owner.UserID, role: RoleAdmin, owners: 2},
+		{name: "invalid role", actorID: owner.UserID, memberID: viewer.UserID, role: "guest", wantErr: InvalidRequest},
+		{name: "unknown member", actorID: owner.UserID, memberID: 99, role: RoleEditor, wantErr: MemberNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMockRepository(owner, admin, editor, viewer)
+			if tc.owners > 0 {
+				repo.owners = tc.owners
+			}
+			srv := NewService(repo)
+
+			err := srv.UpdateMemberRole(context.Background(), 1, tc.actorID, tc.memberID, UpdateMemberRequest{Role: tc.role})
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Empty(t, repo.updatedRole)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.role, repo.updatedRole)
+		})
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	testCases := []struct {
+		name     string
+		actorID  int64
+		memberID int64
+		wantErr  error
+	}{
+		{name: "admin removes editor", actorID: admin.UserID, memberID: editor.UserID},
+		{name: "viewer leaves", actorID: viewer.UserID, memberID: viewer.UserID},
+		{name: "viewer cannot remove others", actorID: viewer.UserID, memberID: editor.UserID, wantErr: Forbidden},
+		{name: "sole owner cannot leave", actorID: owner.UserID, memberID: owner.UserID, wantErr: LastOwner},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newMockRepository(owner, admin, editor, viewer)
+			srv := NewService(repo)
+
+			err := srv.RemoveMember(context.Background(), 1, tc.actorID, tc.memberID)
+			if tc.wantErr != nil {
+				assert.IsType(t, tc.wantErr, err)
+				assert.Zero(t, repo.deletedMemberID)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.memberID, repo.deletedMemberID)
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package workspace
+
+import (
+	"context"
+	"hafiztri123/app-link-shortener/internal/shared"
+)
+
+// GetMemberFromContext returns the caller's membership in the workspace
+// selected for the request, or nil when the request is in personal scope.
+func GetMemberFromContext(ctx context.Context) *Member {
+	member, _ := ctx.Value(shared.WorkspaceContextKey).(*Member)
+	return member
+}
@@ -23,35 +23,37 @@ func TestURLHistory(t *testing.T) {
 	})
 
 	jwtService := auth.NewTokenService("secret")
-	userService := user.NewService(db, user.NewRepository(db), jwtService)
-	urlService := url.NewService(url.NewRepository(db), redis, 0)
+	userService := user.NewService(db, user.NewRepository(db), jwtService, redis)
+	codes := url.NewSequentialGenerator(0)
+	urlService := url.NewService(url.NewRepository(db, codes), redis, codes, nil, nil, nil, nil, nil, nil)
 
 	err := userService.Register(ctx, user.RegisterRequest{
-		Email:    "test",
+		Email:    "test@mail.com",
 		Password: "password",
 	})
 
 	assert.NoError(t, err)
 
-	token, err := userService.Login(ctx, user.LoginRequest{
-		Email:    "test",
+	login, err := userService.Login(ctx, user.LoginRequest{
+		Email:    "test@mail.com",
 		Password: "password",
 	})
 
-	claims, err := jwtService.ValidateToken(token)
+	claims, err := jwtService.ValidateToken(login.Token)
 	assert.NoError(t, err)
 
 	ctxWithValue := context.WithValue(ctx, shared.UserContextKey, claims)
 
-	_, err = urlService.CreateShortCode(ctxWithValue, "https://example.com")
+	_, err = urlService.CreateShortCode(ctxWithValue, "https://example.com", false, url.LinkOptions{})
 	assert.NoError(t, err)
 
-	_, err = urlService.CreateShortCode(ctxWithValue, "https://example2.com")
+	_, err = urlService.CreateShortCode(ctxWithValue, "https://example2.com", false, url.LinkOptions{})
 	assert.NoError(t, err)
 
-	urls, err := urlService.FetchUserURLHistory(ctxWithValue, claims.UserID)
+	page, err := urlService.FetchUserURLHistory(ctxWithValue, claims.UserID, url.HistoryQuery{})
 
 	assert.NoError(t, err)
-	assert.Equal(t, 2, len(urls))
+	assert.Equal(t, 2, page.Count)
+	assert.Equal(t, 2, page.Total)
 
 }
@@ -6,9 +6,12 @@ import (
 )
 
 type Config struct {
-	RabbitMQAddr    string
-	AnalyticsDBAddr string
-	ClickQueueLabel string
+	RabbitMQAddr           string
+	AnalyticsDBAddr        string
+	TransactionDBAddr      string
+	ClickQueueLabel        string
+	LinkPurgeQueueLabel    string
+	LinkMetadataQueueLabel string
 }
 
 func Load() *Config {
@@ -28,9 +31,23 @@ func Load() *Config {
 		utils.GetEnvOrDefault("ANALYTICS_DB", "analytics_db"),
 		utils.GetEnvOrDefault("DB_SSL", "disable"),
 	)
+
+	transactionDbAddr := fmt.Sprintf(
+		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
+		utils.GetEnvOrDefault("DB_USER", "admin"),
+		utils.GetEnvOrDefault("DB_PASSWORD", "admin"),
+		utils.GetEnvOrDefault("DB_HOST", "localhost"),
+		utils.GetEnvOrDefault("DB_PORT", "5432"),
+		utils.GetEnvOrDefault("TRANSACTION_DB", "app_db"),
+		utils.GetEnvOrDefault("DB_SSL", "disable"),
+	)
+
 	return &Config{
-		RabbitMQAddr:    rabbitmqAddr,
-		ClickQueueLabel: utils.GetEnvOrDefault("CLICK_QUEUE_LABEL", "click_event"),
-		AnalyticsDBAddr: analyticsDbAddr,
+		RabbitMQAddr:           rabbitmqAddr,
+		ClickQueueLabel:        utils.GetEnvOrDefault("CLICK_QUEUE_LABEL", "click_event"),
+		LinkPurgeQueueLabel:    utils.GetEnvOrDefault("LINK_PURGE_QUEUE_LABEL", "link_purge"),
+		LinkMetadataQueueLabel: utils.GetEnvOrDefault("LINK_METADATA_QUEUE_LABEL", "link_metadata"),
+		AnalyticsDBAddr:        analyticsDbAddr,
+		TransactionDBAddr:      transactionDbAddr,
 	}
 }
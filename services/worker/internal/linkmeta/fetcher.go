@@ -0,0 +1,144 @@
+package linkmeta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	sharedutils "hpj/hv1-link-shortener/shared/utils"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	fetchTimeout = 5 * time.Second
+	// maxBodyBytes bounds how much of a page is read. The metadata is in the
+	// head, which comes first.
+	maxBodyBytes = 1 << 20
+	maxRedirects = 5
+	userAgent    = "Mozilla/5.0 (compatible; LinkShortenerBot/1.0; link previews)"
+)
+
+var errReservedAddr = errors.New("destination resolves to a reserved address")
+
+// Metadata is what a page says about itself. Fields the page does not set
+// are empty; URLs are absolute.
+type Metadata struct {
+	Title       string
+	Description string
+	FaviconURL  string
+	ImageURL    string
+}
+
+// Fetcher reads the metadata of destination pages. It only connects to
+// public addresses, so links cannot be used to probe internal hosts.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+func NewFetcher() *Fetcher {
+	return newFetcher(rejectReservedAddr)
+}
+
+// newFetcher builds a Fetcher whose connections are vetted by control. Tests
+// pass nil to reach local servers.
+func newFetcher(control func(network, address string, c syscall.RawConn) error) *Fetcher {
+	dialer := &net.Dialer{Timeout: fetchTimeout, Control: control}
+	transport := &http.Transport{
+		// A proxy would make the connection, bypassing control.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   fetchTimeout,
+		ResponseHeaderTimeout: fetchTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &Fetcher{
+		client: &http.Client{
+			Transport:     transport,
+			Timeout:       fetchTimeout,
+			CheckRedirect: checkRedirect,
+		},
+		maxBytes: maxBodyBytes,
+	}
+}
+
+// rejectReservedAddr refuses connections to addresses that do not lead to a
+// public host. It runs on the resolved address of every connection, including
+// those of redirects, so DNS cannot point a public name at an internal host.
+func rejectReservedAddr(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if sharedutils.IsReservedAddr(addrPort.Addr()) {
+		return errReservedAddr
+	}
+
+	return nil
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+	}
+
+	return nil
+}
+
+// Fetch reads the page at rawURL and returns its metadata. Relative URLs are
+// resolved against the page's final address, after redirects.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Metadata, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", target.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("destination responded with status %d", resp.StatusCode)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return nil, fmt.Errorf("destination is not an HTML page (%q)", contentType)
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.maxBytes), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(body, resp.Request.URL)
+}
@@ -0,0 +1,159 @@
+package linkmeta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<!doctype html><html><head>
+			<title>  Plain   title </title>
+			<meta name="description" content="Plain description">
+			<meta property="og:title" content="Open &amp; Graph title">
+			<meta property="og:image" content="/images/card.png">
+			<link rel="shortcut icon" href="static/icon.ico">
+			</head><body><meta property="og:description" content="In the body"></body></html>`))
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/docs/page", http.StatusFound)
+	})
+	mux.HandleFunc("/docs/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Docs</title></head></html>`))
+	})
+	mux.HandleFunc("/latin1", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		w.Write([]byte("<html><head><title>Caf\xe9</title></head></html>"))
+	})
+	mux.HandleFunc("/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Huge</title>`))
+		w.Write([]byte(strings.Repeat("<!-- padding -->", maxBodyBytes/8)))
+		w.Write([]byte(`<meta name="description" content="Past the limit"></head></html>`))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/file.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testCases := []struct {
+		name    string
+		path    string
+		want    *Metadata
+		wantErr string
+	}{
+		{
+			name: "open graph tags preferred",
+			path: "/page",
+			want: &Metadata{
+				Title:       "Open & Graph title",
+				Description: "Plain description",
+				ImageURL:    server.URL + "/images/card.png",
+				FaviconURL:  server.URL + "/static/icon.ico",
+			},
+		},
+		{
+			name: "resolved against the final address",
+			path: "/moved",
+			want: &Metadata{Title: "Docs", FaviconURL: server.URL + "/favicon.ico"},
+		},
+		{
+			name: "declared charset decoded",
+			path: "/latin1",
+			want: &Metadata{Title: "Café", FaviconURL: server.URL + "/favicon.ico"},
+		},
+		{
+			name: "body read up to the size limit",
+			path: "/huge",
+			want: &Metadata{Title: "Huge", FaviconURL: server.URL + "/favicon.ico"},
+		},
+		{
+			name:    "redirect loop",
+			path:    "/loop",
+			wantErr: "stopped after 5 redirects",
+		},
+		{
+			name:    "not an HTML page",
+			path:    "/file.pdf",
+			wantErr: "not an HTML page",
+		},
+		{
+			name:    "error status",
+			path:    "/missing",
+			wantErr: "status 404",
+		},
+	}
+
+	fetcher := newFetcher(nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			meta, err := fetcher.Fetch(context.Background(), server.URL+tc.path)
+
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, meta)
+		})
+	}
+}
+
+func TestFetch_UnsupportedScheme(t *testing.T) {
+	_, err := newFetcher(nil).Fetch(context.Background(), "ftp://example.com/file")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported scheme")
+}
+
+func TestFetch_RejectsReservedAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the fetcher reached a loopback server")
+	}))
+	defer server.Close()
+
+	_, err := NewFetcher().Fetch(context.Background(), server.URL)
+
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errReservedAddr))
+}
+
+func TestParse_Truncates(t *testing.T) {
+	page := `<html><head><title>` + strings.Repeat("é", maxTitleLength+10) + `</title>
+		<meta property="og:image" content="javascript:alert(1)"></head></html>`
+
+	meta, err := parse(strings.NewReader(page), mustParseURL(t, "https://example.com/a/b"))
+
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("é", maxTitleLength), meta.Title)
+	assert.Empty(t, meta.ImageURL)
+	assert.Equal(t, "https://example.com/favicon.ico", meta.FaviconURL)
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
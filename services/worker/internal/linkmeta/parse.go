@@ -0,0 +1,172 @@
+package linkmeta
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Limits of what is stored. Longer titles and descriptions are cut short;
+// longer URLs are dropped, as a cut URL is broken.
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+	maxURLLength         = 2048
+)
+
+// head collects the candidates for each field; the page's Open Graph tags
+// are preferred over its plain HTML, and both over Twitter cards.
+type head struct {
+	title, ogTitle, twitterTitle                   string
+	description, ogDescription, twitterDescription string
+	ogImage, twitterImage                          string
+	icon, touchIcon                                string
+}
+
+// parse reads the metadata of the page in r, stopping at the end of its head.
+// base is the page's address.
+func parse(r io.Reader, base *url.URL) (*Metadata, error) {
+	var h head
+	z := html.NewTokenizer(r)
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// A page cut off by the size limit still has a usable head.
+			if err := z.Err(); err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, err
+			}
+			return h.metadata(base), nil
+
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return h.metadata(base), nil
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := z.Token()
+			switch token.Data {
+			case "body":
+				return h.metadata(base), nil
+			case "title":
+				if z.Next() == html.TextToken && h.title == "" {
+					h.title = string(z.Text())
+				}
+			case "meta":
+				h.meta(token)
+			case "link":
+				h.link(token)
+			}
+		}
+	}
+}
+
+func (h *head) meta(token html.Token) {
+	key := strings.ToLower(attr(token, "property"))
+	if key == "" {
+		key = strings.ToLower(attr(token, "name"))
+	}
+	content := attr(token, "content")
+
+	fields := map[string]*string{
+		"og:title":            &h.ogTitle,
+		"twitter:title":       &h.twitterTitle,
+		"description":         &h.description,
+		"og:description":      &h.ogDescription,
+		"twitter:description": &h.twitterDescription,
+		"og:image":            &h.ogImage,
+		"og:image:url":        &h.ogImage,
+		"og:image:secure_url": &h.ogImage,
+		"twitter:image":       &h.twitterImage,
+	}
+	if field, ok := fields[key]; ok && *field == "" {
+		*field = content
+	}
+}
+
+func (h *head) link(token html.Token) {
+	href := attr(token, "href")
+	for _, rel := range strings.Fields(strings.ToLower(attr(token, "rel"))) {
+		switch rel {
+		case "icon":
+			if h.icon == "" {
+				h.icon = href
+			}
+		case "apple-touch-icon":
+			if h.touchIcon == "" {
+				h.touchIcon = href
+			}
+		}
+	}
+}
+
+func (h *head) metadata(base *url.URL) *Metadata {
+	meta := &Metadata{
+		Title:       clean(first(h.ogTitle, h.title, h.twitterTitle), maxTitleLength),
+		Description: clean(first(h.ogDescription, h.description, h.twitterDescription), maxDescriptionLength),
+		ImageURL:    resolve(base, first(h.ogImage, h.twitterImage)),
+		FaviconURL:  resolve(base, first(h.icon, h.touchIcon)),
+	}
+
+	// Browsers look for an icon here when the page names none.
+	if meta.FaviconURL == "" {
+		meta.FaviconURL = resolve(base, "/favicon.ico")
+	}
+
+	return meta
+}
+
+func attr(token html.Token, name string) string {
+	for _, a := range token.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+// first returns the first of values that is not blank.
+func first(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// clean collapses the whitespace of s and cuts it to at most limit runes.
+func clean(s string, limit int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+
+	return string([]rune(s)[:limit])
+}
+
+// resolve returns ref as an absolute http(s) URL, or "" when it is not one.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	resolved := u.String()
+	if len(resolved) > maxURLLength {
+		return ""
+	}
+
+	return resolved
+}
@@ -0,0 +1,62 @@
+package linkmeta
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Repository stores fetched metadata in the app database, next to the links.
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// FetchedSince reports whether the metadata of link urlID was fetched at or
+// after since.
+func (r *Repository) FetchedSince(ctx context.Context, urlID int64, since time.Time) (bool, error) {
+	var fetched bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM link_metadata WHERE url_id = $1 AND fetched_at >= $2)`,
+		urlID, since,
+	).Scan(&fetched)
+
+	return fetched, err
+}
+
+// Save records the metadata of link urlID. Links deleted before their fetch
+// finished are skipped.
+func (r *Repository) Save(ctx context.Context, urlID int64, meta *Metadata) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO link_metadata (url_id, title, description, favicon_url, image_url, error, fetched_at)
+		SELECT $1, $2, $3, $4, $5, '', NOW() WHERE EXISTS (SELECT 1 FROM urls WHERE id = $1)
+		ON CONFLICT (url_id) DO UPDATE SET
+			title = EXCLUDED.title,
+			description = EXCLUDED.description,
+			favicon_url = EXCLUDED.favicon_url,
+			image_url = EXCLUDED.image_url,
+			error = '',
+			fetched_at = EXCLUDED.fetched_at`,
+		urlID, meta.Title, meta.Description, meta.FaviconURL, meta.ImageURL,
+	)
+
+	return err
+}
+
+// SaveError records why the metadata of link urlID could not be fetched,
+// keeping what an earlier fetch found.
+func (r *Repository) SaveError(ctx context.Context, urlID int64, reason string) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO link_metadata (url_id, error, fetched_at)
+		SELECT $1, $2, NOW() WHERE EXISTS (SELECT 1 FROM urls WHERE id = $1)
+		ON CONFLICT (url_id) DO UPDATE SET error = EXCLUDED.error, fetched_at = EXCLUDED.fetched_at`,
+		urlID, clean(reason, maxDescriptionLength),
+	)
+
+	return err
+}
@@ -154,27 +154,7 @@ func (c *Consumer) handleMetadataMessage(msg amqp.Delivery) {
 }
 
 func (c *Consumer) sendToRetryQueue(msg amqp.Delivery, retryCount int32) error {
-	retryQueueLabel := c.queueLabel + ".retry"
-	retryExchangeLabel := c.queueLabel + ".retry.exchange"
-
-	headers := make(amqp.Table)
-	if msg.Headers != nil {
-		headers = msg.Headers
-	}
-	headers["x-retry-count"] = retryCount
-
-	return c.channel.Publish(
-		retryExchangeLabel,
-		retryQueueLabel,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType:  msg.ContentType,
-			Body:         msg.Body,
-			DeliveryMode: amqp.Persistent,
-			Headers:      headers,
-		},
-	)
+	return publishRetry(c.channel, c.queueLabel, msg, retryCount)
 }
 
 func (c *Consumer) Close() error {
@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hafiztri123/worker-link-shortener/internal/linkmeta"
+	"hpj/hv1-link-shortener/shared/models"
+	"log/slog"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// metadataRefetchAfter is how long fetched metadata is kept before a link to
+// the same page triggers a new fetch.
+const metadataRefetchAfter = 24 * time.Hour
+
+// LinkMetadataConsumer fetches the title, description and images of new
+// links' destinations and stores them on the links.
+type LinkMetadataConsumer struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	queueLabel string
+	fetcher    *linkmeta.Fetcher
+	repository *linkmeta.Repository
+}
+
+func NewLinkMetadataConsumer(addr, queueLabel string, fetcher *linkmeta.Fetcher, repository *linkmeta.Repository) (*LinkMetadataConsumer, error) {
+	conn, err := amqp.Dial(addr)
+	if err != nil {
+		slog.Error("failed to dial rabbit mq server", "err", err)
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		slog.Error("failed to established channel in rabbit mq connection", "err", err)
+		conn.Close()
+		return nil, err
+	}
+
+	if err := setupDeadLetterQueue(ch, queueLabel); err != nil {
+		slog.Error("failed to create dead letter exchange", "error", err)
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	if err := setupRetryQueue(ch, queueLabel); err != nil {
+		slog.Error("failed to create retry queue", "error", err)
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	_, err = ch.QueueDeclare(
+		queueLabel,
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-dead-letter-exchange":    queueLabel + ".dlx",
+			"x-dead-letter-routing-key": queueLabel + ".dlq",
+		},
+	)
+
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		slog.Error("failed to queue declare with dead letter mechanism", "error", err)
+		return nil, err
+	}
+
+	return &LinkMetadataConsumer{
+		conn:       conn,
+		channel:    ch,
+		queueLabel: queueLabel,
+		fetcher:    fetcher,
+		repository: repository,
+	}, nil
+}
+
+func (c *LinkMetadataConsumer) StartConsuming(ctx context.Context) error {
+	msgs, err := c.channel.Consume(
+		c.queueLabel,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+
+	if err != nil {
+		slog.Error("failed to consume", "error", err)
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case msg, ok := <-msgs:
+			if !ok {
+				slog.Error("error in getting value from channel")
+				return fmt.Errorf("channel closed")
+			}
+
+			c.handleLinkMetadataMessage(msg)
+		}
+	}
+}
+
+// handleLinkMetadataMessage fetches the metadata of one link. Pages that
+// cannot be fetched are recorded as such rather than retried; only failures
+// to store the result are.
+func (c *LinkMetadataConsumer) handleLinkMetadataMessage(msg amqp.Delivery) {
+	retryCount := getRetryCount(msg.Headers)
+	var data *models.LinkMetadataFetch
+
+	if err := json.Unmarshal(msg.Body, &data); err != nil {
+		slog.Error("failed to handle data", "err", err)
+		msg.Nack(false, false)
+		return
+	}
+
+	contextTimeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.fetchLinkMetadata(contextTimeout, data); err != nil {
+		slog.Error("failed to store link metadata", "error", err, "url_id", data.URLID)
+		if retryCount >= MaxRetries {
+			msg.Nack(false, false)
+			return
+		}
+
+		if err := publishRetry(c.channel, c.queueLabel, msg, retryCount+1); err != nil {
+			msg.Nack(false, true)
+			return
+		}
+
+		msg.Ack(false)
+		return
+	}
+
+	msg.Ack(false)
+}
+
+func (c *LinkMetadataConsumer) fetchLinkMetadata(ctx context.Context, data *models.LinkMetadataFetch) error {
+	fresh, err := c.repository.FetchedSince(ctx, data.URLID, time.Now().Add(-metadataRefetchAfter))
+	if err != nil {
+		return err
+	}
+
+	if fresh {
+		return nil
+	}
+
+	meta, err := c.fetcher.Fetch(ctx, data.LongURL)
+	if err != nil {
+		slog.Info("failed to fetch link metadata", "error", err, "url_id", data.URLID)
+		return c.repository.SaveError(ctx, data.URLID, err.Error())
+	}
+
+	return c.repository.Save(ctx, data.URLID, meta)
+}
+
+func (c *LinkMetadataConsumer) Close() error {
+	if c.channel != nil {
+		c.channel.Close()
+	}
+
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	return nil
+}
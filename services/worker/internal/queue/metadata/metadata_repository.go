@@ -18,23 +18,57 @@ func NewRepository(db *sql.DB) *Repository {
 	}
 }
 
-func (r *Repository) InsertMetadata(ctx context.Context, data *models.Click) error {
-	stmt :=
-		`INSERT INTO clicks 
-	(
-	url_path,
-	ip_address, 
-	referer, 
-	user_agent, 
-	device, 
-	os, 
-	browser, 
-	country, 
-	city, 
-	timestamp
-	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
-
-	_, err := r.db.ExecContext(ctx, stmt,
+// clickColumns are the columns of clicks a Click is stored in, in the order
+// of clickArgs, with how each value is bound. Optional fields are stored as
+// NULL when empty.
+var clickColumns = []struct {
+	name  string
+	value string
+}{
+	{"short_code", "$%d"},
+	{"url_path", "$%d"},
+	{"ip_address", "$%d"},
+	{"referer", "$%d"},
+	{"user_agent", "$%d"},
+	{"device", "$%d"},
+	{"os", "$%d"},
+	{"browser", "$%d"},
+	{"country", "$%d"},
+	{"city", "$%d"},
+	{"timestamp", "$%d"},
+	{"variant", "NULLIF($%d, '')"},
+	{"utm_source", "NULLIF($%d, '')"},
+	{"utm_medium", "NULLIF($%d, '')"},
+	{"utm_campaign", "NULLIF($%d, '')"},
+	{"utm_term", "NULLIF($%d, '')"},
+	{"utm_content", "NULLIF($%d, '')"},
+	{"domain_id", "NULLIF($%d, 0)"},
+}
+
+// clickColumnNames lists clickColumns for an INSERT.
+func clickColumnNames() string {
+	names := make([]string, len(clickColumns))
+	for i, column := range clickColumns {
+		names[i] = column.name
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// clickValues are the placeholders of the row whose arguments start at
+// $offset+1.
+func clickValues(offset int) string {
+	placeholders := make([]string, len(clickColumns))
+	for i, column := range clickColumns {
+		placeholders[i] = fmt.Sprintf(column.value, offset+i+1)
+	}
+
+	return "(" + strings.Join(placeholders, ",") + ")"
+}
+
+func clickArgs(data *models.Click) []any {
+	return []any{
+		data.ShortCode,
 		data.Path,
 		data.IPAddress,
 		data.Referer,
@@ -45,7 +79,20 @@ func (r *Repository) InsertMetadata(ctx context.Context, data *models.Click) err
 		data.Country,
 		data.City,
 		data.Timestamp,
-	)
+		data.Variant,
+		data.UTMSource,
+		data.UTMMedium,
+		data.UTMCampaign,
+		data.UTMTerm,
+		data.UTMContent,
+		data.DomainID,
+	}
+}
+
+func (r *Repository) InsertMetadata(ctx context.Context, data *models.Click) error {
+	stmt := fmt.Sprintf(`INSERT INTO clicks (%s) VALUES %s`, clickColumnNames(), clickValues(0))
+
+	_, err := r.db.ExecContext(ctx, stmt, clickArgs(data)...)
 
 	if err != nil {
 		return err
@@ -56,51 +103,14 @@ func (r *Repository) InsertMetadata(ctx context.Context, data *models.Click) err
 
 func (r *Repository) InsertMetadataBatch(ctx context.Context, datas []*models.Click) error {
 	value := make([]string, 0, len(datas))
-	args := make([]any, 0, len(datas)*10)
+	args := make([]any, 0, len(datas)*len(clickColumns))
 
 	for i, data := range datas {
-		value = append(value, fmt.Sprintf(
-			`($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)`,
-			i*10+1,
-			i*10+2,
-			i*10+3,
-			i*10+4,
-			i*10+5,
-			i*10+6,
-			i*10+7,
-			i*10+8,
-			i*10+9,
-			i*10+10,
-		))
-
-		args = append(args,
-			data.Path,
-			data.IPAddress,
-			data.Referer,
-			data.UserAgent,
-			data.Device,
-			data.OS,
-			data.Browser,
-			data.Country,
-			data.City,
-			data.Timestamp,
-		)
+		value = append(value, clickValues(i*len(clickColumns)))
+		args = append(args, clickArgs(data)...)
 	}
 
-	query := fmt.Sprintf(
-		`INSERT INTO clicks 
-	(
-	url_path,
-	ip_address, 
-	referer, 
-	user_agent, 
-	device, 
-	os, 
-	browser, 
-	country, 
-	city, 
-	timestamp
-	) VALUES %s`, strings.Join(value, ","))
+	query := fmt.Sprintf(`INSERT INTO clicks (%s) VALUES %s`, clickColumnNames(), strings.Join(value, ","))
 
 	_, err := r.db.ExecContext(ctx, query, args...)
 	if err != nil {
@@ -109,3 +119,26 @@ func (r *Repository) InsertMetadataBatch(ctx context.Context, datas []*models.Cl
 
 	return nil
 }
+
+// DeleteByLinks removes the clicks recorded for the given links. Clicks
+// recorded before domains were tracked count as the default domain's.
+func (r *Repository) DeleteByLinks(ctx context.Context, links []models.PurgedLink) (int64, error) {
+	if len(links) == 0 {
+		return 0, nil
+	}
+
+	domainIDs := make([]int64, len(links))
+	shortCodes := make([]string, len(links))
+	for i, link := range links {
+		domainIDs[i] = link.DomainID
+		shortCodes[i] = link.ShortCode
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM clicks
+		WHERE (COALESCE(domain_id, 0), short_code) IN (SELECT * FROM unnest($1::bigint[], $2::text[]))`, domainIDs, shortCodes)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hafiztri123/worker-link-shortener/internal/queue/metadata"
+	"hpj/hv1-link-shortener/shared/models"
+	"log/slog"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// PurgeConsumer deletes the analytics of links removed together with their
+// owner's account.
+type PurgeConsumer struct {
+	conn               *amqp.Connection
+	channel            *amqp.Channel
+	queueLabel         string
+	metadataRepository *metadata.Repository
+}
+
+func NewPurgeConsumer(addr, queueLabel string, metadataRepository *metadata.Repository) (*PurgeConsumer, error) {
+	conn, err := amqp.Dial(addr)
+	if err != nil {
+		slog.Error("failed to dial rabbit mq server", "err", err)
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		slog.Error("failed to established channel in rabbit mq connection", "err", err)
+		conn.Close()
+		return nil, err
+	}
+
+	if err := setupDeadLetterQueue(ch, queueLabel); err != nil {
+		slog.Error("failed to create dead letter exchange", "error", err)
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	if err := setupRetryQueue(ch, queueLabel); err != nil {
+		slog.Error("failed to create retry queue", "error", err)
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	_, err = ch.QueueDeclare(
+		queueLabel,
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-dead-letter-exchange":    queueLabel + ".dlx",
+			"x-dead-letter-routing-key": queueLabel + ".dlq",
+		},
+	)
+
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		slog.Error("failed to queue declare with dead letter mechanism", "error", err)
+		return nil, err
+	}
+
+	return &PurgeConsumer{
+		conn:               conn,
+		channel:            ch,
+		queueLabel:         queueLabel,
+		metadataRepository: metadataRepository,
+	}, nil
+}
+
+func (c *PurgeConsumer) StartConsuming(ctx context.Context) error {
+	msgs, err := c.channel.Consume(
+		c.queueLabel,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+
+	if err != nil {
+		slog.Error("failed to consume", "error", err)
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case msg, ok := <-msgs:
+			if !ok {
+				slog.Error("error in getting value from channel")
+				return fmt.Errorf("channel closed")
+			}
+
+			c.handlePurgeMessage(msg)
+		}
+	}
+}
+
+func (c *PurgeConsumer) handlePurgeMessage(msg amqp.Delivery) {
+	retryCount := getRetryCount(msg.Headers)
+	var data *models.LinkPurge
+
+	if err := json.Unmarshal(msg.Body, &data); err != nil {
+		slog.Error("failed to handle data", "err", err)
+		msg.Nack(false, false)
+		return
+	}
+
+	contextTimeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	deleted, err := c.metadataRepository.DeleteByLinks(contextTimeout, data.Links)
+	if err != nil {
+		if retryCount >= MaxRetries {
+			msg.Nack(false, false)
+			return
+		}
+
+		if err := publishRetry(c.channel, c.queueLabel, msg, retryCount+1); err != nil {
+			msg.Nack(false, true)
+			return
+		}
+
+		msg.Ack(false)
+		return
+	}
+
+	slog.Info("purged link analytics", "links", len(data.Links), "clicks", deleted)
+	msg.Ack(false)
+}
+
+func (c *PurgeConsumer) Close() error {
+	if c.channel != nil {
+		c.channel.Close()
+	}
+
+	if c.conn != nil {
+		c.conn.Close()
+	}
+
+	return nil
+}
@@ -20,3 +20,27 @@ func getRetryCount(headers amqp.Table) int32 {
 
 	return 0
 }
+
+func publishRetry(ch *amqp.Channel, queueLabel string, msg amqp.Delivery, retryCount int32) error {
+	retryQueueLabel := queueLabel + ".retry"
+	retryExchangeLabel := queueLabel + ".retry.exchange"
+
+	headers := make(amqp.Table)
+	if msg.Headers != nil {
+		headers = msg.Headers
+	}
+	headers["x-retry-count"] = retryCount
+
+	return ch.Publish(
+		retryExchangeLabel,
+		retryQueueLabel,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:  msg.ContentType,
+			Body:         msg.Body,
+			DeliveryMode: amqp.Persistent,
+			Headers:      headers,
+		},
+	)
+}
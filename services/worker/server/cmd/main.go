@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"hafiztri123/worker-link-shortener/internal/config"
+	"hafiztri123/worker-link-shortener/internal/linkmeta"
 	"hafiztri123/worker-link-shortener/internal/queue"
 	"hafiztri123/worker-link-shortener/internal/queue/metadata"
 	"hpj/hv1-link-shortener/shared/database"
@@ -30,6 +31,9 @@ func main() {
 	db := database.Connect(cfg.AnalyticsDBAddr)
 	metadataRepository := metadata.NewRepository(db)
 
+	appDB := database.Connect(cfg.TransactionDBAddr)
+	linkMetadataRepository := linkmeta.NewRepository(appDB)
+
 	consumer, err := queue.NewConsumer(cfg.RabbitMQAddr, cfg.ClickQueueLabel, metadataRepository)
 
 	if err != nil {
@@ -45,10 +49,24 @@ func main() {
 	}
 	defer dlqConsumer.Close()
 
+	purgeConsumer, err := queue.NewPurgeConsumer(cfg.RabbitMQAddr, cfg.LinkPurgeQueueLabel, metadataRepository)
+	if err != nil {
+		slog.Error("failed to create purge consumer", "error", err)
+		os.Exit(1)
+	}
+	defer purgeConsumer.Close()
+
+	linkMetadataConsumer, err := queue.NewLinkMetadataConsumer(cfg.RabbitMQAddr, cfg.LinkMetadataQueueLabel, linkmeta.NewFetcher(), linkMetadataRepository)
+	if err != nil {
+		slog.Error("failed to create link metadata consumer", "error", err)
+		os.Exit(1)
+	}
+	defer linkMetadataConsumer.Close()
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	errChan := make(chan error, 2)
+	errChan := make(chan error, 4)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -61,6 +79,14 @@ func main() {
 		errChan <- dlqConsumer.StartConsuming(ctx)
 	}()
 
+	go func() {
+		errChan <- purgeConsumer.StartConsuming(ctx)
+	}()
+
+	go func() {
+		errChan <- linkMetadataConsumer.StartConsuming(ctx)
+	}()
+
 	select {
 	case err := <-errChan:
 		slog.Error("Consumer error", "error", err)
@@ -0,0 +1,5 @@
+ALTER TABLE urls DROP CONSTRAINT chk_urls_status;
+ALTER TABLE urls DROP COLUMN status;
+
+ALTER TABLE users DROP COLUMN token_version;
+ALTER TABLE users DROP COLUMN display_name;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN display_name VARCHAR(100);
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE urls ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE urls ADD CONSTRAINT chk_urls_status CHECK (status IN ('active', 'disabled'));
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_urls_workspace_id;
+ALTER TABLE urls DROP CONSTRAINT fk_workspace_id;
+ALTER TABLE urls DROP COLUMN workspace_id;
+
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
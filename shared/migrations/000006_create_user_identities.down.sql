@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS user_identities;
+
+UPDATE users SET password = '' WHERE password IS NULL;
+ALTER TABLE users ALTER COLUMN password SET NOT NULL;
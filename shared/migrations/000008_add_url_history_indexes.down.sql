@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_urls_short_code_trgm;
+DROP INDEX IF EXISTS idx_urls_long_url_trgm;
+
+DROP INDEX IF EXISTS idx_urls_workspace_history;
+DROP INDEX IF EXISTS idx_urls_user_history;
+
+CREATE INDEX idx_urls_workspace_id ON urls (workspace_id);
@@ -0,0 +1,9 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+DROP INDEX IF EXISTS idx_urls_workspace_id;
+
+CREATE INDEX idx_urls_user_history ON urls (user_id, created_at DESC, id DESC) WHERE workspace_id IS NULL;
+CREATE INDEX idx_urls_workspace_history ON urls (workspace_id, created_at DESC, id DESC);
+
+CREATE INDEX idx_urls_long_url_trgm ON urls USING GIN (long_url gin_trgm_ops);
+CREATE INDEX idx_urls_short_code_trgm ON urls USING GIN (short_code gin_trgm_ops);
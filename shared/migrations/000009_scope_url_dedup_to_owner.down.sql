@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_urls_anonymous_long_url;
+DROP INDEX IF EXISTS idx_urls_user_long_url;
+DROP INDEX IF EXISTS idx_urls_workspace_long_url;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS reusable;
+
+-- Fails while several owners share a destination; remove the duplicates first.
+ALTER TABLE urls ADD CONSTRAINT urls_long_url_key UNIQUE (long_url);
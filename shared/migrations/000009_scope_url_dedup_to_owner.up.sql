@@ -0,0 +1,7 @@
+ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_long_url_key;
+
+ALTER TABLE urls ADD COLUMN reusable BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE UNIQUE INDEX idx_urls_workspace_long_url ON urls (workspace_id, long_url) WHERE reusable AND workspace_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_urls_user_long_url ON urls (user_id, long_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_urls_anonymous_long_url ON urls (long_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NULL;
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS idx_urls_anonymous_canonical_url;
+DROP INDEX IF EXISTS idx_urls_user_canonical_url;
+DROP INDEX IF EXISTS idx_urls_workspace_canonical_url;
+
+ALTER TABLE urls DROP COLUMN IF EXISTS canonical_url;
+
+-- Fails while an owner has several reusable links whose spellings differ only
+-- in canonicalization; mark the extras non-reusable first.
+CREATE UNIQUE INDEX idx_urls_workspace_long_url ON urls (workspace_id, long_url) WHERE reusable AND workspace_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_urls_user_long_url ON urls (user_id, long_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_urls_anonymous_long_url ON urls (long_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NULL;
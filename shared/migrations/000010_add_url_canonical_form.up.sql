@@ -0,0 +1,14 @@
+ALTER TABLE urls ADD COLUMN canonical_url TEXT;
+
+-- Existing rows keep deduplicating on the exact string they were created with.
+UPDATE urls SET canonical_url = long_url;
+
+ALTER TABLE urls ALTER COLUMN canonical_url SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_urls_anonymous_long_url;
+DROP INDEX IF EXISTS idx_urls_user_long_url;
+DROP INDEX IF EXISTS idx_urls_workspace_long_url;
+
+CREATE UNIQUE INDEX idx_urls_workspace_canonical_url ON urls (workspace_id, canonical_url) WHERE reusable AND workspace_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_urls_user_canonical_url ON urls (user_id, canonical_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_urls_anonymous_canonical_url ON urls (canonical_url) WHERE reusable AND workspace_id IS NULL AND user_id IS NULL;
@@ -0,0 +1,6 @@
+-- 302 keeps browsers coming back, so destination changes and repeat visits
+-- are seen. Links created before this always redirected with 301.
+ALTER TABLE urls ADD COLUMN redirect_type SMALLINT NOT NULL DEFAULT 302
+    CHECK (redirect_type IN (301, 302, 307, 308));
+
+ALTER TABLE urls ADD COLUMN expires_at TIMESTAMPTZ;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_urls_domain_short_code;
+DROP INDEX IF EXISTS idx_urls_default_short_code;
+
+-- Fails once the same code is used on several domains.
+ALTER TABLE urls ADD CONSTRAINT urls_short_code_key UNIQUE (short_code);
+
+ALTER TABLE urls DROP COLUMN IF EXISTS domain_id;
+
+DROP TABLE IF EXISTS domains;
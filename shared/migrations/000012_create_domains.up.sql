@@ -0,0 +1,23 @@
+CREATE TABLE domains (
+    id SERIAL PRIMARY KEY,
+    hostname VARCHAR(253) NOT NULL,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    workspace_id INTEGER REFERENCES workspaces(id) ON DELETE SET NULL,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Anyone may claim a hostname, but only one claim can be verified.
+CREATE UNIQUE INDEX idx_domains_verified_hostname ON domains (hostname) WHERE verified_at IS NOT NULL;
+CREATE UNIQUE INDEX idx_domains_workspace_hostname ON domains (workspace_id, hostname) WHERE workspace_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_domains_user_hostname ON domains (user_id, hostname) WHERE workspace_id IS NULL;
+
+-- Links without a domain are served on the default one. A domain cannot be
+-- removed while links use it.
+ALTER TABLE urls ADD COLUMN domain_id INTEGER REFERENCES domains(id);
+
+-- Short codes are unique per domain rather than globally.
+ALTER TABLE urls DROP CONSTRAINT urls_short_code_key;
+CREATE UNIQUE INDEX idx_urls_default_short_code ON urls (short_code) WHERE domain_id IS NULL;
+CREATE UNIQUE INDEX idx_urls_domain_short_code ON urls (domain_id, short_code) WHERE domain_id IS NOT NULL;
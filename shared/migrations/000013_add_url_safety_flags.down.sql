@@ -0,0 +1,6 @@
+ALTER TABLE urls DROP COLUMN IF EXISTS flagged_at;
+ALTER TABLE urls DROP COLUMN IF EXISTS flagged_reason;
+
+UPDATE urls SET status = 'disabled' WHERE status = 'flagged';
+ALTER TABLE urls DROP CONSTRAINT chk_urls_status;
+ALTER TABLE urls ADD CONSTRAINT chk_urls_status CHECK (status IN ('active', 'disabled'));
@@ -0,0 +1,7 @@
+-- Flagged links point at destinations a safety check found unsafe. Unlike
+-- disabled links, their owners cannot re-enable them.
+ALTER TABLE urls DROP CONSTRAINT chk_urls_status;
+ALTER TABLE urls ADD CONSTRAINT chk_urls_status CHECK (status IN ('active', 'disabled', 'flagged'));
+
+ALTER TABLE urls ADD COLUMN flagged_reason TEXT;
+ALTER TABLE urls ADD COLUMN flagged_at TIMESTAMPTZ;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_urls_search;
+ALTER TABLE urls DROP COLUMN IF EXISTS search_vector;
+
+DROP TABLE IF EXISTS link_tags;
+DROP TABLE IF EXISTS link_details;
@@ -0,0 +1,28 @@
+-- Titles, descriptions and tags are set by link owners to find their links
+-- again. Both tables are only written for links that have any.
+CREATE TABLE link_details (
+    url_id INTEGER PRIMARY KEY REFERENCES urls(id) ON DELETE CASCADE,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    search_vector TSVECTOR GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', title), 'A') || setweight(to_tsvector('simple', description), 'B')
+    ) STORED
+);
+
+CREATE INDEX idx_link_details_search ON link_details USING GIN (search_vector);
+
+CREATE TABLE link_tags (
+    url_id INTEGER NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+    tag VARCHAR(50) NOT NULL,
+    PRIMARY KEY (url_id, tag)
+);
+
+CREATE INDEX idx_link_tags_tag ON link_tags (tag);
+
+-- Destinations are searched by the words of the URL, so that "example" finds
+-- https://www.example.com/page.
+ALTER TABLE urls ADD COLUMN search_vector TSVECTOR GENERATED ALWAYS AS (
+    to_tsvector('simple', regexp_replace(long_url, '[^[:alnum:]]+', ' ', 'g'))
+) STORED;
+
+CREATE INDEX idx_urls_search ON urls USING GIN (search_vector);
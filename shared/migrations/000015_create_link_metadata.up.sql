@@ -0,0 +1,12 @@
+-- What the destination page says about itself, fetched by the worker after a
+-- link is created. Error holds why the last fetch failed; the other columns
+-- then keep what an earlier fetch found.
+CREATE TABLE link_metadata (
+    url_id INTEGER PRIMARY KEY REFERENCES urls(id) ON DELETE CASCADE,
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    favicon_url TEXT NOT NULL DEFAULT '',
+    image_url TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
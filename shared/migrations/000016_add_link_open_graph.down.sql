@@ -0,0 +1,4 @@
+ALTER TABLE link_details
+    DROP COLUMN IF EXISTS og_image_url,
+    DROP COLUMN IF EXISTS og_description,
+    DROP COLUMN IF EXISTS og_title;
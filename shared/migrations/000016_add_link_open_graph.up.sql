@@ -0,0 +1,6 @@
+-- Owners can override what social platforms show when their link is shared.
+-- Empty overrides fall back to the metadata fetched from the destination.
+ALTER TABLE link_details
+    ADD COLUMN og_title TEXT NOT NULL DEFAULT '',
+    ADD COLUMN og_description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN og_image_url TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- Links with a password hash only open for visitors who enter the password.
+ALTER TABLE urls ADD COLUMN password_hash TEXT;
@@ -0,0 +1,3 @@
+-- Routing rules send the visitors they match elsewhere than long_url. They
+-- are evaluated in order, in the app, from the cached link.
+ALTER TABLE urls ADD COLUMN routing_rules JSONB;
@@ -0,0 +1,3 @@
+-- Split links spread the visitors no routing rule matches across weighted
+-- variants instead of sending them to long_url.
+ALTER TABLE urls ADD COLUMN variants JSONB;
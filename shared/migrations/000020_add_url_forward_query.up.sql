@@ -0,0 +1,3 @@
+-- Links that forward the query pass the query string of each visit on to
+-- their destination.
+ALTER TABLE urls ADD COLUMN forward_query BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Which inactive links were reusable before is not recorded; they stay
+-- non-reusable.
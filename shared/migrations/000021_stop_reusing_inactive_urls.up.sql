@@ -0,0 +1,3 @@
+-- Deduplication only hands out active links. Links stop being reusable when
+-- they are disabled or flagged, and stay so if they are enabled again.
+UPDATE urls SET reusable = FALSE WHERE status <> 'active';
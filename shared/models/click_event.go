@@ -4,13 +4,25 @@ import "time"
 
 type Click struct {
 	Timestamp time.Time `json:"timestamp"`
-	Path      string    `json:"path"`
-	IPAddress string    `json:"ip_address"`
-	Referer   string    `json:"referrer"`
-	UserAgent string    `json:"user_agent"`
-	Device    string    `json:"device"`
-	OS        string    `json:"os"`
-	Browser   string    `json:"browser"`
-	Country   string    `json:"country"`
-	City      string    `json:"city"`
+	ShortCode string    `json:"short_code"`
+	// DomainID is the custom domain the link was opened on, 0 for the
+	// default domain.
+	DomainID  int64  `json:"domain_id,omitempty"`
+	Path      string `json:"path"`
+	IPAddress string `json:"ip_address"`
+	Referer   string `json:"referrer"`
+	UserAgent string `json:"user_agent"`
+	Device    string `json:"device"`
+	OS        string `json:"os"`
+	Browser   string `json:"browser"`
+	Country   string `json:"country"`
+	City      string `json:"city"`
+	// Variant names the destination a split link sent the visitor to.
+	Variant string `json:"variant,omitempty"`
+	// The campaign the visit came from, as tagged in its query string.
+	UTMSource   string `json:"utm_source,omitempty"`
+	UTMMedium   string `json:"utm_medium,omitempty"`
+	UTMCampaign string `json:"utm_campaign,omitempty"`
+	UTMTerm     string `json:"utm_term,omitempty"`
+	UTMContent  string `json:"utm_content,omitempty"`
 }
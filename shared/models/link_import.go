@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// LinkImport is an uploaded file of links queued for the app to create in
+// the background, on behalf of the user and workspace that uploaded it.
+type LinkImport struct {
+	JobID       string          `json:"job_id"`
+	UserID      int64           `json:"user_id"`
+	WorkspaceID *int64          `json:"workspace_id,omitempty"`
+	Rows        []LinkImportRow `json:"rows"`
+	Timestamp   time.Time       `json:"timestamp"`
+}
+
+// LinkImportRow is one link of an import file.
+type LinkImportRow struct {
+	LongURL     string     `json:"long_url"`
+	Alias       string     `json:"alias,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Tags        []string   `json:"tags,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LinkMetadataFetch asks the worker to fetch the title, description, favicon
+// and preview image of a new link's destination.
+type LinkMetadataFetch struct {
+	URLID     int64     `json:"url_id"`
+	LongURL   string    `json:"long_url"`
+	Timestamp time.Time `json:"timestamp"`
+}
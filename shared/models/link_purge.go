@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// LinkPurge asks the worker to drop the analytics of links that were deleted.
+type LinkPurge struct {
+	Links     []PurgedLink `json:"links"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// PurgedLink names a deleted link. The same code can exist on several
+// domains, so it takes both to find its clicks. DomainID is 0 for the
+// default domain.
+type PurgedLink struct {
+	DomainID  int64  `json:"domain_id,omitempty"`
+	ShortCode string `json:"short_code"`
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsReservedAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"8.8.8.8":              false,
+		"2606:4700::1111":      false,
+		"192.168.1.1":          true,
+		"172.31.255.255":       true,
+		"172.32.0.1":           false,
+		"198.18.0.1":           true,
+		"255.255.255.255":      true,
+		"::":                   true,
+		"::ffff:10.0.0.1":      true,
+		"64:ff9b::7f00:1":      true,
+		"2001:db8::1":          true,
+		"ff02::1":              true,
+		"::ffff:93.184.215.14": false,
+	} {
+		assert.Equal(t, want, IsReservedAddr(netip.MustParseAddr(addr)), addr)
+	}
+}